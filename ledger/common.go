@@ -5,9 +5,11 @@ import (
 	"fmt"
 
 	"github.com/lunfardo314/easyfl"
+	"github.com/lunfardo314/easyutxo/lazyslice"
 	"github.com/lunfardo314/easyutxo/ledger/constraints"
 	"github.com/lunfardo314/unitrie/common"
 	"github.com/lunfardo314/unitrie/models/trie_blake2b"
+	"golang.org/x/crypto/blake2b"
 )
 
 const (
@@ -37,12 +39,15 @@ type (
 
 	StateReadAccess interface {
 		GetUTXO(id *OutputID) ([]byte, bool)
+		StorageDepositParams() *constraints.StorageDepositParams
+		LedgerIdentity() []byte
 		HasTransaction(txid *TransactionID) bool
 	}
 
 	IndexerAccess interface {
 		GetUTXOsLockedInAccount(accountID constraints.Accountable, state StateReadAccess) ([]*OutputDataWithID, error)
 		GetUTXOForChainID(id []byte, state StateReadAccess) (*OutputDataWithID, error)
+		GetUTXOForNFTID(id []byte, state StateReadAccess) (*OutputDataWithID, error)
 	}
 
 	StateStore interface {
@@ -57,6 +62,29 @@ type (
 	}
 )
 
+// TransactionEssenceBytes makes the essence of the transaction from its transferable bytes: input IDs,
+// produced outputs, input commitment, endorsements, local libraries, fee, timestamp and the identity of the ledger.
+// The essence is signed by the transaction signatures, so signed transaction is valid only on the ledger with the identity
+func TransactionEssenceBytes(txBytes, ledgerIdentity []byte) []byte {
+	arr := lazyslice.ArrayFromBytes(txBytes, int(constraints.TxTreeIndexMax))
+	return common.Concat(
+		arr.At(int(constraints.TxInputIDs)),
+		arr.At(int(constraints.TxOutputs)),
+		arr.At(int(constraints.TxInputCommitment)),
+		arr.At(int(constraints.TxEndorsements)),
+		arr.At(int(constraints.TxLocalLibraries)),
+		arr.At(int(constraints.TxFee)),
+		arr.At(int(constraints.TxTimestamp)),
+		ledgerIdentity,
+	)
+}
+
+// TransactionIDFromTransactionBytes computes the ID of the transaction as the hash of its essence. Unlock parameters
+// and signatures are not part of the ID, so they can't be changed by relayers to change the ID
+func TransactionIDFromTransactionBytes(txBytes, ledgerIdentity []byte) TransactionID {
+	return blake2b.Sum256(TransactionEssenceBytes(txBytes, ledgerIdentity))
+}
+
 func TransactionIDFromBytes(data []byte) (ret TransactionID, err error) {
 	if len(data) != TransactionIDLength {
 		err = errors.New("TransactionIDFromBytes: wrong data length")
@@ -9,11 +9,24 @@ import (
 )
 
 const amountSource = `
-func storageDepositEnough: if(
-	greaterOrEqualThan(
-		$0,
-		concat(u32/0, mul16_32(vbCost16,len16(selfOutputBytes)))
+// $0 - uint32, $1 - uint16. Returns uint64 product
+func mul32_16_64: sum64(
+	concat(u16/0, mul16_32(slice($0, 0, 1), $1), u16/0),
+	concat(u32/0, mul16_32(slice($0, 2, 3), $1))
+)
+
+// minimum storage deposit of the self output as uint64, calculated with the storage deposit parameters of the ledger.
+// Extra weight returned by constraints is not known here, it is accounted by the ledger
+func minimumStorageDeposit: mul32_16_64(
+	sum32(
+		mul16_32(vbDataWeight16, len16(selfOutputBytes)),
+		concat(u16/0, vbKeyWeight16)
 	),
+	vbCost16
+)
+
+func storageDepositEnough: if(
+	greaterOrEqualThan($0, minimumStorageDeposit),
 	true,
 	!!!not_enough_storage_deposit
 )
@@ -64,16 +77,20 @@ func NewAmount(a uint64) Amount {
 }
 
 func initAmountConstraint() {
-	easyfl.MustExtendMany(amountSource)
+	MustRegisterConstraint(&ConstraintDefinition{
+		Name:   amountName,
+		Source: amountSource,
+		Parser: func(data []byte) (Constraint, error) {
+			return AmountFromBytes(data)
+		},
+	})
+
 	// sanity check
 	example := NewAmount(1337)
-	sym, prefix, args, err := easyfl.ParseBytecodeOneLevel(example.Bytes(), 1)
+	sym, _, args, err := easyfl.ParseBytecodeOneLevel(example.Bytes(), 1)
 	easyfl.AssertNoError(err)
 	amountBin := easyfl.StripDataPrefix(args[0])
 	common.Assert(sym == amountName && len(amountBin) == 8 && binary.BigEndian.Uint64(amountBin) == 1337, "'amount' consistency check failed")
-	registerConstraint(amountName, prefix, func(data []byte) (Constraint, error) {
-		return AmountFromBytes(data)
-	})
 }
 
 func AmountFromBytes(data []byte) (Amount, error) {
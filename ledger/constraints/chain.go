@@ -7,31 +7,41 @@ import (
 
 	"github.com/lunfardo314/easyfl"
 	"github.com/lunfardo314/unitrie/common"
+	"golang.org/x/crypto/blake2b"
 )
 
 /*
  ChainConstraint constraint imposes chain of consumed UTXOs with the same identity from the origin to the final state
  Each chain represents a sequence of state changes.
+ The chain starts with the origin output with the chainInit constraint. The chain ID is derived from the ID of the origin.
  Structure of the output:
  - identity (chainID)
  - amount
  - timestamp
- - chain lock: 2 locks: state lock and governance lock
- - state metadata
- - governance metadata
+ - chain lock: chainControllerLock with state controller and governor
+ - state metadata: chainMetadata in state transition mode
+ - governance metadata: chainMetadata in governance transition mode
  - immutable metadata
 
-- ChainConstraint data constraint: array: chain config (back ref), chain identity, state metadata, governance metadata, immutable metadata (xN)
-- ChainConstraint lock constraint: state controller, governance controller
-- ChainConstraint data unlock params: forward ref
+- ChainConstraint data constraint: chain identity, back ref, transition mode
+- ChainConstraint lock constraint: state controller, governor
+- ChainConstraint data unlock params: forward ref, transition mode
 */
 
+// Transition modes of the chain. The transition mode of the successor is enforced by the chain constraint,
+// but it is interpreted by constraints bound to the chain, such as chainControllerLock and chainMetadata
+const (
+	// ChainTransitionModeState state transition, unlocked by the state controller
+	ChainTransitionModeState = byte(0)
+	// ChainTransitionModeGovernance governance transition, unlocked by the governor
+	ChainTransitionModeGovernance = byte(1)
+)
+
 // ChainConstraint is chain constraint
 type ChainConstraint struct {
-	// ID all-0 for origin
 	ID             [32]byte
 	TransitionMode byte
-	// Previous index of the consumed chain input with the same ID. Must be 0xFF for the origin
+	// Previous index of the consumed chain input with the same ID
 	PreviousOutput byte
 	PreviousBlock  byte
 }
@@ -50,26 +60,6 @@ func NewChainConstraint(id [32]byte, prevOut, prevBlock, mode byte) *ChainConstr
 	}
 }
 
-func NewChainOrigin() *ChainConstraint {
-	return NewChainConstraint([32]byte{}, 0xff, 0xff, 0xff)
-}
-
-func (ch *ChainConstraint) IsOrigin() bool {
-	if ch.ID != [32]byte{} {
-		return false
-	}
-	if ch.PreviousOutput != 0xff {
-		return false
-	}
-	if ch.PreviousBlock != 0xff {
-		return false
-	}
-	if ch.TransitionMode != 0xff {
-		return false
-	}
-	return true
-}
-
 func (ch *ChainConstraint) Name() string {
 	return ChainConstraintName
 }
@@ -115,23 +105,82 @@ func ChainConstraintFromBytes(data []byte) (*ChainConstraint, error) {
 	return ret, nil
 }
 
+// ChainInit is the origin of the chain. The chain ID is not known in the origin output: it is the blake2b hash
+// of the ID of the output which contains the chainInit constraint. When consumed, the origin is succeeded by
+// the chain constraint with the chain ID
+type ChainInit struct{}
+
+const ChainInitName = "chainInit"
+
+func NewChainInit() *ChainInit {
+	return &ChainInit{}
+}
+
+func ChainInitFromBytes(data []byte) (*ChainInit, error) {
+	sym, _, _, err := easyfl.ParseBytecodeOneLevel(data, 0)
+	if err != nil {
+		return nil, err
+	}
+	if sym != ChainInitName {
+		return nil, fmt.Errorf("not a chainInit constraint")
+	}
+	return NewChainInit(), nil
+}
+
+// OriginChainID returns ID of the chain, originated in the output with the ID
+func OriginChainID(originOutputID []byte) [32]byte {
+	return blake2b.Sum256(originOutputID)
+}
+
+func (ci *ChainInit) Name() string {
+	return ChainInitName
+}
+
+func (ci *ChainInit) Bytes() []byte {
+	return mustBinFromSource(ChainInitName)
+}
+
+func (ci *ChainInit) String() string {
+	return ChainInitName
+}
+
 func initChainConstraint() {
-	easyfl.MustExtendMany(chainConstraintSource)
+	easyfl.EmbedLong("chainConstraintPrefix", 0, evalChainConstraintPrefix)
+
+	// chainInit is defined in the same source before the chain
+	MustRegisterConstraint(&ConstraintDefinition{
+		Name:   ChainConstraintName,
+		Source: chainConstraintSource,
+		Parser: func(data []byte) (Constraint, error) {
+			return ChainConstraintFromBytes(data)
+		},
+	})
+	MustRegisterConstraint(&ConstraintDefinition{
+		Name: ChainInitName,
+		Parser: func(data []byte) (Constraint, error) {
+			return ChainInitFromBytes(data)
+		},
+	})
 
-	example := NewChainOrigin()
+	example := NewChainConstraint(blake2b.Sum256([]byte("chain")), 1, 3, ChainTransitionModeState)
 	back, err := ChainConstraintFromBytes(example.Bytes())
 	easyfl.AssertNoError(err)
 	easyfl.Assert(bytes.Equal(back.Bytes(), example.Bytes()), "inconsistency in "+ChainConstraintName)
 
-	_, prefix, _, err := easyfl.ParseBytecodeOneLevel(example.Bytes(), 1)
+	_, err = ChainInitFromBytes(NewChainInit().Bytes())
 	easyfl.AssertNoError(err)
-
-	registerConstraint(ChainConstraintName, prefix, func(data []byte) (Constraint, error) {
-		return ChainConstraintFromBytes(data)
-	})
 }
 
-// TODO re-write chain constraint with two functions: 'chainInit' and 'chain'. To get rid of all0 init code
+// Returns the call prefix of the chain constraint. The chainInit is defined before the chain constraint,
+// so the prefix literal '#chain' can't be compiled in its source
+func evalChainConstraintPrefix(ctx *easyfl.CallParams) []byte {
+	ret, err := easyfl.FunctionCallPrefixByName(ChainConstraintName, 1)
+	if err != nil {
+		ctx.Trace("evalChainConstraintPrefix: %v", err)
+		return nil
+	}
+	return ret
+}
 
 const chainConstraintSource = `
 // chain(<chain constraint data>)
@@ -141,8 +190,6 @@ const chainConstraintSource = `
 // - 33 byte predecessor block index 
 // - 34 byte transition mode 
 
-// reserved value of the chain constraint data at origin
-func originChainData: concat(repeat(0,32), 0xffffff)
 func destroyUnlockParams : 0xffffff
 
 // parsing chain constraint data
@@ -151,69 +198,80 @@ func chainID : slice($0, 0, 31)
 func transitionMode: byte($0, 34)
 func predecessorConstraintIndex : slice($0, 32, 33) // 2 bytes
 
-// accessing to predecessor data
-func predecessorOutput : consumedConstraintByIndex(predecessorConstraintIndex($0))
-func predecessorInputID : inputIDByIndex(byte($0,32))
+// ID of the chain originated in the consumed output with index $0
+func originChainID : blake2b(inputIDByIndex($0))
 
-// unlock parameters for the chain constraint. 3 bytes: 
+// unlock parameters for the chainInit and chain constraints. 3 bytes: 
 // 0 - successor output index 
 // 1 - successor block index
 // 2 - transition mode must be equal to the transition mode in the successor constrain data 
+// The chain is destroyed (has no successor) with unlock parameters 0xffffff
 
-// only called for produced output
-// $0 - self produced constraint data
-// $1 - predecessor data
-func validPredecessorData : and(
-	if(
-		isZero(chainID($1)), 
+// successor constraint is computed in the context of the consumed output from the unlock parameters
+func chainSuccessor : producedConstraintByIndex(slice(selfUnlockParameters,0,1))
+
+// $0 - successor chain constraint data
+// the successor must carry the chain ID derived from the origin and point to the origin
+func validChainInitSuccessor : and(
+	equal(chainID($0), originChainID(selfOutputIndex)),
+	equal(predecessorConstraintIndex($0), selfConstraintIndex)
+)
+
+// Constraint source: chainInit
+// Origin of the chain. It does not contain chain ID. The chain ID is blake2b hash of the ID of the origin output.
+// When consumed, unlock parameters must point to the successor chain constraint with the chain ID.
+// The successor is fully validated by the chain constraint on the 'produced' side
+func chainInit: and(
+	// chain constraint cannot be on output with index 0xff = 255
+	not(equal(selfOutputIndex, 0xff)),
+	or(
+		selfIsProducedOutput,
 		and(
-			// case 1: predecessor is origin. ChainID must be blake2b hash of the corresponding input ID 
-			equal($1, originChainData),
-			equal(chainID($0), blake2b(predecessorInputID($0)))
+			selfIsConsumedOutput,
+			or(
+				// origin is being destroyed (no successor)
+				equal(selfUnlockParameters, destroyUnlockParams),
+				and(
+					// the successor must be the chain constraint
+					equal(parseBytecodePrefix(chainSuccessor), chainConstraintPrefix),
+					validChainInitSuccessor(parseBytecodeArg(chainSuccessor, chainConstraintPrefix, 0))
+				)
+			)
 		),
-		and(
-			// case 2: normal transition
-			equal(chainID($0), chainID($1)),
-		)
-	),
+		!!!chainInit_constraint_failed
+	)
+)
+
+// $0 - predecessor constraint index
+// returns chain ID of the predecessor, which is either chain origin or chain constraint
+func chainPredecessorID : if(
+	equal(parseBytecodePrefix(consumedConstraintByIndex($0)), #chainInit),
+	originChainID(byte($0,0)),
+	chainID(parseBytecodeArg(consumedConstraintByIndex($0), selfBytecodePrefix, 0))
+)
+
+// only called for produced output
+// $0 - self produced constraint data
+func validPredecessor : and(
+	equal(chainID($0), chainPredecessorID(predecessorConstraintIndex($0))),
 	equal(
 		// enforcing equal transition mode on unlock data and on the produced output
 		transitionMode($0),
 		byte(unlockParamsByConstraintIndex(predecessorConstraintIndex($0)),2)
-	)
+	),
+	// only state and governance transition modes
+	lessThan(transitionMode($0), 2)
 )
 
-// $0 - predecessor constraint index
-func chainPredecessorData: 
-	parseBytecodeArg(
-		consumedConstraintByIndex($0),
-		selfBytecodePrefix,
-		0
-	)
-
 // $0 - self chain data (consumed)
 // $1 - successor constraint parsed data (produced)
 func validSuccessorData : and(
-		if (
-			// if chainID = 0, it must be origin data
-			// otherwise chain IDs must be equal on both sides
-			isZero(chainID($0)),
-			equal($0, originChainData),
-			equal(chainID($0),chainID($1))
-		),
-		// the successor (produced) must point to the consumed (self)
-		equal(predecessorConstraintIndex($1), selfConstraintIndex)
+	// chain IDs must be equal on both sides
+	equal(chainID($0),chainID($1)),
+	// the successor (produced) must point to the consumed (self)
+	equal(predecessorConstraintIndex($1), selfConstraintIndex)
 )
 
-// chain successor data is computed form in the context of the consumed output
-// from the selfUnlock data
-func chainSuccessorData : 
-	parseBytecodeArg(
-		producedConstraintByIndex(slice(selfUnlockParameters,0,1)),
-		selfBytecodePrefix,
-		0
-	)
-
 // Constraint source: chain($0)
 // $0 - 35-bytes data: 
 //     32 bytes chain id
@@ -222,51 +280,44 @@ func chainSuccessorData :
 //     1 byte transition mode
 // Transition mode: 
 //     0x00 - state transition
-//     0xff - origin state, can be any other values. 
+//     0x01 - governance transition
 // It is enforced by the chain constraint 
 // but it is interpreted by other constraints, bound to chain 
 // constraint, such as controller locks
 func chain: and(
-      // chain constraint cannot be on output with index 0xff = 255
-   not(equal(selfOutputIndex, 0xff)),  
-   or(
-      if(
-        // if it is produced output with zero-chainID, it is chain origin.
-         and(
-            isZero(chainID($0)),
-            selfIsProducedOutput
-         ),
-         or(
-            // enforcing valid constraint data of the origin: concat(repeat(0,32), 0xffffff)
-            equal($0, originChainData), 
-            !!!chain_wrong_origin
-         ),
-         nil
-       ),
-        // check validity of chain transition. Unlock data of the constraint 
-        // must point to the valid successor (in case of consumed output) 
-        // or predecessor (in case of produced output) 
-       and(
-           // 'consumed' side case, checking if unlock params and successor is valid
-          selfIsConsumedOutput,
-          or(
-               // consumed chain output is being destroyed (no successor)
-            equal(selfUnlockParameters, destroyUnlockParams),
-               // or it must be unlocked by pointing to the successor
-            validSuccessorData($0, chainSuccessorData),     
-            !!!chain_wrong_successor
-          )	
-       ), 
-       and(
-          // 'produced' side case, checking if predecessor is valid
-           selfIsProducedOutput,
-           or(
-              // 'produced' side case checking if predecessor is valid
-              validPredecessorData($0, chainPredecessorData( predecessorConstraintIndex($0) )),
-              !!!chain_wrong_predecessor
-           )
-       ),
-       !!!chain_constraint_failed
-   )
+	// chain constraint cannot be on output with index 0xff = 255
+	not(equal(selfOutputIndex, 0xff)),  
+	or(
+		// check validity of chain transition. Unlock data of the constraint 
+		// must point to the valid successor (in case of consumed output) 
+		// or predecessor (in case of produced output) 
+		and(
+			// 'consumed' side case, checking if unlock params and successor is valid
+			selfIsConsumedOutput,
+			or(
+				// consumed chain output is being destroyed (no successor)
+				equal(selfUnlockParameters, destroyUnlockParams),
+				// or it must be unlocked by pointing to the successor
+				validSuccessorData($0, parseBytecodeArg(chainSuccessor, selfBytecodePrefix, 0)),     
+				!!!chain_wrong_successor
+			)	
+		), 
+		and(
+			// 'produced' side case, checking if predecessor is valid
+			selfIsProducedOutput,
+			or(
+				validPredecessor($0),
+				!!!chain_wrong_predecessor
+			)
+		),
+		!!!chain_constraint_failed
+	)
+)
+
+// $0 - constraint bytecode
+// returns non-empty value if the constraint is the chain constraint or the chain origin
+func isChainConstraint : or(
+	equal(parseBytecodePrefix($0), #chainInit),
+	equal(parseBytecodePrefix($0), #chain)
 )
 `
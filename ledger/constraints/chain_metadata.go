@@ -0,0 +1,186 @@
+package constraints
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/lunfardo314/easyfl"
+	"github.com/lunfardo314/easyutxo/lazyslice"
+)
+
+// ChainMetadata is the metadata block of the chain, referenced by the chain constraint index.
+// The metadata must be repeated on the successor of the chain. It can only be changed in the transition of the
+// specified mode: state metadata in state transitions and governance metadata in governance transitions.
+// New metadata can be added only in the transition of its mode or on the chain origin.
+// The 1-byte unlock parameters of the constraint point to the metadata block in the chain successor
+
+type ChainMetadata struct {
+	ChainBlockIndex byte
+	Mode            byte
+	Data            []byte
+}
+
+const (
+	ChainMetadataName     = "chainMetadata"
+	chainMetadataTemplate = ChainMetadataName + "(0x%s, 0x%s)"
+)
+
+func NewChainMetadata(chainBlockIndex, mode byte, data []byte) *ChainMetadata {
+	return &ChainMetadata{
+		ChainBlockIndex: chainBlockIndex,
+		Mode:            mode,
+		Data:            data,
+	}
+}
+
+// NewChainStateMetadata makes the metadata which can be changed by the state controller
+func NewChainStateMetadata(chainBlockIndex byte, data []byte) *ChainMetadata {
+	return NewChainMetadata(chainBlockIndex, ChainTransitionModeState, data)
+}
+
+// NewChainGovernanceMetadata makes the metadata which can be changed by the governor
+func NewChainGovernanceMetadata(chainBlockIndex byte, data []byte) *ChainMetadata {
+	return NewChainMetadata(chainBlockIndex, ChainTransitionModeGovernance, data)
+}
+
+func ChainMetadataFromBytes(data []byte) (*ChainMetadata, error) {
+	sym, _, args, err := easyfl.ParseBytecodeOneLevel(data, 2)
+	if err != nil {
+		return nil, err
+	}
+	if sym != ChainMetadataName {
+		return nil, fmt.Errorf("not a chainMetadata")
+	}
+	d := easyfl.StripDataPrefix(args[0])
+	if len(d) != 2 {
+		return nil, fmt.Errorf("can't parse chainMetadata")
+	}
+	return NewChainMetadata(d[0], d[1], easyfl.StripDataPrefix(args[1])), nil
+}
+
+func (m *ChainMetadata) source() string {
+	return fmt.Sprintf(chainMetadataTemplate,
+		hex.EncodeToString([]byte{m.ChainBlockIndex, m.Mode}), hex.EncodeToString(m.Data))
+}
+
+func (m *ChainMetadata) Bytes() []byte {
+	return mustBinFromSource(m.source())
+}
+
+func (m *ChainMetadata) Name() string {
+	return ChainMetadataName
+}
+
+func (m *ChainMetadata) String() string {
+	return m.source()
+}
+
+func initChainMetadataConstraint() {
+	easyfl.EmbedLong("hasChainMetadataPredecessor", 4, evalHasChainMetadataPredecessor)
+
+	MustRegisterConstraint(&ConstraintDefinition{
+		Name:   ChainMetadataName,
+		Source: chainMetadataSource,
+		Parser: func(data []byte) (Constraint, error) {
+			return ChainMetadataFromBytes(data)
+		},
+	})
+
+	example := NewChainGovernanceMetadata(4, []byte("metadata"))
+	back, err := ChainMetadataFromBytes(example.Bytes())
+	easyfl.AssertNoError(err)
+	easyfl.Assert(back.ChainBlockIndex == 4, "inconsistency "+ChainMetadataName)
+	easyfl.Assert(back.Mode == ChainTransitionModeGovernance, "inconsistency "+ChainMetadataName)
+	easyfl.Assert(bytes.Equal(back.Data, []byte("metadata")), "inconsistency "+ChainMetadataName)
+}
+
+// arg 0 - consumed predecessor output of the chain
+// arg 1 - unlock parameters of the predecessor output
+// arg 2 - block index of the produced metadata
+// arg 3 - transition mode of the produced metadata
+// Returns non-empty value if the predecessor contains metadata with the same mode, which points
+// to the produced metadata with its unlock parameters
+func evalHasChainMetadataPredecessor(ctx *easyfl.CallParams) []byte {
+	selfBlockIdx := ctx.Arg(2)
+	mode := ctx.Arg(3)
+	if len(selfBlockIdx) != 1 || len(mode) != 1 {
+		ctx.Trace("evalHasChainMetadataPredecessor: wrong arguments")
+		return nil
+	}
+	unlockBlock := lazyslice.ArrayFromBytes(ctx.Arg(1), 256)
+	found := false
+	lazyslice.ArrayFromBytes(ctx.Arg(0), 256).ForEach(func(i int, constr []byte) bool {
+		md, err := ChainMetadataFromBytes(constr)
+		if err != nil || md.Mode != mode[0] {
+			return true
+		}
+		found = i < unlockBlock.NumElements() && bytes.Equal(unlockBlock.At(i), selfBlockIdx)
+		return !found
+	})
+	if !found {
+		ctx.Trace("evalHasChainMetadataPredecessor: metadata with mode %d has no predecessor", mode[0])
+		return nil
+	}
+	return []byte{0xff}
+}
+
+const chainMetadataSource = `
+
+// $0 - 1-byte block index of the chain constraint in the consumed output
+// returns successor of the self metadata, referenced by the unlock parameters in the chain successor output
+func chainMetadataSuccessor : producedConstraintByIndex(
+	concat(
+		byte(selfSiblingUnlockBlock($0), 0), // chain successor output index
+		byte(selfUnlockParameters, 0)        // successor metadata block index
+	)
+)
+
+// $0 - 2 bytes: [0] is block index of the sibling chain constraint, [1] is transition mode
+// returns non-empty value if the produced metadata can be added or changed by the chain transition:
+// on the chain origin, in the transition with the same mode, or if the metadata is a successor of the consumed one.
+// The consumed predecessor then enforces the metadata is not changed
+func validChainMetadataTransition : or(
+	equal(parseBytecodePrefix(selfSiblingConstraint(byte($0,0))), #chainInit),
+	equal(transitionMode(parseBytecodeArg(selfSiblingConstraint(byte($0,0)), #chain, 0)), byte($0,1)),
+	hasChainMetadataPredecessor(
+		consumedOutputByIndex(byte(predecessorConstraintIndex(parseBytecodeArg(selfSiblingConstraint(byte($0,0)), #chain, 0)), 0)),
+		unlockParamsByIndex(byte(predecessorConstraintIndex(parseBytecodeArg(selfSiblingConstraint(byte($0,0)), #chain, 0)), 0)),
+		selfBlockIndex,
+		byte($0,1)
+	)
+)
+
+// constraint 'chainMetadata(m, d)'
+// $0 - 2 bytes: [0] is block index of the sibling chain constraint, [1] is transition mode
+// $1 - metadata
+func chainMetadata : or(
+	and(
+		selfIsProducedOutput,
+		equal(len8($0), 2),
+		isChainConstraint(selfSiblingConstraint(byte($0,0))),
+		lessThan(byte($0,1), 2), // only state and governance modes
+		validChainMetadataTransition($0)
+	),
+	and(
+		selfIsConsumedOutput,
+		or(
+			// chain is destroyed, metadata is not enforced
+			equal(selfSiblingUnlockBlock(byte($0,0)), destroyUnlockParams),
+			and(
+				// the successor must be bound to the chain successor with the same mode
+				equal(
+					parseBytecodeArg(chainMetadataSuccessor(byte($0,0)), selfBytecodePrefix, 0),
+					concat(byte(selfSiblingUnlockBlock(byte($0,0)), 1), byte($0,1))
+				),
+				or(
+					// the metadata can be changed only in the transition with the same mode
+					equal(siblingChainTransitionMode(byte($0,0)), byte($0,1)),
+					equal(parseBytecodeArg(chainMetadataSuccessor(byte($0,0)), selfBytecodePrefix, 1), $1)
+				)
+			)
+		)
+	),
+	!!!chainMetadata_constraint_failed
+)
+`
@@ -68,7 +68,13 @@ func (cs *CommitToSibling) String() string {
 }
 
 func initCommitToSiblingConstraint() {
-	easyfl.MustExtendMany(CommitToSiblingSource)
+	MustRegisterConstraint(&ConstraintDefinition{
+		Name:   CommitToSiblingName,
+		Source: CommitToSiblingSource,
+		Parser: func(data []byte) (Constraint, error) {
+			return CommitToSiblingFromBytes(data)
+		},
+	})
 
 	h := blake2b.Sum256([]byte("just data"))
 	example := NewCommitToSibling(2, h[:])
@@ -76,13 +82,6 @@ func initCommitToSiblingConstraint() {
 	easyfl.AssertNoError(err)
 	easyfl.Assert(csBack.SiblingIndex == 2, "inconsistency "+CommitToSiblingName)
 	easyfl.Assert(bytes.Equal(csBack.SiblingHash, h[:]), "inconsistency "+CommitToSiblingName)
-
-	prefix, err := easyfl.ParseBytecodePrefix(example.Bytes())
-	easyfl.AssertNoError(err)
-
-	registerConstraint(CommitToSiblingName, prefix, func(data []byte) (Constraint, error) {
-		return CommitToSiblingFromBytes(data)
-	})
 }
 
 const CommitToSiblingSource = `
@@ -31,44 +31,104 @@ type (
 
 	Parser func([]byte) (Constraint, error)
 
+	// ConstraintDefinition defines the constraint for the registry. Constraints in the registry are recognized
+	// by the call prefix of their bytecode
+	ConstraintDefinition struct {
+		// Name is the name of the EasyFL function of the constraint
+		Name string
+		// Source is the EasyFL source which extends the library with the constraint function and auxiliary functions.
+		// It can be empty if the library already contains the constraint function
+		Source string
+		// Parser parses the constraint from its bytecode
+		Parser Parser
+		// IsLock marks the constraint as a lock. The parsed constraint must implement Lock
+		IsLock bool
+		// IsAccountable marks the constraint as an account, which can be embedded into other locks.
+		// The parsed constraint must implement Accountable
+		IsAccountable bool
+		// IndexableTags is an optional hook, which returns accounts the output locked by the lock is indexed by.
+		// If nil, IndexableTags of the parsed lock is used
+		IndexableTags func(data []byte) ([]Accountable, error)
+		// String is an optional hook, which returns string form of the constraint.
+		// If nil, String of the parsed constraint is used
+		String func(data []byte) string
+	}
+
 	constraintRecord struct {
-		name   string
+		*ConstraintDefinition
 		prefix []byte
-		parser Parser
 	}
 )
 
 var (
 	constraintByPrefix = make(map[string]*constraintRecord)
-	constraintNames    = make(map[string]struct{})
+	constraintByName   = make(map[string]*constraintRecord)
 )
 
-func registerConstraint(name string, prefix []byte, parser Parser) {
-	_, already := constraintNames[name]
-	easyfl.Assert(!already, "repeating constraint name '%s'", name)
-	_, already = constraintByPrefix[string(prefix)]
-	easyfl.Assert(!already, "repeating constraint prefix %s with name '%s'", easyfl.Fmt(prefix), name)
-	easyfl.Assert(0 < len(prefix) && len(prefix) <= 2, "wrong constraint prefix %s, name: %s", easyfl.Fmt(prefix), name)
-	constraintByPrefix[string(prefix)] = &constraintRecord{
-		name:   name,
-		prefix: common.Concat(prefix),
-		parser: parser,
-	}
-	constraintNames[name] = struct{}{}
+// RegisterConstraint extends the EasyFL library with the source of the constraint and puts the constraint
+// into the registry. Constraints from other packages are registered the same way as the standard ones.
+// The prefix of the constraint is known only after the library is extended, and the extension can't be undone.
+// So the error returned after the extension leaves the library inconsistent with the registry and must be
+// treated as fatal, as in MustRegisterConstraint
+func RegisterConstraint(def *ConstraintDefinition) error {
+	if def.Name == "" || def.Parser == nil {
+		return fmt.Errorf("constraint name and parser must be provided")
+	}
+	if _, already := constraintByName[def.Name]; already {
+		return fmt.Errorf("repeating constraint name '%s'", def.Name)
+	}
+	if def.Source != "" {
+		// the new function can't have the prefix of the existing one
+		if _, _, _, err := easyfl.CompileExpression("#" + def.Name); err == nil {
+			return fmt.Errorf("constraint '%s' is already in the library", def.Name)
+		}
+		if err := easyfl.ExtendMany(def.Source); err != nil {
+			return fmt.Errorf("failed to extend library with constraint '%s': %v", def.Name, err)
+		}
+	}
+	// function call prefix literal is compiled as data
+	_, _, prefixBin, err := easyfl.CompileExpression("#" + def.Name)
+	if err != nil {
+		return fmt.Errorf("constraint '%s' is not in the library: %v", def.Name, err)
+	}
+	prefix := easyfl.StripDataPrefix(prefixBin)
+	if _, already := constraintByPrefix[string(prefix)]; already {
+		return fmt.Errorf("repeating constraint prefix %s with name '%s'", easyfl.Fmt(prefix), def.Name)
+	}
+	if len(prefix) == 0 || len(prefix) > 2 {
+		return fmt.Errorf("wrong constraint prefix %s, name: %s", easyfl.Fmt(prefix), def.Name)
+	}
+	rec := &constraintRecord{
+		ConstraintDefinition: def,
+		prefix:               common.Concat(prefix),
+	}
+	constraintByPrefix[string(prefix)] = rec
+	constraintByName[def.Name] = rec
+	return nil
+}
+
+func MustRegisterConstraint(def *ConstraintDefinition) {
+	err := RegisterConstraint(def)
+	easyfl.AssertNoError(err)
 }
 
 func NameByPrefix(prefix []byte) (string, bool) {
 	if ret, found := constraintByPrefix[string(prefix)]; found {
-		return ret.name, true
+		return ret.Name, true
 	}
 	return "", false
 }
 
-func parserByPrefix(prefix []byte) (Parser, bool) {
-	if ret, found := constraintByPrefix[string(prefix)]; found {
-		return ret.parser, true
+func recordFromBytes(data []byte) (*constraintRecord, error) {
+	prefix, err := easyfl.ParseBytecodePrefix(data)
+	if err != nil {
+		return nil, err
 	}
-	return nil, false
+	ret, found := constraintByPrefix[string(prefix)]
+	if !found {
+		return nil, fmt.Errorf("unknown constraint with prefix '%s'", easyfl.Fmt(prefix))
+	}
+	return ret, nil
 }
 
 func mustBinFromSource(src string) []byte {
@@ -92,49 +152,82 @@ func FromBytes(data []byte) (Constraint, error) {
 	if err != nil {
 		return nil, err
 	}
-	parser, ok := parserByPrefix(prefix)
+	rec, ok := constraintByPrefix[string(prefix)]
 	if ok {
-		return parser(data)
+		return rec.Parser(data)
 	}
 	return NewGeneralScript(data), nil
 }
 
+// StringFromBytes returns string form of the constraint, provided by the registry
+func StringFromBytes(data []byte) (string, error) {
+	if rec, err := recordFromBytes(data); err == nil && rec.String != nil {
+		return rec.String(data), nil
+	}
+	c, err := FromBytes(data)
+	if err != nil {
+		return "", err
+	}
+	return c.String(), nil
+}
+
 func (acc AccountID) Bytes() []byte {
 	return acc
 }
 
 func LockFromBytes(data []byte) (Lock, error) {
-	prefix, err := easyfl.ParseBytecodePrefix(data)
+	rec, err := recordFromBytes(data)
 	if err != nil {
 		return nil, err
 	}
-	name, ok := NameByPrefix(prefix)
-	if !ok {
-		return nil, fmt.Errorf("unknown constraint with prefix '%s'", easyfl.Fmt(prefix))
+	if !rec.IsLock {
+		return nil, fmt.Errorf("not a lock constraint '%s'", rec.Name)
+	}
+	c, err := rec.Parser(data)
+	if err != nil {
+		return nil, err
 	}
-	switch name {
-	case addressED25519Name:
-		return AddressED25519FromBytes(data)
-	case deadlineLockName:
-		return DeadlineLockFromBytes(data)
-	case ChainLockName:
-		return ChainLockFromBytes(data)
+	ret, ok := c.(Lock)
+	if !ok {
+		return nil, fmt.Errorf("constraint '%s' does not implement Lock", rec.Name)
 	}
-	return nil, fmt.Errorf("not a lock constraint '%s'", name)
+	return ret, nil
 }
 
 func AccountableFromBytes(data []byte) (Accountable, error) {
-	prefix, err := easyfl.ParseBytecodePrefix(data)
+	rec, err := recordFromBytes(data)
+	if err != nil {
+		return nil, err
+	}
+	if !rec.IsAccountable {
+		return nil, fmt.Errorf("not a indexable constraint '%s'", rec.Name)
+	}
+	c, err := rec.Parser(data)
 	if err != nil {
 		return nil, err
 	}
-	name, ok := NameByPrefix(prefix)
+	ret, ok := c.(Accountable)
 	if !ok {
-		return nil, fmt.Errorf("unknown constraint with prefix '%s'", easyfl.Fmt(prefix))
+		return nil, fmt.Errorf("constraint '%s' does not implement Accountable", rec.Name)
+	}
+	return ret, nil
+}
+
+// IndexableTagsFromBytes returns accounts the output locked with the lock is indexed by
+func IndexableTagsFromBytes(data []byte) ([]Accountable, error) {
+	rec, err := recordFromBytes(data)
+	if err != nil {
+		return nil, err
+	}
+	if !rec.IsLock {
+		return nil, fmt.Errorf("not a lock constraint '%s'", rec.Name)
+	}
+	if rec.IndexableTags != nil {
+		return rec.IndexableTags(data)
 	}
-	switch name {
-	case addressED25519Name:
-		return AddressED25519FromBytes(data)
+	lock, err := LockFromBytes(data)
+	if err != nil {
+		return nil, err
 	}
-	return nil, fmt.Errorf("not a indexable constraint '%s'", name)
+	return lock.IndexableTags(), nil
 }
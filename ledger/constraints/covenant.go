@@ -0,0 +1,230 @@
+package constraints
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"math"
+
+	"github.com/lunfardo314/easyfl"
+	"github.com/lunfardo314/easyutxo/lazyslice"
+	"golang.org/x/crypto/blake2b"
+)
+
+// Covenant constraint restricts where tokens of the output can be spent to. It commits to the whitelist of locks,
+// the blake2b hash of the serialized whitelist. When the output is consumed, its unlock parameters reveal the whitelist
+// and name produced outputs which receive the tokens. Each named output must be locked with the lock from the whitelist.
+// The named outputs must hold together at least the amount and native tokens of all consumed outputs with the same covenant.
+// The named outputs can't be named by other covenants, orders, storage deposit returns or Merkle claims of the transaction.
+// For example, whitelist with the chain lock of the vault makes the tokens to go only back to the vault
+
+type Covenant struct {
+	WhitelistHash [32]byte
+}
+
+const (
+	CovenantName     = "covenant"
+	covenantTemplate = CovenantName + "(0x%s)"
+)
+
+func NewCovenant(whitelist ...Lock) *Covenant {
+	return &Covenant{
+		WhitelistHash: blake2b.Sum256(CovenantWhitelistBytes(whitelist...)),
+	}
+}
+
+// CovenantWhitelistBytes serializes the whitelist of locks as lazy array
+func CovenantWhitelistBytes(whitelist ...Lock) []byte {
+	arr := lazyslice.EmptyArray(256)
+	for _, lock := range whitelist {
+		arr.Push(lock.Bytes())
+	}
+	return arr.Bytes()
+}
+
+// NewCovenantUnlockParams makes unlock parameters of the covenant: the whitelist and indices of the produced outputs
+func NewCovenantUnlockParams(whitelist []Lock, outputIndices ...byte) []byte {
+	return lazyslice.MakeArrayFromData(CovenantWhitelistBytes(whitelist...), outputIndices).Bytes()
+}
+
+func CovenantFromBytes(data []byte) (*Covenant, error) {
+	sym, _, args, err := easyfl.ParseBytecodeOneLevel(data, 1)
+	if err != nil {
+		return nil, err
+	}
+	if sym != CovenantName {
+		return nil, fmt.Errorf("not a covenant")
+	}
+	hash := easyfl.StripDataPrefix(args[0])
+	if len(hash) != 32 {
+		return nil, fmt.Errorf("wrong whitelist hash")
+	}
+	ret := &Covenant{}
+	copy(ret.WhitelistHash[:], hash)
+	return ret, nil
+}
+
+func (c *Covenant) source() string {
+	return fmt.Sprintf(covenantTemplate, hex.EncodeToString(c.WhitelistHash[:]))
+}
+
+func (c *Covenant) Bytes() []byte {
+	return mustBinFromSource(c.source())
+}
+
+func (c *Covenant) Name() string {
+	return CovenantName
+}
+
+func (c *Covenant) String() string {
+	return c.source()
+}
+
+func initCovenantConstraint() {
+	easyfl.EmbedLong("validCovenant", 5, evalValidCovenant)
+
+	MustRegisterConstraint(&ConstraintDefinition{
+		Name:   CovenantName,
+		Source: covenantSource,
+		Parser: func(data []byte) (Constraint, error) {
+			return CovenantFromBytes(data)
+		},
+	})
+
+	example := NewCovenant(AddressED25519Null(), ChainLockNull())
+	back, err := CovenantFromBytes(example.Bytes())
+	easyfl.AssertNoError(err)
+	easyfl.Assert(back.WhitelistHash == example.WhitelistHash, "inconsistency "+CovenantName)
+}
+
+// addTokensOfOutput adds the amount of the output to sums with the empty key and amounts of native tokens
+// of the output to sums with the token ID as the key
+func addTokensOfOutput(sums map[string]uint64, out *lazyslice.Array) error {
+	add := func(key string, amount uint64) error {
+		if amount > math.MaxUint64-sums[key] {
+			return fmt.Errorf("amount overflow")
+		}
+		sums[key] += amount
+		return nil
+	}
+	amount, err := AmountFromBytes(out.At(int(ConstraintIndexAmount)))
+	if err != nil {
+		return err
+	}
+	if err = add("", uint64(amount)); err != nil {
+		return err
+	}
+	out.ForEach(func(i int, data []byte) bool {
+		if i <= int(ConstraintIndexLock) {
+			return true
+		}
+		nt, errParse := NativeTokenFromBytes(data)
+		if errParse != nil {
+			return true
+		}
+		err = add(string(nt.TokenID[:]), nt.Amount)
+		return err == nil
+	})
+	return err
+}
+
+// arg 0 - whitelist of locks, serialized lazy array
+// arg 1 - indices of produced outputs, 1 byte each
+// arg 2 - all produced outputs, serialized lazy array
+// arg 3 - all consumed outputs, serialized lazy array
+// arg 4 - bytes of the covenant constraint
+// Returns non-empty value if all indexed produced outputs are locked with whitelisted locks and together hold
+// at least the amount and native tokens of all consumed outputs with the covenant
+func evalValidCovenant(ctx *easyfl.CallParams) []byte {
+	whitelist := make(map[string]struct{})
+	lazyslice.ArrayFromBytes(ctx.Arg(0), 256).ForEach(func(_ int, lock []byte) bool {
+		whitelist[string(lock)] = struct{}{}
+		return true
+	})
+	produced := lazyslice.ArrayFromBytes(ctx.Arg(2), 256)
+	indices := ctx.Arg(1)
+	if len(indices) == 0 {
+		ctx.Trace("evalValidCovenant: no produced outputs")
+		return nil
+	}
+	seen := make(map[byte]struct{})
+	sumProduced := make(map[string]uint64)
+	for _, idx := range indices {
+		if _, already := seen[idx]; already {
+			ctx.Trace("evalValidCovenant: repeating output index %d", idx)
+			return nil
+		}
+		seen[idx] = struct{}{}
+		out := lazyslice.ArrayFromBytes(produced.At(int(idx)), 256)
+		if _, ok := whitelist[string(out.At(int(ConstraintIndexLock)))]; !ok {
+			ctx.Trace("evalValidCovenant: lock of the produced output %d is not in the whitelist", idx)
+			return nil
+		}
+		if err := addTokensOfOutput(sumProduced, out); err != nil {
+			ctx.Trace("evalValidCovenant: wrong amount in the produced output %d: %v", idx, err)
+			return nil
+		}
+	}
+	self := ctx.Arg(4)
+	sumConsumed := make(map[string]uint64)
+	var errMsg string
+	lazyslice.ArrayFromBytes(ctx.Arg(3), 256).ForEach(func(i int, data []byte) bool {
+		out := lazyslice.ArrayFromBytes(data, 256)
+		hasCovenant := false
+		out.ForEach(func(_ int, constr []byte) bool {
+			hasCovenant = bytes.Equal(constr, self)
+			return !hasCovenant
+		})
+		if hasCovenant {
+			if err := addTokensOfOutput(sumConsumed, out); err != nil {
+				errMsg = fmt.Sprintf("wrong amount in the consumed output %d: %v", i, err)
+				return false
+			}
+		}
+		return true
+	})
+	if len(errMsg) > 0 {
+		ctx.Trace("evalValidCovenant: %s", errMsg)
+		return nil
+	}
+	for key, sum := range sumConsumed {
+		if sumProduced[key] < sum {
+			ctx.Trace("evalValidCovenant: produced outputs hold %d, consumed outputs with the covenant %d of the token '%s'",
+				sumProduced[key], sum, hex.EncodeToString([]byte(key)))
+			return nil
+		}
+	}
+	return []byte{0xff}
+}
+
+const covenantSource = `
+
+// constraint covenant($0)
+// $0 - blake2b hash of the whitelist of locks
+// Unlock parameters: lazy array of 2 elements: the whitelist and indices of produced outputs
+func covenant: or(
+	and(
+		selfIsProducedOutput,
+		equal(len8($0), 32)
+	),
+	and(
+		selfIsConsumedOutput,
+		equal($0, blake2b(@Array8(selfUnlockParameters, 0))),
+		validCovenant(
+			@Array8(selfUnlockParameters, 0),
+			@Array8(selfUnlockParameters, 1),
+			@Path(pathToProducedOutputs),
+			@Path(pathToConsumedOutputs),
+			self
+		),
+		// the produced outputs can't be counted by other covenants or other constraints of the transaction
+		uniqueProducedOutputs(
+			selfOutputIndex,
+			selfBlockIndex,
+			@Path(pathToConsumedOutputs),
+			@Path(pathToUnlockParams)
+		)
+	),
+	!!!covenant_constraint_failed
+)
+`
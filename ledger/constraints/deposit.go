@@ -1,7 +1,67 @@
 package constraints
 
-// TODO propper calculation of the storage deposit
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+)
 
-func MinimumStorageDeposit(outputByteSize, extraWeight uint32) uint64 {
-	return uint64(outputByteSize)
+// StorageDepositParams are ledger-level parameters of the storage deposit model. They are set in the genesis
+// of the ledger. The size of the output is measured in virtual bytes (vBytes):
+// - each byte of the output data weights DataWeight vBytes
+// - the key of the output in the ledger state weights KeyWeight vBytes
+// - each unit of the extra weight, returned by constraints as 4-byte value, weights ExtraWeight vBytes
+// One vByte costs VByteCost tokens
+type StorageDepositParams struct {
+	VByteCost   uint16
+	DataWeight  uint16
+	KeyWeight   uint16
+	ExtraWeight uint16
+}
+
+const storageDepositParamsSize = 8
+
+// DefaultStorageDepositParams minimum storage deposit is equal to the byte size of the output plus extra weight
+func DefaultStorageDepositParams() *StorageDepositParams {
+	return &StorageDepositParams{
+		VByteCost:   1,
+		DataWeight:  1,
+		KeyWeight:   0,
+		ExtraWeight: 1,
+	}
+}
+
+func StorageDepositParamsFromBytes(data []byte) (*StorageDepositParams, error) {
+	if len(data) != storageDepositParamsSize {
+		return nil, fmt.Errorf("wrong data length of storage deposit parameters")
+	}
+	return &StorageDepositParams{
+		VByteCost:   binary.BigEndian.Uint16(data[0:2]),
+		DataWeight:  binary.BigEndian.Uint16(data[2:4]),
+		KeyWeight:   binary.BigEndian.Uint16(data[4:6]),
+		ExtraWeight: binary.BigEndian.Uint16(data[6:8]),
+	}, nil
+}
+
+func (p *StorageDepositParams) Bytes() []byte {
+	var ret [storageDepositParamsSize]byte
+	binary.BigEndian.PutUint16(ret[0:2], p.VByteCost)
+	binary.BigEndian.PutUint16(ret[2:4], p.DataWeight)
+	binary.BigEndian.PutUint16(ret[4:6], p.KeyWeight)
+	binary.BigEndian.PutUint16(ret[6:8], p.ExtraWeight)
+	return ret[:]
+}
+
+func (p *StorageDepositParams) String() string {
+	return fmt.Sprintf("vByteCost: %d, dataWeight: %d, keyWeight: %d, extraWeight: %d",
+		p.VByteCost, p.DataWeight, p.KeyWeight, p.ExtraWeight)
+}
+
+// MinimumStorageDeposit calculates minimum storage deposit of the output. Saturates at math.MaxUint64
+func (p *StorageDepositParams) MinimumStorageDeposit(outputByteSize, extraWeight uint32) uint64 {
+	vBytes := uint64(p.DataWeight)*uint64(outputByteSize) + uint64(p.KeyWeight) + uint64(p.ExtraWeight)*uint64(extraWeight)
+	if p.VByteCost != 0 && vBytes > math.MaxUint64/uint64(p.VByteCost) {
+		return math.MaxUint64
+	}
+	return vBytes * uint64(p.VByteCost)
 }
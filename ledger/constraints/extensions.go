@@ -1,6 +1,7 @@
 package constraints
 
 import (
+	"encoding/binary"
 	"fmt"
 
 	"github.com/lunfardo314/easyfl"
@@ -23,11 +24,12 @@ Constants which define validation context data tree branches. Structure of the d
        -- TxUnlockParams = 0x00 (path 0x0000)  -- contains unlock params for each input
        -- TxInputIDs = 0x01     (path 0x0001)  -- contains up to 256 inputs, the IDs of consumed outputs
        -- TxOutputBranch = 0x02       (path 0x0002)  -- contains up to 256 produced outputs
-       -- TxSignature = 0x03          (path 0x0003)  -- contains the only signature of the essence. It is mandatory
+       -- TxSignatures = 0x03         (path 0x0003)  -- contains up to 256 signatures of the essence. At least one is mandatory
        -- TxTimestamp = 0x04          (path 0x0004)  -- mandatory timestamp of the transaction, Unix seconds
        -- TxInputCommitment = 0x05    (path 0x0005)  -- blake2b hash of the all consumed outputs (which are under path 0x1000)
        -- TxEndorsements = 0x06       (path 0x0006)  -- list of transaction IDs of endorsed transaction
        -- TxLocalLibraries = 0x07     (path 0x0007)  -- list of local libraries in its binary form
+       -- TxFee = 0x08                (path 0x0008)  -- mandatory 8-byte big-endian amount of tokens burned by the transaction as a fee
   -- ConsumedBranch = 0x01
        -- ConsumedOutputsBranch = 0x00 (path 0x0100) -- all consumed outputs, up to 256
 
@@ -49,11 +51,12 @@ const (
 	TxUnlockParams = byte(iota)
 	TxInputIDs
 	TxOutputs
-	TxSignature
+	TxSignatures
 	TxTimestamp
 	TxInputCommitment
 	TxEndorsements
 	TxLocalLibraries
+	TxFee
 	TxTreeIndexMax
 )
 
@@ -66,11 +69,12 @@ var (
 	PathToProducedOutputs = lazyslice.Path(TransactionBranch, TxOutputs)
 	PathToUnlockParams    = lazyslice.Path(TransactionBranch, TxUnlockParams)
 	PathToInputIDs        = lazyslice.Path(TransactionBranch, TxInputIDs)
-	PathToSignature       = lazyslice.Path(TransactionBranch, TxSignature)
+	PathToSignatures      = lazyslice.Path(TransactionBranch, TxSignatures)
 	PathToInputCommitment = lazyslice.Path(TransactionBranch, TxInputCommitment)
 	PathToEndorsements    = lazyslice.Path(TransactionBranch, TxEndorsements)
 	PathToLocalLibraries  = lazyslice.Path(TransactionBranch, TxLocalLibraries)
 	PathToTimestamp       = lazyslice.Path(TransactionBranch, TxTimestamp)
+	PathToFee             = lazyslice.Path(TransactionBranch, TxFee)
 )
 
 // Mandatory output block indices
@@ -93,25 +97,43 @@ func init() {
 	// returns data bytes at the given path of the data context (lazy tree)
 	easyfl.EmbedShort("@Path", 1, evalAtPath)
 
-	// gives a vByte cost as big-endian uint16
-	easyfl.Extend("vbCost16", "u16/1")
+	// storage deposit parameters of the ledger as big-endian uint16. They are taken from the data context
+	easyfl.EmbedShort("vbCost16", 0, evalVBCost16, true)
+	easyfl.EmbedShort("vbDataWeight16", 0, evalVBDataWeight16, true)
+	easyfl.EmbedShort("vbKeyWeight16", 0, evalVBKeyWeight16, true)
+	// identity of the ledger. It is taken from the data context
+	easyfl.EmbedShort("ledgerIdentity", 0, evalLedgerIdentity, true)
 
 	// @Array8 interprets $0 as serialized LazyArray with max 256 elements. Takes the $1 element of it. $1 is expected 1-byte long
 	easyfl.EmbedLong("@Array8", 2, evalAtArray8)
 
 	easyfl.EmbedLong("callLocalLibrary", -1, evalCallLocalLibrary)
 
+	// validSignatureP256(msg, sig, pubKey) verifies ECDSA P-256 signature of SHA-256 hash of the message.
+	// sig is 64 bytes r || s, pubKey is 33 bytes compressed public key
+	easyfl.EmbedLong("validSignatureP256", 3, evalValidSignatureP256)
+
+	// merkleRoot(leaf, index, proof) computes the Merkle root from the 32-byte leaf hash, uint16 index of the leaf
+	// and the proof: 32-byte hashes of siblings from the leaf to the root
+	easyfl.EmbedLong("merkleRoot", 3, evalMerkleRoot)
+
+	// uniqueProducedOutputs(outputIdx, blockIdx, consumedOutputs, unlockParams) checks that produced outputs named by
+	// the constraint of the consumed output are not named by order fill, storage deposit return, covenant or
+	// Merkle claim constraints of other consumed outputs
+	easyfl.EmbedLong("uniqueProducedOutputs", 4, evalUniqueProducedOutputs)
+
 	// path constants
 	easyfl.Extend("pathToTransaction", fmt.Sprintf("%d", TransactionBranch))
 	easyfl.Extend("pathToConsumedOutputs", fmt.Sprintf("0x%s", PathToConsumedOutputs.Hex()))
 	easyfl.Extend("pathToProducedOutputs", fmt.Sprintf("0x%s", PathToProducedOutputs.Hex()))
 	easyfl.Extend("pathToUnlockParams", fmt.Sprintf("0x%s", PathToUnlockParams.Hex()))
 	easyfl.Extend("pathToInputIDs", fmt.Sprintf("0x%s", PathToInputIDs.Hex()))
-	easyfl.Extend("pathToSignature", fmt.Sprintf("0x%s", PathToSignature.Hex()))
+	easyfl.Extend("pathToSignatures", fmt.Sprintf("0x%s", PathToSignatures.Hex()))
 	easyfl.Extend("pathToInputCommitment", fmt.Sprintf("0x%s", PathToInputCommitment.Hex()))
 	easyfl.Extend("pathToEndorsements", fmt.Sprintf("0x%s", PathToEndorsements.Hex()))
 	easyfl.Extend("pathToLocalLibrary", fmt.Sprintf("0x%s", PathToLocalLibraries.Hex()))
 	easyfl.Extend("pathToTimestamp", fmt.Sprintf("0x%s", PathToTimestamp.Hex()))
+	easyfl.Extend("pathToFee", fmt.Sprintf("0x%s", PathToFee.Hex()))
 
 	// mandatory block indices in the output
 	easyfl.Extend("amountBlockIndex", fmt.Sprintf("%d", ConstraintIndexAmount))
@@ -150,10 +172,18 @@ func init() {
 	// special transaction related
 
 	easyfl.Extend("txBytes", "@Path(pathToTransaction)")
-	easyfl.Extend("txID", "blake2b(txBytes)")
-	easyfl.Extend("txSignature", "@Path(pathToSignature)")
+	easyfl.Extend("txSignatures", "@Path(pathToSignatures)")
+	// signature by 1-byte index $0
+	easyfl.Extend("txSignatureByIndex", "@Array8(txSignatures, $0)")
+	// the first signature is the signature of the sender
+	easyfl.Extend("txSignature", "txSignatureByIndex(0)")
 	easyfl.Extend("txTimestampBytes", "@Path(pathToTimestamp)")
-	easyfl.Extend("txEssenceBytes", "concat(@Path(pathToInputIDs), @Path(pathToProducedOutputs), @Path(pathToInputCommitment))") // timestamp is not a part of the essence
+	easyfl.Extend("txFeeBytes", "@Path(pathToFee)")
+	// the fee, the timestamp, endorsements and local libraries are signed, so they can't be changed after signing.
+	// The identity of the ledger is signed too, so the transaction can't be replayed on another ledger
+	easyfl.Extend("txEssenceBytes", "concat(@Path(pathToInputIDs), @Path(pathToProducedOutputs), @Path(pathToInputCommitment), @Path(pathToEndorsements), @Path(pathToLocalLibrary), @Path(pathToFee), @Path(pathToTimestamp), ledgerIdentity)")
+	// transaction ID is the hash of the essence. It does not depend on unlock parameters and signatures
+	easyfl.Extend("txID", "blake2b(txEssenceBytes)")
 
 	// functions with prefix 'self' are invocation context specific, i.e. they use function '@' to calculate
 	// local values which depend on the invoked constraint
@@ -190,6 +220,10 @@ func init() {
 	// returns unlock block of the sibling
 	easyfl.Extend("selfSiblingUnlockBlock", "@Array8(@Path(concat(pathToUnlockParams, selfOutputIndex)), $0)")
 
+	// auxiliary unlock parameters of the lock. They are located in the unlock block at the timestamp block index,
+	// because unlock parameters of the lock block may be occupied by the embedded lock
+	easyfl.Extend("selfLockAuxUnlockParameters", "selfSiblingUnlockBlock(timestampBlockIndex)")
+
 	// returns selfUnlockParameters if blake2b hash of it is equal to the given hash, otherwise nil
 	easyfl.Extend("selfHashUnlock", "if(equal($0, blake2b(selfUnlockParameters)),selfUnlockParameters,nil)")
 
@@ -197,19 +231,42 @@ func init() {
 	easyfl.Extend("signatureED25519", "slice($0, 0, 63)")
 	// takes ED25519 public key from full signature
 	easyfl.Extend("publicKeyED25519", "slice($0, 64, 95)")
+	// signature of the P-256 scheme is prefixed with the scheme byte
+	easyfl.Extend("isSignatureP256", fmt.Sprintf("and(equal(len8($0), %d), equal(byte($0, 0), %d))", SignatureP256Size, SignatureSchemeP256))
+	// takes P-256 signature r || s from full signature
+	easyfl.Extend("signatureP256", "slice($0, 1, 64)")
+	// takes compressed P-256 public key from full signature
+	easyfl.Extend("publicKeyP256", "slice($0, 65, 97)")
 
 	// init constraints
 	initAmountConstraint()
 	initTimestampConstraint()
 	initAddressED25519Constraint()
+	initMultisigED25519Constraint()
+	initAddressP256Constraint()
+	initScriptHashLockConstraint()
 	initDeadlineLockConstraint()
+	initHTLCConstraint()
 	initTimelockConstraint()
+	initRelativeTimelockConstraint()
+	initVestingConstraint()
+	initOrderConstraint()
 	initSenderConstraint()
 	initChainConstraint()
 	initChainLockConstraint()
+	initChainControllerLockConstraint()
+	initChainMetadataConstraint()
+	initSenderChainConstraint()
+	initNFTConstraint()
 	initRoyaltiesED25519Constraint()
+	initStorageDepositReturnConstraint()
 	initImmutableConstraint()
 	initCommitToSiblingConstraint()
+	initCovenantConstraint()
+	initOracleConditionConstraint()
+	initMerkleClaimConstraint()
+	initNativeTokenConstraint()
+	initFoundryConstraint()
 
 	easyfl.PrintLibraryStats()
 }
@@ -217,13 +274,21 @@ func init() {
 // DataContext is the data structure passed to the eval call. It contains:
 // - tree: all validation context of the transaction, all data which is to be validated
 // - path: a path in the validation context of the constraint being validated in the eval call
+// - storageDepositParams: storage deposit parameters of the ledger
+// - ledgerIdentity: identity of the ledger, signed as part of the transaction essence
 type DataContext struct {
-	tree *lazyslice.Tree
-	path lazyslice.TreePath
+	tree                 *lazyslice.Tree
+	path                 lazyslice.TreePath
+	storageDepositParams *StorageDepositParams
+	ledgerIdentity       []byte
 }
 
-func NewDataContext(tree *lazyslice.Tree) *DataContext {
-	return &DataContext{tree: tree}
+func NewDataContext(tree *lazyslice.Tree, storageDepositParams *StorageDepositParams, ledgerIdentity []byte) *DataContext {
+	return &DataContext{
+		tree:                 tree,
+		storageDepositParams: storageDepositParams,
+		ledgerIdentity:       ledgerIdentity,
+	}
 }
 
 func (c *DataContext) DataTree() *lazyslice.Tree {
@@ -238,6 +303,14 @@ func (c *DataContext) SetPath(path lazyslice.TreePath) {
 	c.path = common.Concat(path.Bytes())
 }
 
+func (c *DataContext) StorageDepositParams() *StorageDepositParams {
+	return c.storageDepositParams
+}
+
+func (c *DataContext) LedgerIdentity() []byte {
+	return c.ledgerIdentity
+}
+
 func evalPath(ctx *easyfl.CallParams) []byte {
 	return ctx.DataContext().(*DataContext).Path()
 }
@@ -246,6 +319,28 @@ func evalAtPath(ctx *easyfl.CallParams) []byte {
 	return ctx.DataContext().(*DataContext).DataTree().BytesAtPath(ctx.Arg(0))
 }
 
+func evalVBCost16(ctx *easyfl.CallParams) []byte {
+	var ret [2]byte
+	binary.BigEndian.PutUint16(ret[:], ctx.DataContext().(*DataContext).StorageDepositParams().VByteCost)
+	return ret[:]
+}
+
+func evalVBDataWeight16(ctx *easyfl.CallParams) []byte {
+	var ret [2]byte
+	binary.BigEndian.PutUint16(ret[:], ctx.DataContext().(*DataContext).StorageDepositParams().DataWeight)
+	return ret[:]
+}
+
+func evalVBKeyWeight16(ctx *easyfl.CallParams) []byte {
+	var ret [2]byte
+	binary.BigEndian.PutUint16(ret[:], ctx.DataContext().(*DataContext).StorageDepositParams().KeyWeight)
+	return ret[:]
+}
+
+func evalLedgerIdentity(ctx *easyfl.CallParams) []byte {
+	return ctx.DataContext().(*DataContext).LedgerIdentity()
+}
+
 func evalAtArray8(ctx *easyfl.CallParams) []byte {
 	arr := lazyslice.ArrayFromBytes(ctx.Arg(0))
 	idx := ctx.Arg(1)
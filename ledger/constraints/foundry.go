@@ -0,0 +1,176 @@
+package constraints
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/lunfardo314/easyfl"
+	"github.com/lunfardo314/unitrie/common"
+	"golang.org/x/crypto/blake2b"
+)
+
+// Foundry constraint controls supply of the native token. It is bound to the chain constraint in the same output.
+// The token ID of the foundry is derived from the chain ID and the serial of the foundry. The serial is the timestamp
+// of the transaction which creates the foundry, so a foundry, destroyed on the chain, can't be re-created with the
+// same token ID. Transition of the foundry along the chain authorizes minting and burning of tokens, i.e. the change
+// of the circulating supply. The balance of the token between inputs and outputs of the transaction is checked by
+// the ledger against the change of the circulating supply
+
+type Foundry struct {
+	ChainBlockIndex   byte
+	CirculatingSupply uint64
+	MaxSupply         uint64
+	Serial            uint32
+}
+
+const (
+	FoundryName     = "foundry"
+	foundryTemplate = FoundryName + "(%d, u64/%d, u64/%d, u32/%d)"
+)
+
+func NewFoundry(chainBlockIndex byte, circulatingSupply, maxSupply uint64, serial uint32) *Foundry {
+	return &Foundry{
+		ChainBlockIndex:   chainBlockIndex,
+		CirculatingSupply: circulatingSupply,
+		MaxSupply:         maxSupply,
+		Serial:            serial,
+	}
+}
+
+// FoundryTokenID is token ID of the foundry with the serial, bound to the chain
+func FoundryTokenID(chainID [32]byte, serial uint32) [32]byte {
+	var serialBin [4]byte
+	binary.BigEndian.PutUint32(serialBin[:], serial)
+	return blake2b.Sum256(common.Concat(chainID[:], serialBin[:]))
+}
+
+func FoundryFromBytes(data []byte) (*Foundry, error) {
+	sym, _, args, err := easyfl.ParseBytecodeOneLevel(data, 4)
+	if err != nil {
+		return nil, err
+	}
+	if sym != FoundryName {
+		return nil, fmt.Errorf("not a foundry")
+	}
+	chainBlockIndexBin := easyfl.StripDataPrefix(args[0])
+	if len(chainBlockIndexBin) != 1 {
+		return nil, fmt.Errorf("wrong chain block index")
+	}
+	circulatingBin := easyfl.StripDataPrefix(args[1])
+	if len(circulatingBin) != 8 {
+		return nil, fmt.Errorf("wrong circulating supply")
+	}
+	maxBin := easyfl.StripDataPrefix(args[2])
+	if len(maxBin) != 8 {
+		return nil, fmt.Errorf("wrong maximum supply")
+	}
+	serialBin := easyfl.StripDataPrefix(args[3])
+	if len(serialBin) != 4 {
+		return nil, fmt.Errorf("wrong serial")
+	}
+	return NewFoundry(chainBlockIndexBin[0], binary.BigEndian.Uint64(circulatingBin), binary.BigEndian.Uint64(maxBin),
+		binary.BigEndian.Uint32(serialBin)), nil
+}
+
+func (f *Foundry) source() string {
+	return fmt.Sprintf(foundryTemplate, f.ChainBlockIndex, f.CirculatingSupply, f.MaxSupply, f.Serial)
+}
+
+func (f *Foundry) Bytes() []byte {
+	return mustBinFromSource(f.source())
+}
+
+func (f *Foundry) Name() string {
+	return FoundryName
+}
+
+func (f *Foundry) String() string {
+	return f.source()
+}
+
+func initFoundryConstraint() {
+	MustRegisterConstraint(&ConstraintDefinition{
+		Name:   FoundryName,
+		Source: foundrySource,
+		Parser: func(data []byte) (Constraint, error) {
+			return FoundryFromBytes(data)
+		},
+	})
+
+	example := NewFoundry(3, 1337, 31337, 1234)
+	back, err := FoundryFromBytes(example.Bytes())
+	easyfl.AssertNoError(err)
+	easyfl.Assert(back.ChainBlockIndex == 3, "inconsistency "+FoundryName)
+	easyfl.Assert(back.CirculatingSupply == 1337, "inconsistency "+FoundryName)
+	easyfl.Assert(back.MaxSupply == 31337, "inconsistency "+FoundryName)
+	easyfl.Assert(back.Serial == 1234, "inconsistency "+FoundryName)
+}
+
+const foundrySource = `
+// constraint foundry($0, $1, $2, $3) controls supply of the native token with ID = blake2b(chainID || $3).
+// The chain constraint must be located in the same output at block $0.
+// The mint and burn deltas of the token are the change of the circulating supply along the chain transition.
+// They are checked by the ledger against the balance of the token between inputs and outputs
+// $0 - 1-byte index of the chain constraint block in the same output
+// $1 - circulating supply, uint64 big-endian
+// $2 - maximum supply, uint64 big-endian. Can't be changed after the foundry is created
+// $3 - serial, uint32 big-endian. Can't be changed after the foundry is created. The ledger checks that the
+// serial of the created foundry is equal to the transaction timestamp
+// Unlock parameters of the consumed foundry is 1-byte index of the successor foundry block in the chain successor output.
+// The foundry can be destroyed with unlock parameters 0xff, only if circulating supply is 0
+
+// $0 - chain block index
+func foundryChainData : parseBytecodeArg(selfSiblingConstraint($0), #chain, 0)
+
+// $0 - produced chain constraint data
+// the foundry can't be on the chain origin because foundryChainData requires the chain constraint.
+// The output must be the successor the predecessor chain points to.
+// It prevents from producing more than one foundry transition of the same token in one transaction
+func foundryValidChain : equal(
+	byte(unlockParamsByConstraintIndex(predecessorConstraintIndex($0)), 0),
+	selfOutputIndex
+)
+
+// successor of the consumed foundry is in the chain successor output, at block, specified by unlock parameters
+// $0 - chain block index
+func foundrySuccessor : producedConstraintByIndex(concat(byte(selfSiblingUnlockBlock($0), 0), selfUnlockParameters))
+
+// $0 - successor foundry constraint
+// $1 - self chain block index
+// $2 - self maximum supply
+// $3 - self serial
+func validFoundrySuccessor : and(
+	// successor foundry must be bound to the successor chain constraint
+	equal(parseBytecodeArg($0, selfBytecodePrefix, 0), byte(selfSiblingUnlockBlock($1), 1)),
+	// maximum supply is immutable
+	equal(parseBytecodeArg($0, selfBytecodePrefix, 2), $2),
+	// serial is immutable
+	equal(parseBytecodeArg($0, selfBytecodePrefix, 3), $3)
+)
+
+func foundry : and(
+	lessThan(lockBlockIndex, selfBlockIndex),  // can't be at the mandatory blocks
+	or(
+		and(
+			selfIsProducedOutput,
+			equal(len8($0), 1),
+			equal(len8($1), 8),
+			equal(len8($2), 8),
+			equal(len8($3), 4),
+			lessOrEqualThan($1, $2),
+			foundryValidChain(foundryChainData($0))
+		),
+		and(
+			selfIsConsumedOutput,
+			or(
+				and(
+					equal(selfUnlockParameters, 0xff),
+					isZero($1)
+				),
+				validFoundrySuccessor(foundrySuccessor($0), $0, $2, $3)
+			)
+		),
+		!!!foundry_constraint_failed
+	)
+)
+`
@@ -58,20 +58,19 @@ func (d *Immutable) String() string {
 }
 
 func initImmutableConstraint() {
-	easyfl.MustExtendMany(ImmutableDataSource)
+	MustRegisterConstraint(&ConstraintDefinition{
+		Name:   ImmutableName,
+		Source: ImmutableDataSource,
+		Parser: func(data []byte) (Constraint, error) {
+			return ImmutableFromBytes(data)
+		},
+	})
 
 	example := NewImmutable(1, 5)
 	immutableDataBack, err := ImmutableFromBytes(example.Bytes())
 	easyfl.AssertNoError(err)
 	easyfl.Assert(immutableDataBack.DataBlockIndex == 5, "inconsistency "+ImmutableName)
 	easyfl.Assert(immutableDataBack.ChainBlockIndex == 1, "inconsistency "+ImmutableName)
-
-	prefix, err := easyfl.ParseBytecodePrefix(example.Bytes())
-	easyfl.AssertNoError(err)
-
-	registerConstraint(ImmutableName, prefix, func(data []byte) (Constraint, error) {
-		return ImmutableFromBytes(data)
-	})
 }
 
 const ImmutableDataSource = `
@@ -87,11 +86,8 @@ const ImmutableDataSource = `
 func immutable : or(
 	and(
 		selfIsProducedOutput,  // produced side
-		equal(
-			// 1st byte must point to the sibling-chain constraint
-			parseBytecodePrefix(selfSiblingConstraint(byte($0,0))), 
-			#chain
-		), 
+		// 1st byte must point to the sibling-chain constraint or the chain origin
+		isChainConstraint(selfSiblingConstraint(byte($0,0))), 
 		selfSiblingConstraint(byte($0,1))                                  // 2nd byte must point to existing non-empty block
 	),
 	and(
@@ -78,50 +78,48 @@ func (cl ChainLock) ChainID() []byte {
 	return common.Concat([]byte(cl))
 }
 
+// NewChainLockUnlockParams makes unlock parameters of the chain lock. They point to the successor
+// chain constraint in the produced output
 func NewChainLockUnlockParams(chainOutputIndex, chainConstraintIndex byte) []byte {
 	return []byte{chainOutputIndex, chainConstraintIndex}
 }
 
 func initChainLockConstraint() {
-	easyfl.MustExtendMany(ChainLockConstraintSource)
+	MustRegisterConstraint(&ConstraintDefinition{
+		Name:   ChainLockName,
+		Source: ChainLockConstraintSource,
+		Parser: func(data []byte) (Constraint, error) {
+			return ChainLockFromBytes(data)
+		},
+		IsLock:        true,
+		IsAccountable: true,
+	})
 
 	example := ChainLockNull()
 	chainLockBack, err := ChainLockFromBytes(example.Bytes())
 	easyfl.AssertNoError(err)
 	easyfl.Assert(Equal(chainLockBack, ChainLockNull()), "inconsistency "+ChainLockName)
-
-	prefix, err := easyfl.ParseBytecodePrefix(example.Bytes())
-	easyfl.AssertNoError(err)
-
-	registerConstraint(ChainLockName, prefix, func(data []byte) (Constraint, error) {
-		return ChainLockFromBytes(data)
-	})
 }
 
 const ChainLockConstraintSource = `
 
+// chain constraint data of the produced chain constraint, referenced by 2-byte unlock parameters:
+// output index and block index
 func selfReferencedChainData :
 	parseBytecodeArg(
-		consumedConstraintByIndex(selfUnlockParameters),
+		producedConstraintByIndex(selfUnlockParameters),
 		#chain,
 		0
 	)
 
-// $0 - parsed referenced chain constraint
-func selfReferencedChainIDAdjusted : if(
-	isZero($0),
-	blake2b(inputIDByIndex(byte(selfUnlockParameters, 0))),
-	$0
-)
-
 // $0 - chainID
+// $1 - referenced produced chain constraint data
 func validChainUnlock : and(
-	equal($0, selfReferencedChainIDAdjusted(slice(selfReferencedChainData,0,31))), // chain id must be equal to the referenced chain id 
-	equal(
-		// the chain must be unlocked for state transition (mode = 0) 
-		byte(unlockParamsByConstraintIndex(selfUnlockParameters),2),
-		0
-	)
+	equal($0, chainID($1)), // chain id must be equal to the referenced chain id 
+	// the chain must be transited in the state transition mode
+	equal(transitionMode($1), 0),
+	// prevent self referencing: the chain output can't be unlocked by its own transition
+	not(equal(selfOutputIndex, byte($1, 32)))
 )
 
 func chainLock : and(
@@ -133,8 +131,8 @@ func chainLock : and(
 		),
 		and(
 			selfIsConsumedOutput,
-			not(equal(selfOutputIndex, byte(selfUnlockParameters,0))), // prevent self referencing 
-			validChainUnlock($0)
+			equal(len8(selfUnlockParameters), 2),
+			validChainUnlock($0, selfReferencedChainData)
 		)
 	)
 )
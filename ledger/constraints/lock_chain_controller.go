@@ -0,0 +1,140 @@
+package constraints
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/lunfardo314/easyfl"
+)
+
+// ChainControllerLock is the lock of the chain output with two controllers. The state controller unlocks
+// state transitions of the chain and cannot change the lock. The governor unlocks governance transitions
+// and destruction of the chain and may replace the lock, for example rotate the state controller.
+// The transition mode is taken from the unlock parameters of the chain constraint at ChainBlockIndex
+type ChainControllerLock struct {
+	ChainBlockIndex byte
+	StateController Accountable
+	Governor        Accountable
+}
+
+const (
+	ChainControllerLockName     = "chainControllerLock"
+	chainControllerLockTemplate = ChainControllerLockName + "(0x%s, x/%s, x/%s)"
+)
+
+func NewChainControllerLock(chainBlockIndex byte, stateController, governor Accountable) *ChainControllerLock {
+	return &ChainControllerLock{
+		ChainBlockIndex: chainBlockIndex,
+		StateController: stateController,
+		Governor:        governor,
+	}
+}
+
+func (cl *ChainControllerLock) source() string {
+	return fmt.Sprintf(chainControllerLockTemplate,
+		hex.EncodeToString([]byte{cl.ChainBlockIndex}),
+		hex.EncodeToString(cl.StateController.AccountID()),
+		hex.EncodeToString(cl.Governor.AccountID()),
+	)
+}
+
+func (cl *ChainControllerLock) Bytes() []byte {
+	return mustBinFromSource(cl.source())
+}
+
+func (cl *ChainControllerLock) String() string {
+	return fmt.Sprintf("%s(%d,%s,%s)", ChainControllerLockName, cl.ChainBlockIndex, cl.StateController, cl.Governor)
+}
+
+func (cl *ChainControllerLock) IndexableTags() []Accountable {
+	return []Accountable{cl.StateController, cl.Governor}
+}
+
+// UnlockableWith returns true if the account is the state controller or the governor
+func (cl *ChainControllerLock) UnlockableWith(acc AccountID, _ uint32) bool {
+	return bytes.Equal(cl.StateController.AccountID(), acc) || bytes.Equal(cl.Governor.AccountID(), acc)
+}
+
+func (cl *ChainControllerLock) Name() string {
+	return ChainControllerLockName
+}
+
+func ChainControllerLockFromBytes(data []byte) (*ChainControllerLock, error) {
+	sym, _, args, err := easyfl.ParseBytecodeOneLevel(data, 3)
+	if err != nil {
+		return nil, err
+	}
+	if sym != ChainControllerLockName {
+		return nil, fmt.Errorf("not a chainControllerLock")
+	}
+	idxBin := easyfl.StripDataPrefix(args[0])
+	if len(idxBin) != 1 {
+		return nil, fmt.Errorf("wrong chain block index")
+	}
+	ret := &ChainControllerLock{ChainBlockIndex: idxBin[0]}
+	if ret.StateController, err = AccountableFromBytes(args[1]); err != nil {
+		return nil, err
+	}
+	if ret.Governor, err = AccountableFromBytes(args[2]); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+func initChainControllerLockConstraint() {
+	MustRegisterConstraint(&ConstraintDefinition{
+		Name:   ChainControllerLockName,
+		Source: chainControllerLockSource,
+		Parser: func(data []byte) (Constraint, error) {
+			return ChainControllerLockFromBytes(data)
+		},
+		IsLock: true,
+	})
+
+	example := NewChainControllerLock(4, AddressED25519Null(), ChainLockNull())
+	lockBack, err := ChainControllerLockFromBytes(example.Bytes())
+	easyfl.AssertNoError(err)
+	easyfl.Assert(lockBack.ChainBlockIndex == 4, "inconsistency "+ChainControllerLockName)
+	easyfl.Assert(Equal(lockBack.StateController, AddressED25519Null()), "inconsistency "+ChainControllerLockName)
+	easyfl.Assert(Equal(lockBack.Governor, ChainLockNull()), "inconsistency "+ChainControllerLockName)
+}
+
+const chainControllerLockSource = `
+
+// transition mode of the sibling chain constraint at block index $0, taken from its unlock parameters
+// It is 0xff when the chain is destroyed
+func siblingChainTransitionMode : byte(selfSiblingUnlockBlock($0), 2)
+
+// successor output of the sibling chain constraint at block index $0
+func siblingChainSuccessorOutput : producedOutputByIndex(byte(selfSiblingUnlockBlock($0), 0))
+
+// $0 - 1-byte block index of the chain constraint in the same output
+// $1 - state controller lock. It unlocks state transitions. The lock must be repeated on the successor
+// $2 - governor lock. It unlocks governance transitions and destruction of the chain
+func chainControllerLock: and(
+	equal(selfBlockIndex,2), // locks must be at block 2
+	or(
+		and(
+			selfIsProducedOutput,
+			equal(len8($0), 1),
+			isChainConstraint(selfSiblingConstraint($0)),
+			$1,
+			$2
+		),
+		and(
+			selfIsConsumedOutput,
+			if(
+				equal(siblingChainTransitionMode($0), 0),
+				and(
+					$1,
+					// state controller cannot change the lock
+					equal(self, lockConstraint(siblingChainSuccessorOutput($0)))
+				),
+				$2
+			)
+		),
+		!!!chainControllerLock_unlock_failed
+	)
+)
+`
@@ -60,7 +60,14 @@ func (dl *DeadlineLock) Name() string {
 }
 
 func initDeadlineLockConstraint() {
-	easyfl.MustExtendMany(deadlineLockSource)
+	MustRegisterConstraint(&ConstraintDefinition{
+		Name:   deadlineLockName,
+		Source: deadlineLockSource,
+		Parser: func(data []byte) (Constraint, error) {
+			return DeadlineLockFromBytes(data)
+		},
+		IsLock: true,
+	})
 
 	example := NewDeadlineLock(1337, AddressED25519Null(), AddressED25519Null())
 	lockBack, err := DeadlineLockFromBytes(example.Bytes())
@@ -68,13 +75,6 @@ func initDeadlineLockConstraint() {
 
 	easyfl.Assert(Equal(lockBack.ConstraintMain, AddressED25519Null()), "inconsistency "+deadlineLockName)
 	easyfl.Assert(Equal(lockBack.ConstraintExpiry, AddressED25519Null()), "inconsistency "+deadlineLockName)
-
-	prefix, err := easyfl.ParseBytecodePrefix(example.Bytes())
-	easyfl.AssertNoError(err)
-
-	registerConstraint(deadlineLockName, prefix, func(data []byte) (Constraint, error) {
-		return DeadlineLockFromBytes(data)
-	})
 }
 
 func DeadlineLockFromBytes(data []byte) (*DeadlineLock, error) {
@@ -100,12 +100,15 @@ func DeadlineLockFromBytes(data []byte) (*DeadlineLock, error) {
 
 const deadlineLockSource = `
 
+// $0 - deadline, Unix seconds, uint32 big-endian
+// $1 - main lock. It unlocks the output before the deadline (inclusive)
+// $2 - expiry lock. It unlocks the output after the deadline
 func deadlineLock: and(
 	equal(selfBlockIndex,2), // locks must be at block 2
 	if(
 		lessThan($0, txTimestampBytes),
-		$1, 
-		$2
+		$2,
+		$1
 	)
 )
 `
@@ -17,6 +17,20 @@ const (
 	addressED25519Template = addressED25519Name + "(0x%s)"
 )
 
+// Signature hash modes of ED25519 signatures. The mode byte follows the signature and the public key.
+// The signature without the mode byte signs the whole essence.
+// Signatures with other modes sign only a part of the transaction, so other parties can add their inputs and outputs.
+// The signed message is the mode byte, the ID of the unlocked input, the signed outputs, the timestamp, the fee
+// and the identity of the ledger. The signed outputs are:
+// - SigHashAnyoneCanPay: all produced outputs. Anyone can add inputs, for example for crowdfunding
+// - SigHashSingle: the produced output with the same index as the unlocked input, for example for two-party swaps
+// Inputs can't be unlocked by reference to the input, unlocked with the partial signature
+const (
+	SigHashAll = byte(iota)
+	SigHashAnyoneCanPay
+	SigHashSingle
+)
+
 func AddressED25519FromBytes(data []byte) (AddressED25519, error) {
 	sym, _, args, err := easyfl.ParseBytecodeOneLevel(data, 1)
 	if err != nil {
@@ -74,19 +88,20 @@ func (a AddressED25519) AsLock() Lock {
 }
 
 func initAddressED25519Constraint() {
-	easyfl.MustExtendMany(AddressED25519ConstraintSource)
+	MustRegisterConstraint(&ConstraintDefinition{
+		Name:   addressED25519Name,
+		Source: AddressED25519ConstraintSource,
+		Parser: func(data []byte) (Constraint, error) {
+			return AddressED25519FromBytes(data)
+		},
+		IsLock:        true,
+		IsAccountable: true,
+	})
 
 	example := AddressED25519Null()
 	addrBack, err := AddressED25519FromBytes(example.Bytes())
 	easyfl.AssertNoError(err)
 	easyfl.Assert(Equal(addrBack, AddressED25519Null()), "inconsistency "+addressED25519Name)
-
-	prefix, err := easyfl.ParseBytecodePrefix(example.Bytes())
-	easyfl.AssertNoError(err)
-
-	registerConstraint(addressED25519Name, prefix, func(data []byte) (Constraint, error) {
-		return AddressED25519FromBytes(data)
-	})
 }
 
 const AddressED25519ConstraintSource = `
@@ -96,24 +111,67 @@ const AddressED25519ConstraintSource = `
 // the output unlockable only with the presence of signature correspomding 
 // to the address '0x010203040506..'
 
+// $0 = full signature: signature || public key || optional signature hash mode
+// returns signature hash mode of the signature
+func sigHashModeED25519: if(equal(len8($0), 97), byte($0, 96), 0)
+
+// $0 = signature hash mode
+// returns the message signed with the mode for the consumed output of the invocation context
+func sigHashMessage: if(
+	isZero($0),
+	txEssenceBytes,      // sign all
+	concat(
+		$0,
+		inputIDByIndex(selfOutputIndex),
+		if(
+			equal($0, 1),
+			@Path(pathToProducedOutputs),   // anyone can pay: all outputs
+			if(
+				equal($0, 2),
+				producedOutputByIndex(selfOutputIndex),   // single: the output with the same index
+				!!!unknown_signature_hash_mode
+			)
+		),
+		txTimestampBytes,
+		txFeeBytes,
+		ledgerIdentity
+	)
+)
+
 // $0 = address data 32 bytes
-// $1 = signature
-// $2 = public key
-// return true if transaction essence signature is valid for the address
+// $1 = full signature: signature || public key || optional signature hash mode
+// return true if the signature of the message for the signature hash mode is valid for the address
 func unlockedWithSigED25519: and(
-	equal($0, blake2b($2)), 		       // address in the address data must be equal to the hash of the public key
-	validSignatureED25519(txEssenceBytes, $1, $2)
+	equal($0, blake2b(publicKeyED25519($1))), 	// address in the address data must be equal to the hash of the public key
+	validSignatureED25519(sigHashMessage(sigHashModeED25519($1)), signatureED25519($1), publicKeyED25519($1))
 )
 
 // 'unlockedByReference'' specifies validation of the input unlock with the reference.
 // The referenced constraint must be exactly the same  but with strictly lesser index.
 // This prevents from cycles and forces some other unlock mechanism up in the list of outputs
 func unlockedByReference: and(
+	equal(len8(selfUnlockParameters), 1),                         // reference is 1-byte index of the input
 	lessThan(selfUnlockParameters, selfOutputIndex),              // unlock parameter must point to another input with 
 							                                      // strictly smaller index. This prevents reference cycles	
 	equal(self, consumedLockByOutputIndex(selfUnlockParameters))  // the referenced constraint bytes must be equal to the self constraint bytes
 )
 
+// signature of the transaction, referenced by the unlock parameters $0:
+// 0xff - the first signature, 0xff||idx - the signature with index idx
+func signatureByUnlockParams: if(
+	equal(len8($0), 1),
+	txSignature,
+	txSignatureByIndex(byte($0, 1))
+)
+
+// $0 = unlock parameters of the referenced input
+// returns true if the referenced input is unlocked by reference itself or with the signature of the whole essence.
+// The partial signature covers only its own input, so it can't be used to unlock other inputs by reference
+func referencedUnlockedWithSigHashAll: or(
+	not(equal(byte($0, 0), 0xff)),
+	isZero(sigHashModeED25519(signatureByUnlockParams($0)))
+)
+
 // if it is 'produced' invocation context (constraint invoked in the input), only size of the address is checked
 // Otherwise the first will check first condition if it is unlocked by reference, otherwise checks unlocking signature
 // Second condition not evaluated if the first is true
@@ -129,9 +187,12 @@ func addressED25519: and(
 			selfIsConsumedOutput, 
 			or(
 					// if it is unlocked with reference, the signature is not checked
-				unlockedByReference,
-					// tx signature is checked
-				unlockedWithSigED25519($0, signatureED25519(txSignature), publicKeyED25519(txSignature)) 
+				and(
+					unlockedByReference,
+					referencedUnlockedWithSigHashAll(unlockParamsByConstraintIndex(concat(selfUnlockParameters, lockBlockIndex)))
+				),
+					// tx signature, referenced by the unlock parameters, is checked
+				unlockedWithSigED25519($0, signatureByUnlockParams(selfUnlockParameters))
 			)
 		),
 		!!!addressED25519_unlock_failed
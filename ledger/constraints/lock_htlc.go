@@ -0,0 +1,152 @@
+package constraints
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/lunfardo314/easyfl"
+	"golang.org/x/crypto/blake2b"
+)
+
+// HTLC is the hash time-locked lock. Before the deadline (inclusive) the output can be unlocked by the receiver,
+// who reveals the preimage of the hash. After the deadline the output can be unlocked by the refund account
+type HTLC struct {
+	Hash     [32]byte
+	Deadline uint32
+	Receiver Accountable
+	Refund   Accountable
+}
+
+const (
+	HTLCName     = "htlc"
+	htlcTemplate = HTLCName + "(0x%s, u32/%d, x/%s, x/%s)"
+)
+
+func NewHTLC(hash [32]byte, deadline uint32, receiver, refund Accountable) *HTLC {
+	return &HTLC{
+		Hash:     hash,
+		Deadline: deadline,
+		Receiver: receiver,
+		Refund:   refund,
+	}
+}
+
+// NewHTLCFromPreimage makes the HTLC lock with the blake2b hash of the preimage
+func NewHTLCFromPreimage(preimage []byte, deadline uint32, receiver, refund Accountable) *HTLC {
+	return NewHTLC(blake2b.Sum256(preimage), deadline, receiver, refund)
+}
+
+func (h *HTLC) source() string {
+	return fmt.Sprintf(htlcTemplate,
+		hex.EncodeToString(h.Hash[:]),
+		h.Deadline,
+		hex.EncodeToString(h.Receiver.AccountID()),
+		hex.EncodeToString(h.Refund.AccountID()),
+	)
+}
+
+func (h *HTLC) Bytes() []byte {
+	return mustBinFromSource(h.source())
+}
+
+func (h *HTLC) String() string {
+	return fmt.Sprintf("%s(%s,%d,%s,%s)", HTLCName, easyfl.Fmt(h.Hash[:]), h.Deadline, h.Receiver, h.Refund)
+}
+
+func (h *HTLC) IndexableTags() []Accountable {
+	return []Accountable{h.Receiver, h.Refund}
+}
+
+func (h *HTLC) UnlockableWith(acc AccountID, ts uint32) bool {
+	if ts <= h.Deadline {
+		return bytes.Equal(h.Receiver.AccountID(), acc)
+	}
+	return bytes.Equal(h.Refund.AccountID(), acc)
+}
+
+func (h *HTLC) Name() string {
+	return HTLCName
+}
+
+func HTLCFromBytes(data []byte) (*HTLC, error) {
+	sym, _, args, err := easyfl.ParseBytecodeOneLevel(data, 4)
+	if err != nil {
+		return nil, err
+	}
+	if sym != HTLCName {
+		return nil, fmt.Errorf("not a htlc lock")
+	}
+	ret := &HTLC{}
+	hashBin := easyfl.StripDataPrefix(args[0])
+	if len(hashBin) != 32 {
+		return nil, fmt.Errorf("wrong hash length")
+	}
+	copy(ret.Hash[:], hashBin)
+	deadlineBin := easyfl.StripDataPrefix(args[1])
+	if len(deadlineBin) != 4 {
+		return nil, fmt.Errorf("wrong deadline")
+	}
+	ret.Deadline = binary.BigEndian.Uint32(deadlineBin)
+	if ret.Receiver, err = AccountableFromBytes(args[2]); err != nil {
+		return nil, err
+	}
+	if ret.Refund, err = AccountableFromBytes(args[3]); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+func initHTLCConstraint() {
+	MustRegisterConstraint(&ConstraintDefinition{
+		Name:   HTLCName,
+		Source: htlcSource,
+		Parser: func(data []byte) (Constraint, error) {
+			return HTLCFromBytes(data)
+		},
+		IsLock: true,
+	})
+
+	example := NewHTLCFromPreimage([]byte("secret"), 1337, AddressED25519Null(), AddressED25519Null())
+	lockBack, err := HTLCFromBytes(example.Bytes())
+	easyfl.AssertNoError(err)
+	easyfl.Assert(lockBack.Hash == blake2b.Sum256([]byte("secret")), "inconsistency "+HTLCName)
+	easyfl.Assert(lockBack.Deadline == 1337, "inconsistency "+HTLCName)
+	easyfl.Assert(Equal(lockBack.Receiver, AddressED25519Null()), "inconsistency "+HTLCName)
+	easyfl.Assert(Equal(lockBack.Refund, AddressED25519Null()), "inconsistency "+HTLCName)
+}
+
+const htlcSource = `
+// Hash time-locked lock for atomic swaps
+// $0 - blake2b hash of the preimage, 32 bytes
+// $1 - deadline, Unix seconds, uint32 big-endian
+// $2 - receiver lock. It unlocks the output before the deadline (inclusive), if the preimage is revealed
+// $3 - refund lock. It unlocks the output after the deadline
+// The unlock parameters of the lock block are used by the embedded receiver or refund lock,
+// so the preimage is revealed in the auxiliary unlock parameters of the lock
+func htlc: and(
+	equal(selfBlockIndex,2), // locks must be at block 2
+	or(
+		and(
+			selfIsProducedOutput,
+			equal(len8($0), 32),
+			equal(len8($1), 4),
+			$2,
+			$3
+		),
+		and(
+			selfIsConsumedOutput,
+			if(
+				lessOrEqualThan(txTimestampBytes, $1),
+				and(
+					equal(blake2b(selfLockAuxUnlockParameters), $0),
+					$2
+				),
+				$3
+			)
+		),
+		!!!htlc_unlock_failed
+	)
+)
+`
@@ -0,0 +1,346 @@
+package constraints
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/lunfardo314/easyfl"
+	"github.com/lunfardo314/easyutxo/lazyslice"
+	"github.com/lunfardo314/unitrie/common"
+	"golang.org/x/crypto/blake2b"
+)
+
+// MerkleClaim is the lock of the airdrop pool, held by the chain. The pool commits to the Merkle root of the
+// airdrop entries (index, lock, amount). Anyone can consume the pool with the claim: the proof of inclusion of
+// the entry in the auxiliary unlock parameters of the lock. The claim is a state transition of the chain.
+// The chain successor of the pool must be locked with the same lock, only with the bit of the entry set
+// in the bitmap of claimed entries, so each entry is paid only once. The successor keeps the rest of the pool,
+// the claimed amount is paid to the lock of the entry. The payment output can't be named by other claims, orders,
+// storage deposit returns or covenants of the transaction. The owner unlocks the pool without the claim, for example
+// to take back the unclaimed amount
+
+type MerkleClaim struct {
+	Root [32]byte
+	// Claimed is the bitmap of claimed entries. Bit i%8 of the byte i/8 is the entry i
+	Claimed []byte
+	Owner   Accountable
+}
+
+// AirdropEntry is the leaf of the Merkle tree of the airdrop
+type AirdropEntry struct {
+	Lock   Lock
+	Amount uint64
+}
+
+const (
+	MerkleClaimName     = "merkleClaim"
+	merkleClaimTemplate = MerkleClaimName + "(0x%s, %s, x/%s)"
+	// maxDataChunk is the maximum length of the data literal in EasyFL. Longer bitmaps are concatenated from chunks
+	maxDataChunk = 127
+	// MaxAirdropEntries is limited by uint16 index of the entry
+	MaxAirdropEntries = 1 << 16
+)
+
+// NewMerkleClaim makes the lock of the pool with nothing claimed yet
+func NewMerkleClaim(root [32]byte, numEntries int, owner Accountable) *MerkleClaim {
+	easyfl.Assert(numEntries > 0 && numEntries <= MaxAirdropEntries, "NewMerkleClaim: wrong number of entries")
+	return &MerkleClaim{
+		Root:    root,
+		Claimed: make([]byte, (numEntries+7)/8),
+		Owner:   owner,
+	}
+}
+
+func (m *MerkleClaim) IsClaimed(index uint16) bool {
+	return int(index)/8 < len(m.Claimed) && m.Claimed[index/8]&(1<<(index%8)) != 0
+}
+
+// WithClaimed returns the copy of the lock with the entry marked as claimed
+func (m *MerkleClaim) WithClaimed(index uint16) (*MerkleClaim, error) {
+	claimed, err := bitmapWithBit(m.Claimed, index)
+	if err != nil {
+		return nil, err
+	}
+	return &MerkleClaim{
+		Root:    m.Root,
+		Claimed: claimed,
+		Owner:   m.Owner,
+	}, nil
+}
+
+func bitmapWithBit(bitmap []byte, index uint16) ([]byte, error) {
+	if int(index)/8 >= len(bitmap) {
+		return nil, fmt.Errorf("entry index %d is out of bitmap bounds", index)
+	}
+	if bitmap[index/8]&(1<<(index%8)) != 0 {
+		return nil, fmt.Errorf("entry %d is already claimed", index)
+	}
+	ret := make([]byte, len(bitmap))
+	copy(ret, bitmap)
+	ret[index/8] |= 1 << (index % 8)
+	return ret, nil
+}
+
+func (m *MerkleClaim) source() string {
+	chunks := make([]string, 0, len(m.Claimed)/maxDataChunk+1)
+	for i := 0; i < len(m.Claimed); i += maxDataChunk {
+		end := i + maxDataChunk
+		if end > len(m.Claimed) {
+			end = len(m.Claimed)
+		}
+		chunks = append(chunks, "0x"+hex.EncodeToString(m.Claimed[i:end]))
+	}
+	return fmt.Sprintf(merkleClaimTemplate,
+		hex.EncodeToString(m.Root[:]),
+		"concat("+strings.Join(chunks, ",")+")",
+		hex.EncodeToString(m.Owner.AccountID()),
+	)
+}
+
+func (m *MerkleClaim) Bytes() []byte {
+	return mustBinFromSource(m.source())
+}
+
+func (m *MerkleClaim) String() string {
+	return fmt.Sprintf("%s(%s,%s,%s)", MerkleClaimName, easyfl.Fmt(m.Root[:]), easyfl.Fmt(m.Claimed), m.Owner)
+}
+
+func (m *MerkleClaim) Name() string {
+	return MerkleClaimName
+}
+
+func (m *MerkleClaim) IndexableTags() []Accountable {
+	return []Accountable{m.Owner}
+}
+
+func (m *MerkleClaim) UnlockableWith(acc AccountID, ts uint32) bool {
+	return bytes.Equal(m.Owner.AccountID(), acc)
+}
+
+func MerkleClaimFromBytes(data []byte) (*MerkleClaim, error) {
+	sym, _, args, err := easyfl.ParseBytecodeOneLevel(data, 3)
+	if err != nil {
+		return nil, err
+	}
+	if sym != MerkleClaimName {
+		return nil, fmt.Errorf("not a merkleClaim lock")
+	}
+	root := easyfl.StripDataPrefix(args[0])
+	if len(root) != 32 {
+		return nil, fmt.Errorf("wrong Merkle root")
+	}
+	// the bitmap is concatenated from data chunks
+	claimed, err := easyfl.EvalFromBinary(nil, args[1])
+	if err != nil {
+		return nil, err
+	}
+	if len(claimed) == 0 || len(claimed) > MaxAirdropEntries/8 {
+		return nil, fmt.Errorf("wrong bitmap of claimed entries")
+	}
+	owner, err := AccountableFromBytes(args[2])
+	if err != nil {
+		return nil, err
+	}
+	ret := &MerkleClaim{
+		Claimed: claimed,
+		Owner:   owner,
+	}
+	copy(ret.Root[:], root)
+	return ret, nil
+}
+
+// MerkleClaimLeafHash is the hash of the airdrop entry with the index
+func MerkleClaimLeafHash(index uint16, e *AirdropEntry) [32]byte {
+	var idx [2]byte
+	var amount [8]byte
+	binary.BigEndian.PutUint16(idx[:], index)
+	binary.BigEndian.PutUint64(amount[:], e.Amount)
+	return blake2b.Sum256(common.Concat([]byte{0x00}, idx[:], e.Lock.Bytes(), amount[:]))
+}
+
+func merkleNodeHash(left, right []byte) [32]byte {
+	return blake2b.Sum256(common.Concat([]byte{0x01}, left, right))
+}
+
+// MerkleClaimTree builds the Merkle tree of the airdrop entries. Returns the root and proofs of all entries.
+// The tree is padded with zero leaves to the power of 2
+func MerkleClaimTree(entries []*AirdropEntry) ([32]byte, [][]byte) {
+	easyfl.Assert(len(entries) > 0 && len(entries) <= MaxAirdropEntries, "MerkleClaimTree: wrong number of entries")
+	size := 1
+	for size < len(entries) {
+		size *= 2
+	}
+	level := make([][32]byte, size)
+	for i, e := range entries {
+		level[i] = MerkleClaimLeafHash(uint16(i), e)
+	}
+	proofs := make([][]byte, len(entries))
+	// position of each entry in the current level
+	pos := make([]int, len(entries))
+	for i := range pos {
+		pos[i] = i
+	}
+	for len(level) > 1 {
+		for i := range entries {
+			sibling := level[pos[i]^1]
+			proofs[i] = append(proofs[i], sibling[:]...)
+			pos[i] /= 2
+		}
+		next := make([][32]byte, len(level)/2)
+		for i := range next {
+			next[i] = merkleNodeHash(level[2*i][:], level[2*i+1][:])
+		}
+		level = next
+	}
+	return level[0], proofs
+}
+
+// NewMerkleClaimUnlockParams makes auxiliary unlock parameters of the claim
+func NewMerkleClaimUnlockParams(chainBlockIndex byte, index uint16, e *AirdropEntry, proof []byte, paymentIndex byte) []byte {
+	var idx [2]byte
+	var amount [8]byte
+	binary.BigEndian.PutUint16(idx[:], index)
+	binary.BigEndian.PutUint64(amount[:], e.Amount)
+	return lazyslice.MakeArrayFromData([]byte{chainBlockIndex}, idx[:], e.Lock.Bytes(), amount[:], proof, []byte{paymentIndex}).Bytes()
+}
+
+func initMerkleClaimConstraint() {
+	easyfl.EmbedLong("validMerkleClaimSuccessor", 3, evalValidMerkleClaimSuccessor)
+
+	MustRegisterConstraint(&ConstraintDefinition{
+		Name:   MerkleClaimName,
+		Source: merkleClaimSource,
+		Parser: func(data []byte) (Constraint, error) {
+			return MerkleClaimFromBytes(data)
+		},
+		IsLock: true,
+	})
+
+	example := NewMerkleClaim(blake2b.Sum256([]byte("root")), 2000, AddressED25519Null())
+	example, err := example.WithClaimed(1999)
+	easyfl.AssertNoError(err)
+	back, err := MerkleClaimFromBytes(example.Bytes())
+	easyfl.AssertNoError(err)
+	easyfl.Assert(back.Root == example.Root && bytes.Equal(back.Claimed, example.Claimed), "inconsistency "+MerkleClaimName)
+	easyfl.Assert(back.IsClaimed(1999) && !back.IsClaimed(0), "inconsistency "+MerkleClaimName)
+	easyfl.Assert(Equal(back.Owner, AddressED25519Null()), "inconsistency "+MerkleClaimName)
+}
+
+// arg 0 - 32 bytes of the leaf hash
+// arg 1 - index of the leaf, uint16
+// arg 2 - Merkle proof: 32-byte hashes of siblings from the leaf to the root
+// Returns the Merkle root
+func evalMerkleRoot(ctx *easyfl.CallParams) []byte {
+	leaf, index, proof := ctx.Arg(0), ctx.Arg(1), ctx.Arg(2)
+	if len(leaf) != 32 || len(index) != 2 || len(proof)%32 != 0 {
+		ctx.TracePanic("evalMerkleRoot: wrong data length")
+	}
+	idx := binary.BigEndian.Uint16(index)
+	h := leaf
+	for i := 0; i < len(proof); i += 32 {
+		var node [32]byte
+		if idx%2 == 0 {
+			node = merkleNodeHash(h, proof[i:i+32])
+		} else {
+			node = merkleNodeHash(proof[i:i+32], h)
+		}
+		h = node[:]
+		idx /= 2
+	}
+	if idx != 0 {
+		ctx.TracePanic("evalMerkleRoot: index is out of the tree")
+	}
+	return h
+}
+
+// arg 0 - bytes of the consumed merkleClaim lock
+// arg 1 - bytes of the lock of the successor
+// arg 2 - index of the claimed entry, uint16
+// Returns non-empty value if the successor is the same lock with the entry marked as claimed
+func evalValidMerkleClaimSuccessor(ctx *easyfl.CallParams) []byte {
+	index := ctx.Arg(2)
+	if len(index) != 2 {
+		ctx.TracePanic("evalValidMerkleClaimSuccessor: wrong index")
+	}
+	pred, err := MerkleClaimFromBytes(ctx.Arg(0))
+	if err != nil {
+		ctx.TracePanic("evalValidMerkleClaimSuccessor: %v", err)
+	}
+	expected, err := pred.WithClaimed(binary.BigEndian.Uint16(index))
+	if err != nil {
+		ctx.Trace("evalValidMerkleClaimSuccessor: %v", err)
+		return nil
+	}
+	succ, err := MerkleClaimFromBytes(ctx.Arg(1))
+	if err != nil {
+		ctx.Trace("evalValidMerkleClaimSuccessor: %v", err)
+		return nil
+	}
+	if succ.Root != expected.Root || !bytes.Equal(succ.Claimed, expected.Claimed) || !Equal(succ.Owner, expected.Owner) {
+		ctx.Trace("evalValidMerkleClaimSuccessor: successor must mark the entry %d as claimed", binary.BigEndian.Uint16(index))
+		return nil
+	}
+	return []byte{0xff}
+}
+
+const merkleClaimSource = `
+// auxiliary unlock parameters of the claim, lazy array:
+// 0 - block index of the chain constraint of the pool, 1 byte
+// 1 - index of the entry, uint16
+// 2 - lock of the entry
+// 3 - amount of the entry, uint64
+// 4 - Merkle proof: 32-byte hashes of siblings from the leaf to the root
+// 5 - index of the produced output which pays the amount to the lock of the entry
+func merkleClaimParam : @Array8(selfLockAuxUnlockParameters, $0)
+func merkleClaimLeaf : blake2b(0x00, merkleClaimParam(1), merkleClaimParam(2), merkleClaimParam(3))
+// unlock parameters of the chain constraint point to the successor of the pool
+func merkleClaimChainUnlock : selfSiblingUnlockBlock(merkleClaimParam(0))
+func merkleClaimSuccessor : producedOutputByIndex(byte(merkleClaimChainUnlock, 0))
+func merkleClaimPayment : producedOutputByIndex(merkleClaimParam(5))
+
+// $0 - Merkle root of the airdrop entries
+// $1 - bitmap of claimed entries
+// $2 - owner lock
+func merkleClaim: and(
+	equal(selfBlockIndex,2), // locks must be at block 2
+	or(
+		and(
+			selfIsProducedOutput,
+			equal(len8($0), 32),
+			not(isZero(len16($1))),
+			$2
+		),
+		and(
+			selfIsConsumedOutput,
+			if(
+				isZero(len16(selfLockAuxUnlockParameters)),
+				// unlocked by the owner
+				$2,
+				// claim by anyone
+				and(
+					isChainConstraint(selfSiblingConstraint(merkleClaimParam(0))),
+					// the claim is a state transition of the chain
+					equal(byte(merkleClaimChainUnlock, 2), 0),
+					equal(merkleRoot(merkleClaimLeaf, merkleClaimParam(1), merkleClaimParam(4)), $0),
+					validMerkleClaimSuccessor(self, lockConstraint(merkleClaimSuccessor), merkleClaimParam(1)),
+					// the successor keeps the rest of the pool
+					lessOrEqualThan(amountValue(selfOutputBytes), sum64(amountValue(merkleClaimSuccessor), merkleClaimParam(3))),
+					equal(lockConstraint(merkleClaimPayment), merkleClaimParam(2)),
+					lessOrEqualThan(merkleClaimParam(3), amountValue(merkleClaimPayment)),
+					// the payment can't be counted by other constraints of the transaction
+					uniqueProducedOutputs(
+						selfOutputIndex,
+						selfBlockIndex,
+						@Path(pathToConsumedOutputs),
+						@Path(pathToUnlockParams)
+					)
+				)
+			)
+		),
+		!!!merkleClaim_unlock_failed
+	)
+)
+`
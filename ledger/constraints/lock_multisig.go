@@ -0,0 +1,246 @@
+package constraints
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/lunfardo314/easyfl"
+	"github.com/lunfardo314/easyutxo/lazyslice"
+	"golang.org/x/crypto/blake2b"
+)
+
+// MultisigPolicyED25519 is M-of-N policy of ED25519 addresses: at least Threshold of Addresses
+// must sign the transaction. The policy is serialized as lazy array: 1-byte threshold followed by addresses.
+// The output is locked with the blake2b hash of the serialized policy, the policy itself is revealed
+// in the unlock parameters when the output is consumed
+type MultisigPolicyED25519 struct {
+	Threshold byte
+	Addresses []AddressED25519
+}
+
+// MultisigED25519 is the lock with the hash of the MultisigPolicyED25519. It is the address of the policy
+type MultisigED25519 []byte
+
+const (
+	MultisigED25519Name     = "multisigED25519"
+	multisigED25519Template = MultisigED25519Name + "(0x%s)"
+)
+
+func NewMultisigPolicyED25519(threshold byte, addresses ...AddressED25519) (*MultisigPolicyED25519, error) {
+	ret := &MultisigPolicyED25519{
+		Threshold: threshold,
+		Addresses: addresses,
+	}
+	if err := ret.validate(); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+func MultisigPolicyED25519FromBytes(data []byte) (*MultisigPolicyED25519, error) {
+	var elems [][]byte
+	err := easyfl.CatchPanicOrError(func() error {
+		elems = lazyslice.ArrayFromBytes(data, 256).Parsed()
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(elems) < 2 || len(elems[0]) != 1 {
+		return nil, fmt.Errorf("wrong multisig policy data")
+	}
+	ret := &MultisigPolicyED25519{
+		Threshold: elems[0][0],
+		Addresses: make([]AddressED25519, len(elems)-1),
+	}
+	for i := range ret.Addresses {
+		ret.Addresses[i] = elems[i+1]
+	}
+	if err = ret.validate(); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+func (p *MultisigPolicyED25519) validate() error {
+	if len(p.Addresses) == 0 || len(p.Addresses) > 255 {
+		return fmt.Errorf("number of addresses in the multisig policy must be from 1 to 255")
+	}
+	if p.Threshold == 0 || int(p.Threshold) > len(p.Addresses) {
+		return fmt.Errorf("wrong threshold %d of the multisig policy with %d addresses", p.Threshold, len(p.Addresses))
+	}
+	for i, addr := range p.Addresses {
+		if len(addr) != 32 {
+			return fmt.Errorf("wrong address #%d in the multisig policy", i)
+		}
+		for _, prev := range p.Addresses[:i] {
+			if bytes.Equal(prev, addr) {
+				return fmt.Errorf("repeating address %s in the multisig policy", easyfl.Fmt(addr))
+			}
+		}
+	}
+	return nil
+}
+
+func (p *MultisigPolicyED25519) Bytes() []byte {
+	arr := lazyslice.EmptyArray(256)
+	arr.Push([]byte{p.Threshold})
+	for _, addr := range p.Addresses {
+		arr.Push(addr)
+	}
+	return arr.Bytes()
+}
+
+func (p *MultisigPolicyED25519) Contains(addr AddressED25519) bool {
+	for _, a := range p.Addresses {
+		if bytes.Equal(a, addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// Address returns lock of the policy
+func (p *MultisigPolicyED25519) Address() MultisigED25519 {
+	h := blake2b.Sum256(p.Bytes())
+	return h[:]
+}
+
+func MultisigED25519FromBytes(data []byte) (MultisigED25519, error) {
+	sym, _, args, err := easyfl.ParseBytecodeOneLevel(data, 1)
+	if err != nil {
+		return nil, err
+	}
+	if sym != MultisigED25519Name {
+		return nil, fmt.Errorf("not a MultisigED25519")
+	}
+	hashBin := easyfl.StripDataPrefix(args[0])
+	if len(hashBin) != 32 {
+		return nil, fmt.Errorf("wrong data length")
+	}
+	return hashBin, nil
+}
+
+func MultisigED25519Null() MultisigED25519 {
+	return make([]byte, 32)
+}
+
+func (m MultisigED25519) source() string {
+	return fmt.Sprintf(multisigED25519Template, hex.EncodeToString(m))
+}
+
+func (m MultisigED25519) Bytes() []byte {
+	return mustBinFromSource(m.source())
+}
+
+func (m MultisigED25519) IndexableTags() []Accountable {
+	return []Accountable{m}
+}
+
+func (m MultisigED25519) UnlockableWith(acc AccountID, _ uint32) bool {
+	return bytes.Equal(m.AccountID(), acc)
+}
+
+func (m MultisigED25519) AccountID() AccountID {
+	return m.Bytes()
+}
+
+func (m MultisigED25519) Name() string {
+	return MultisigED25519Name
+}
+
+func (m MultisigED25519) String() string {
+	return m.source()
+}
+
+func (m MultisigED25519) AsLock() Lock {
+	return m
+}
+
+func initMultisigED25519Constraint() {
+	easyfl.EmbedLong("validMultisigED25519", 3, evalValidMultisigED25519)
+	MustRegisterConstraint(&ConstraintDefinition{
+		Name:   MultisigED25519Name,
+		Source: multisigED25519Source,
+		Parser: func(data []byte) (Constraint, error) {
+			return MultisigED25519FromBytes(data)
+		},
+		IsLock:        true,
+		IsAccountable: true,
+	})
+
+	example := MultisigED25519Null()
+	back, err := MultisigED25519FromBytes(example.Bytes())
+	easyfl.AssertNoError(err)
+	easyfl.Assert(Equal(back, example), "inconsistency "+MultisigED25519Name)
+
+	policy, err := NewMultisigPolicyED25519(1, AddressED25519Null())
+	easyfl.AssertNoError(err)
+	policyBack, err := MultisigPolicyED25519FromBytes(policy.Bytes())
+	easyfl.AssertNoError(err)
+	easyfl.Assert(Equal(policyBack.Address(), policy.Address()), "inconsistency "+MultisigED25519Name)
+}
+
+// arg 0 - serialized multisig policy
+// arg 1 - signed essence
+// arg 2 - list of signatures of the transaction
+// Returns non-empty value if at least 'threshold' of distinct addresses of the policy validly signed the essence
+func evalValidMultisigED25519(ctx *easyfl.CallParams) []byte {
+	policy, err := MultisigPolicyED25519FromBytes(ctx.Arg(0))
+	if err != nil {
+		ctx.Trace("evalValidMultisigED25519: %v", err)
+		return nil
+	}
+	essence := ctx.Arg(1)
+	signed := make(map[string]struct{})
+	lazyslice.ArrayFromBytes(ctx.Arg(2), 256).ForEach(func(_ int, sig []byte) bool {
+		if len(sig) != 96 {
+			return true
+		}
+		addr := AddressED25519FromPublicKey(sig[64:])
+		if _, already := signed[string(addr)]; already || !policy.Contains(addr) {
+			return true
+		}
+		if ed25519.Verify(sig[64:], essence, sig[:64]) {
+			signed[string(addr)] = struct{}{}
+		}
+		return true
+	})
+	if len(signed) < int(policy.Threshold) {
+		ctx.Trace("evalValidMultisigED25519: %d valid signatures, threshold %d", len(signed), policy.Threshold)
+		return nil
+	}
+	return []byte{0xff}
+}
+
+const multisigED25519Source = `
+
+// Multisig ED25519 lock wraps 32 bytes blake2b hash of the serialized M-of-N policy.
+// The output is unlockable if the policy is revealed in the unlock parameters
+// and at least M signatures of distinct addresses of the policy are among signatures of the transaction
+// Alternatively, the output can be unlocked by reference to the input with the same lock
+
+// $0 - blake2b hash of the multisig policy, 32 bytes
+func multisigED25519: and(
+	equal(selfBlockIndex,2), // locks must be at block 2
+	or(
+		and(
+			selfIsProducedOutput,
+			equal(len8($0), 32)
+		),
+		and(
+			selfIsConsumedOutput,
+			or(
+				unlockedByReference,
+				and(
+					equal($0, blake2b(selfUnlockParameters)),
+					validMultisigED25519(selfUnlockParameters, txEssenceBytes, txSignatures)
+				)
+			)
+		),
+		!!!multisigED25519_unlock_failed
+	)
+)
+`
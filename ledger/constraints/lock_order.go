@@ -0,0 +1,317 @@
+package constraints
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"math/bits"
+
+	"github.com/lunfardo314/easyfl"
+	"github.com/lunfardo314/easyutxo/lazyslice"
+)
+
+// Order is the lock of the limit order. The output offers its amount for WantAmount of tokens: native tokens
+// with WantTokenID or base tokens, if WantTokenID is nil. Anyone can consume the order output if the same transaction
+// pays the price to the owner's lock. The price of the part taken from the order is proportional to the WantAmount,
+// rounded up. The partial fill leaves the rest in the successor order with the same owner and token, and with the
+// want amount not lesser than proportional to the rest. Other blocks of the successor must be the same as of the order.
+// The fill is referenced by the auxiliary unlock parameters of the lock: 2 bytes with indices of the payment output
+// and of the successor (0xff if filled completely).
+// The payment and the successor can't be named by other orders, storage deposit returns, covenants or Merkle claims
+// of the transaction.
+// The owner cancels the order by unlocking its lock, the same way as the own output.
+// Orders with identical locks can't be cancelled by reference, the signature must be referenced instead
+type Order struct {
+	Owner       Accountable
+	WantAmount  uint64
+	WantTokenID []byte
+}
+
+const (
+	OrderName     = "order"
+	orderTemplate = OrderName + "(x/%s, u64/%d, 0x%s)"
+)
+
+// OrderFillNoSuccessor is the successor index in the fill unlock parameters of the completely filled order
+const OrderFillNoSuccessor = byte(0xff)
+
+func NewOrder(owner Accountable, wantAmount uint64, wantTokenID ...[32]byte) *Order {
+	ret := &Order{
+		Owner:      owner,
+		WantAmount: wantAmount,
+	}
+	if len(wantTokenID) > 0 {
+		ret.WantTokenID = wantTokenID[0][:]
+	}
+	return ret
+}
+
+// NewOrderFillUnlockParams makes auxiliary unlock parameters of the order fill
+func NewOrderFillUnlockParams(paymentIndex, successorIndex byte) []byte {
+	return []byte{paymentIndex, successorIndex}
+}
+
+func (o *Order) source() string {
+	return fmt.Sprintf(orderTemplate, hex.EncodeToString(o.Owner.AccountID()), o.WantAmount, hex.EncodeToString(o.WantTokenID))
+}
+
+func (o *Order) Bytes() []byte {
+	return mustBinFromSource(o.source())
+}
+
+func (o *Order) String() string {
+	return fmt.Sprintf("%s(%s,%d,%s)", OrderName, o.Owner, o.WantAmount, easyfl.Fmt(o.WantTokenID))
+}
+
+func (o *Order) Name() string {
+	return OrderName
+}
+
+func (o *Order) IndexableTags() []Accountable {
+	return []Accountable{o.Owner}
+}
+
+func (o *Order) UnlockableWith(acc AccountID, ts uint32) bool {
+	return bytes.Equal(o.Owner.AccountID(), acc)
+}
+
+// Price returns the payment for taking the amount from the order output with the offered amount, rounded up.
+// The taken amount must not be bigger than offered
+func (o *Order) Price(offered, take uint64) uint64 {
+	easyfl.Assert(take <= offered && offered > 0, "Order.Price: wrong amounts")
+	// the quotient is not bigger than WantAmount
+	hi, lo := bits.Mul64(take, o.WantAmount)
+	ret, rem := bits.Div64(hi, lo, offered)
+	if rem > 0 {
+		ret++
+	}
+	return ret
+}
+
+func OrderFromBytes(data []byte) (*Order, error) {
+	sym, _, args, err := easyfl.ParseBytecodeOneLevel(data, 3)
+	if err != nil {
+		return nil, err
+	}
+	if sym != OrderName {
+		return nil, fmt.Errorf("not an order lock")
+	}
+	owner, err := AccountableFromBytes(args[0])
+	if err != nil {
+		return nil, err
+	}
+	wantBin := easyfl.StripDataPrefix(args[1])
+	if len(wantBin) != 8 {
+		return nil, fmt.Errorf("wrong want amount")
+	}
+	tokenID := easyfl.StripDataPrefix(args[2])
+	if len(tokenID) != 0 && len(tokenID) != 32 {
+		return nil, fmt.Errorf("wrong token ID")
+	}
+	ret := &Order{
+		Owner:      owner,
+		WantAmount: binary.BigEndian.Uint64(wantBin),
+	}
+	if len(tokenID) > 0 {
+		ret.WantTokenID = tokenID
+	}
+	return ret, nil
+}
+
+func initOrderConstraint() {
+	easyfl.EmbedLong("validOrderFill", 5, evalValidOrderFill)
+
+	MustRegisterConstraint(&ConstraintDefinition{
+		Name:   OrderName,
+		Source: orderSource,
+		Parser: func(data []byte) (Constraint, error) {
+			return OrderFromBytes(data)
+		},
+		IsLock: true,
+	})
+
+	var tokenID [32]byte
+	tokenID[31] = 0xff
+	example := NewOrder(AddressED25519Null(), 1337, tokenID)
+	back, err := OrderFromBytes(example.Bytes())
+	easyfl.AssertNoError(err)
+	easyfl.Assert(Equal(back.Owner, AddressED25519Null()), "inconsistency "+OrderName)
+	easyfl.Assert(back.WantAmount == 1337 && bytes.Equal(back.WantTokenID, tokenID[:]), "inconsistency "+OrderName)
+	easyfl.Assert(back.Price(1000, 500) == 669 && back.Price(1000, 1000) == 1337, "inconsistency "+OrderName)
+
+	example = NewOrder(AddressED25519Null(), 1337)
+	back, err = OrderFromBytes(example.Bytes())
+	easyfl.AssertNoError(err)
+	easyfl.Assert(len(back.WantTokenID) == 0, "inconsistency "+OrderName)
+}
+
+// paidToOrder returns amount of the wanted token in the output: native token amount or the amount of the output
+func paidToOrder(out *lazyslice.Array, tokenID []byte) (uint64, error) {
+	if len(tokenID) == 0 {
+		amount, err := AmountFromBytes(out.At(int(ConstraintIndexAmount)))
+		return uint64(amount), err
+	}
+	ret := uint64(0)
+	var err error
+	out.ForEach(func(i int, data []byte) bool {
+		if i <= int(ConstraintIndexLock) {
+			return true
+		}
+		nt, errParse := NativeTokenFromBytes(data)
+		if errParse != nil || !bytes.Equal(nt.TokenID[:], tokenID) {
+			return true
+		}
+		if nt.Amount > math.MaxUint64-ret {
+			err = fmt.Errorf("native token amount overflow")
+			return false
+		}
+		ret += nt.Amount
+		return true
+	})
+	return ret, err
+}
+
+// sameOutputExceptMandatory returns true if the outputs have the same blocks after the amount, timestamp and lock
+func sameOutputExceptMandatory(out1, out2 *lazyslice.Array) bool {
+	if out1.NumElements() != out2.NumElements() {
+		return false
+	}
+	for i := int(ConstraintIndexLock) + 1; i < out1.NumElements(); i++ {
+		if !bytes.Equal(out1.At(i), out2.At(i)) {
+			return false
+		}
+	}
+	return true
+}
+
+// mulGreaterOrEqual returns a*b >= c*d without overflow
+func mulGreaterOrEqual(a, b, c, d uint64) bool {
+	hi1, lo1 := bits.Mul64(a, b)
+	hi2, lo2 := bits.Mul64(c, d)
+	return hi1 > hi2 || (hi1 == hi2 && lo1 >= lo2)
+}
+
+// arg 0 - bytes of the order lock
+// arg 1 - index of the consumed order output
+// arg 2 - all consumed outputs, serialized lazy array
+// arg 3 - unlock parameters of all consumed outputs, serialized lazy array
+// arg 4 - all produced outputs, serialized lazy array
+// Returns non-empty value if the fill, referenced by the auxiliary unlock parameters of the order, pays the price
+// to the owner and leaves the rest in the valid successor order
+func evalValidOrderFill(ctx *easyfl.CallParams) []byte {
+	order, err := OrderFromBytes(ctx.Arg(0))
+	if err != nil {
+		ctx.Trace("evalValidOrderFill: %v", err)
+		return nil
+	}
+	selfIdx := ctx.Arg(1)
+	if len(selfIdx) != 1 {
+		ctx.Trace("evalValidOrderFill: wrong output index")
+		return nil
+	}
+	consumed := lazyslice.ArrayFromBytes(ctx.Arg(2), 256)
+	unlockParams := lazyslice.ArrayFromBytes(ctx.Arg(3), 256)
+	produced := lazyslice.ArrayFromBytes(ctx.Arg(4), 256)
+
+	fill := lazyslice.ArrayFromBytes(unlockParams.At(int(selfIdx[0])), 256).At(int(ConstraintIndexTimestamp))
+	paymentIdx, successorIdx := fill[0], fill[1]
+	if paymentIdx == successorIdx {
+		ctx.Trace("evalValidOrderFill: payment and successor must be different outputs")
+		return nil
+	}
+	offered, err := AmountFromBytes(lazyslice.ArrayFromBytes(consumed.At(int(selfIdx[0])), 256).At(int(ConstraintIndexAmount)))
+	easyfl.AssertNoError(err)
+
+	payment := lazyslice.ArrayFromBytes(produced.At(int(paymentIdx)), 256)
+	if !bytes.Equal(payment.At(int(ConstraintIndexLock)), order.Owner.AsLock().Bytes()) {
+		ctx.Trace("evalValidOrderFill: payment output %d is not locked with the owner's lock", paymentIdx)
+		return nil
+	}
+	paid, err := paidToOrder(payment, order.WantTokenID)
+	if err != nil {
+		ctx.Trace("evalValidOrderFill: %v", err)
+		return nil
+	}
+	rest, restWant := uint64(0), uint64(0)
+	if successorIdx != OrderFillNoSuccessor {
+		successor := lazyslice.ArrayFromBytes(produced.At(int(successorIdx)), 256)
+		succOrder, err := OrderFromBytes(successor.At(int(ConstraintIndexLock)))
+		if err != nil || !Equal(succOrder.Owner, order.Owner) || !bytes.Equal(succOrder.WantTokenID, order.WantTokenID) {
+			ctx.Trace("evalValidOrderFill: successor output %d is not the order of the same owner and token", successorIdx)
+			return nil
+		}
+		if !sameOutputExceptMandatory(successor, lazyslice.ArrayFromBytes(consumed.At(int(selfIdx[0])), 256)) {
+			ctx.Trace("evalValidOrderFill: successor output %d has other blocks than the order", successorIdx)
+			return nil
+		}
+		restAmount, err := AmountFromBytes(successor.At(int(ConstraintIndexAmount)))
+		easyfl.AssertNoError(err)
+		rest, restWant = uint64(restAmount), succOrder.WantAmount
+	}
+	if rest >= uint64(offered) {
+		ctx.Trace("evalValidOrderFill: nothing is taken from the order")
+		return nil
+	}
+	// paid / taken >= want / offered
+	if !mulGreaterOrEqual(paid, uint64(offered), uint64(offered)-rest, order.WantAmount) {
+		ctx.Trace("evalValidOrderFill: paid %d is less than the price", paid)
+		return nil
+	}
+	// restWant / rest >= want / offered
+	if !mulGreaterOrEqual(restWant, uint64(offered), rest, order.WantAmount) {
+		ctx.Trace("evalValidOrderFill: want amount %d of the successor is less than proportional", restWant)
+		return nil
+	}
+	return []byte{0xff}
+}
+
+const orderSource = `
+
+// $0 - owner lock. It receives the payment and cancels the order
+// $1 - want amount, uint64 big-endian. Must be positive
+// $2 - want token ID: 32 bytes of the native token ID or empty for base tokens
+// The fill is referenced by 2 bytes of the auxiliary unlock parameters: payment and successor output indices
+func order: and(
+	equal(selfBlockIndex,2), // locks must be at block 2
+	or(
+		and(
+			selfIsProducedOutput,
+			equal(len8($1), 8),
+			not(isZero($1)),
+			or(isZero(len8($2)), equal(len8($2), 32)),
+			$0
+		),
+		and(
+			selfIsConsumedOutput,
+			if(
+				isZero(len8(selfLockAuxUnlockParameters)),
+				// cancel by the owner. Unlock by reference is not allowed: the referenced order may be filled
+				and(
+					not(unlockedByReference),
+					$0
+				),
+				// fill by anyone
+				and(
+					equal(len8(selfLockAuxUnlockParameters), 2),
+					validOrderFill(
+						self,
+						selfOutputIndex,
+						@Path(pathToConsumedOutputs),
+						@Path(pathToUnlockParams),
+						@Path(pathToProducedOutputs)
+					),
+					uniqueProducedOutputs(
+						selfOutputIndex,
+						selfBlockIndex,
+						@Path(pathToConsumedOutputs),
+						@Path(pathToUnlockParams)
+					)
+				)
+			)
+		),
+		!!!order_constraint_failed
+	)
+)
+`
@@ -0,0 +1,201 @@
+package constraints
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+
+	"github.com/lunfardo314/easyfl"
+	"golang.org/x/crypto/blake2b"
+)
+
+// AddressP256 is the lock with ECDSA P-256 key. The address is blake2b hash of the compressed public key.
+// The ECDSA signature is over the SHA-256 hash of the transaction essence.
+// Signatures of the P-256 scheme are prefixed with the scheme byte, see EncodeSignatureP256
+type AddressP256 []byte
+
+const (
+	addressP256Name     = "addressP256"
+	addressP256Template = addressP256Name + "(0x%s)"
+)
+
+// ED25519 signatures are 96 bytes long without the scheme prefix: signature || public key
+// Signatures of other schemes start with the scheme byte
+const (
+	SignatureSchemeP256 = byte(0x01)
+	// SignatureP256Size = scheme byte || r (32 bytes) || s (32 bytes) || compressed public key (33 bytes)
+	SignatureP256Size = 1 + 32 + 32 + 33
+)
+
+func AddressP256FromBytes(data []byte) (AddressP256, error) {
+	sym, _, args, err := easyfl.ParseBytecodeOneLevel(data, 1)
+	if err != nil {
+		return nil, err
+	}
+	if sym != addressP256Name {
+		return nil, fmt.Errorf("not an AddressP256")
+	}
+	addrBin := easyfl.StripDataPrefix(args[0])
+	if len(addrBin) != 32 {
+		return nil, fmt.Errorf("wrong data length")
+	}
+	return addrBin, nil
+}
+
+func AddressP256FromPublicKey(pubKey *ecdsa.PublicKey) AddressP256 {
+	h := blake2b.Sum256(elliptic.MarshalCompressed(elliptic.P256(), pubKey.X, pubKey.Y))
+	return h[:]
+}
+
+func AddressP256Null() AddressP256 {
+	return make([]byte, 32)
+}
+
+func (a AddressP256) source() string {
+	return fmt.Sprintf(addressP256Template, hex.EncodeToString(a))
+}
+
+func (a AddressP256) Bytes() []byte {
+	return mustBinFromSource(a.source())
+}
+
+func (a AddressP256) IndexableTags() []Accountable {
+	return []Accountable{a}
+}
+
+func (a AddressP256) UnlockableWith(acc AccountID, ts uint32) bool {
+	return bytes.Equal(a.AccountID(), acc)
+}
+
+func (a AddressP256) AccountID() AccountID {
+	return a.Bytes()
+}
+
+func (a AddressP256) Name() string {
+	return addressP256Name
+}
+
+func (a AddressP256) String() string {
+	return a.source()
+}
+
+func (a AddressP256) AsLock() Lock {
+	return a
+}
+
+// EncodeSignatureP256 encodes the ECDSA signature with the public key into the signature of the transaction
+func EncodeSignatureP256(r, s *big.Int, pubKey *ecdsa.PublicKey) []byte {
+	ret := make([]byte, SignatureP256Size)
+	ret[0] = SignatureSchemeP256
+	r.FillBytes(ret[1:33])
+	s.FillBytes(ret[33:65])
+	copy(ret[65:], elliptic.MarshalCompressed(elliptic.P256(), pubKey.X, pubKey.Y))
+	return ret
+}
+
+// SignP256 signs SHA-256 hash of the message and returns the encoded signature
+func SignP256(privKey *ecdsa.PrivateKey, msg []byte) ([]byte, error) {
+	h := sha256.Sum256(msg)
+	r, s, err := ecdsa.Sign(rand.Reader, privKey, h[:])
+	if err != nil {
+		return nil, err
+	}
+	return EncodeSignatureP256(r, s, &privKey.PublicKey), nil
+}
+
+// DecodeSignatureP256 decodes the signature of the P-256 scheme
+func DecodeSignatureP256(sig []byte) (*big.Int, *big.Int, *ecdsa.PublicKey, error) {
+	if len(sig) != SignatureP256Size || sig[0] != SignatureSchemeP256 {
+		return nil, nil, nil, fmt.Errorf("not a P-256 signature")
+	}
+	pubKey, err := publicKeyP256FromBytes(sig[65:])
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return new(big.Int).SetBytes(sig[1:33]), new(big.Int).SetBytes(sig[33:65]), pubKey, nil
+}
+
+func publicKeyP256FromBytes(data []byte) (*ecdsa.PublicKey, error) {
+	x, y := elliptic.UnmarshalCompressed(elliptic.P256(), data)
+	if x == nil {
+		return nil, fmt.Errorf("wrong P-256 public key")
+	}
+	return &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}, nil
+}
+
+// arg 0 - signed message
+// arg 1 - 64 bytes of the signature: r || s
+// arg 2 - 33 bytes of the compressed public key
+// Returns non-empty value if the signature of the SHA-256 hash of the message is valid
+func evalValidSignatureP256(ctx *easyfl.CallParams) []byte {
+	sig := ctx.Arg(1)
+	if len(sig) != 64 {
+		ctx.Trace("evalValidSignatureP256: wrong signature length")
+		return nil
+	}
+	pubKey, err := publicKeyP256FromBytes(ctx.Arg(2))
+	if err != nil {
+		ctx.Trace("evalValidSignatureP256: %v", err)
+		return nil
+	}
+	h := sha256.Sum256(ctx.Arg(0))
+	if !ecdsa.Verify(pubKey, h[:], new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])) {
+		return nil
+	}
+	return []byte{0xff}
+}
+
+func initAddressP256Constraint() {
+	MustRegisterConstraint(&ConstraintDefinition{
+		Name:   addressP256Name,
+		Source: addressP256Source,
+		Parser: func(data []byte) (Constraint, error) {
+			return AddressP256FromBytes(data)
+		},
+		IsLock:        true,
+		IsAccountable: true,
+	})
+
+	example := AddressP256Null()
+	addrBack, err := AddressP256FromBytes(example.Bytes())
+	easyfl.AssertNoError(err)
+	easyfl.Assert(Equal(addrBack, AddressP256Null()), "inconsistency "+addressP256Name)
+}
+
+const addressP256Source = `
+
+// $0 = address data 32 bytes
+// $1 = full signature with the scheme prefix
+// return true if transaction essence signature is valid for the address
+func unlockedWithSigP256: and(
+	isSignatureP256($1),
+	equal($0, blake2b(publicKeyP256($1))), // address must be equal to the hash of the compressed public key
+	validSignatureP256(txEssenceBytes, signatureP256($1), publicKeyP256($1))
+)
+
+// P-256 address constraint wraps 32 bytes address, the blake2b hash of the compressed public key
+// The consumed output is unlocked by reference or by the P-256 signature, referenced by the unlock parameters
+// $0 - P-256 address
+func addressP256: and(
+	equal(selfBlockIndex,2), // locks must be at block 2
+	or(
+		and(
+			selfIsProducedOutput,
+			equal(len8($0), 32)
+		),
+		and(
+			selfIsConsumedOutput,
+			or(
+				unlockedByReference,
+				unlockedWithSigP256($0, signatureByUnlockParams(selfUnlockParameters))
+			)
+		),
+		!!!addressP256_unlock_failed
+	)
+)
+`
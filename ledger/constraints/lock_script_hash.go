@@ -0,0 +1,127 @@
+package constraints
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/lunfardo314/easyfl"
+	"golang.org/x/crypto/blake2b"
+)
+
+// ScriptHashLock is the pay-to-script-hash lock. It commits to the blake2b hash of the EasyFL bytecode of the script.
+// The script is revealed only when the output is consumed. It is evaluated in the context of the lock, so the script
+// may be any lock or combination of constraints, for example multisig or address with the time lock.
+// The script is revealed in the auxiliary unlock parameters of the lock, because unlock parameters of the lock block
+// are used by the script itself, for example by the address lock in it
+type ScriptHashLock []byte
+
+const (
+	scriptHashLockName     = "scriptHashLock"
+	scriptHashLockTemplate = scriptHashLockName + "(0x%s)"
+)
+
+func ScriptHashLockFromBytes(data []byte) (ScriptHashLock, error) {
+	sym, _, args, err := easyfl.ParseBytecodeOneLevel(data, 1)
+	if err != nil {
+		return nil, err
+	}
+	if sym != scriptHashLockName {
+		return nil, fmt.Errorf("not a ScriptHashLock")
+	}
+	hashBin := easyfl.StripDataPrefix(args[0])
+	if len(hashBin) != 32 {
+		return nil, fmt.Errorf("wrong data length")
+	}
+	return hashBin, nil
+}
+
+// ScriptHashLockFromScript makes the lock with the hash of the script bytecode
+func ScriptHashLockFromScript(script []byte) ScriptHashLock {
+	h := blake2b.Sum256(script)
+	return h[:]
+}
+
+func ScriptHashLockNull() ScriptHashLock {
+	return make([]byte, 32)
+}
+
+func (s ScriptHashLock) source() string {
+	return fmt.Sprintf(scriptHashLockTemplate, hex.EncodeToString(s))
+}
+
+func (s ScriptHashLock) Bytes() []byte {
+	return mustBinFromSource(s.source())
+}
+
+func (s ScriptHashLock) IndexableTags() []Accountable {
+	return []Accountable{s}
+}
+
+func (s ScriptHashLock) UnlockableWith(acc AccountID, ts uint32) bool {
+	return bytes.Equal(s.AccountID(), acc)
+}
+
+func (s ScriptHashLock) AccountID() AccountID {
+	return s.Bytes()
+}
+
+func (s ScriptHashLock) Name() string {
+	return scriptHashLockName
+}
+
+func (s ScriptHashLock) String() string {
+	return s.source()
+}
+
+func (s ScriptHashLock) AsLock() Lock {
+	return s
+}
+
+func initScriptHashLockConstraint() {
+	easyfl.EmbedLong("evalRevealedScript", 1, evalRevealedScript)
+
+	MustRegisterConstraint(&ConstraintDefinition{
+		Name:   scriptHashLockName,
+		Source: scriptHashLockSource,
+		Parser: func(data []byte) (Constraint, error) {
+			return ScriptHashLockFromBytes(data)
+		},
+		IsLock:        true,
+		IsAccountable: true,
+	})
+
+	example := ScriptHashLockFromScript(AddressED25519Null().Bytes())
+	back, err := ScriptHashLockFromBytes(example.Bytes())
+	easyfl.AssertNoError(err)
+	easyfl.Assert(Equal(back, example), "inconsistency "+scriptHashLockName)
+}
+
+// arg 0 - bytecode of the script
+// Evaluates the script without parameters in the context of the invocation
+func evalRevealedScript(ctx *easyfl.CallParams) []byte {
+	ret := easyfl.CallLocalLibrary(ctx.Slice(0, 0), [][]byte{ctx.Arg(0)}, 0)
+	ctx.Trace("evalRevealedScript: -> %s", easyfl.Fmt(ret))
+	return ret
+}
+
+const scriptHashLockSource = `
+
+// $0 - blake2b hash of the script bytecode, 32 bytes
+// The script is revealed in the auxiliary unlock parameters of the lock
+func scriptHashLock: and(
+	equal(selfBlockIndex,2), // locks must be at block 2
+	or(
+		and(
+			selfIsProducedOutput,
+			equal(len8($0), 32)
+		),
+		and(
+			selfIsConsumedOutput,
+			equal(blake2b(selfLockAuxUnlockParameters), $0),
+			evalRevealedScript(selfLockAuxUnlockParameters)
+		),
+		!!!scriptHashLock_unlock_failed
+	)
+)
+`
@@ -0,0 +1,206 @@
+package constraints
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"math/bits"
+
+	"github.com/lunfardo314/easyfl"
+)
+
+// Vesting is the lock which releases Total tokens to the beneficiary linearly from Start to End.
+// Nothing is vested before the Cliff. When the output is consumed, the still unvested part of the Total
+// must be left in the successor with exactly the same lock. The successor must be the produced output
+// with the same index as the consumed output. This way two consumed outputs with the same vesting terms
+// can't share one successor
+type Vesting struct {
+	Start       uint32
+	Cliff       uint32
+	End         uint32
+	Total       uint64
+	Beneficiary Accountable
+}
+
+const (
+	vestingName     = "vesting"
+	vestingTemplate = vestingName + "(u32/%d, u32/%d, u32/%d, u64/%d, x/%s)"
+)
+
+func NewVesting(start, cliff, end uint32, total uint64, beneficiary Accountable) (*Vesting, error) {
+	if start > cliff || cliff > end || start >= end {
+		return nil, fmt.Errorf("wrong vesting schedule: must be start <= cliff <= end and start < end")
+	}
+	return &Vesting{
+		Start:       start,
+		Cliff:       cliff,
+		End:         end,
+		Total:       total,
+		Beneficiary: beneficiary,
+	}, nil
+}
+
+func (v *Vesting) source() string {
+	return fmt.Sprintf(vestingTemplate, v.Start, v.Cliff, v.End, v.Total, hex.EncodeToString(v.Beneficiary.AccountID()))
+}
+
+func (v *Vesting) Bytes() []byte {
+	return mustBinFromSource(v.source())
+}
+
+func (v *Vesting) String() string {
+	return fmt.Sprintf("%s(%d,%d,%d,%d,%s)", vestingName, v.Start, v.Cliff, v.End, v.Total, v.Beneficiary)
+}
+
+func (v *Vesting) Name() string {
+	return vestingName
+}
+
+func (v *Vesting) IndexableTags() []Accountable {
+	return []Accountable{v.Beneficiary}
+}
+
+// UnlockableWith returns true if the account is the beneficiary and some tokens are vested at the timestamp
+func (v *Vesting) UnlockableWith(acc AccountID, ts uint32) bool {
+	return ts >= v.Cliff && bytes.Equal(v.Beneficiary.AccountID(), acc)
+}
+
+// Unvested returns the part of the Total which is still not vested at the timestamp
+func (v *Vesting) Unvested(ts uint32) uint64 {
+	switch {
+	case ts < v.Cliff:
+		return v.Total
+	case ts >= v.End:
+		return 0
+	}
+	// Total * (End - ts) / (End - Start) without overflow. The quotient is not bigger than Total
+	hi, lo := bits.Mul64(v.Total, uint64(v.End-ts))
+	ret, _ := bits.Div64(hi, lo, uint64(v.End-v.Start))
+	return ret
+}
+
+// Vested returns the part of the output amount which can be taken by the beneficiary at the timestamp
+func (v *Vesting) Vested(amount uint64, ts uint32) uint64 {
+	unvested := v.Unvested(ts)
+	if unvested >= amount {
+		return 0
+	}
+	return amount - unvested
+}
+
+func VestingFromBytes(data []byte) (*Vesting, error) {
+	sym, _, args, err := easyfl.ParseBytecodeOneLevel(data, 5)
+	if err != nil {
+		return nil, err
+	}
+	if sym != vestingName {
+		return nil, fmt.Errorf("not a vesting lock")
+	}
+	startBin := easyfl.StripDataPrefix(args[0])
+	cliffBin := easyfl.StripDataPrefix(args[1])
+	endBin := easyfl.StripDataPrefix(args[2])
+	totalBin := easyfl.StripDataPrefix(args[3])
+	if len(startBin) != 4 || len(cliffBin) != 4 || len(endBin) != 4 || len(totalBin) != 8 {
+		return nil, fmt.Errorf("can't parse vesting lock")
+	}
+	beneficiary, err := AccountableFromBytes(args[4])
+	if err != nil {
+		return nil, err
+	}
+	return NewVesting(
+		binary.BigEndian.Uint32(startBin),
+		binary.BigEndian.Uint32(cliffBin),
+		binary.BigEndian.Uint32(endBin),
+		binary.BigEndian.Uint64(totalBin),
+		beneficiary,
+	)
+}
+
+func initVestingConstraint() {
+	easyfl.EmbedLong("vestingUnvested", 5, evalVestingUnvested)
+
+	MustRegisterConstraint(&ConstraintDefinition{
+		Name:   vestingName,
+		Source: vestingSource,
+		Parser: func(data []byte) (Constraint, error) {
+			return VestingFromBytes(data)
+		},
+		IsLock: true,
+	})
+
+	example, err := NewVesting(1000, 1100, 2000, 1337, AddressED25519Null())
+	easyfl.AssertNoError(err)
+	back, err := VestingFromBytes(example.Bytes())
+	easyfl.AssertNoError(err)
+	easyfl.Assert(back.Start == 1000 && back.Cliff == 1100 && back.End == 2000 && back.Total == 1337, "inconsistency "+vestingName)
+	easyfl.Assert(Equal(back.Beneficiary, AddressED25519Null()), "inconsistency "+vestingName)
+	easyfl.Assert(back.Unvested(1099) == 1337 && back.Unvested(1500) == 668 && back.Unvested(2000) == 0, "inconsistency "+vestingName)
+}
+
+// arg 0 - start, uint32
+// arg 1 - cliff, uint32
+// arg 2 - end, uint32
+// arg 3 - total, uint64
+// arg 4 - timestamp, uint32
+// Returns the unvested part of the total at the timestamp as uint64
+func evalVestingUnvested(ctx *easyfl.CallParams) []byte {
+	start, cliff, end, total, ts := ctx.Arg(0), ctx.Arg(1), ctx.Arg(2), ctx.Arg(3), ctx.Arg(4)
+	if len(start) != 4 || len(cliff) != 4 || len(end) != 4 || len(total) != 8 || len(ts) != 4 {
+		ctx.TracePanic("evalVestingUnvested: wrong data length")
+	}
+	v := &Vesting{
+		Start: binary.BigEndian.Uint32(start),
+		Cliff: binary.BigEndian.Uint32(cliff),
+		End:   binary.BigEndian.Uint32(end),
+		Total: binary.BigEndian.Uint64(total),
+	}
+	if v.Start >= v.End {
+		ctx.TracePanic("evalVestingUnvested: wrong vesting schedule")
+	}
+	var ret [8]byte
+	binary.BigEndian.PutUint64(ret[:], v.Unvested(binary.BigEndian.Uint32(ts)))
+	return ret[:]
+}
+
+const vestingSource = `
+
+// the successor of the consumed vesting output is the produced output with the same index
+func vestingSuccessor : producedOutputByIndex(selfOutputIndex)
+
+// $0 - start, Unix seconds, uint32 big-endian
+// $1 - cliff. Nothing is vested before the cliff
+// $2 - end. Everything is vested at the end
+// $3 - total amount of vested tokens, uint64 big-endian
+// $4 - beneficiary lock. It unlocks the output
+func vesting: and(
+	equal(selfBlockIndex,2), // locks must be at block 2
+	or(
+		and(
+			selfIsProducedOutput,
+			equal(len8($0), 4),
+			equal(len8($1), 4),
+			equal(len8($2), 4),
+			equal(len8($3), 8),
+			lessOrEqualThan($0, $1),
+			lessOrEqualThan($1, $2),
+			lessThan($0, $2),
+			$4
+		),
+		and(
+			selfIsConsumedOutput,
+			$4,
+			or(
+				// everything is vested, successor is not needed
+				isZero(vestingUnvested($0, $1, $2, $3, txTimestampBytes)),
+				and(
+					// the successor has the same vesting terms and keeps the unvested part
+					equal(lockConstraint(vestingSuccessor), self),
+					greaterOrEqualThan(amountValue(vestingSuccessor), vestingUnvested($0, $1, $2, $3, txTimestampBytes))
+				)
+			)
+		),
+		!!!vesting_unlock_failed
+	)
+)
+`
@@ -0,0 +1,106 @@
+package constraints
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/lunfardo314/easyfl"
+)
+
+// NativeToken constraint carries specified amount of the native token with the token ID in the output.
+// The balance of each native token between consumed and produced outputs is checked by the ledger
+
+type NativeToken struct {
+	TokenID [32]byte
+	Amount  uint64
+}
+
+const (
+	NativeTokenName     = "nativeToken"
+	nativeTokenTemplate = NativeTokenName + "(0x%s, u64/%d)"
+)
+
+func NewNativeToken(tokenID [32]byte, amount uint64) *NativeToken {
+	return &NativeToken{
+		TokenID: tokenID,
+		Amount:  amount,
+	}
+}
+
+func NativeTokenFromBytes(data []byte) (*NativeToken, error) {
+	sym, _, args, err := easyfl.ParseBytecodeOneLevel(data, 2)
+	if err != nil {
+		return nil, err
+	}
+	if sym != NativeTokenName {
+		return nil, fmt.Errorf("not a nativeToken")
+	}
+	tokenIDBin := easyfl.StripDataPrefix(args[0])
+	if len(tokenIDBin) != 32 {
+		return nil, fmt.Errorf("wrong token ID")
+	}
+	amountBin := easyfl.StripDataPrefix(args[1])
+	if len(amountBin) != 8 {
+		return nil, fmt.Errorf("wrong amount")
+	}
+	ret := &NativeToken{
+		Amount: binary.BigEndian.Uint64(amountBin),
+	}
+	copy(ret.TokenID[:], tokenIDBin)
+	return ret, nil
+}
+
+func (nt *NativeToken) source() string {
+	return fmt.Sprintf(nativeTokenTemplate, hex.EncodeToString(nt.TokenID[:]), nt.Amount)
+}
+
+func (nt *NativeToken) Bytes() []byte {
+	return mustBinFromSource(nt.source())
+}
+
+func (nt *NativeToken) Name() string {
+	return NativeTokenName
+}
+
+func (nt *NativeToken) String() string {
+	return nt.source()
+}
+
+func initNativeTokenConstraint() {
+	MustRegisterConstraint(&ConstraintDefinition{
+		Name:   NativeTokenName,
+		Source: nativeTokenSource,
+		Parser: func(data []byte) (Constraint, error) {
+			return NativeTokenFromBytes(data)
+		},
+	})
+
+	var tokenID [32]byte
+	tokenID[31] = 0xff
+	example := NewNativeToken(tokenID, 1337)
+	back, err := NativeTokenFromBytes(example.Bytes())
+	easyfl.AssertNoError(err)
+	easyfl.Assert(back.TokenID == tokenID, "inconsistency "+NativeTokenName)
+	easyfl.Assert(back.Amount == 1337, "inconsistency "+NativeTokenName)
+}
+
+const nativeTokenSource = `
+// constraint nativeToken($0, $1) makes the output to carry $1 units of the native token with ID $0
+// Only the format is checked by the constraint. The balance of native tokens between inputs and
+// outputs of the transaction is enforced by the ledger
+// $0 - 32 bytes of the token ID
+// $1 - amount of tokens, uint64 big-endian. Must be positive
+
+func nativeToken : or(
+	selfIsConsumedOutput,  // not checked in consumed branch
+	and(
+		selfIsProducedOutput,
+		lessThan(lockBlockIndex, selfBlockIndex),  // can't be at the mandatory blocks
+		equal(len8($0), 32),
+		equal(len8($1), 8),
+		not(isZero($1))
+	),
+	!!!nativeToken_constraint_failed
+)
+`
@@ -0,0 +1,157 @@
+package constraints
+
+import (
+	"encoding/hex"
+	"fmt"
+
+	"github.com/lunfardo314/easyfl"
+	"golang.org/x/crypto/blake2b"
+)
+
+// NFT constraint makes the chain a non-fungible token. The nft block must be located immediately after
+// the chain constraint (or the chain origin) in the output. The NFT ID is the chain ID, i.e. it is derived
+// from the ID of the minting output. At mint, the issuer must be the sender of the output:
+// it is enforced by the sender constraint senderAddressED25519 or senderChain, embedded as the issuer.
+// The nft constraint is immutable along the chain: each successor must repeat it exactly.
+// The NFT is burned by destroying the chain
+
+type NFT struct {
+	// Issuer is the sender constraint: *SenderAddressED25519 or *SenderChain
+	Issuer       Constraint
+	MetadataHash [32]byte
+}
+
+const (
+	NFTName     = "nft"
+	nftTemplate = NFTName + "(x/%s, 0x%s)"
+)
+
+func NewNFT(issuer Constraint, metadataHash [32]byte) *NFT {
+	return &NFT{
+		Issuer:       issuer,
+		MetadataHash: metadataHash,
+	}
+}
+
+// NewNFTFromMetadata makes the NFT with blake2b hash of the metadata
+func NewNFTFromMetadata(issuer Constraint, metadata []byte) *NFT {
+	return NewNFT(issuer, blake2b.Sum256(metadata))
+}
+
+// IssuerAccount returns the account of the issuer: address or chain lock
+func (n *NFT) IssuerAccount() Accountable {
+	switch issuer := n.Issuer.(type) {
+	case *SenderAddressED25519:
+		return issuer.Address
+	case *SenderChain:
+		return issuer.ChainLock()
+	}
+	panic("wrong NFT issuer")
+}
+
+func (n *NFT) source() string {
+	return fmt.Sprintf(nftTemplate, hex.EncodeToString(n.Issuer.Bytes()), hex.EncodeToString(n.MetadataHash[:]))
+}
+
+func (n *NFT) Bytes() []byte {
+	return mustBinFromSource(n.source())
+}
+
+func (n *NFT) Name() string {
+	return NFTName
+}
+
+func (n *NFT) String() string {
+	return fmt.Sprintf("%s(%s,%s)", NFTName, n.Issuer, easyfl.Fmt(n.MetadataHash[:]))
+}
+
+func NFTFromBytes(data []byte) (*NFT, error) {
+	sym, _, args, err := easyfl.ParseBytecodeOneLevel(data, 2)
+	if err != nil {
+		return nil, err
+	}
+	if sym != NFTName {
+		return nil, fmt.Errorf("not a nft")
+	}
+	ret := &NFT{}
+	if ret.Issuer, err = SenderAddressED25519FromBytes(args[0]); err != nil {
+		if ret.Issuer, err = SenderChainFromBytes(args[0]); err != nil {
+			return nil, fmt.Errorf("wrong issuer of the nft")
+		}
+	}
+	hashBin := easyfl.StripDataPrefix(args[1])
+	if len(hashBin) != 32 {
+		return nil, fmt.Errorf("wrong metadata hash length")
+	}
+	copy(ret.MetadataHash[:], hashBin)
+	return ret, nil
+}
+
+func initNFTConstraint() {
+	MustRegisterConstraint(&ConstraintDefinition{
+		Name:   NFTName,
+		Source: nftSource,
+		Parser: func(data []byte) (Constraint, error) {
+			return NFTFromBytes(data)
+		},
+	})
+
+	example := NewNFTFromMetadata(NewSenderAddressED25519(AddressED25519Null()), []byte("metadata"))
+	back, err := NFTFromBytes(example.Bytes())
+	easyfl.AssertNoError(err)
+	easyfl.Assert(Equal(back.IssuerAccount(), AddressED25519Null()), "inconsistency "+NFTName)
+	easyfl.Assert(back.MetadataHash == blake2b.Sum256([]byte("metadata")), "inconsistency "+NFTName)
+
+	example = NewNFTFromMetadata(NewSenderChain(blake2b.Sum256([]byte("chain")), 0, 3), []byte("metadata"))
+	back, err = NFTFromBytes(example.Bytes())
+	easyfl.AssertNoError(err)
+	easyfl.Assert(Equal(back.IssuerAccount(), ChainLock(example.Issuer.(*SenderChain).ChainID[:])), "inconsistency "+NFTName)
+}
+
+const nftSource = `
+// the chain constraint of the nft is located immediately before the nft block
+func nftChainBlockIndex : sub8(selfBlockIndex, 1)
+func nftChainConstraint : selfSiblingConstraint(nftChainBlockIndex)
+
+// $0 - produced chain constraint data
+// returns the nft in the predecessor output, located immediately after the predecessor chain constraint
+func nftPredecessor : consumedConstraintByIndex(concat(byte($0, 32), sum8(byte($0, 33), 1)))
+
+// returns the nft in the successor output, located immediately after the successor chain constraint
+func nftSuccessor : producedConstraintByIndex(
+	concat(
+		byte(selfSiblingUnlockBlock(nftChainBlockIndex), 0),
+		sum8(byte(selfSiblingUnlockBlock(nftChainBlockIndex), 1), 1)
+	)
+)
+
+// constraint nft($0, $1)
+// $0 - issuer, the embedded sender constraint. It is checked only at mint
+// $1 - blake2b hash of the metadata
+func nft: and(
+	lessThan(sum8(lockBlockIndex, 1), selfBlockIndex), // the chain must be between the lock and the nft
+	or(
+		and(
+			selfIsProducedOutput,
+			equal(len8($1), 32),
+			if(
+				equal(parseBytecodePrefix(nftChainConstraint), #chainInit),
+				// mint: the issuer must be the sender
+				$0,
+				// transfer: the nft must be repeated from the predecessor
+				equal(self, nftPredecessor(parseBytecodeArg(nftChainConstraint, #chain, 0)))
+			)
+		),
+		and(
+			selfIsConsumedOutput,
+			or(
+				// burn: the chain is destroyed
+				equal(selfSiblingUnlockBlock(nftChainBlockIndex), destroyUnlockParams),
+				// the nft must be repeated on the chain successor
+				equal(self, nftSuccessor)
+			)
+		),
+		!!!nft_constraint_failed
+	)
+)
+`
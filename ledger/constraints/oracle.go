@@ -0,0 +1,149 @@
+package constraints
+
+import (
+	"crypto/ed25519"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/lunfardo314/easyfl"
+	"github.com/lunfardo314/easyutxo/lazyslice"
+)
+
+// OracleCondition constraint makes spending of the output dependent on the external fact, reported by the oracle.
+// When the output is consumed, the unlock parameters of the constraint reveal the oracle data, signed by the oracle:
+// 4 bytes of the timestamp of the data followed by the value. The data must not be older than MaxAge seconds
+// at the transaction timestamp, and it can't be from the future. The Predicate is evaluated over the data in the
+// context of the constraint: it accesses the value with the function 'oracleValue'.
+// For example, predicate 'lessThan(u64/1000, oracleValue)' requires the reported price above 1000
+
+type OracleCondition struct {
+	OraclePublicKey ed25519.PublicKey
+	MaxAge          uint32
+	Predicate       []byte
+}
+
+const (
+	OracleConditionName     = "oracleCondition"
+	oracleConditionTemplate = OracleConditionName + "(0x%s, u32/%d, x/%s)"
+)
+
+func NewOracleCondition(oraclePublicKey ed25519.PublicKey, maxAge uint32, predicate []byte) *OracleCondition {
+	return &OracleCondition{
+		OraclePublicKey: oraclePublicKey,
+		MaxAge:          maxAge,
+		Predicate:       predicate,
+	}
+}
+
+// NewOracleConditionFromSource compiles the predicate from the EasyFL source
+func NewOracleConditionFromSource(oraclePublicKey ed25519.PublicKey, maxAge uint32, predicateSource string) (*OracleCondition, error) {
+	_, numParams, predicate, err := easyfl.CompileExpression(predicateSource)
+	if err != nil {
+		return nil, err
+	}
+	if numParams != 0 {
+		return nil, fmt.Errorf("predicate can't have parameters")
+	}
+	return NewOracleCondition(oraclePublicKey, maxAge, predicate), nil
+}
+
+// OracleData is the data signed by the oracle: timestamp followed by the value
+func OracleData(ts uint32, value []byte) []byte {
+	ret := make([]byte, 4, 4+len(value))
+	binary.BigEndian.PutUint32(ret, ts)
+	return append(ret, value...)
+}
+
+// NewOracleUnlockParams makes unlock parameters of the oracle condition: the data and the signature of the oracle
+func NewOracleUnlockParams(data, signature []byte) []byte {
+	return lazyslice.MakeArrayFromData(data, signature).Bytes()
+}
+
+// SignOracleData makes unlock parameters with the value reported by the oracle at the timestamp
+func SignOracleData(oraclePrivateKey ed25519.PrivateKey, ts uint32, value []byte) []byte {
+	data := OracleData(ts, value)
+	return NewOracleUnlockParams(data, ed25519.Sign(oraclePrivateKey, data))
+}
+
+func OracleConditionFromBytes(data []byte) (*OracleCondition, error) {
+	sym, _, args, err := easyfl.ParseBytecodeOneLevel(data, 3)
+	if err != nil {
+		return nil, err
+	}
+	if sym != OracleConditionName {
+		return nil, fmt.Errorf("not an oracleCondition")
+	}
+	pubKey := easyfl.StripDataPrefix(args[0])
+	maxAgeBin := easyfl.StripDataPrefix(args[1])
+	if len(pubKey) != ed25519.PublicKeySize || len(maxAgeBin) != 4 {
+		return nil, fmt.Errorf("can't parse oracleCondition")
+	}
+	return NewOracleCondition(pubKey, binary.BigEndian.Uint32(maxAgeBin), args[2]), nil
+}
+
+func (oc *OracleCondition) source() string {
+	return fmt.Sprintf(oracleConditionTemplate,
+		hex.EncodeToString(oc.OraclePublicKey), oc.MaxAge, hex.EncodeToString(oc.Predicate))
+}
+
+func (oc *OracleCondition) Bytes() []byte {
+	return mustBinFromSource(oc.source())
+}
+
+func (oc *OracleCondition) Name() string {
+	return OracleConditionName
+}
+
+func (oc *OracleCondition) String() string {
+	return fmt.Sprintf("%s(%s,%d,%s)", OracleConditionName, easyfl.Fmt(oc.OraclePublicKey), oc.MaxAge, easyfl.Fmt(oc.Predicate))
+}
+
+func initOracleConditionConstraint() {
+	MustRegisterConstraint(&ConstraintDefinition{
+		Name:   OracleConditionName,
+		Source: oracleConditionSource,
+		Parser: func(data []byte) (Constraint, error) {
+			return OracleConditionFromBytes(data)
+		},
+	})
+
+	example, err := NewOracleConditionFromSource(make([]byte, ed25519.PublicKeySize), 60, "lessThan(u64/1000, oracleValue)")
+	easyfl.AssertNoError(err)
+	back, err := OracleConditionFromBytes(example.Bytes())
+	easyfl.AssertNoError(err)
+	easyfl.Assert(back.MaxAge == 60 && back.String() == example.String(), "inconsistency "+OracleConditionName)
+}
+
+const oracleConditionSource = `
+// oracle data in the unlock parameters of the oracle condition: timestamp (4 bytes) || value
+func oracleData : @Array8(selfUnlockParameters, 0)
+// ED25519 signature of the oracle data, 64 bytes
+func oracleSignature : @Array8(selfUnlockParameters, 1)
+func oracleTimestamp : slice(oracleData, 0, 3)
+// the value reported by the oracle. It is used by the predicate
+func oracleValue : tail(oracleData, 4)
+
+// constraint oracleCondition($0, $1, $2)
+// $0 - ED25519 public key of the oracle
+// $1 - max age of the oracle data at the transaction timestamp, seconds, uint32 big-endian
+// $2 - predicate over the oracle value. It is evaluated only when the output is consumed
+func oracleCondition : or(
+	and(
+		selfIsProducedOutput,
+		lessThan(lockBlockIndex, selfBlockIndex),  // can't be at the mandatory blocks
+		equal(len8($0), 32),
+		equal(len8($1), 4)
+	),
+	and(
+		selfIsConsumedOutput,
+		validSignatureED25519(oracleData, oracleSignature, $0),
+		// the data can't be from the future
+		lessOrEqualThan(oracleTimestamp, txTimestampBytes),
+		// the data can't be too old
+		lessOrEqualThan(concat(u32/0, txTimestampBytes), sum32_64(oracleTimestamp, $1)),
+		$2
+	),
+	!!!oracleCondition_failed
+)
+`
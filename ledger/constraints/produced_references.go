@@ -0,0 +1,113 @@
+package constraints
+
+import (
+	"bytes"
+
+	"github.com/lunfardo314/easyfl"
+	"github.com/lunfardo314/easyutxo/lazyslice"
+)
+
+// Order fill, storage deposit return, covenant and Merkle claim constraints of consumed outputs name produced
+// outputs in their unlock parameters and count amounts of them. A produced output can be named only by one
+// constraint of the transaction, otherwise the same amount would be counted for several consumed outputs
+
+// producedOutputReferences returns indices of produced outputs named by the constraint at block index blockIdx of the
+// consumed output. unlockBlock is the unlock block of the consumed output
+func producedOutputReferences(out, unlockBlock *lazyslice.Array, blockIdx int) []byte {
+	if blockIdx >= out.NumElements() {
+		return nil
+	}
+	unlockParams := func(idx int) []byte {
+		if idx >= unlockBlock.NumElements() {
+			return nil
+		}
+		return unlockBlock.At(idx)
+	}
+	constr := out.At(blockIdx)
+	if _, err := StorageDepositReturnFromBytes(constr); err == nil {
+		if ret := unlockParams(blockIdx); len(ret) == 1 {
+			return ret
+		}
+		return nil
+	}
+	if _, err := CovenantFromBytes(constr); err == nil {
+		if params := unlockParams(blockIdx); len(params) > 0 {
+			return lazyslice.ArrayFromBytes(params, 2).At(1)
+		}
+		return nil
+	}
+	if blockIdx != int(ConstraintIndexLock) {
+		return nil
+	}
+	aux := unlockParams(int(ConstraintIndexTimestamp))
+	if len(aux) == 0 {
+		// unlocked by the owner
+		return nil
+	}
+	if _, err := OrderFromBytes(constr); err == nil {
+		if len(aux) != 2 {
+			return nil
+		}
+		if aux[1] == OrderFillNoSuccessor {
+			return aux[:1]
+		}
+		return aux
+	}
+	if _, err := MerkleClaimFromBytes(constr); err == nil {
+		return lazyslice.ArrayFromBytes(aux, 256).At(5)
+	}
+	return nil
+}
+
+// arg 0 - index of the consumed output
+// arg 1 - block index of the constraint in the consumed output
+// arg 2 - all consumed outputs, serialized lazy array
+// arg 3 - unlock parameters of all consumed outputs, serialized lazy array
+// Returns non-empty value if no other constraint of consumed outputs names produced outputs named by the constraint
+func evalUniqueProducedOutputs(ctx *easyfl.CallParams) []byte {
+	selfIdx := ctx.Arg(0)
+	selfBlockIdx := ctx.Arg(1)
+	if len(selfIdx) != 1 || len(selfBlockIdx) != 1 {
+		ctx.Trace("evalUniqueProducedOutputs: wrong index")
+		return nil
+	}
+	consumed := lazyslice.ArrayFromBytes(ctx.Arg(2), 256)
+	unlockParams := lazyslice.ArrayFromBytes(ctx.Arg(3), 256)
+
+	named := make(map[byte]struct{})
+	selfOut := lazyslice.ArrayFromBytes(consumed.At(int(selfIdx[0])), 256)
+	selfUnlockBlock := lazyslice.ArrayFromBytes(unlockParams.At(int(selfIdx[0])), 256)
+	for _, idx := range producedOutputReferences(selfOut, selfUnlockBlock, int(selfBlockIdx[0])) {
+		named[idx] = struct{}{}
+	}
+	// consumed outputs with the same covenant are counted together, so they can name the same produced outputs
+	var sameCovenant []byte
+	if _, err := CovenantFromBytes(selfOut.At(int(selfBlockIdx[0]))); err == nil {
+		sameCovenant = selfOut.At(int(selfBlockIdx[0]))
+	}
+	unique := true
+	consumed.ForEach(func(i int, data []byte) bool {
+		out := lazyslice.ArrayFromBytes(data, 256)
+		unlockBlock := lazyslice.ArrayFromBytes(unlockParams.At(i), 256)
+		for j := 0; j < out.NumElements(); j++ {
+			if i == int(selfIdx[0]) && j == int(selfBlockIdx[0]) {
+				continue
+			}
+			if len(sameCovenant) > 0 && bytes.Equal(out.At(j), sameCovenant) {
+				continue
+			}
+			for _, idx := range producedOutputReferences(out, unlockBlock, j) {
+				if _, ok := named[idx]; ok {
+					ctx.Trace("evalUniqueProducedOutputs: produced output %d is named by the block %d of the consumed output %d", idx, j, i)
+					unique = false
+					return false
+				}
+			}
+		}
+		return true
+	})
+	if !unique {
+		return nil
+	}
+	return []byte{0xff}
+}
@@ -64,7 +64,13 @@ func (cl RoyaltiesED25519) String() string {
 }
 
 func initRoyaltiesED25519Constraint() {
-	easyfl.MustExtendMany(RoyaltiesED25519Source)
+	MustRegisterConstraint(&ConstraintDefinition{
+		Name:   RoyaltiesED25519Name,
+		Source: RoyaltiesED25519Source,
+		Parser: func(data []byte) (Constraint, error) {
+			return RoyaltiesED25519FromBytes(data)
+		},
+	})
 
 	addr0 := AddressED25519Null()
 	example := NewRoyalties(addr0, 1337)
@@ -72,13 +78,6 @@ func initRoyaltiesED25519Constraint() {
 	easyfl.AssertNoError(err)
 	easyfl.Assert(Equal(royaltiesBack.Address, addr0), "inconsistency "+RoyaltiesED25519Name)
 	easyfl.Assert(royaltiesBack.Amount == 1337, "inconsistency "+RoyaltiesED25519Name)
-
-	prefix, err := easyfl.ParseBytecodePrefix(example.Bytes())
-	easyfl.AssertNoError(err)
-
-	registerConstraint(RoyaltiesED25519Name, prefix, func(data []byte) (Constraint, error) {
-		return RoyaltiesED25519FromBytes(data)
-	})
 }
 
 const RoyaltiesED25519Source = `
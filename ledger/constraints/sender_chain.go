@@ -0,0 +1,111 @@
+package constraints
+
+import (
+	"encoding/hex"
+	"fmt"
+
+	"github.com/lunfardo314/easyfl"
+	"golang.org/x/crypto/blake2b"
+)
+
+// SenderChain constraint states the chain as the sender of the output. The chain must be transited
+// in the state transition mode in the same transaction. The successor of the chain is referenced
+// by the output index and the block index in the produced outputs
+type SenderChain struct {
+	ChainID          [32]byte
+	ChainOutputIndex byte
+	ChainBlockIndex  byte
+}
+
+const (
+	SenderChainName     = "senderChain"
+	senderChainTemplate = SenderChainName + "(0x%s, 0x%s)"
+)
+
+func NewSenderChain(chainID [32]byte, chainOutputIndex, chainBlockIndex byte) *SenderChain {
+	return &SenderChain{
+		ChainID:          chainID,
+		ChainOutputIndex: chainOutputIndex,
+		ChainBlockIndex:  chainBlockIndex,
+	}
+}
+
+func (s *SenderChain) Name() string {
+	return SenderChainName
+}
+
+func (s *SenderChain) Bytes() []byte {
+	return mustBinFromSource(s.source())
+}
+
+func (s *SenderChain) String() string {
+	return fmt.Sprintf("%s(%s)", SenderChainName, easyfl.Fmt(s.ChainID[:]))
+}
+
+func (s *SenderChain) source() string {
+	return fmt.Sprintf(senderChainTemplate,
+		hex.EncodeToString(s.ChainID[:]), hex.EncodeToString([]byte{s.ChainOutputIndex, s.ChainBlockIndex}))
+}
+
+// ChainLock is the account of the sender
+func (s *SenderChain) ChainLock() ChainLock {
+	return ChainLock(s.ChainID[:])
+}
+
+func SenderChainFromBytes(data []byte) (*SenderChain, error) {
+	sym, _, args, err := easyfl.ParseBytecodeOneLevel(data, 2)
+	if err != nil {
+		return nil, err
+	}
+	if sym != SenderChainName {
+		return nil, fmt.Errorf("not a SenderChain constraint")
+	}
+	chainID := easyfl.StripDataPrefix(args[0])
+	ref := easyfl.StripDataPrefix(args[1])
+	if len(chainID) != 32 || len(ref) != 2 {
+		return nil, fmt.Errorf("can't parse SenderChain constraint")
+	}
+	ret := &SenderChain{
+		ChainOutputIndex: ref[0],
+		ChainBlockIndex:  ref[1],
+	}
+	copy(ret.ChainID[:], chainID)
+	return ret, nil
+}
+
+func initSenderChainConstraint() {
+	MustRegisterConstraint(&ConstraintDefinition{
+		Name:   SenderChainName,
+		Source: senderChainSource,
+		Parser: func(data []byte) (Constraint, error) {
+			return SenderChainFromBytes(data)
+		},
+	})
+
+	example := NewSenderChain(blake2b.Sum256([]byte("chain")), 1, 3)
+	back, err := SenderChainFromBytes(example.Bytes())
+	easyfl.AssertNoError(err)
+	easyfl.Assert(back.ChainID == example.ChainID, "inconsistency "+SenderChainName)
+	easyfl.Assert(back.ChainOutputIndex == 1 && back.ChainBlockIndex == 3, "inconsistency "+SenderChainName)
+}
+
+const senderChainSource = `
+// $0 - chain ID
+// $1 - referenced produced chain constraint data
+func validSenderChain : and(
+	equal($0, chainID($1)),
+	// the chain must be transited in the state transition mode
+	equal(transitionMode($1), 0)
+)
+
+// $0 - chain ID
+// $1 - 2 bytes: output index and block index of the successor chain constraint in the produced outputs
+func senderChain: or(
+	selfIsConsumedOutput,
+	and(
+		selfIsProducedOutput,
+		equal(len8($1), 2),
+		validSenderChain($0, parseBytecodeArg(producedConstraintByIndex($1), #chain, 0))
+	)
+)
+`
@@ -54,18 +54,20 @@ func SenderAddressED25519FromBytes(data []byte) (*SenderAddressED25519, error) {
 }
 
 func initSenderConstraint() {
-	easyfl.MustExtendMany(senderAddressED25519Source)
+	MustRegisterConstraint(&ConstraintDefinition{
+		Name:   SenderAddressED25519Name,
+		Source: senderAddressED25519Source,
+		Parser: func(data []byte) (Constraint, error) {
+			return SenderAddressED25519FromBytes(data)
+		},
+	})
 
 	addr := AddressED25519Null()
 	example := NewSenderAddressED25519(addr)
-	sym, prefix, args, err := easyfl.ParseBytecodeOneLevel(example.Bytes(), 1)
+	sym, _, args, err := easyfl.ParseBytecodeOneLevel(example.Bytes(), 1)
 	easyfl.AssertNoError(err)
 	addrBin := easyfl.StripDataPrefix(args[0])
 	common.Assert(sym == SenderAddressED25519Name && bytes.Equal(addrBin, addr), "inconsistency in 'senderAddressED25519'")
-
-	registerConstraint(SenderAddressED25519Name, prefix, func(data []byte) (Constraint, error) {
-		return SenderAddressED25519FromBytes(data)
-	})
 }
 
 const senderAddressED25519Source = `
@@ -77,7 +79,11 @@ func senderAddressED25519: or(
 		equal(
        		$0, 
 			blake2b(publicKeyED25519(txSignature))
-		)
+		),
+		// the sender must sign the whole transaction
+		isZero(sigHashModeED25519(txSignature)),
+		// signature #0 is not necessarily used for unlocking, so it is verified here
+		validSignatureED25519(txEssenceBytes, signatureED25519(txSignature), publicKeyED25519(txSignature))
 	)
 )
 `
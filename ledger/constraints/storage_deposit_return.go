@@ -0,0 +1,133 @@
+package constraints
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/lunfardo314/easyfl"
+)
+
+// StorageDepositReturn constraint forces the consumer of the output to return specified amount of tokens
+// to the return account in the same transaction. The 1-byte unlock parameters of the constraint point to the
+// produced output which returns the deposit. The return is not required after the expiry if the output is locked
+// with the deadlineLock with the return account as the expiry account
+
+type StorageDepositReturn struct {
+	ReturnAccount Accountable
+	Amount        uint64
+}
+
+const (
+	StorageDepositReturnName     = "storageDepositReturn"
+	storageDepositReturnTemplate = StorageDepositReturnName + "(0x%s, u64/%d)"
+)
+
+func NewStorageDepositReturn(returnAccount Accountable, amount uint64) *StorageDepositReturn {
+	return &StorageDepositReturn{
+		ReturnAccount: returnAccount,
+		Amount:        amount,
+	}
+}
+
+func StorageDepositReturnFromBytes(data []byte) (*StorageDepositReturn, error) {
+	sym, _, args, err := easyfl.ParseBytecodeOneLevel(data, 2)
+	if err != nil {
+		return nil, err
+	}
+	if sym != StorageDepositReturnName {
+		return nil, fmt.Errorf("not a storageDepositReturn")
+	}
+	returnAccount, err := AccountableFromBytes(easyfl.StripDataPrefix(args[0]))
+	if err != nil {
+		return nil, err
+	}
+	amountBin := easyfl.StripDataPrefix(args[1])
+	if len(amountBin) != 8 {
+		return nil, fmt.Errorf("wrong amount")
+	}
+	return NewStorageDepositReturn(returnAccount, binary.BigEndian.Uint64(amountBin)), nil
+}
+
+// ReturnRequired returns false if the output with the lock is consumed after the expiry by the return account,
+// i.e. the storage deposit return is not enforced
+func (s *StorageDepositReturn) ReturnRequired(lock Lock, ts uint32) bool {
+	dl, isDeadlineLock := lock.(*DeadlineLock)
+	if !isDeadlineLock {
+		return true
+	}
+	return ts <= dl.Deadline || !Equal(dl.ConstraintExpiry, s.ReturnAccount)
+}
+
+func (s *StorageDepositReturn) source() string {
+	return fmt.Sprintf(storageDepositReturnTemplate, hex.EncodeToString(s.ReturnAccount.AccountID()), s.Amount)
+}
+
+func (s *StorageDepositReturn) Bytes() []byte {
+	return mustBinFromSource(s.source())
+}
+
+func (s *StorageDepositReturn) Name() string {
+	return StorageDepositReturnName
+}
+
+func (s *StorageDepositReturn) String() string {
+	return fmt.Sprintf("%s(%s,%d)", StorageDepositReturnName, s.ReturnAccount, s.Amount)
+}
+
+func initStorageDepositReturnConstraint() {
+	MustRegisterConstraint(&ConstraintDefinition{
+		Name:   StorageDepositReturnName,
+		Source: storageDepositReturnSource,
+		Parser: func(data []byte) (Constraint, error) {
+			return StorageDepositReturnFromBytes(data)
+		},
+	})
+
+	example := NewStorageDepositReturn(AddressED25519Null(), 1337)
+	back, err := StorageDepositReturnFromBytes(example.Bytes())
+	easyfl.AssertNoError(err)
+	easyfl.Assert(Equal(back.ReturnAccount, AddressED25519Null()), "inconsistency "+StorageDepositReturnName)
+	easyfl.Assert(back.Amount == 1337, "inconsistency "+StorageDepositReturnName)
+}
+
+const storageDepositReturnSource = `
+// returns true if the self output is locked with the deadlineLock, the deadline has passed
+// and the expiry lock is equal to $0
+func storageDepositReturnExpired: and(
+	equal(parseBytecodePrefix(lockConstraint(selfOutputBytes)), #deadlineLock),
+	lessThan(parseBytecodeArg(lockConstraint(selfOutputBytes), #deadlineLock, 0), txTimestampBytes),
+	equal(parseBytecodeArg(lockConstraint(selfOutputBytes), #deadlineLock, 2), $0)
+)
+
+// constraint storageDepositReturn($0, $1) enforces returning exactly amount $1 to the lock $0
+// The 1-byte long unlock parameters of the constraint must point to the produced output, which returns the amount.
+// The return output can't be named by another storage deposit return, order fill, covenant or Merkle claim of the transaction.
+// The return amount cannot exceed the amount of the output.
+// The return is not enforced after expiry of the deadlineLock with $0 as the expiry lock
+func storageDepositReturn : or(
+	and(
+		selfIsProducedOutput,
+		equal(len8($1), 8),
+		lessOrEqualThan($1, amountValue(selfOutputBytes))
+	),
+	and(
+		selfIsConsumedOutput,
+		or(
+			storageDepositReturnExpired($0),
+			and(
+				equal(len8(selfUnlockParameters), 1),
+				equal($0, lockConstraint(producedOutputByIndex(selfUnlockParameters))),
+				equal($1, amountValue(producedOutputByIndex(selfUnlockParameters))),
+				uniqueProducedOutputs(
+					selfOutputIndex,
+					selfBlockIndex,
+					@Path(pathToConsumedOutputs),
+					@Path(pathToUnlockParams)
+				)
+			)
+		)
+	),
+	!!!storageDepositReturn_constraint_failed
+)
+`
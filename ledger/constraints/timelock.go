@@ -64,15 +64,17 @@ func TimelockFromBytes(data []byte) (Timelock, error) {
 }
 
 func initTimelockConstraint() {
-	easyfl.MustExtendMany(timelockSource)
+	MustRegisterConstraint(&ConstraintDefinition{
+		Name:   timelockName,
+		Source: timelockSource,
+		Parser: func(data []byte) (Constraint, error) {
+			return TimelockFromBytes(data)
+		},
+	})
 
 	example := NewTimelock(1337)
-	sym, prefix, args, err := easyfl.ParseBytecodeOneLevel(example.Bytes(), 1)
+	sym, _, args, err := easyfl.ParseBytecodeOneLevel(example.Bytes(), 1)
 	easyfl.AssertNoError(err)
 	tlBin := easyfl.StripDataPrefix(args[0])
 	common.Assert(sym == timelockName && len(tlBin) == 4 && binary.BigEndian.Uint32(tlBin) == 1337, "inconsistency in 'timelock'")
-
-	registerConstraint(timelockName, prefix, func(data []byte) (Constraint, error) {
-		return TimestampFromBytes(data)
-	})
 }
@@ -0,0 +1,89 @@
+package constraints
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/lunfardo314/easyfl"
+	"github.com/lunfardo314/unitrie/common"
+)
+
+const relativeTimelockSource = `
+// enforces output can be unlocked only after specified number of seconds since the output was produced
+// $0 is seconds of the time lock (uint32 big-endian), counted from the timestamp of the output
+func relativeTimelock: or(
+	and( 
+		selfIsProducedOutput, 
+		equal(len8($0), 4)    // must be 4-bytes long
+	), 
+	and( 
+		selfIsConsumedOutput, 
+		// is unlocked if tx timestamp is strongly after the timestamp of the output plus the time lock 
+		lessThan(sum32_64(timestampValue(selfOutputBytes), $0), concat(u32/0, txTimestampBytes))
+	) 
+)
+`
+const (
+	relativeTimelockName     = "relativeTimelock"
+	relativeTimelockTemplate = relativeTimelockName + "(u32/%d)"
+)
+
+// RelativeTimelock is the time lock relative to the timestamp of the output: the output can be consumed only
+// by the transaction with the timestamp more than the specified number of seconds after the output's timestamp
+type RelativeTimelock uint32
+
+func NewRelativeTimelock(seconds uint32) RelativeTimelock {
+	return RelativeTimelock(seconds)
+}
+
+func (t RelativeTimelock) Name() string {
+	return relativeTimelockName
+}
+
+func (t RelativeTimelock) Bytes() []byte {
+	return mustBinFromSource(t.source())
+}
+
+func (t RelativeTimelock) String() string {
+	return fmt.Sprintf("%s(%d)", relativeTimelockName, uint32(t))
+}
+
+func (t RelativeTimelock) source() string {
+	return fmt.Sprintf(relativeTimelockTemplate, t)
+}
+
+// IsExpired returns true if the output with the timestamp outputTs can be consumed at ts
+func (t RelativeTimelock) IsExpired(outputTs, ts uint32) bool {
+	return uint64(outputTs)+uint64(t) < uint64(ts)
+}
+
+func RelativeTimelockFromBytes(data []byte) (RelativeTimelock, error) {
+	sym, _, args, err := easyfl.ParseBytecodeOneLevel(data, 1)
+	if err != nil {
+		return 0, err
+	}
+	if sym != relativeTimelockName {
+		return 0, fmt.Errorf("not a relativeTimelock constraint")
+	}
+	tlBin := easyfl.StripDataPrefix(args[0])
+	if len(tlBin) != 4 {
+		return 0, fmt.Errorf("can't parse relativeTimelock")
+	}
+	return RelativeTimelock(binary.BigEndian.Uint32(tlBin)), nil
+}
+
+func initRelativeTimelockConstraint() {
+	MustRegisterConstraint(&ConstraintDefinition{
+		Name:   relativeTimelockName,
+		Source: relativeTimelockSource,
+		Parser: func(data []byte) (Constraint, error) {
+			return RelativeTimelockFromBytes(data)
+		},
+	})
+
+	example := NewRelativeTimelock(1337)
+	back, err := RelativeTimelockFromBytes(example.Bytes())
+	easyfl.AssertNoError(err)
+	common.Assert(back == example, "inconsistency in 'relativeTimelock'")
+	common.Assert(!example.IsExpired(100, 1437) && example.IsExpired(100, 1438), "inconsistency in 'relativeTimelock'")
+}
@@ -19,6 +19,10 @@ func timestamp: and(
 		and( selfIsConsumedOutput, lessThan($0, txTimestampBytes) )	
 	)
 )
+
+// utility function which extracts timestamp value from the output
+// $0 - output bytes
+func timestampValue : parseBytecodeArg(timestampConstraint($0), #timestamp, 0)
 `
 
 const (
@@ -61,15 +65,17 @@ func TimestampFromBytes(data []byte) (Timestamp, error) {
 }
 
 func initTimestampConstraint() {
-	easyfl.MustExtendMany(timestampSource)
+	MustRegisterConstraint(&ConstraintDefinition{
+		Name:   timestampName,
+		Source: timestampSource,
+		Parser: func(data []byte) (Constraint, error) {
+			return TimestampFromBytes(data)
+		},
+	})
 
 	example := NewTimestamp(1337)
-	sym, prefix, args, err := easyfl.ParseBytecodeOneLevel(example.Bytes(), 1)
+	sym, _, args, err := easyfl.ParseBytecodeOneLevel(example.Bytes(), 1)
 	easyfl.AssertNoError(err)
 	tsBin := easyfl.StripDataPrefix(args[0])
 	common.Assert(sym == timestampName && len(tsBin) == 4 && binary.BigEndian.Uint32(tsBin) == 1337, "'timestamp' consistency check failed")
-
-	registerConstraint(timestampName, prefix, func(data []byte) (Constraint, error) {
-		return TimestampFromBytes(data)
-	})
 }
@@ -28,6 +28,7 @@ type Partition byte
 const (
 	PartitionAccount = Partition(byte(iota))
 	PartitionChainID
+	PartitionNFTID
 )
 
 func (p Partition) String() string {
@@ -36,6 +37,8 @@ func (p Partition) String() string {
 		return "account"
 	case PartitionChainID:
 		return "chain"
+	case PartitionNFTID:
+		return "nft"
 	}
 	return "unknown partition"
 }
@@ -101,17 +104,26 @@ func (inr *Indexer) GetUTXOsLockedInAccount(addr constraints.Accountable, stateR
 }
 
 func (inr *Indexer) GetUTXOForChainID(id []byte, stateReader ledger.StateReadAccess) (*ledger.OutputDataWithID, error) {
+	return inr.getUTXOByID(PartitionChainID, id, stateReader)
+}
+
+// GetUTXOForNFTID returns the output which currently holds the NFT. NFT ID is the ID of its chain
+func (inr *Indexer) GetUTXOForNFTID(id []byte, stateReader ledger.StateReadAccess) (*ledger.OutputDataWithID, error) {
+	return inr.getUTXOByID(PartitionNFTID, id, stateReader)
+}
+
+func (inr *Indexer) getUTXOByID(partition Partition, id []byte, stateReader ledger.StateReadAccess) (*ledger.OutputDataWithID, error) {
 	if len(id) != 32 {
-		return nil, fmt.Errorf("GetUTXOForChainID: chainID length must be 32-byte long")
+		return nil, fmt.Errorf("GetUTXOForID: %s ID length must be 32-byte long", partition)
 	}
-	key := common.Concat(PartitionChainID, id)
+	key := common.Concat(partition, id)
 
 	inr.mutex.RLock()
 	defer inr.mutex.RUnlock()
 
 	outID := inr.store.Get(key)
 	if len(outID) == 0 {
-		return nil, fmt.Errorf("GetUTXOForChainID: indexer record for chainID '%s' has not not been found", easyfl.Fmt(id))
+		return nil, fmt.Errorf("GetUTXOForID: indexer record for %s ID '%s' has not not been found", partition, easyfl.Fmt(id))
 	}
 	oid, err := ledger.OutputIDFromBytes(outID)
 	if err != nil {
@@ -119,7 +131,7 @@ func (inr *Indexer) GetUTXOForChainID(id []byte, stateReader ledger.StateReadAcc
 	}
 	outData, found := stateReader.GetUTXO(&oid)
 	if !found {
-		return nil, fmt.Errorf("GetUTXOForChainID: chain id: %s, outputID: %s. Output has not been found", easyfl.Fmt(id), oid)
+		return nil, fmt.Errorf("GetUTXOForID: %s id: %s, outputID: %s. Output has not been found", partition, easyfl.Fmt(id), oid)
 	}
 	return &ledger.OutputDataWithID{
 		ID:         oid,
@@ -166,6 +178,18 @@ func (cmd *Command) run(w common.KVWriter) error {
 			value = cmd.OutputID[:]
 		}
 
+	case PartitionNFTID:
+		if len(cmd.ID) != 32 {
+			return fmt.Errorf("indexer: NFT ID should be 32 bytes")
+		}
+		// ID is NFT ID
+		// key = 0x02 || ID
+		// value = outputID
+		key = common.Concat(PartitionNFTID, cmd.ID)
+		if !cmd.Delete {
+			value = cmd.OutputID[:]
+		}
+
 	default:
 		return fmt.Errorf("unsupported indeexer partition '%d'", cmd.Partition)
 	}
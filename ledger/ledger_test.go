@@ -2,14 +2,23 @@ package ledger_test
 
 import (
 	"bytes"
+	"crypto/ecdsa"
 	"crypto/ed25519"
+	"crypto/elliptic"
+	crand "crypto/rand"
+	"encoding/binary"
 	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"math/rand"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/lunfardo314/easyfl"
+	"github.com/lunfardo314/easyutxo/lazyslice"
 	"github.com/lunfardo314/easyutxo/ledger"
 	"github.com/lunfardo314/easyutxo/ledger/constraints"
 	"github.com/lunfardo314/easyutxo/ledger/state"
@@ -142,7 +151,87 @@ func TestMainConstraints(t *testing.T) {
 	})
 }
 
+func TestStorageDepositParams(t *testing.T) {
+	params := &constraints.StorageDepositParams{
+		VByteCost:   10,
+		DataWeight:  1,
+		KeyWeight:   40,
+		ExtraWeight: 5,
+	}
+	var u *utxodb.UTXODB
+	var privKey0 ed25519.PrivateKey
+	var addr0, addr1 constraints.AddressED25519
+
+	initTest := func() {
+		u = utxodb.NewUTXODBWithStorageDepositParams(params, true)
+		privKey0, _, addr0 = u.GenerateAddress(0)
+		_, _, addr1 = u.GenerateAddress(1)
+		err := u.TokensFromFaucet(addr0, 100000)
+		require.NoError(t, err)
+	}
+	t.Run("genesis", func(t *testing.T) {
+		initTest()
+		require.EqualValues(t, params.Bytes(), u.StorageDepositParams().Bytes())
+		require.EqualValues(t, 100000, u.Balance(addr0))
+	})
+	t.Run("minimum", func(t *testing.T) {
+		initTest()
+		par, err := u.MakeTransferData(privKey0, nil, 0)
+		require.NoError(t, err)
+		par.WithAmount(1, true).WithTargetLock(addr1)
+		minimum := par.AdjustedAmount()
+		out := txbuilder.OutputBasic(minimum, 0, addr1)
+		require.EqualValues(t, params.MinimumStorageDeposit(uint32(len(out.Bytes())), 0), minimum)
+		require.EqualValues(t, 10*(len(out.Bytes())+40), minimum)
+
+		err = u.DoTransfer(par)
+		require.NoError(t, err)
+		require.EqualValues(t, minimum, u.Balance(addr1))
+	})
+	t.Run("below minimum", func(t *testing.T) {
+		initTest()
+		par, err := u.MakeTransferData(privKey0, nil, 0)
+		require.NoError(t, err)
+		par.WithAmount(1, true).WithTargetLock(addr1)
+		minimum := par.AdjustedAmount()
+
+		par, err = u.MakeTransferData(privKey0, nil, 0)
+		require.NoError(t, err)
+		err = u.DoTransfer(par.WithAmount(minimum - 1).WithTargetLock(addr1))
+		easyfl.RequireErrorWith(t, err, "not enough storage deposit")
+	})
+	t.Run("extra weight", func(t *testing.T) {
+		initTest()
+		// constraint which returns 4 bytes is valid and adds its value to the extra weight of the output
+		extraWeight, err := constraints.NewGeneralScriptFromSource("u32/100")
+		require.NoError(t, err)
+		par, err := u.MakeTransferData(privKey0, nil, 0)
+		require.NoError(t, err)
+		par.WithAmount(1, true).WithTargetLock(addr1).WithConstraint(extraWeight)
+		minimum := par.AdjustedAmount()
+
+		par, err = u.MakeTransferData(privKey0, nil, 0)
+		require.NoError(t, err)
+		err = u.DoTransfer(par.WithAmount(minimum).WithTargetLock(addr1).WithConstraint(extraWeight))
+		easyfl.RequireErrorWith(t, err, "not enough storage deposit in output")
+
+		par, err = u.MakeTransferData(privKey0, nil, 0)
+		require.NoError(t, err)
+		err = u.DoTransfer(par.WithAmount(minimum + 10*5*100).WithTargetLock(addr1).WithConstraint(extraWeight))
+		require.NoError(t, err)
+		require.EqualValues(t, minimum+10*5*100, u.Balance(addr1))
+	})
+}
+
 func TestTimelock(t *testing.T) {
+	t.Run("parse", func(t *testing.T) {
+		ts := uint32(time.Now().Unix())
+		c, err := constraints.FromBytes(constraints.NewTimelock(ts).Bytes())
+		require.NoError(t, err)
+		tl, isTimelock := c.(constraints.Timelock)
+		require.True(t, isTimelock)
+		require.EqualValues(t, ts, uint32(tl))
+	})
 	t.Run("time lock 1", func(t *testing.T) {
 		u := utxodb.NewUTXODB(true)
 		privKey0, _, addr0 := u.GenerateAddress(0)
@@ -258,6 +347,94 @@ func TestTimelock(t *testing.T) {
 	})
 }
 
+func TestRelativeTimelock(t *testing.T) {
+	var privKey0, privKey1 ed25519.PrivateKey
+	var addr0, addr1 constraints.AddressED25519
+	var u *utxodb.UTXODB
+	var lockedTs uint32
+
+	// addr0 sends 2000 to addr1 with relative time lock of 10 seconds
+	initTest := func() {
+		u = utxodb.NewUTXODB(true)
+		privKey0, _, addr0 = u.GenerateAddress(0)
+		privKey1, _, addr1 = u.GenerateAddress(1)
+		err := u.TokensFromFaucet(addr0, 10000)
+		require.NoError(t, err)
+
+		par, err := u.MakeTransferData(privKey0, nil, 0)
+		require.NoError(t, err)
+		outs, err := u.DoTransferOutputs(par.
+			WithAmount(2000).
+			WithTargetLock(addr1).
+			WithConstraint(constraints.NewRelativeTimelock(10)),
+		)
+		require.NoError(t, err)
+		for _, o := range outs {
+			out, err := txbuilder.OutputFromBytes(o.OutputData)
+			require.NoError(t, err)
+			if rtl, ok := out.RelativeTimeLock(); ok {
+				require.EqualValues(t, 10, rtl)
+				lockedTs = out.Timestamp()
+			}
+		}
+		require.True(t, lockedTs > 0)
+	}
+	// spend consumes the locked output at the timestamp, bypassing the filter of utxodb
+	spend := func(ts uint32) error {
+		outsData, err := u.IndexerAccess().GetUTXOsLockedInAccount(addr1, u.StateReader())
+		require.NoError(t, err)
+		outs, err := txbuilder.ParseAndSortOutputData(outsData, nil)
+		require.NoError(t, err)
+		par := txbuilder.NewTransferData(privKey1, addr1, ts).WithLedgerIdentity(u.LedgerIdentity()).
+			WithOutputs(outs).
+			WithAmount(2000).
+			WithTargetLock(addr0)
+		txBytes, err := txbuilder.MakeTransferTransaction(par)
+		require.NoError(t, err)
+		return u.AddTransaction(txBytes, state.TraceOptionFailedConstraints)
+	}
+	t.Run("balance", func(t *testing.T) {
+		initTest()
+		require.EqualValues(t, 2000, u.Balance(addr1))
+		require.EqualValues(t, 0, u.Balance(addr1, lockedTs+10))
+		require.EqualValues(t, 2000, u.Balance(addr1, lockedTs+11))
+
+		par, err := u.MakeTransferData(privKey1, nil, lockedTs+10)
+		require.NoError(t, err)
+		require.EqualValues(t, 0, len(par.Outputs))
+		par, err = u.MakeTransferData(privKey1, nil, lockedTs+11)
+		require.NoError(t, err)
+		require.EqualValues(t, 1, len(par.Outputs))
+	})
+	t.Run("locked", func(t *testing.T) {
+		initTest()
+		err := spend(lockedTs + 1)
+		easyfl.RequireErrorWith(t, err, "relativeTimelock")
+		err = spend(lockedTs + 10)
+		easyfl.RequireErrorWith(t, err, "relativeTimelock")
+		require.EqualValues(t, 2000, u.Balance(addr1))
+	})
+	t.Run("expired", func(t *testing.T) {
+		initTest()
+		err := spend(lockedTs + 11)
+		require.NoError(t, err)
+		require.EqualValues(t, 0, u.Balance(addr1))
+		require.EqualValues(t, 10000, u.Balance(addr0))
+	})
+	t.Run("transfer", func(t *testing.T) {
+		initTest()
+		par, err := u.MakeTransferData(privKey1, nil, lockedTs+10)
+		require.NoError(t, err)
+		err = u.DoTransfer(par.WithAmount(2000).WithTargetLock(addr0))
+		require.Error(t, err)
+		par, err = u.MakeTransferData(privKey1, nil, lockedTs+11)
+		require.NoError(t, err)
+		err = u.DoTransfer(par.WithAmount(2000).WithTargetLock(addr0))
+		require.NoError(t, err)
+		require.EqualValues(t, 10000, u.Balance(addr0))
+	})
+}
+
 func TestDeadlineLock(t *testing.T) {
 	u := utxodb.NewUTXODB(true)
 	privKey0, pubKey0, addr0 := u.GenerateAddress(0)
@@ -304,6 +481,37 @@ func TestDeadlineLock(t *testing.T) {
 	require.EqualValues(t, 0, u.NumUTXOs(addr1, ts+11))
 	require.EqualValues(t, 2000, int(u.Balance(addr1, ts+10)))
 	require.EqualValues(t, 0, int(u.Balance(addr1, ts+11)))
+
+	// the ledger must agree with DeadlineLock.UnlockableWith: the main lock before the deadline, the expiry lock after
+	deadlineOutputs := func() []*txbuilder.OutputWithID {
+		outsData, err := u.IndexerAccess().GetUTXOsLockedInAccount(addr1, u.StateReader())
+		require.NoError(t, err)
+		outs, err := txbuilder.ParseAndSortOutputData(outsData, func(o *txbuilder.Output) bool {
+			_, isDeadlineLock := o.Lock().(*constraints.DeadlineLock)
+			return isDeadlineLock
+		})
+		require.NoError(t, err)
+		require.EqualValues(t, 1, len(outs))
+		return outs
+	}
+	spend := func(privKey ed25519.PrivateKey, addr constraints.AddressED25519, txTs uint32) error {
+		par := txbuilder.NewTransferData(privKey, addr, txTs).WithLedgerIdentity(u.LedgerIdentity()).
+			WithOutputs(deadlineOutputs()).
+			WithAmount(2000).
+			WithTargetLock(addr)
+		return u.DoTransfer(par)
+	}
+	privKey1, _, _ := u.GenerateAddress(1)
+	t.Run("expiry lock before deadline", func(t *testing.T) {
+		require.Error(t, spend(privKey0, addr0, ts+10))
+	})
+	t.Run("main lock after deadline", func(t *testing.T) {
+		require.Error(t, spend(privKey1, addr1, ts+11))
+	})
+	t.Run("main lock before deadline", func(t *testing.T) {
+		require.NoError(t, spend(privKey1, addr1, ts+10))
+		require.EqualValues(t, 2000, u.Balance(addr1))
+	})
 }
 
 func TestSenderAddressED25519(t *testing.T) {
@@ -340,6 +548,38 @@ func TestSenderAddressED25519(t *testing.T) {
 	saddr, ok := outs[0].Output.SenderAddressED25519()
 	require.True(t, ok)
 	require.True(t, constraints.Equal(addr0, saddr))
+
+	// the attacker unlocks own input with the signature #1 and puts forged signature #0 with the public key of the victim
+	forge := func(constraintsToPush ...constraints.Constraint) error {
+		privKey1, _, _ := u.GenerateAddress(1)
+		pubKey0 := privKey0.Public().(ed25519.PublicKey)
+		ts := outs[0].Output.Timestamp() + 1
+		txb := txbuilder.NewTransactionBuilder(u.LedgerIdentity())
+		_, err := txb.ConsumeOutput(outs[0].Output, outs[0].ID)
+		require.NoError(t, err)
+		txb.PutSignatureUnlock(0, constraints.ConstraintIndexLock, 1)
+		out := txbuilder.OutputBasic(outs[0].Output.Amount(), ts, addr1)
+		for _, c := range constraintsToPush {
+			_, err = out.PushConstraint(c.Bytes())
+			require.NoError(t, err)
+		}
+		_, err = txb.ProduceOutput(out)
+		require.NoError(t, err)
+		txb.Transaction.Timestamp = ts
+		txb.Transaction.InputCommitment = txb.InputCommitment()
+		txb.Transaction.Signatures = append(txb.Transaction.Signatures, append(make([]byte, 64), pubKey0...))
+		require.EqualValues(t, 1, txb.SignED25519(privKey1))
+		return u.AddTransaction(txb.Transaction.Bytes())
+	}
+	t.Run("forged sender", func(t *testing.T) {
+		err := forge(constraints.NewSenderAddressED25519(addr0))
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "senderAddressED25519")
+	})
+	t.Run("forged nft issuer", func(t *testing.T) {
+		err := forge(constraints.NewChainInit(), constraints.NewNFTFromMetadata(constraints.NewSenderAddressED25519(addr0), []byte("artwork #1")))
+		easyfl.RequireErrorWith(t, err, "nft constraint failed")
+	})
 }
 
 func TestChain1(t *testing.T) {
@@ -362,7 +602,7 @@ func TestChain1(t *testing.T) {
 		outs, err := u.DoTransferOutputs(par.
 			WithAmount(2000).
 			WithTargetLock(addr0).
-			WithConstraint(constraints.NewChainOrigin()),
+			WithConstraint(constraints.NewChainInit()),
 		)
 		require.NoError(t, err)
 		require.EqualValues(t, 1, u.NumUTXOs(u.GenesisAddress()))
@@ -375,7 +615,7 @@ func TestChain1(t *testing.T) {
 		return chains
 	}
 	t.Run("compile", func(t *testing.T) {
-		const source = "chain(originChainData)"
+		const source = "chainInit"
 		_, _, _, err := easyfl.CompileExpression(source)
 		require.NoError(t, err)
 	})
@@ -385,7 +625,7 @@ func TestChain1(t *testing.T) {
 	t.Run("create origin ok 2", func(t *testing.T) {
 		initTest()
 
-		const source = "chain(originChainData)"
+		const source = "chainInit"
 		_, _, code, err := easyfl.CompileExpression(source)
 		require.NoError(t, err)
 
@@ -404,7 +644,7 @@ func TestChain1(t *testing.T) {
 	t.Run("create origin twice in same output", func(t *testing.T) {
 		initTest()
 
-		const source = "chain(originChainData)"
+		const source = "chainInit"
 		_, _, code, err := easyfl.CompileExpression(source)
 		require.NoError(t, err)
 
@@ -433,6 +673,12 @@ func TestChain1(t *testing.T) {
 		err = u.DoTransfer(par.WithConstraintBinary(bytes.Repeat([]byte{0}, 35)))
 		require.Error(t, err)
 
+		// the former all-zero origin encoding is not a valid chain
+		_, _, code, err = easyfl.CompileExpression("chain(0x" + hex.EncodeToString(bytes.Repeat([]byte{0}, 32)) + "ffffff)")
+		require.NoError(t, err)
+		err = u.DoTransfer(par.WithConstraintBinary(code))
+		require.Error(t, err)
+
 		err = u.DoTransfer(par.WithConstraintBinary(nil))
 		require.Error(t, err)
 	})
@@ -443,9 +689,9 @@ func TestChain1(t *testing.T) {
 		require.NoError(t, err)
 		o, err := txbuilder.OutputFromBytes(chs.OutputData)
 		require.NoError(t, err)
-		ch, idx := o.ChainConstraint()
-		require.True(t, idx != 0xff)
-		require.True(t, ch.IsOrigin())
+		require.True(t, o.ChainInit() != 0xff)
+		_, idx := o.ChainConstraint()
+		require.True(t, idx == 0xff)
 		t.Logf("chain created: %s", easyfl.Fmt(chains[0].ChainID[:]))
 	})
 	t.Run("create-destroy", func(t *testing.T) {
@@ -457,9 +703,8 @@ func TestChain1(t *testing.T) {
 
 		chainIN, err := txbuilder.OutputFromBytes(chs.OutputData)
 		require.NoError(t, err)
-		ch, predecessorConstraintIndex := chainIN.ChainConstraint()
+		predecessorConstraintIndex := chainIN.ChainInit()
 		require.True(t, predecessorConstraintIndex != 0xff)
-		require.True(t, ch.IsOrigin())
 		t.Logf("chain created: %s", easyfl.Fmt(chains[0].ChainID[:]))
 
 		require.EqualValues(t, 1, u.NumUTXOs(u.GenesisAddress()))
@@ -468,7 +713,7 @@ func TestChain1(t *testing.T) {
 		require.EqualValues(t, 2, u.NumUTXOs(addr0))
 
 		ts := chainIN.Timestamp() + 1
-		txb := txbuilder.NewTransactionBuilder()
+		txb := txbuilder.NewTransactionBuilder(u.LedgerIdentity())
 		consumedIndex, err := txb.ConsumeOutput(chainIN, chains[0].ID)
 		require.NoError(t, err)
 		outNonChain := txbuilder.NewOutput().
@@ -497,6 +742,40 @@ func TestChain1(t *testing.T) {
 		require.EqualValues(t, 10000, u.Balance(addr0))
 		require.EqualValues(t, 2, u.NumUTXOs(addr0))
 	})
+	t.Run("origin succeeded by non-chain constraint", func(t *testing.T) {
+		chains := initTest2()
+		chs, err := u.IndexerAccess().GetUTXOForChainID(chains[0].ChainID[:], u.StateReader())
+		require.NoError(t, err)
+		chainIN, err := txbuilder.OutputFromBytes(chs.OutputData)
+		require.NoError(t, err)
+		predecessorConstraintIndex := chainIN.ChainInit()
+
+		ts := chainIN.Timestamp() + 1
+		txb := txbuilder.NewTransactionBuilder(u.LedgerIdentity())
+		consumedIndex, err := txb.ConsumeOutput(chainIN, chains[0].ID)
+		require.NoError(t, err)
+		// the successor carries valid chain data, but it is not the chain constraint
+		chainData := append(chains[0].ChainID[:], consumedIndex, predecessorConstraintIndex, constraints.ChainTransitionModeState)
+		_, _, code, err := easyfl.CompileExpression("or(0x" + hex.EncodeToString(chainData) + ")")
+		require.NoError(t, err)
+		outNonChain := txbuilder.NewOutput().
+			WithAmount(chainIN.Amount()).
+			WithTimestamp(ts).
+			WithLock(chainIN.Lock())
+		successorBlockIndex, err := outNonChain.PushConstraint(code)
+		require.NoError(t, err)
+		successorIndex, err := txb.ProduceOutput(outNonChain)
+		require.NoError(t, err)
+
+		txb.PutUnlockParams(consumedIndex, predecessorConstraintIndex, []byte{successorIndex, successorBlockIndex, constraints.ChainTransitionModeState})
+		txb.PutSignatureUnlock(consumedIndex, constraints.ConstraintIndexLock)
+		txb.Transaction.Timestamp = ts
+		txb.Transaction.InputCommitment = txb.InputCommitment()
+		txb.SignED25519(privKey0)
+
+		err = u.AddTransaction(txb.Transaction.Bytes())
+		easyfl.RequireErrorWith(t, err, "chainInit constraint failed")
+	})
 }
 
 func TestChain2(t *testing.T) {
@@ -519,7 +798,7 @@ func TestChain2(t *testing.T) {
 		outs, err := u.DoTransferOutputs(par.
 			WithAmount(2000).
 			WithTargetLock(addr0).
-			WithConstraint(constraints.NewChainOrigin()),
+			WithConstraint(constraints.NewChainInit()),
 		)
 		require.NoError(t, err)
 		require.EqualValues(t, 1, u.NumUTXOs(u.GenesisAddress()))
@@ -542,11 +821,11 @@ func TestChain2(t *testing.T) {
 		chainIN, err := txbuilder.OutputFromBytes(chs.OutputData)
 		require.NoError(t, err)
 
-		_, constraintIdx := chainIN.ChainConstraint()
+		constraintIdx := chainIN.ChainBlockIndex()
 		require.True(t, constraintIdx != 0xff)
 
 		ts := chainIN.Timestamp() + 1
-		txb := txbuilder.NewTransactionBuilder()
+		txb := txbuilder.NewTransactionBuilder(u.LedgerIdentity())
 		predIdx, err := txb.ConsumeOutput(chainIN, chains[0].ID)
 		require.NoError(t, err)
 
@@ -679,7 +958,7 @@ func TestChain3(t *testing.T) {
 		outs, err := u.DoTransferOutputs(par.
 			WithAmount(2000).
 			WithTargetLock(addr0).
-			WithConstraint(constraints.NewChainOrigin()),
+			WithConstraint(constraints.NewChainInit()),
 		)
 		require.NoError(t, err)
 		require.EqualValues(t, 1, u.NumUTXOs(u.GenesisAddress()))
@@ -701,11 +980,11 @@ func TestChain3(t *testing.T) {
 	chainIN, err := txbuilder.OutputFromBytes(chs.OutputData)
 	require.NoError(t, err)
 
-	_, constraintIdx := chainIN.ChainConstraint()
+	constraintIdx := chainIN.ChainBlockIndex()
 	require.True(t, constraintIdx != 0xff)
 
 	ts := chainIN.Timestamp() + 1
-	txb := txbuilder.NewTransactionBuilder()
+	txb := txbuilder.NewTransactionBuilder(u.LedgerIdentity())
 	predIdx, err := txb.ConsumeOutput(chainIN, chains[0].ID)
 	require.NoError(t, err)
 
@@ -761,7 +1040,7 @@ func TestChainLock(t *testing.T) {
 		outs, err := u.DoTransferOutputs(par.
 			WithAmount(2000).
 			WithTargetLock(addr0).
-			WithConstraint(constraints.NewChainOrigin()),
+			WithConstraint(constraints.NewChainInit()),
 		)
 		require.NoError(t, err)
 		require.EqualValues(t, 1, u.NumUTXOs(u.GenesisAddress()))
@@ -843,6 +1122,120 @@ func TestChainLock(t *testing.T) {
 		require.EqualValues(t, 2500, int(onChainOut))
 		require.EqualValues(t, 11000, int(u.Balance(addr0))) // also includes 500 on chain
 	})
+	t.Run("self referencing chain lock", func(t *testing.T) {
+		initTest2()
+		transit := func(modify ...func(o *txbuilder.Output)) error {
+			chs, err := u.IndexerAccess().GetUTXOForChainID(chainID[:], u.StateReader())
+			require.NoError(t, err)
+			chainIN, err := txbuilder.OutputFromBytes(chs.OutputData)
+			require.NoError(t, err)
+			ts := chainIN.Timestamp() + 1
+			txb := txbuilder.NewTransactionBuilder(u.LedgerIdentity())
+			err = txb.InsertChainTransition(&ledger.OutputDataWithChainID{
+				OutputDataWithID: *chs,
+				ChainID:          chainID,
+			}, ts, constraints.ChainTransitionModeState, modify...)
+			require.NoError(t, err)
+			txb.Transaction.Timestamp = ts
+			txb.Transaction.InputCommitment = txb.InputCommitment()
+			txb.SignED25519(privKey0)
+			return u.AddTransaction(txb.Transaction.Bytes(), state.TraceOptionFailedConstraints)
+		}
+		// chain output is locked with its own chain lock
+		err := transit(func(o *txbuilder.Output) {
+			o.WithLock(chainAddr)
+		})
+		require.NoError(t, err)
+
+		// the chain can't be unlocked by its own transition
+		chs, err := u.IndexerAccess().GetUTXOForChainID(chainID[:], u.StateReader())
+		require.NoError(t, err)
+		chainIN, err := txbuilder.OutputFromBytes(chs.OutputData)
+		require.NoError(t, err)
+		constraintIdx := chainIN.ChainBlockIndex()
+		ts := chainIN.Timestamp() + 1
+		txb := txbuilder.NewTransactionBuilder(u.LedgerIdentity())
+		predIdx, err := txb.ConsumeOutput(chainIN, chs.ID)
+		require.NoError(t, err)
+		chainOut := chainIN.Clone().WithTimestamp(ts)
+		chainOut.PutConstraint(constraints.NewChainConstraint(chainID, predIdx, constraintIdx, 0).Bytes(), constraintIdx)
+		succIdx, err := txb.ProduceOutput(chainOut)
+		require.NoError(t, err)
+		txb.PutUnlockParams(predIdx, constraintIdx, []byte{succIdx, constraintIdx, 0})
+		txb.PutUnlockParams(predIdx, constraints.ConstraintIndexLock, constraints.NewChainLockUnlockParams(succIdx, constraintIdx))
+		txb.Transaction.Timestamp = ts
+		txb.Transaction.InputCommitment = txb.InputCommitment()
+		txb.SignED25519(privKey0)
+		err = u.AddTransaction(txb.Transaction.Bytes(), state.TraceOptionFailedConstraints)
+		require.Error(t, err)
+	})
+	t.Run("deadline lock", func(t *testing.T) {
+		initTest2()
+		ts := uint32(time.Now().Unix()) + 5
+
+		// chain is the main account before the deadline
+		toChain := constraints.NewDeadlineLock(ts+100, chainAddr, addr1)
+		lockBack, err := constraints.LockFromBytes(toChain.Bytes())
+		require.NoError(t, err)
+		require.EqualValues(t, toChain.Bytes(), lockBack.Bytes())
+		// chain is the expiry account after the deadline
+		fromChain := constraints.NewDeadlineLock(ts+100, addr1, chainAddr)
+
+		par, err := u.MakeTransferData(privKey1, nil, ts)
+		require.NoError(t, err)
+		err = u.DoTransfer(par.WithAmount(1000).WithTargetLock(toChain))
+		require.NoError(t, err)
+		par, err = u.MakeTransferData(privKey1, nil, ts+1)
+		require.NoError(t, err)
+		err = u.DoTransfer(par.WithAmount(3000).WithTargetLock(fromChain))
+		require.NoError(t, err)
+
+		// both outputs are indexed by the chain and by the address
+		require.EqualValues(t, 2, u.NumUTXOs(chainAddr))
+		require.EqualValues(t, 4000, u.Balance(chainAddr))
+		require.EqualValues(t, 1000, u.Balance(chainAddr, ts+100))
+		require.EqualValues(t, 3000, u.Balance(chainAddr, ts+101))
+		require.EqualValues(t, 20000, u.Balance(addr1))
+
+		// before the deadline the chain consumes only the output where it is the main account
+		par, err = u.MakeTransferData(privKey0, chainAddr, ts+2)
+		require.NoError(t, err)
+		require.EqualValues(t, 1, len(par.Outputs))
+		err = u.DoTransfer(par.WithAmount(500).WithTargetLock(addr0))
+		require.NoError(t, err)
+		onLocked, onChainOut, err := u.BalanceOnChain(chainID[:])
+		require.NoError(t, err)
+		require.EqualValues(t, 3000, int(onLocked))
+		require.EqualValues(t, 2500, int(onChainOut))
+
+		// the output where the chain is expiry account can't be consumed before the deadline
+		outs, err := u.IndexerAccess().GetUTXOsLockedInAccount(chainAddr, u.StateReader())
+		require.NoError(t, err)
+		chainOut, _, err := txbuilder.GetChainAccount(chainID[:], u.IndexerAccess(), u.StateReader())
+		require.NoError(t, err)
+		parsedOuts, err := txbuilder.ParseAndSortOutputData(outs, nil)
+		require.NoError(t, err)
+		_, err = txbuilder.MakeTransferTransaction(txbuilder.NewTransferData(privKey0, chainAddr, ts+3).WithLedgerIdentity(u.LedgerIdentity()).
+			WithOutputs(parsedOuts).
+			WithChainOutput(chainOut).
+			WithAmount(500).
+			WithTargetLock(addr0),
+		)
+		easyfl.RequireErrorWith(t, err, "can't be unlocked by the chain")
+
+		// after the deadline the chain consumes the expired output
+		par, err = u.MakeTransferData(privKey0, chainAddr, ts+101)
+		require.NoError(t, err)
+		require.EqualValues(t, 1, len(par.Outputs))
+		err = u.DoTransfer(par.WithAmount(500).WithTargetLock(addr0))
+		require.NoError(t, err)
+		onLocked, onChainOut, err = u.BalanceOnChain(chainID[:])
+		require.NoError(t, err)
+		require.EqualValues(t, 0, int(onLocked))
+		require.EqualValues(t, 5000, int(onChainOut))
+		require.EqualValues(t, 0, u.NumUTXOs(chainAddr))
+		require.EqualValues(t, 16000, int(u.Balance(addr1)))
+	})
 
 }
 
@@ -922,7 +1315,7 @@ func TestHashUnlock(t *testing.T) {
 	require.NoError(t, err)
 
 	// produce transaction without providing hash unlocking library for the output with script
-	par = txbuilder.NewTransferData(privKey0, addr0, 0)
+	par = txbuilder.NewTransferData(privKey0, addr0, 0).WithLedgerIdentity(u.LedgerIdentity())
 	par.WithOutputs(outs).
 		WithAmount(1000).
 		WithTargetLock(addr0)
@@ -1036,7 +1429,7 @@ func TestImmutable(t *testing.T) {
 	par, err := u.MakeTransferData(privKey, nil, uint32(time.Now().Unix()))
 	par.WithAmount(2000).
 		WithTargetLock(addr0).
-		WithConstraint(constraints.NewChainOrigin())
+		WithConstraint(constraints.NewChainInit())
 	txbytes, err := txbuilder.MakeTransferTransaction(par)
 	require.NoError(t, err)
 	t.Logf("tx1 = %s", u.TxToString(txbytes))
@@ -1061,11 +1454,11 @@ func TestImmutable(t *testing.T) {
 	chainIN, err := txbuilder.OutputFromBytes(chs.OutputData)
 	require.NoError(t, err)
 
-	_, constraintIdx := chainIN.ChainConstraint()
+	constraintIdx := chainIN.ChainBlockIndex()
 	require.True(t, constraintIdx != 0xff)
 
 	ts := chainIN.Timestamp() + 1
-	txb := txbuilder.NewTransactionBuilder()
+	txb := txbuilder.NewTransactionBuilder(u.LedgerIdentity())
 	predIdx, err := txb.ConsumeOutput(chainIN, chains[0].ID)
 	require.NoError(t, err)
 
@@ -1107,11 +1500,11 @@ func TestImmutable(t *testing.T) {
 	chainIN, err = txbuilder.OutputFromBytes(chs.OutputData)
 	require.NoError(t, err)
 
-	_, constraintIdx = chainIN.ChainConstraint()
+	constraintIdx = chainIN.ChainBlockIndex()
 	require.True(t, constraintIdx != 0xff)
 
 	ts = chainIN.Timestamp() + 1
-	txb = txbuilder.NewTransactionBuilder()
+	txb = txbuilder.NewTransactionBuilder(u.LedgerIdentity())
 	predIdx, err = txb.ConsumeOutput(chainIN, chs.ID)
 	require.NoError(t, err)
 
@@ -1145,11 +1538,11 @@ func TestImmutable(t *testing.T) {
 	chainIN, err = txbuilder.OutputFromBytes(chs.OutputData)
 	require.NoError(t, err)
 
-	_, constraintIdx = chainIN.ChainConstraint()
+	constraintIdx = chainIN.ChainBlockIndex()
 	require.True(t, constraintIdx != 0xff)
 
 	ts = chainIN.Timestamp() + 1
-	txb = txbuilder.NewTransactionBuilder()
+	txb = txbuilder.NewTransactionBuilder(u.LedgerIdentity())
 	predIdx, err = txb.ConsumeOutput(chainIN, chs.ID)
 	require.NoError(t, err)
 
@@ -1191,11 +1584,11 @@ func TestImmutable(t *testing.T) {
 	chainIN, err = txbuilder.OutputFromBytes(chs.OutputData)
 	require.NoError(t, err)
 
-	_, constraintIdx = chainIN.ChainConstraint()
+	constraintIdx = chainIN.ChainBlockIndex()
 	require.True(t, constraintIdx != 0xff)
 
 	ts = chainIN.Timestamp() + 1
-	txb = txbuilder.NewTransactionBuilder()
+	txb = txbuilder.NewTransactionBuilder(u.LedgerIdentity())
 	predIdx, err = txb.ConsumeOutput(chainIN, chs.ID)
 	require.NoError(t, err)
 
@@ -1241,3 +1634,3330 @@ func TestGGG(t *testing.T) {
 	require.NoError(t, err)
 	t.Logf("bin = %s, prefix = %s", hex.EncodeToString(bin), hex.EncodeToString(prefix))
 }
+
+func TestNativeTokens(t *testing.T) {
+	var privKey0 ed25519.PrivateKey
+	var u *utxodb.UTXODB
+	var addr0, addr1 constraints.AddressED25519
+	tokenID := blake2b.Sum256([]byte("test token"))
+
+	initTest := func() {
+		u = utxodb.NewUTXODB(true)
+		privKey0, _, addr0 = u.GenerateAddress(0)
+		_, _, addr1 = u.GenerateAddress(1)
+		err := u.TokensFromFaucet(addr0, 10000)
+		require.NoError(t, err)
+		require.EqualValues(t, 10000, u.Balance(addr0))
+		require.EqualValues(t, 0, u.BalanceNativeToken(addr0, tokenID))
+	}
+	t.Run("output", func(t *testing.T) {
+		out := txbuilder.OutputBasic(1000, 0, constraints.AddressED25519Null()).
+			WithNativeToken(tokenID, 100).
+			WithNativeToken(tokenID, 50)
+		outBack, err := txbuilder.OutputFromBytes(out.Bytes())
+		require.NoError(t, err)
+		require.EqualValues(t, out.Bytes(), outBack.Bytes())
+		require.EqualValues(t, 4, outBack.NumConstraints())
+		require.EqualValues(t, 150, outBack.NativeTokenAmount(tokenID))
+		require.EqualValues(t, 0, outBack.NativeTokenAmount([32]byte{}))
+		t.Logf("output with native token:\n%s", outBack.ToString("   "))
+	})
+	t.Run("can't create out of nothing", func(t *testing.T) {
+		initTest()
+		par, err := u.MakeTransferData(privKey0, nil, 0)
+		require.NoError(t, err)
+		err = u.DoTransfer(par.
+			WithAmount(2000).
+			WithTargetLock(addr1).
+			WithConstraint(constraints.NewNativeToken(tokenID, 100)),
+		)
+		easyfl.RequireErrorWith(t, err, "unbalanced native token")
+		require.EqualValues(t, 10000, u.Balance(addr0))
+		require.EqualValues(t, 0, u.BalanceNativeToken(addr1, tokenID))
+	})
+	t.Run("zero amount", func(t *testing.T) {
+		initTest()
+		par, err := u.MakeTransferData(privKey0, nil, 0)
+		require.NoError(t, err)
+		err = u.DoTransfer(par.
+			WithAmount(2000).
+			WithTargetLock(addr1).
+			WithConstraint(constraints.NewNativeToken(tokenID, 0)),
+		)
+		easyfl.RequireErrorWith(t, err, "nativeToken constraint failed")
+	})
+	t.Run("not enough native tokens", func(t *testing.T) {
+		initTest()
+		par, err := u.MakeTransferData(privKey0, nil, 0)
+		require.NoError(t, err)
+		err = u.DoTransfer(par.
+			WithAmount(2000).
+			WithTargetLock(addr1).
+			WithNativeToken(tokenID, 100),
+		)
+		easyfl.RequireErrorWith(t, err, "not enough native tokens")
+	})
+}
+
+func TestFoundry(t *testing.T) {
+	var privKey0, privKey1 ed25519.PrivateKey
+	var u *utxodb.UTXODB
+	var addr0, addr1, addr2 constraints.AddressED25519
+	var chainID, tokenID [32]byte
+
+	// creates chain controlled by addr1
+	initTest := func() {
+		u = utxodb.NewUTXODB(true)
+		privKey0, _, addr0 = u.GenerateAddress(0)
+		privKey1, _, addr1 = u.GenerateAddress(1)
+		_, _, addr2 = u.GenerateAddress(2)
+		err := u.TokensFromFaucet(addr0, 10000)
+		require.NoError(t, err)
+		par, err := u.MakeTransferData(privKey0, nil, 0)
+		require.NoError(t, err)
+		outs, err := u.DoTransferOutputs(par.
+			WithAmount(2000).
+			WithTargetLock(addr1).
+			WithConstraint(constraints.NewChainInit()),
+		)
+		require.NoError(t, err)
+		chains, err := txbuilder.ParseChainConstraints(outs)
+		require.NoError(t, err)
+		require.EqualValues(t, 1, len(chains))
+		chainID = chains[0].ChainID
+	}
+	chainOutput := func() *txbuilder.OutputWithChainID {
+		chainData, err := u.IndexerAccess().GetUTXOForChainID(chainID[:], u.StateReader())
+		require.NoError(t, err)
+		chains, err := txbuilder.ParseChainConstraints([]*ledger.OutputDataWithID{chainData})
+		require.NoError(t, err)
+		require.EqualValues(t, 1, len(chains))
+		return chains[0]
+	}
+	// non-chain output of addr1 with native tokens
+	tokenOutput := func() *txbuilder.OutputWithID {
+		outsData, err := u.IndexerAccess().GetUTXOsLockedInAccount(addr1, u.StateReader())
+		require.NoError(t, err)
+		outs, err := txbuilder.ParseAndSortOutputData(outsData, func(o *txbuilder.Output) bool {
+			return o.ChainBlockIndex() == 0xff && o.NativeTokenAmount(tokenID) > 0
+		})
+		require.NoError(t, err)
+		require.EqualValues(t, 1, len(outs))
+		return outs[0]
+	}
+	// consumes chain output and, optionally, the output with tokens. Produces chain successor with the foundry
+	// and output with tokens, locked in addr1. Foundry is created if it does not exist. The token ID of the
+	// created foundry is stored in tokenID
+	foundryTx := func(tokensIn *txbuilder.OutputWithID, circulating, maxSupply, tokensOut uint64) []byte {
+		chainIn := chainOutput()
+		predIdx := chainIn.PredecessorConstraintIndex
+		ts := chainIn.Output.Timestamp() + 1
+
+		txb := txbuilder.NewTransactionBuilder(u.LedgerIdentity())
+		_, err := txb.ConsumeOutput(chainIn.Output, chainIn.ID)
+		require.NoError(t, err)
+		amount := chainIn.Output.Amount()
+		if tokensIn != nil {
+			_, err = txb.ConsumeOutput(tokensIn.Output, tokensIn.ID)
+			require.NoError(t, err)
+			amount += tokensIn.Output.Amount()
+			err = txb.PutUnlockReference(1, constraints.ConstraintIndexLock, 0)
+			require.NoError(t, err)
+			if tokensIn.Output.Timestamp() >= ts {
+				ts = tokensIn.Output.Timestamp() + 1
+			}
+		}
+		successor := chainIn.Output.Clone().WithTimestamp(ts).WithAmount(amount - 500)
+		successor.PutConstraint(constraints.NewChainConstraint(chainID, 0, predIdx, 0).Bytes(), predIdx)
+		foundryIn, foundryIdx := chainIn.Output.Foundry()
+		if foundryIdx == 0xff {
+			tokenID = constraints.FoundryTokenID(chainID, ts)
+			foundryIdx, err = successor.PushConstraint(constraints.NewFoundry(predIdx, circulating, maxSupply, ts).Bytes())
+			require.NoError(t, err)
+		} else {
+			successor.PutConstraint(constraints.NewFoundry(predIdx, circulating, maxSupply, foundryIn.Serial).Bytes(), foundryIdx)
+			txb.PutUnlockParams(0, foundryIdx, []byte{foundryIdx})
+		}
+		_, err = txb.ProduceOutput(successor)
+		require.NoError(t, err)
+
+		outTokens := txbuilder.OutputBasic(500, ts, addr1)
+		if tokensOut > 0 {
+			outTokens.WithNativeToken(tokenID, tokensOut)
+		}
+		_, err = txb.ProduceOutput(outTokens)
+		require.NoError(t, err)
+
+		txb.PutUnlockParams(0, predIdx, []byte{0, predIdx, 0})
+		txb.PutSignatureUnlock(0, constraints.ConstraintIndexLock)
+		txb.Transaction.Timestamp = ts
+		txb.Transaction.InputCommitment = txb.InputCommitment()
+		txb.SignED25519(privKey1)
+		return txb.Transaction.Bytes()
+	}
+	t.Run("mint", func(t *testing.T) {
+		initTest()
+		txBytes := foundryTx(nil, 1000, 5000, 1000)
+		t.Logf("%s", u.TxToString(txBytes))
+		err := u.AddTransaction(txBytes, state.TraceOptionFailedConstraints)
+		require.NoError(t, err)
+		require.EqualValues(t, 1000, u.BalanceNativeToken(addr1, tokenID))
+
+		f, idx := chainOutput().Output.Foundry()
+		require.True(t, idx != 0xff)
+		require.EqualValues(t, 1000, f.CirculatingSupply)
+		require.EqualValues(t, 5000, f.MaxSupply)
+	})
+	t.Run("mint over maximum supply", func(t *testing.T) {
+		initTest()
+		txBytes := foundryTx(nil, 6000, 5000, 6000)
+		err := u.AddTransaction(txBytes, state.TraceOptionFailedConstraints)
+		easyfl.RequireErrorWith(t, err, "foundry constraint failed")
+	})
+	t.Run("mint unbalanced", func(t *testing.T) {
+		initTest()
+		txBytes := foundryTx(nil, 1000, 5000, 1001)
+		err := u.AddTransaction(txBytes, state.TraceOptionFailedConstraints)
+		easyfl.RequireErrorWith(t, err, "unbalanced native token")
+	})
+	t.Run("mint without foundry transition", func(t *testing.T) {
+		initTest()
+		err := u.AddTransaction(foundryTx(nil, 1000, 5000, 1000), state.TraceOptionFailedConstraints)
+		require.NoError(t, err)
+		// the circulating supply remains the same
+		txBytes := foundryTx(tokenOutput(), 1000, 5000, 1500)
+		err = u.AddTransaction(txBytes, state.TraceOptionFailedConstraints)
+		easyfl.RequireErrorWith(t, err, "unbalanced native token")
+		require.EqualValues(t, 1000, u.BalanceNativeToken(addr1, tokenID))
+	})
+	t.Run("change maximum supply", func(t *testing.T) {
+		initTest()
+		err := u.AddTransaction(foundryTx(nil, 1000, 5000, 1000), state.TraceOptionFailedConstraints)
+		require.NoError(t, err)
+		txBytes := foundryTx(tokenOutput(), 1000, 10000, 1000)
+		err = u.AddTransaction(txBytes, state.TraceOptionFailedConstraints)
+		easyfl.RequireErrorWith(t, err, "foundry constraint failed")
+	})
+	t.Run("mint and burn", func(t *testing.T) {
+		initTest()
+		err := u.AddTransaction(foundryTx(nil, 1000, 5000, 1000), state.TraceOptionFailedConstraints)
+		require.NoError(t, err)
+		err = u.AddTransaction(foundryTx(tokenOutput(), 3000, 5000, 3000), state.TraceOptionFailedConstraints)
+		require.NoError(t, err)
+		require.EqualValues(t, 3000, u.BalanceNativeToken(addr1, tokenID))
+
+		err = u.AddTransaction(foundryTx(tokenOutput(), 400, 5000, 400), state.TraceOptionFailedConstraints)
+		require.NoError(t, err)
+		require.EqualValues(t, 400, u.BalanceNativeToken(addr1, tokenID))
+
+		f, idx := chainOutput().Output.Foundry()
+		require.True(t, idx != 0xff)
+		require.EqualValues(t, 400, f.CirculatingSupply)
+	})
+	// destroys the foundry with zero circulating supply and creates new foundry with the serial on the chain
+	recreateTx := func(serial uint32, maxSupply uint64) []byte {
+		chainIn := chainOutput()
+		predIdx := chainIn.PredecessorConstraintIndex
+		ts := chainIn.Output.Timestamp() + 1
+
+		txb := txbuilder.NewTransactionBuilder(u.LedgerIdentity())
+		_, err := txb.ConsumeOutput(chainIn.Output, chainIn.ID)
+		require.NoError(t, err)
+		_, foundryIdx := chainIn.Output.Foundry()
+		require.True(t, foundryIdx != 0xff)
+		successor := chainIn.Output.Clone().WithTimestamp(ts)
+		successor.PutConstraint(constraints.NewChainConstraint(chainID, 0, predIdx, 0).Bytes(), predIdx)
+		successor.PutConstraint(constraints.NewFoundry(predIdx, 0, maxSupply, serial).Bytes(), foundryIdx)
+		_, err = txb.ProduceOutput(successor)
+		require.NoError(t, err)
+		txb.PutUnlockParams(0, foundryIdx, []byte{0xff})
+		txb.PutUnlockParams(0, predIdx, []byte{0, predIdx, 0})
+		txb.PutSignatureUnlock(0, constraints.ConstraintIndexLock)
+		txb.Transaction.Timestamp = ts
+		txb.Transaction.InputCommitment = txb.InputCommitment()
+		txb.SignED25519(privKey1)
+		return txb.Transaction.Bytes()
+	}
+	t.Run("re-create destroyed foundry", func(t *testing.T) {
+		initTest()
+		err := u.AddTransaction(foundryTx(nil, 1000, 5000, 1000), state.TraceOptionFailedConstraints)
+		require.NoError(t, err)
+		// burn all tokens
+		err = u.AddTransaction(foundryTx(tokenOutput(), 0, 5000, 0), state.TraceOptionFailedConstraints)
+		require.NoError(t, err)
+		require.EqualValues(t, 0, u.BalanceNativeToken(addr1, tokenID))
+		f, _ := chainOutput().Output.Foundry()
+
+		// the token ID of the destroyed foundry can't be re-used with new maximum supply
+		err = u.AddTransaction(recreateTx(f.Serial, 10000), state.TraceOptionFailedConstraints)
+		easyfl.RequireErrorWith(t, err, "is created with serial")
+
+		// new foundry is created with the new token ID
+		err = u.AddTransaction(recreateTx(chainOutput().Output.Timestamp()+1, 10000), state.TraceOptionFailedConstraints)
+		require.NoError(t, err)
+		fNew, _ := chainOutput().Output.Foundry()
+		require.True(t, fNew.Serial != f.Serial)
+		require.True(t, constraints.FoundryTokenID(chainID, fNew.Serial) != tokenID)
+		require.EqualValues(t, 10000, fNew.MaxSupply)
+	})
+	t.Run("transfer", func(t *testing.T) {
+		initTest()
+		err := u.AddTransaction(foundryTx(nil, 1000, 5000, 1000), state.TraceOptionFailedConstraints)
+		require.NoError(t, err)
+
+		par, err := u.MakeTransferData(privKey1, nil, 0)
+		require.NoError(t, err)
+		err = u.DoTransfer(par.
+			WithOutputs([]*txbuilder.OutputWithID{tokenOutput()}).
+			WithAmount(200, true).
+			WithTargetLock(addr2).
+			WithNativeToken(tokenID, 300),
+		)
+		require.NoError(t, err)
+		require.EqualValues(t, 700, u.BalanceNativeToken(addr1, tokenID))
+		require.EqualValues(t, 300, u.BalanceNativeToken(addr2, tokenID))
+		require.EqualValues(t, 0, u.BalanceNativeToken(addr0, tokenID))
+	})
+	t.Run("chain collects leftover tokens", func(t *testing.T) {
+		initTest()
+		err := u.AddTransaction(foundryTx(nil, 1000, 5000, 1000), state.TraceOptionFailedConstraints)
+		require.NoError(t, err)
+		// second chain of addr1, without foundry
+		par, err := u.MakeTransferData(privKey0, nil, 0)
+		require.NoError(t, err)
+		outs, err := u.DoTransferOutputs(par.
+			WithAmount(2000).
+			WithTargetLock(addr1).
+			WithConstraint(constraints.NewChainInit()),
+		)
+		require.NoError(t, err)
+		chains, err := txbuilder.ParseChainConstraints(outs)
+		require.NoError(t, err)
+		require.EqualValues(t, 1, len(chains))
+		chainLock := constraints.ChainLock(chains[0].ChainID[:])
+		err = u.TokensFromFaucet(addr1, 10000)
+		require.NoError(t, err)
+		for _, tokens := range []uint64{300, 700} {
+			// tokens are sent to the chain account. The chain transfer collects them to the chain output
+			outsData, err := u.IndexerAccess().GetUTXOsLockedInAccount(addr1, u.StateReader())
+			require.NoError(t, err)
+			outs, err := txbuilder.ParseAndSortOutputData(outsData, func(o *txbuilder.Output) bool {
+				return o.ChainBlockIndex() == 0xff
+			})
+			require.NoError(t, err)
+			par, err = u.MakeTransferData(privKey1, nil, 0)
+			require.NoError(t, err)
+			err = u.DoTransfer(par.
+				WithOutputs(outs).
+				WithAmount(200, true).
+				WithTargetLock(chainLock).
+				WithNativeToken(tokenID, tokens),
+			)
+			require.NoError(t, err)
+			par, err = u.MakeTransferData(privKey1, chainLock, 0)
+			require.NoError(t, err)
+			err = u.DoTransfer(par.WithAmount(100).WithTargetLock(addr2))
+			require.NoError(t, err)
+		}
+		chainData, err := u.IndexerAccess().GetUTXOForChainID(chains[0].ChainID[:], u.StateReader())
+		require.NoError(t, err)
+		chainOut, err := txbuilder.OutputFromBytes(chainData.OutputData)
+		require.NoError(t, err)
+		require.EqualValues(t, 1000, chainOut.NativeTokenAmount(tokenID))
+		// the leftover is added to the existing native token block of the chain output
+		numBlocks := 0
+		chainOut.ForEachConstraint(func(_ byte, constr []byte) bool {
+			if _, err := constraints.NativeTokenFromBytes(constr); err == nil {
+				numBlocks++
+			}
+			return true
+		})
+		require.EqualValues(t, 1, numBlocks)
+	})
+}
+
+func TestMultisig(t *testing.T) {
+	var privKey0, privKey1, privKey2, privKey3 ed25519.PrivateKey
+	var u *utxodb.UTXODB
+	var addr0, addr1, addr2, addr3 constraints.AddressED25519
+	var policy *constraints.MultisigPolicyED25519
+
+	// 2 of 3 policy of addr1, addr2 and addr3
+	initTest := func() {
+		u = utxodb.NewUTXODB(true)
+		privKey0, _, addr0 = u.GenerateAddress(0)
+		privKey1, _, addr1 = u.GenerateAddress(1)
+		privKey2, _, addr2 = u.GenerateAddress(2)
+		privKey3, _, addr3 = u.GenerateAddress(3)
+		err := u.TokensFromFaucet(addr0, 10000)
+		require.NoError(t, err)
+		policy, err = constraints.NewMultisigPolicyED25519(2, addr1, addr2, addr3)
+		require.NoError(t, err)
+		err = u.TransferTokens(privKey0, policy.Address(), 5000)
+		require.NoError(t, err)
+		require.EqualValues(t, 5000, u.Balance(policy.Address()))
+		require.EqualValues(t, 1, u.NumUTXOs(policy.Address()))
+	}
+	t.Run("policy", func(t *testing.T) {
+		initTest()
+		_, err := constraints.NewMultisigPolicyED25519(0, addr1, addr2)
+		require.Error(t, err)
+		_, err = constraints.NewMultisigPolicyED25519(3, addr1, addr2)
+		require.Error(t, err)
+		_, err = constraints.NewMultisigPolicyED25519(1, addr1, addr1)
+		require.Error(t, err)
+
+		policyBack, err := constraints.MultisigPolicyED25519FromBytes(policy.Bytes())
+		require.NoError(t, err)
+		require.EqualValues(t, 2, policyBack.Threshold)
+		require.EqualValues(t, policy.Address(), policyBack.Address())
+
+		lock, err := constraints.LockFromBytes(policy.Address().Bytes())
+		require.NoError(t, err)
+		require.EqualValues(t, constraints.MultisigED25519Name, lock.Name())
+		t.Logf("multisig lock: %s", lock.String())
+	})
+	t.Run("2 of 3", func(t *testing.T) {
+		initTest()
+		par, err := u.MakeTransferData(privKey1, policy.Address(), 0)
+		require.NoError(t, err)
+		err = u.DoTransfer(par.
+			WithAmount(1000).
+			WithTargetLock(addr0).
+			WithMultisig(policy, privKey3),
+		)
+		require.NoError(t, err)
+		require.EqualValues(t, 4000, u.Balance(policy.Address()))
+		require.EqualValues(t, 6000, u.Balance(addr0))
+	})
+	t.Run("3 of 3", func(t *testing.T) {
+		initTest()
+		par, err := u.MakeTransferData(privKey1, policy.Address(), 0)
+		require.NoError(t, err)
+		err = u.DoTransfer(par.
+			WithAmount(1000).
+			WithTargetLock(addr0).
+			WithMultisig(policy, privKey2, privKey3),
+		)
+		require.NoError(t, err)
+		require.EqualValues(t, 4000, u.Balance(policy.Address()))
+	})
+	t.Run("1 of 3", func(t *testing.T) {
+		initTest()
+		par, err := u.MakeTransferData(privKey1, policy.Address(), 0)
+		require.NoError(t, err)
+		err = u.DoTransfer(par.
+			WithAmount(1000).
+			WithTargetLock(addr0).
+			WithMultisig(policy),
+		)
+		easyfl.RequireErrorWith(t, err, "multisigED25519 unlock failed")
+		require.EqualValues(t, 5000, u.Balance(policy.Address()))
+	})
+	t.Run("repeating signer", func(t *testing.T) {
+		initTest()
+		par, err := u.MakeTransferData(privKey1, policy.Address(), 0)
+		require.NoError(t, err)
+		err = u.DoTransfer(par.
+			WithAmount(1000).
+			WithTargetLock(addr0).
+			WithMultisig(policy, privKey1),
+		)
+		easyfl.RequireErrorWith(t, err, "multisigED25519 unlock failed")
+	})
+	t.Run("signer not in policy", func(t *testing.T) {
+		initTest()
+		par, err := u.MakeTransferData(privKey1, policy.Address(), 0)
+		require.NoError(t, err)
+		err = u.DoTransfer(par.
+			WithAmount(1000).
+			WithTargetLock(addr0).
+			WithMultisig(policy, privKey0),
+		)
+		easyfl.RequireErrorWith(t, err, "multisigED25519 unlock failed")
+	})
+	t.Run("wrong policy", func(t *testing.T) {
+		initTest()
+		wrongPolicy, err := constraints.NewMultisigPolicyED25519(2, addr1, addr2)
+		require.NoError(t, err)
+		par, err := u.MakeTransferData(privKey1, policy.Address(), 0)
+		require.NoError(t, err)
+		err = u.DoTransfer(par.
+			WithAmount(1000).
+			WithTargetLock(addr0).
+			WithMultisig(wrongPolicy, privKey2),
+		)
+		easyfl.RequireErrorWith(t, err, "multisigED25519 unlock failed")
+	})
+	t.Run("signature by index", func(t *testing.T) {
+		initTest()
+		outsData, err := u.IndexerAccess().GetUTXOsLockedInAccount(addr0, u.StateReader())
+		require.NoError(t, err)
+		outs, err := txbuilder.ParseAndSortOutputData(outsData, nil)
+		require.NoError(t, err)
+		require.EqualValues(t, 1, len(outs))
+
+		makeTx := func(sigIndex byte) []byte {
+			txb := txbuilder.NewTransactionBuilder(u.LedgerIdentity())
+			_, err := txb.ConsumeOutput(outs[0].Output, outs[0].ID)
+			require.NoError(t, err)
+			ts := outs[0].Output.Timestamp() + 1
+			_, err = txb.ProduceOutput(txbuilder.OutputBasic(outs[0].Output.Amount(), ts, addr1))
+			require.NoError(t, err)
+			txb.PutSignatureUnlock(0, constraints.ConstraintIndexLock, sigIndex)
+			txb.Transaction.Timestamp = ts
+			txb.Transaction.InputCommitment = txb.InputCommitment()
+			txb.SignED25519(privKey1)
+			txb.SignED25519(privKey0)
+			return txb.Transaction.Bytes()
+		}
+		err = u.AddTransaction(makeTx(0), state.TraceOptionFailedConstraints)
+		easyfl.RequireErrorWith(t, err, "addressED25519 unlock failed")
+
+		txBytes := makeTx(1)
+		t.Logf("%s", u.TxToString(txBytes))
+		err = u.AddTransaction(txBytes, state.TraceOptionFailedConstraints)
+		require.NoError(t, err)
+		require.EqualValues(t, 0, u.Balance(addr0))
+		require.EqualValues(t, 5000, u.Balance(addr1))
+	})
+}
+
+func TestHTLC(t *testing.T) {
+	preimage := []byte("atomic swap secret")
+	var privKey0, privKey1 ed25519.PrivateKey
+	var u *utxodb.UTXODB
+	var addr0, addr1, addr2 constraints.AddressED25519
+	var ts uint32
+
+	// addr0 locks 2000 for addr1 until ts+10 with refund to addr0
+	initTest := func() {
+		u = utxodb.NewUTXODB(true)
+		privKey0, _, addr0 = u.GenerateAddress(0)
+		privKey1, _, addr1 = u.GenerateAddress(1)
+		_, _, addr2 = u.GenerateAddress(2)
+		err := u.TokensFromFaucet(addr0, 10000)
+		require.NoError(t, err)
+
+		ts = uint32(time.Now().Unix())
+		par, err := u.MakeTransferData(privKey0, nil, ts)
+		require.NoError(t, err)
+		htlc := constraints.NewHTLCFromPreimage(preimage, ts+10, addr1, addr0)
+		t.Logf("htlc lock: %s, %d bytes", htlc.String(), len(htlc.Bytes()))
+		err = u.DoTransfer(par.
+			WithAmount(2000).
+			WithTargetLock(htlc),
+		)
+		require.NoError(t, err)
+
+		require.EqualValues(t, 2000, u.Balance(addr1, ts+10))
+		require.EqualValues(t, 0, u.Balance(addr1, ts+11))
+		require.EqualValues(t, 8000, u.Balance(addr0, ts+10))
+		require.EqualValues(t, 10000, u.Balance(addr0, ts+11))
+	}
+	htlcOutputs := func() []*txbuilder.OutputWithID {
+		outsData, err := u.IndexerAccess().GetUTXOsLockedInAccount(addr1, u.StateReader())
+		require.NoError(t, err)
+		outs, err := txbuilder.ParseAndSortOutputData(outsData, func(o *txbuilder.Output) bool {
+			return o.Lock().Name() == constraints.HTLCName
+		})
+		require.NoError(t, err)
+		require.EqualValues(t, 1, len(outs))
+		return outs
+	}
+	t.Run("lock", func(t *testing.T) {
+		initTest()
+		lock := htlcOutputs()[0].Output.Lock()
+		htlc, ok := lock.(*constraints.HTLC)
+		require.True(t, ok)
+		require.EqualValues(t, blake2b.Sum256(preimage), htlc.Hash)
+		require.EqualValues(t, ts+10, htlc.Deadline)
+		require.True(t, constraints.Equal(addr1, htlc.Receiver))
+		require.True(t, constraints.Equal(addr0, htlc.Refund))
+	})
+	t.Run("claim", func(t *testing.T) {
+		initTest()
+		par, err := u.MakeTransferData(privKey1, nil, ts+5)
+		require.NoError(t, err)
+		err = u.DoTransfer(par.
+			WithAmount(2000).
+			WithTargetLock(addr1).
+			WithPreimage(preimage),
+		)
+		require.NoError(t, err)
+		require.EqualValues(t, 2000, u.Balance(addr1))
+		require.EqualValues(t, 1, u.NumUTXOs(addr1))
+		require.EqualValues(t, 8000, u.Balance(addr0))
+	})
+	t.Run("claim with wrong preimage", func(t *testing.T) {
+		initTest()
+		par, err := u.MakeTransferData(privKey1, nil, ts+5)
+		require.NoError(t, err)
+		err = u.DoTransfer(par.
+			WithAmount(2000).
+			WithTargetLock(addr1).
+			WithPreimage([]byte("wrong secret")),
+		)
+		easyfl.RequireErrorWith(t, err, "htlc unlock failed")
+	})
+	t.Run("claim without preimage", func(t *testing.T) {
+		initTest()
+		par, err := u.MakeTransferData(privKey1, nil, ts+5)
+		require.NoError(t, err)
+		err = u.DoTransfer(par.
+			WithAmount(2000).
+			WithTargetLock(addr1),
+		)
+		easyfl.RequireErrorWith(t, err, "htlc unlock failed")
+	})
+	t.Run("claim after deadline", func(t *testing.T) {
+		initTest()
+		par := txbuilder.NewTransferData(privKey1, addr1, ts+11).WithLedgerIdentity(u.LedgerIdentity()).
+			WithOutputs(htlcOutputs()).
+			WithAmount(2000).
+			WithTargetLock(addr1).
+			WithPreimage(preimage)
+		err := u.DoTransfer(par)
+		// after the deadline the embedded refund lock is checked
+		easyfl.RequireErrorWith(t, err, "addressED25519 unlock failed")
+	})
+	t.Run("refund before deadline", func(t *testing.T) {
+		initTest()
+		par := txbuilder.NewTransferData(privKey0, addr0, ts+5).WithLedgerIdentity(u.LedgerIdentity()).
+			WithOutputs(htlcOutputs()).
+			WithAmount(2000).
+			WithTargetLock(addr0)
+		err := u.DoTransfer(par)
+		easyfl.RequireErrorWith(t, err, "htlc unlock failed")
+	})
+	t.Run("refund", func(t *testing.T) {
+		initTest()
+		par, err := u.MakeTransferData(privKey0, nil, ts+11)
+		require.NoError(t, err)
+		err = u.DoTransfer(par.
+			WithAmount(10000).
+			WithTargetLock(addr2),
+		)
+		require.NoError(t, err)
+		require.EqualValues(t, 0, u.Balance(addr0))
+		require.EqualValues(t, 0, u.Balance(addr1))
+		require.EqualValues(t, 10000, u.Balance(addr2))
+	})
+}
+
+func TestStorageDepositReturn(t *testing.T) {
+	var privKey0, privKey1 ed25519.PrivateKey
+	var u *utxodb.UTXODB
+	var addr0, addr1, addr2 constraints.AddressED25519
+	var ts uint32
+
+	// addr0 sends 1000 to addr1 requiring to return 500. With deadline, the output
+	// is locked in addr1 until ts+10 with expiry to addr0
+	initTest := func(withDeadline bool) {
+		u = utxodb.NewUTXODB(true)
+		privKey0, _, addr0 = u.GenerateAddress(0)
+		privKey1, _, addr1 = u.GenerateAddress(1)
+		_, _, addr2 = u.GenerateAddress(2)
+		err := u.TokensFromFaucet(addr0, 10000)
+		require.NoError(t, err)
+		err = u.TokensFromFaucet(addr1, 10000)
+		require.NoError(t, err)
+
+		ts = uint32(time.Now().Unix())
+		var lock constraints.Lock = addr1
+		if withDeadline {
+			lock = constraints.NewDeadlineLock(ts+10, addr1, addr0)
+		}
+		par, err := u.MakeTransferData(privKey0, nil, ts)
+		require.NoError(t, err)
+		err = u.DoTransfer(par.
+			WithAmount(1000).
+			WithTargetLock(lock).
+			WithStorageDepositReturn(addr0, 500),
+		)
+		require.NoError(t, err)
+		require.EqualValues(t, 9000, u.Balance(addr0, ts+1))
+	}
+	sdrOutputs := func(addr constraints.AddressED25519) []*txbuilder.OutputWithID {
+		outsData, err := u.IndexerAccess().GetUTXOsLockedInAccount(addr, u.StateReader())
+		require.NoError(t, err)
+		outs, err := txbuilder.ParseAndSortOutputData(outsData, func(o *txbuilder.Output) bool {
+			_, idx := o.StorageDepositReturn()
+			return idx != 0xff
+		})
+		require.NoError(t, err)
+		require.EqualValues(t, 1, len(outs))
+		return outs
+	}
+	t.Run("output", func(t *testing.T) {
+		initTest(false)
+		sdr, idx := sdrOutputs(addr1)[0].Output.StorageDepositReturn()
+		require.True(t, idx != 0xff)
+		require.True(t, constraints.Equal(addr0, sdr.ReturnAccount))
+		require.EqualValues(t, 500, sdr.Amount)
+		require.EqualValues(t, 11000, u.Balance(addr1))
+	})
+	t.Run("return more than deposit", func(t *testing.T) {
+		initTest(false)
+		par, err := u.MakeTransferData(privKey0, nil, 0)
+		require.NoError(t, err)
+		err = u.DoTransfer(par.
+			WithAmount(1000).
+			WithTargetLock(addr1).
+			WithStorageDepositReturn(addr0, 1001),
+		)
+		easyfl.RequireErrorWith(t, err, "storageDepositReturn constraint failed")
+	})
+	t.Run("consume", func(t *testing.T) {
+		initTest(false)
+		par := txbuilder.NewTransferData(privKey1, addr1, ts+1).WithLedgerIdentity(u.LedgerIdentity()).
+			WithOutputs(sdrOutputs(addr1)).
+			WithAmount(500).
+			WithTargetLock(addr2)
+		txBytes, err := u.DoTransferTx(par)
+		require.NoError(t, err)
+		t.Logf("%s", u.TxToString(txBytes))
+		require.EqualValues(t, 9500, u.Balance(addr0))
+		require.EqualValues(t, 500, u.Balance(addr2))
+		require.EqualValues(t, 10000, u.Balance(addr1))
+	})
+	t.Run("consume not enough", func(t *testing.T) {
+		initTest(false)
+		par := txbuilder.NewTransferData(privKey1, addr1, ts+1).WithLedgerIdentity(u.LedgerIdentity()).
+			WithOutputs(sdrOutputs(addr1)).
+			WithAmount(600).
+			WithTargetLock(addr2)
+		_, err := txbuilder.MakeTransferTransaction(par)
+		easyfl.RequireErrorWith(t, err, "not enough tokens")
+	})
+	t.Run("consume without return", func(t *testing.T) {
+		initTest(false)
+		// points storage deposit return to the main output
+		par := txbuilder.NewTransferData(privKey1, addr1, ts+1).WithLedgerIdentity(u.LedgerIdentity()).
+			WithOutputs(sdrOutputs(addr1)).
+			WithAmount(500).
+			WithTargetLock(addr2).
+			WithUnlockData(0, 3, []byte{0})
+		err := u.DoTransfer(par)
+		easyfl.RequireErrorWith(t, err, "storageDepositReturn constraint failed")
+	})
+	t.Run("consume with other transfers", func(t *testing.T) {
+		initTest(false)
+		par, err := u.MakeTransferData(privKey1, nil, ts+1)
+		require.NoError(t, err)
+		err = u.DoTransfer(par.
+			WithAmount(10500).
+			WithTargetLock(addr2),
+		)
+		require.NoError(t, err)
+		require.EqualValues(t, 9500, u.Balance(addr0))
+		require.EqualValues(t, 10500, u.Balance(addr2))
+		require.EqualValues(t, 0, u.Balance(addr1))
+	})
+	t.Run("shared return output", func(t *testing.T) {
+		initTest(false)
+		par, err := u.MakeTransferData(privKey0, nil, ts)
+		require.NoError(t, err)
+		err = u.DoTransfer(par.
+			WithAmount(1000).
+			WithTargetLock(addr1).
+			WithStorageDepositReturn(addr0, 500),
+		)
+		require.NoError(t, err)
+		outsData, err := u.IndexerAccess().GetUTXOsLockedInAccount(addr1, u.StateReader())
+		require.NoError(t, err)
+		outs, err := txbuilder.ParseAndSortOutputData(outsData, func(o *txbuilder.Output) bool {
+			_, idx := o.StorageDepositReturn()
+			return idx != 0xff
+		})
+		require.NoError(t, err)
+		require.EqualValues(t, 2, len(outs))
+
+		// both storage deposit returns point to the return output #0. With separateReturns, each to its own
+		makeTx := func(separateReturns bool) []byte {
+			txTs := ts + 1
+			txb := txbuilder.NewTransactionBuilder(u.LedgerIdentity())
+			for i, o := range outs {
+				_, err := txb.ConsumeOutput(o.Output, o.ID)
+				require.NoError(t, err)
+				_, sdrIdx := o.Output.StorageDepositReturn()
+				if o.Output.Timestamp() >= txTs {
+					txTs = o.Output.Timestamp() + 1
+				}
+				if i == 0 {
+					txb.PutSignatureUnlock(0, constraints.ConstraintIndexLock)
+					txb.PutUnlockParams(0, sdrIdx, []byte{0})
+				} else {
+					require.NoError(t, txb.PutUnlockReference(1, constraints.ConstraintIndexLock, 0))
+					if separateReturns {
+						txb.PutUnlockParams(1, sdrIdx, []byte{1})
+					} else {
+						txb.PutUnlockParams(1, sdrIdx, []byte{0})
+					}
+				}
+			}
+			_, err := txb.ProduceOutput(txbuilder.OutputBasic(500, txTs, addr0))
+			require.NoError(t, err)
+			if separateReturns {
+				_, err = txb.ProduceOutput(txbuilder.OutputBasic(500, txTs, addr0))
+				require.NoError(t, err)
+				_, err = txb.ProduceOutput(txbuilder.OutputBasic(1000, txTs, addr2))
+			} else {
+				_, err = txb.ProduceOutput(txbuilder.OutputBasic(1500, txTs, addr2))
+			}
+			require.NoError(t, err)
+			txb.Transaction.Timestamp = txTs
+			txb.Transaction.InputCommitment = txb.InputCommitment()
+			txb.SignED25519(privKey1)
+			return txb.Transaction.Bytes()
+		}
+		err = u.AddTransaction(makeTx(false))
+		easyfl.RequireErrorWith(t, err, "storageDepositReturn constraint failed")
+
+		err = u.AddTransaction(makeTx(true), state.TraceOptionFailedConstraints)
+		require.NoError(t, err)
+		require.EqualValues(t, 1000, u.Balance(addr2))
+		require.EqualValues(t, 9000, u.Balance(addr0))
+	})
+	t.Run("expired", func(t *testing.T) {
+		initTest(true)
+		// before the deadline the receiver must return the deposit
+		par := txbuilder.NewTransferData(privKey1, addr1, ts+5).WithLedgerIdentity(u.LedgerIdentity()).
+			WithOutputs(sdrOutputs(addr1)).
+			WithAmount(500).
+			WithTargetLock(addr2)
+		err := u.DoTransfer(par)
+		require.NoError(t, err)
+		require.EqualValues(t, 9500, u.Balance(addr0))
+
+		// after the deadline the sender takes everything back without returning to itself
+		initTest(true)
+		par = txbuilder.NewTransferData(privKey0, addr0, ts+11).WithLedgerIdentity(u.LedgerIdentity()).
+			WithOutputs(sdrOutputs(addr0)).
+			WithAmount(1000).
+			WithTargetLock(addr2)
+		txBytes, err := u.DoTransferTx(par)
+		require.NoError(t, err)
+		t.Logf("%s", u.TxToString(txBytes))
+		require.EqualValues(t, 1000, u.Balance(addr2))
+		require.EqualValues(t, 9000, u.Balance(addr0))
+	})
+}
+
+// testHashLock is a lock registered outside the constraints package. The output can be unlocked by anyone
+// who reveals the preimage of the hash. The owner is only used to index the output
+type testHashLock struct {
+	Hash  [32]byte
+	Owner constraints.AddressED25519
+}
+
+const (
+	testHashLockName     = "testHashLock"
+	testHashLockTemplate = testHashLockName + "(0x%s, 0x%s)"
+	testHashLockSource   = `
+func testHashLock: and(
+	equal(selfBlockIndex,2),
+	or(
+		and(selfIsProducedOutput, equal(len8($0), 32), equal(len8($1), 32)),
+		and(selfIsConsumedOutput, equal(blake2b(selfUnlockParameters), $0)),
+		!!!testHashLock_unlock_failed
+	)
+)
+`
+)
+
+var registerTestHashLockOnce sync.Once
+
+func registerTestHashLock() {
+	registerTestHashLockOnce.Do(func() {
+		constraints.MustRegisterConstraint(&constraints.ConstraintDefinition{
+			Name:   testHashLockName,
+			Source: testHashLockSource,
+			Parser: func(data []byte) (constraints.Constraint, error) {
+				return testHashLockFromBytes(data)
+			},
+			IsLock: true,
+			IndexableTags: func(data []byte) ([]constraints.Accountable, error) {
+				lock, err := testHashLockFromBytes(data)
+				if err != nil {
+					return nil, err
+				}
+				return []constraints.Accountable{lock.Owner}, nil
+			},
+			String: func(data []byte) string {
+				lock, err := testHashLockFromBytes(data)
+				if err != nil {
+					return err.Error()
+				}
+				return fmt.Sprintf("testHashLock(owner: %s)", easyfl.Fmt(lock.Owner))
+			},
+		})
+	})
+}
+
+func testHashLockFromBytes(data []byte) (*testHashLock, error) {
+	sym, _, args, err := easyfl.ParseBytecodeOneLevel(data, 2)
+	if err != nil {
+		return nil, err
+	}
+	if sym != testHashLockName {
+		return nil, fmt.Errorf("not a testHashLock")
+	}
+	ret := &testHashLock{Owner: easyfl.StripDataPrefix(args[1])}
+	copy(ret.Hash[:], easyfl.StripDataPrefix(args[0]))
+	return ret, nil
+}
+
+func (l *testHashLock) Name() string {
+	return testHashLockName
+}
+
+func (l *testHashLock) Bytes() []byte {
+	_, _, ret, err := easyfl.CompileExpression(fmt.Sprintf(testHashLockTemplate, hex.EncodeToString(l.Hash[:]), hex.EncodeToString(l.Owner)))
+	easyfl.AssertNoError(err)
+	return ret
+}
+
+func (l *testHashLock) String() string {
+	return fmt.Sprintf(testHashLockTemplate, hex.EncodeToString(l.Hash[:]), hex.EncodeToString(l.Owner))
+}
+
+func (l *testHashLock) IndexableTags() []constraints.Accountable {
+	return []constraints.Accountable{l.Owner}
+}
+
+func (l *testHashLock) UnlockableWith(acc constraints.AccountID, _ uint32) bool {
+	return bytes.Equal(l.Owner.AccountID(), acc)
+}
+
+func TestConstraintRegistry(t *testing.T) {
+	registerTestHashLock()
+
+	preimage := []byte("registry test secret")
+	u := utxodb.NewUTXODB(true)
+	privKey0, _, addr0 := u.GenerateAddress(0)
+	privKey1, _, addr1 := u.GenerateAddress(1)
+	err := u.TokensFromFaucet(addr0, 10000)
+	require.NoError(t, err)
+
+	lock := &testHashLock{Hash: blake2b.Sum256(preimage), Owner: addr1}
+	t.Run("parse", func(t *testing.T) {
+		name, ok := constraints.NameByPrefix(lock.Bytes()[:2])
+		require.True(t, ok)
+		require.EqualValues(t, testHashLockName, name)
+
+		c, err := constraints.FromBytes(lock.Bytes())
+		require.NoError(t, err)
+		_, isTestHashLock := c.(*testHashLock)
+		require.True(t, isTestHashLock)
+
+		lockBack, err := constraints.LockFromBytes(lock.Bytes())
+		require.NoError(t, err)
+		require.EqualValues(t, lock.Bytes(), lockBack.Bytes())
+
+		_, err = constraints.AccountableFromBytes(lock.Bytes())
+		require.Error(t, err)
+
+		str, err := constraints.StringFromBytes(lock.Bytes())
+		require.NoError(t, err)
+		require.Contains(t, str, "testHashLock(owner:")
+	})
+	t.Run("repeating registration", func(t *testing.T) {
+		err := constraints.RegisterConstraint(&constraints.ConstraintDefinition{
+			Name: testHashLockName,
+			Parser: func(data []byte) (constraints.Constraint, error) {
+				return testHashLockFromBytes(data)
+			},
+		})
+		easyfl.RequireErrorWith(t, err, "repeating constraint name")
+
+		// the library function can't be redefined as the constraint. It is checked before the library is extended
+		err = constraints.RegisterConstraint(&constraints.ConstraintDefinition{
+			Name:   "selfIsProducedOutput",
+			Source: "func selfIsProducedOutput: 0x01",
+			Parser: func(data []byte) (constraints.Constraint, error) {
+				return testHashLockFromBytes(data)
+			},
+		})
+		easyfl.RequireErrorWith(t, err, "is already in the library")
+	})
+	t.Run("transfer", func(t *testing.T) {
+		par, err := u.MakeTransferData(privKey0, nil, 0)
+		require.NoError(t, err)
+		err = u.DoTransfer(par.
+			WithAmount(2000).
+			WithTargetLock(lock),
+		)
+		require.NoError(t, err)
+		// indexed by the owner
+		require.EqualValues(t, 2000, u.Balance(addr1))
+		require.EqualValues(t, 1, u.NumUTXOs(addr1))
+
+		outs, err := u.IndexerAccess().GetUTXOsLockedInAccount(addr1, u.StateReader())
+		require.NoError(t, err)
+		require.EqualValues(t, 1, len(outs))
+		o, err := txbuilder.OutputFromBytes(outs[0].OutputData)
+		require.NoError(t, err)
+		require.Contains(t, o.ToString(), "testHashLock(owner:")
+
+		// wrong preimage
+		par, err = u.MakeTransferData(privKey1, nil, 0)
+		require.NoError(t, err)
+		err = u.DoTransfer(par.
+			WithAmount(2000).
+			WithTargetLock(addr1).
+			WithUnlockData(0, constraints.ConstraintIndexLock, []byte("wrong secret")),
+		)
+		easyfl.RequireErrorWith(t, err, "testHashLock unlock failed")
+
+		par, err = u.MakeTransferData(privKey1, nil, 0)
+		require.NoError(t, err)
+		err = u.DoTransfer(par.
+			WithAmount(2000).
+			WithTargetLock(addr1).
+			WithUnlockData(0, constraints.ConstraintIndexLock, preimage),
+		)
+		require.NoError(t, err)
+		require.EqualValues(t, 2000, u.Balance(addr1))
+		require.EqualValues(t, 1, u.NumUTXOs(addr1))
+		require.EqualValues(t, 8000, u.Balance(addr0))
+	})
+}
+
+func TestChainControllers(t *testing.T) {
+	var privKey0, privKey1, privKey2 ed25519.PrivateKey
+	var addr0, addr1, addr2 constraints.AddressED25519
+	var u *utxodb.UTXODB
+	var chainID [32]byte
+
+	// addr0 is the state controller (operator), addr2 is the governor (committee)
+	initTest := func() {
+		u = utxodb.NewUTXODB(true)
+		privKey0, _, addr0 = u.GenerateAddress(0)
+		privKey1, _, addr1 = u.GenerateAddress(1)
+		privKey2, _, addr2 = u.GenerateAddress(2)
+		err := u.TokensFromFaucet(addr0, 10000)
+		require.NoError(t, err)
+
+		par, err := u.MakeTransferData(privKey0, nil, uint32(time.Now().Unix()))
+		require.NoError(t, err)
+		outs, err := u.DoTransferOutputs(par.
+			WithAmount(2000).
+			WithTargetLock(constraints.NewChainControllerLock(3, addr0, addr2)).
+			WithConstraint(constraints.NewChainInit()).
+			WithConstraint(constraints.NewChainStateMetadata(3, []byte("state 0"))).
+			WithConstraint(constraints.NewChainGovernanceMetadata(3, []byte("governance 0"))),
+		)
+		require.NoError(t, err)
+		chains, err := txbuilder.ParseChainConstraints(outs)
+		require.NoError(t, err)
+		require.EqualValues(t, 1, len(chains))
+		chainID = chains[0].ChainID
+		// chain output is indexed by both controllers
+		require.EqualValues(t, 2, u.NumUTXOs(addr0))
+		require.EqualValues(t, 1, u.NumUTXOs(addr2))
+	}
+	chainOutput := func() *txbuilder.Output {
+		chs, err := u.IndexerAccess().GetUTXOForChainID(chainID[:], u.StateReader())
+		require.NoError(t, err)
+		o, err := txbuilder.OutputFromBytes(chs.OutputData)
+		require.NoError(t, err)
+		return o
+	}
+	transition := func(privKey ed25519.PrivateKey, mode byte, modify ...func(o *txbuilder.Output)) error {
+		chs, err := u.IndexerAccess().GetUTXOForChainID(chainID[:], u.StateReader())
+		require.NoError(t, err)
+		ts := chainOutput().Timestamp() + 1
+
+		txb := txbuilder.NewTransactionBuilder(u.LedgerIdentity())
+		err = txb.InsertChainTransition(&ledger.OutputDataWithChainID{
+			OutputDataWithID: *chs,
+			ChainID:          chainID,
+		}, ts, mode, modify...)
+		require.NoError(t, err)
+
+		txb.Transaction.Timestamp = ts
+		txb.Transaction.InputCommitment = txb.InputCommitment()
+		txb.SignED25519(privKey)
+		return u.AddTransaction(txb.Transaction.Bytes(), state.TraceOptionFailedConstraints)
+	}
+	withMetadata := func(mode byte, data string) func(o *txbuilder.Output) {
+		return func(o *txbuilder.Output) {
+			_, idx := o.ChainMetadata(mode)
+			require.True(t, idx != 0xff)
+			o.PutConstraint(constraints.NewChainMetadata(3, mode, []byte(data)).Bytes(), idx)
+		}
+	}
+	withNewMetadata := func(mode byte, data string) func(o *txbuilder.Output) {
+		return func(o *txbuilder.Output) {
+			_, err := o.PushConstraint(constraints.NewChainMetadata(3, mode, []byte(data)).Bytes())
+			require.NoError(t, err)
+		}
+	}
+	withLock := func(lock constraints.Lock) func(o *txbuilder.Output) {
+		return func(o *txbuilder.Output) {
+			o.WithLock(lock)
+		}
+	}
+	requireMetadata := func(mode byte, data string) {
+		md, idx := chainOutput().ChainMetadata(mode)
+		require.True(t, idx != 0xff)
+		require.EqualValues(t, data, string(md.Data))
+	}
+	t.Run("state transition", func(t *testing.T) {
+		initTest()
+		err := transition(privKey0, constraints.ChainTransitionModeState, withMetadata(constraints.ChainTransitionModeState, "state 1"))
+		require.NoError(t, err)
+		requireMetadata(constraints.ChainTransitionModeState, "state 1")
+		requireMetadata(constraints.ChainTransitionModeGovernance, "governance 0")
+
+		// governor can't do state transitions
+		err = transition(privKey2, constraints.ChainTransitionModeState)
+		require.Error(t, err)
+		// state controller can't change governance metadata
+		err = transition(privKey0, constraints.ChainTransitionModeState, withMetadata(constraints.ChainTransitionModeGovernance, "governance 1"))
+		easyfl.RequireErrorWith(t, err, "chainMetadata constraint failed")
+		// state controller can't add new governance metadata
+		err = transition(privKey0, constraints.ChainTransitionModeState, withNewMetadata(constraints.ChainTransitionModeGovernance, "governance 1"))
+		easyfl.RequireErrorWith(t, err, "chainMetadata constraint failed")
+		// state controller can add new state metadata
+		err = transition(privKey0, constraints.ChainTransitionModeState, withNewMetadata(constraints.ChainTransitionModeState, "state 2"))
+		require.NoError(t, err)
+		// state controller can't change the lock
+		err = transition(privKey0, constraints.ChainTransitionModeState, withLock(constraints.NewChainControllerLock(3, addr1, addr2)))
+		require.Error(t, err)
+		// state controller can't do governance transitions
+		err = transition(privKey0, constraints.ChainTransitionModeGovernance)
+		require.Error(t, err)
+	})
+	t.Run("governance transition", func(t *testing.T) {
+		initTest()
+		// governor can't change state metadata
+		err := transition(privKey2, constraints.ChainTransitionModeGovernance, withMetadata(constraints.ChainTransitionModeState, "state 1"))
+		easyfl.RequireErrorWith(t, err, "chainMetadata constraint failed")
+
+		// governor can't add new state metadata
+		err = transition(privKey2, constraints.ChainTransitionModeGovernance, withNewMetadata(constraints.ChainTransitionModeState, "state 1"))
+		easyfl.RequireErrorWith(t, err, "chainMetadata constraint failed")
+
+		// governor rotates state controller
+		err = transition(privKey2, constraints.ChainTransitionModeGovernance,
+			withLock(constraints.NewChainControllerLock(3, addr1, addr2)),
+			withMetadata(constraints.ChainTransitionModeGovernance, "governance 1"),
+		)
+		require.NoError(t, err)
+		requireMetadata(constraints.ChainTransitionModeState, "state 0")
+		requireMetadata(constraints.ChainTransitionModeGovernance, "governance 1")
+		require.EqualValues(t, 1, u.NumUTXOs(addr0))
+		require.EqualValues(t, 1, u.NumUTXOs(addr1))
+
+		// old state controller can't do state transitions anymore
+		err = transition(privKey0, constraints.ChainTransitionModeState)
+		require.Error(t, err)
+		err = transition(privKey1, constraints.ChainTransitionModeState, withMetadata(constraints.ChainTransitionModeState, "state 1"))
+		require.NoError(t, err)
+		requireMetadata(constraints.ChainTransitionModeState, "state 1")
+	})
+	t.Run("chain transfer", func(t *testing.T) {
+		initTest()
+		chainAddr, err := constraints.ChainLockFromChainID(chainID[:])
+		require.NoError(t, err)
+		err = u.TransferTokens(privKey0, chainAddr, 1000)
+		require.NoError(t, err)
+
+		// state controller transfers funds from the chain. Metadata is preserved
+		par, err := u.MakeTransferData(privKey0, chainAddr, chainOutput().Timestamp()+1)
+		require.NoError(t, err)
+		err = u.DoTransfer(par.WithAmount(500).WithTargetLock(addr1))
+		require.NoError(t, err)
+		require.EqualValues(t, 500, u.Balance(addr1))
+		requireMetadata(constraints.ChainTransitionModeState, "state 0")
+		requireMetadata(constraints.ChainTransitionModeGovernance, "governance 0")
+
+		// governor can't
+		par, err = u.MakeTransferData(privKey2, chainAddr, chainOutput().Timestamp()+1)
+		require.NoError(t, err)
+		err = u.DoTransfer(par.WithAmount(500).WithTargetLock(addr1))
+		require.Error(t, err)
+	})
+}
+
+func TestNFT(t *testing.T) {
+	var privKey0, privKey1 ed25519.PrivateKey
+	var addr0, addr1 constraints.AddressED25519
+	var u *utxodb.UTXODB
+	var nftID [32]byte
+
+	initTest := func() {
+		u = utxodb.NewUTXODB(true)
+		privKey0, _, addr0 = u.GenerateAddress(0)
+		privKey1, _, addr1 = u.GenerateAddress(1)
+		err := u.TokensFromFaucet(addr0, 10000)
+		require.NoError(t, err)
+	}
+	nftData := func() *ledger.OutputDataWithChainID {
+		o, err := u.IndexerAccess().GetUTXOForNFTID(nftID[:], u.StateReader())
+		require.NoError(t, err)
+		return &ledger.OutputDataWithChainID{
+			OutputDataWithID: *o,
+			ChainID:          nftID,
+		}
+	}
+	nftOutput := func() *txbuilder.Output {
+		o, err := txbuilder.OutputFromBytes(nftData().OutputData)
+		require.NoError(t, err)
+		return o
+	}
+	mint := func() {
+		initTest()
+		par, err := u.MakeTransferData(privKey0, nil, uint32(time.Now().Unix()))
+		require.NoError(t, err)
+		outs, err := u.DoTransferOutputs(par.
+			WithAmount(2000).
+			WithTargetLock(addr0).
+			WithMintNFT([]byte("artwork #1")),
+		)
+		require.NoError(t, err)
+		chains, err := txbuilder.ParseChainConstraints(outs)
+		require.NoError(t, err)
+		require.EqualValues(t, 1, len(chains))
+		nftID = chains[0].ChainID
+	}
+	transit := func(privKey ed25519.PrivateKey, modify ...func(o *txbuilder.Output)) error {
+		ts := nftOutput().Timestamp() + 1
+		txb := txbuilder.NewTransactionBuilder(u.LedgerIdentity())
+		err := txb.InsertChainStateTransition(nftData(), ts, modify...)
+		require.NoError(t, err)
+		txb.Transaction.Timestamp = ts
+		txb.Transaction.InputCommitment = txb.InputCommitment()
+		txb.SignED25519(privKey)
+		return u.AddTransaction(txb.Transaction.Bytes(), state.TraceOptionFailedConstraints)
+	}
+	t.Run("mint by address", func(t *testing.T) {
+		mint()
+		nft, idx := nftOutput().NFT()
+		require.True(t, idx != 0xff)
+		require.EqualValues(t, nftOutput().ChainBlockIndex()+1, idx)
+		require.True(t, constraints.Equal(addr0, nft.IssuerAccount()))
+		require.EqualValues(t, blake2b.Sum256([]byte("artwork #1")), nft.MetadataHash)
+
+		_, err := u.IndexerAccess().GetUTXOForChainID(nftID[:], u.StateReader())
+		require.NoError(t, err)
+		t.Logf("%s", nftOutput().ToString())
+	})
+	t.Run("mint by foreign issuer", func(t *testing.T) {
+		initTest()
+		par, err := u.MakeTransferData(privKey0, nil, uint32(time.Now().Unix()))
+		require.NoError(t, err)
+		nft := constraints.NewNFTFromMetadata(constraints.NewSenderAddressED25519(addr1), []byte("artwork #1"))
+		err = u.DoTransfer(par.
+			WithAmount(2000).
+			WithTargetLock(addr0).
+			WithConstraint(constraints.NewChainInit()).
+			WithConstraint(nft),
+		)
+		easyfl.RequireErrorWith(t, err, "nft constraint failed")
+	})
+	t.Run("mint by chain", func(t *testing.T) {
+		initTest()
+		par, err := u.MakeTransferData(privKey0, nil, uint32(time.Now().Unix()))
+		require.NoError(t, err)
+		outs, err := u.DoTransferOutputs(par.
+			WithAmount(2000).
+			WithTargetLock(addr0).
+			WithConstraint(constraints.NewChainInit()),
+		)
+		require.NoError(t, err)
+		chains, err := txbuilder.ParseChainConstraints(outs)
+		require.NoError(t, err)
+		require.EqualValues(t, 1, len(chains))
+		issuerChainID := chains[0].ChainID
+
+		par, err = u.MakeTransferData(privKey0, constraints.ChainLock(issuerChainID[:]), chains[0].Output.Timestamp()+1)
+		require.NoError(t, err)
+		outs, err = u.DoTransferOutputs(par.
+			WithAmount(500).
+			WithTargetLock(addr1).
+			WithMintNFT([]byte("artwork #2")),
+		)
+		require.NoError(t, err)
+		chains, err = txbuilder.ParseChainConstraints(outs)
+		require.NoError(t, err)
+		require.EqualValues(t, 2, len(chains))
+		nftID = chains[1].ChainID
+
+		nft, idx := nftOutput().NFT()
+		require.True(t, idx != 0xff)
+		require.True(t, constraints.Equal(constraints.ChainLock(issuerChainID[:]), nft.IssuerAccount()))
+		require.True(t, constraints.Equal(addr1, nftOutput().Lock()))
+	})
+	t.Run("transfer", func(t *testing.T) {
+		mint()
+		ts := nftOutput().Timestamp() + 1
+		txBytes, err := txbuilder.MakeNFTTransferTransaction(nftData(), addr1, ts, privKey0, u.LedgerIdentity())
+		require.NoError(t, err)
+		err = u.AddTransaction(txBytes, state.TraceOptionFailedConstraints)
+		require.NoError(t, err)
+		require.True(t, constraints.Equal(addr1, nftOutput().Lock()))
+
+		// the owner can't transfer the NFT
+		txBytes, err = txbuilder.MakeNFTTransferTransaction(nftData(), addr0, ts+1, privKey0, u.LedgerIdentity())
+		require.NoError(t, err)
+		err = u.AddTransaction(txBytes, state.TraceOptionFailedConstraints)
+		require.Error(t, err)
+
+		txBytes, err = txbuilder.MakeNFTTransferTransaction(nftData(), addr0, ts+1, privKey1, u.LedgerIdentity())
+		require.NoError(t, err)
+		err = u.AddTransaction(txBytes, state.TraceOptionFailedConstraints)
+		require.NoError(t, err)
+		require.True(t, constraints.Equal(addr0, nftOutput().Lock()))
+		nft, _ := nftOutput().NFT()
+		require.True(t, constraints.Equal(addr0, nft.IssuerAccount()))
+	})
+	t.Run("change metadata", func(t *testing.T) {
+		mint()
+		err := transit(privKey0, func(o *txbuilder.Output) {
+			nft, idx := o.NFT()
+			require.True(t, idx != 0xff)
+			o.PutConstraint(constraints.NewNFTFromMetadata(nft.Issuer, []byte("artwork #2")).Bytes(), idx)
+		})
+		easyfl.RequireErrorWith(t, err, "nft constraint failed")
+	})
+	t.Run("remove nft", func(t *testing.T) {
+		mint()
+		err := transit(privKey0, func(o *txbuilder.Output) {
+			_, idx := o.NFT()
+			require.True(t, idx != 0xff)
+			o.PutConstraint(constraints.NewSenderAddressED25519(addr0).Bytes(), idx)
+		})
+		easyfl.RequireErrorWith(t, err, "nft constraint failed")
+	})
+	t.Run("forge on existing chain", func(t *testing.T) {
+		initTest()
+		par, err := u.MakeTransferData(privKey0, nil, uint32(time.Now().Unix()))
+		require.NoError(t, err)
+		outs, err := u.DoTransferOutputs(par.
+			WithAmount(1000).
+			WithTargetLock(addr0).
+			WithConstraint(constraints.NewChainInit()),
+		)
+		require.NoError(t, err)
+		chains, err := txbuilder.ParseChainConstraints(outs)
+		require.NoError(t, err)
+		require.EqualValues(t, 1, len(chains))
+		// the chain exists before the nft, the nft can't be added to it
+		nftID = chains[0].ChainID
+		_, err = u.IndexerAccess().GetUTXOForNFTID(nftID[:], u.StateReader())
+		require.Error(t, err)
+
+		chs, err := u.IndexerAccess().GetUTXOForChainID(nftID[:], u.StateReader())
+		require.NoError(t, err)
+		ts := chains[0].Output.Timestamp() + 1
+		txb := txbuilder.NewTransactionBuilder(u.LedgerIdentity())
+		err = txb.InsertChainStateTransition(&ledger.OutputDataWithChainID{
+			OutputDataWithID: *chs,
+			ChainID:          nftID,
+		}, ts, func(o *txbuilder.Output) {
+			nft := constraints.NewNFTFromMetadata(constraints.NewSenderAddressED25519(addr0), []byte("artwork #1"))
+			_, err := o.PushConstraint(nft.Bytes())
+			require.NoError(t, err)
+		})
+		require.NoError(t, err)
+		txb.Transaction.Timestamp = ts
+		txb.Transaction.InputCommitment = txb.InputCommitment()
+		txb.SignED25519(privKey0)
+		err = u.AddTransaction(txb.Transaction.Bytes(), state.TraceOptionFailedConstraints)
+		easyfl.RequireErrorWith(t, err, "constraint 'nft' failed")
+	})
+	t.Run("burn", func(t *testing.T) {
+		mint()
+		require.EqualValues(t, 10000, u.Balance(addr0))
+		ts := nftOutput().Timestamp() + 1
+		txBytes, err := txbuilder.MakeNFTBurnTransaction(nftData(), addr1, ts, privKey0, u.LedgerIdentity())
+		require.NoError(t, err)
+		err = u.AddTransaction(txBytes, state.TraceOptionFailedConstraints)
+		require.NoError(t, err)
+		require.EqualValues(t, 8000, u.Balance(addr0))
+		require.EqualValues(t, 2000, u.Balance(addr1))
+
+		_, err = u.IndexerAccess().GetUTXOForNFTID(nftID[:], u.StateReader())
+		require.Error(t, err)
+		_, err = u.IndexerAccess().GetUTXOForChainID(nftID[:], u.StateReader())
+		require.Error(t, err)
+	})
+}
+
+func TestAddressP256(t *testing.T) {
+	var u *utxodb.UTXODB
+	var privKeyP256 *ecdsa.PrivateKey
+	var addrP256 constraints.AddressP256
+	var privKey1 ed25519.PrivateKey
+	var addr1 constraints.AddressED25519
+
+	initTest := func() {
+		u = utxodb.NewUTXODB(true)
+		var err error
+		privKeyP256, err = ecdsa.GenerateKey(elliptic.P256(), crand.Reader)
+		require.NoError(t, err)
+		addrP256 = constraints.AddressP256FromPublicKey(&privKeyP256.PublicKey)
+		privKey1, _, addr1 = u.GenerateAddress(1)
+
+		err = u.TokensFromFaucet(addrP256, 10000)
+		require.NoError(t, err)
+		require.EqualValues(t, 10000, u.Balance(addrP256))
+		require.EqualValues(t, 1, u.NumUTXOs(addrP256))
+	}
+	t.Run("parse", func(t *testing.T) {
+		initTest()
+		back, err := constraints.LockFromBytes(addrP256.Bytes())
+		require.NoError(t, err)
+		require.True(t, constraints.Equal(addrP256, back))
+		t.Logf("%s", back)
+	})
+	t.Run("transfer", func(t *testing.T) {
+		initTest()
+		par, err := u.MakeTransferDataP256(privKeyP256, 0)
+		require.NoError(t, err)
+		err = u.DoTransfer(par.WithAmount(1000).WithTargetLock(addr1))
+		require.NoError(t, err)
+		require.EqualValues(t, 9000, u.Balance(addrP256))
+		require.EqualValues(t, 1000, u.Balance(addr1))
+
+		par, err = u.MakeTransferData(privKey1, nil, 0)
+		require.NoError(t, err)
+		err = u.DoTransfer(par.WithAmount(500).WithTargetLock(addrP256))
+		require.NoError(t, err)
+		require.EqualValues(t, 9500, u.Balance(addrP256))
+		require.EqualValues(t, 2, u.NumUTXOs(addrP256))
+
+		par, err = u.MakeTransferDataP256(privKeyP256, 0)
+		require.NoError(t, err)
+		txBytes, err := u.DoTransferTx(par.WithAmount(9500).WithTargetLock(addr1))
+		require.NoError(t, err)
+		require.EqualValues(t, 0, u.Balance(addrP256))
+		require.EqualValues(t, 10000, u.Balance(addr1))
+		t.Logf("%s", u.TxToString(txBytes))
+	})
+	t.Run("wrong key", func(t *testing.T) {
+		initTest()
+		otherKey, err := ecdsa.GenerateKey(elliptic.P256(), crand.Reader)
+		require.NoError(t, err)
+		par, err := u.MakeTransferDataP256(privKeyP256, 0)
+		require.NoError(t, err)
+		par.SenderPrivateKeyP256 = otherKey
+		err = u.DoTransfer(par.WithAmount(1000).WithTargetLock(addr1))
+		easyfl.RequireErrorWith(t, err, "addressP256 unlock failed")
+	})
+	t.Run("ED25519 signature", func(t *testing.T) {
+		initTest()
+		par, err := u.MakeTransferDataP256(privKeyP256, 0)
+		require.NoError(t, err)
+		par.SenderPrivateKeyP256 = nil
+		par.SenderPrivateKey = privKey1
+		err = u.DoTransfer(par.WithAmount(1000).WithTargetLock(addr1))
+		easyfl.RequireErrorWith(t, err, "addressP256 unlock failed")
+	})
+	t.Run("sender constraints", func(t *testing.T) {
+		initTest()
+		par, err := u.MakeTransferDataP256(privKeyP256, 0)
+		require.NoError(t, err)
+		_, err = txbuilder.MakeTransferTransaction(par.WithAmount(1000).WithTargetLock(addr1).WithSender())
+		easyfl.RequireErrorWith(t, err, "requires the ED25519 key of the sender")
+
+		par, err = u.MakeTransferDataP256(privKeyP256, 0)
+		require.NoError(t, err)
+		_, err = txbuilder.MakeTransferTransaction(par.WithAmount(1000).WithTargetLock(addr1).WithMintNFT([]byte("nft")))
+		easyfl.RequireErrorWith(t, err, "requires the ED25519 key of the sender")
+		require.EqualValues(t, 10000, u.Balance(addrP256))
+	})
+	t.Run("invalid signature", func(t *testing.T) {
+		initTest()
+		outs, err := u.IndexerAccess().GetUTXOsLockedInAccount(addrP256, u.StateReader())
+		require.NoError(t, err)
+		require.EqualValues(t, 1, len(outs))
+		in, err := txbuilder.OutputFromBytes(outs[0].OutputData)
+		require.NoError(t, err)
+
+		ts := in.Timestamp() + 1
+		txb := txbuilder.NewTransactionBuilder(u.LedgerIdentity())
+		_, err = txb.ConsumeOutput(in, outs[0].ID)
+		require.NoError(t, err)
+		_, err = txb.ProduceOutput(txbuilder.OutputBasic(in.Amount(), ts, addr1))
+		require.NoError(t, err)
+		txb.PutSignatureUnlock(0, constraints.ConstraintIndexLock)
+		txb.Transaction.Timestamp = ts
+		txb.Transaction.InputCommitment = txb.InputCommitment()
+
+		// valid signature of the other message
+		sig, err := constraints.SignP256(privKeyP256, []byte("other message"))
+		require.NoError(t, err)
+		r, s, pubKey, err := constraints.DecodeSignatureP256(sig)
+		require.NoError(t, err)
+		require.True(t, constraints.Equal(addrP256, constraints.AddressP256FromPublicKey(pubKey)))
+		txb.Transaction.Signatures = append(txb.Transaction.Signatures, constraints.EncodeSignatureP256(r, s, pubKey))
+		err = u.AddTransaction(txb.Transaction.Bytes(), state.TraceOptionFailedConstraints)
+		easyfl.RequireErrorWith(t, err, "addressP256 unlock failed")
+
+		txb.Transaction.Signatures = txb.Transaction.Signatures[:0]
+		txb.SignP256(privKeyP256)
+		err = u.AddTransaction(txb.Transaction.Bytes(), state.TraceOptionFailedConstraints)
+		require.NoError(t, err)
+		require.EqualValues(t, 0, u.Balance(addrP256))
+	})
+}
+
+func TestFee(t *testing.T) {
+	var privKey0 ed25519.PrivateKey
+	var addr0, addr1 constraints.AddressED25519
+	var u *utxodb.UTXODB
+
+	initTest := func() {
+		u = utxodb.NewUTXODB(true)
+		privKey0, _, addr0 = u.GenerateAddress(0)
+		_, _, addr1 = u.GenerateAddress(1)
+		err := u.TokensFromFaucet(addr0, 10000)
+		require.NoError(t, err)
+		require.EqualValues(t, 0, u.Burned())
+	}
+	// makeTx consumes all outputs of addr0 and sends the amount to addr1. The rest goes to the remainder, except the fee
+	makeTx := func(amount, fee uint64) *txbuilder.TransactionBuilder {
+		outs, err := u.IndexerAccess().GetUTXOsLockedInAccount(addr0, u.StateReader())
+		require.NoError(t, err)
+		require.EqualValues(t, 1, len(outs))
+		in, err := txbuilder.OutputFromBytes(outs[0].OutputData)
+		require.NoError(t, err)
+
+		ts := in.Timestamp() + 1
+		txb := txbuilder.NewTransactionBuilder(u.LedgerIdentity())
+		_, err = txb.ConsumeOutput(in, outs[0].ID)
+		require.NoError(t, err)
+		_, err = txb.ProduceOutput(txbuilder.OutputBasic(amount, ts, addr1))
+		require.NoError(t, err)
+		_, err = txb.ProduceOutput(txbuilder.OutputBasic(in.Amount()-amount-fee, ts, addr0))
+		require.NoError(t, err)
+		txb.PutSignatureUnlock(0, constraints.ConstraintIndexLock)
+		txb.Transaction.Timestamp = ts
+		txb.Transaction.Fee = fee
+		txb.Transaction.InputCommitment = txb.InputCommitment()
+		txb.SignED25519(privKey0)
+		return txb
+	}
+	t.Run("transfer", func(t *testing.T) {
+		initTest()
+		par, err := u.MakeTransferData(privKey0, nil, 0)
+		require.NoError(t, err)
+		txBytes, err := u.DoTransferTx(par.WithAmount(1000).WithTargetLock(addr1).WithFee(100))
+		require.NoError(t, err)
+		require.EqualValues(t, 10000-1000-100, u.Balance(addr0))
+		require.EqualValues(t, 1000, u.Balance(addr1))
+		require.EqualValues(t, 100, u.Burned())
+		require.EqualValues(t, u.Supply()-100, u.Balance(u.GenesisAddress())+u.Balance(addr0)+u.Balance(addr1))
+		t.Logf("%s", u.TxToString(txBytes))
+	})
+	t.Run("transfer all", func(t *testing.T) {
+		initTest()
+		par, err := u.MakeTransferData(privKey0, nil, 0)
+		require.NoError(t, err)
+		err = u.DoTransfer(par.WithAmount(9900).WithTargetLock(addr1).WithFee(100))
+		require.NoError(t, err)
+		require.EqualValues(t, 0, u.Balance(addr0))
+		require.EqualValues(t, 9900, u.Balance(addr1))
+		require.EqualValues(t, 100, u.Burned())
+	})
+	t.Run("not enough for fee", func(t *testing.T) {
+		initTest()
+		par, err := u.MakeTransferData(privKey0, nil, 0)
+		require.NoError(t, err)
+		err = u.DoTransfer(par.WithAmount(9950).WithTargetLock(addr1).WithFee(100))
+		require.Error(t, err)
+		require.EqualValues(t, 0, u.Burned())
+	})
+	t.Run("chain transfer", func(t *testing.T) {
+		initTest()
+		par, err := u.MakeTransferData(privKey0, nil, 0)
+		require.NoError(t, err)
+		outs, err := u.DoTransferOutputs(par.
+			WithAmount(2000).
+			WithTargetLock(addr0).
+			WithConstraint(constraints.NewChainInit()),
+		)
+		require.NoError(t, err)
+		chains, err := txbuilder.ParseChainConstraints(outs)
+		require.NoError(t, err)
+		require.EqualValues(t, 1, len(chains))
+		chainID := chains[0].ChainID
+
+		par, err = u.MakeTransferData(privKey0, constraints.ChainLock(chainID[:]), chains[0].Output.Timestamp()+1)
+		require.NoError(t, err)
+		err = u.DoTransfer(par.WithAmount(500).WithTargetLock(addr1).WithFee(50))
+		require.NoError(t, err)
+		_, onChain, err := u.BalanceOnChain(chainID[:])
+		require.NoError(t, err)
+		require.EqualValues(t, 2000-500-50, onChain)
+		require.EqualValues(t, 500, u.Balance(addr1))
+		require.EqualValues(t, 50, u.Burned())
+	})
+	t.Run("unbalanced", func(t *testing.T) {
+		initTest()
+		txb := makeTx(1000, 100)
+		txb.Transaction.Fee = 99
+		txb.Transaction.Signatures = txb.Transaction.Signatures[:0]
+		txb.SignED25519(privKey0)
+		err := u.AddTransaction(txb.Transaction.Bytes(), state.TraceOptionFailedConstraints)
+		easyfl.RequireErrorWith(t, err, "unbalanced amount")
+
+		err = u.AddTransaction(makeTx(1000, 100).Transaction.Bytes(), state.TraceOptionFailedConstraints)
+		require.NoError(t, err)
+		require.EqualValues(t, 100, u.Burned())
+	})
+	t.Run("fee is signed", func(t *testing.T) {
+		initTest()
+		txb := makeTx(1000, 100)
+		// the fee can't be changed after signing
+		txb.Transaction.Fee = 50
+		err := u.AddTransaction(txb.Transaction.Bytes(), state.TraceOptionFailedConstraints)
+		easyfl.RequireErrorWith(t, err, "addressED25519 unlock failed")
+		require.EqualValues(t, 0, u.Burned())
+	})
+}
+
+func TestVesting(t *testing.T) {
+	var privKey0, privKey1 ed25519.PrivateKey
+	var addr0, addr1 constraints.AddressED25519
+	var u *utxodb.UTXODB
+	var vesting *constraints.Vesting
+	var t0 uint32
+
+	initTest := func(numOutputs int) {
+		u = utxodb.NewUTXODB(true)
+		privKey0, _, addr0 = u.GenerateAddress(0)
+		privKey1, _, addr1 = u.GenerateAddress(1)
+		err := u.TokensFromFaucet(addr0, 10000)
+		require.NoError(t, err)
+
+		t0 = uint32(time.Now().Unix())
+		vesting, err = constraints.NewVesting(t0, t0+100, t0+1000, 1000, addr1)
+		require.NoError(t, err)
+		for i := 0; i < numOutputs; i++ {
+			par, err := u.MakeTransferData(privKey0, nil, t0+uint32(i))
+			require.NoError(t, err)
+			err = u.DoTransfer(par.WithAmount(1000).WithTargetLock(vesting))
+			require.NoError(t, err)
+		}
+	}
+	vestingOutputs := func() []*ledger.OutputDataWithID {
+		outs, err := u.IndexerAccess().GetUTXOsLockedInAccount(addr1, u.StateReader())
+		require.NoError(t, err)
+		ret := make([]*ledger.OutputDataWithID, 0)
+		for _, o := range outs {
+			out, err := txbuilder.OutputFromBytes(o.OutputData)
+			require.NoError(t, err)
+			if _, isVesting := out.Lock().(*constraints.Vesting); isVesting {
+				ret = append(ret, o)
+			}
+		}
+		return ret
+	}
+	// withdraw consumes the vesting output and produces the successor with the lock and the amount
+	withdraw := func(successorLock constraints.Lock, successorAmount uint64, ts uint32) error {
+		outs := vestingOutputs()
+		require.EqualValues(t, 1, len(outs))
+		in, err := txbuilder.OutputFromBytes(outs[0].OutputData)
+		require.NoError(t, err)
+		txb := txbuilder.NewTransactionBuilder(u.LedgerIdentity())
+		_, err = txb.ConsumeOutput(in, outs[0].ID)
+		require.NoError(t, err)
+		_, err = txb.ProduceOutput(txbuilder.OutputBasic(successorAmount, ts, successorLock))
+		require.NoError(t, err)
+		_, err = txb.ProduceOutput(txbuilder.OutputBasic(in.Amount()-successorAmount, ts, addr1))
+		require.NoError(t, err)
+		txb.PutSignatureUnlock(0, constraints.ConstraintIndexLock)
+		txb.Transaction.Timestamp = ts
+		txb.Transaction.InputCommitment = txb.InputCommitment()
+		txb.SignED25519(privKey1)
+		return u.AddTransaction(txb.Transaction.Bytes(), state.TraceOptionFailedConstraints)
+	}
+	t.Run("balance", func(t *testing.T) {
+		initTest(1)
+		back, err := constraints.LockFromBytes(vesting.Bytes())
+		require.NoError(t, err)
+		require.EqualValues(t, vesting.String(), back.String())
+
+		require.EqualValues(t, 1000, u.Balance(addr1))
+		require.EqualValues(t, 0, u.Balance(addr1, t0+50))
+		require.EqualValues(t, 0, u.NumUTXOs(addr1, t0+50))
+		require.EqualValues(t, 100, u.Balance(addr1, t0+100))
+		require.EqualValues(t, 500, u.Balance(addr1, t0+500))
+		require.EqualValues(t, 1000, u.Balance(addr1, t0+1000))
+	})
+	t.Run("withdraw", func(t *testing.T) {
+		initTest(1)
+		txBytes, err := txbuilder.MakeVestingWithdrawalTransaction(vestingOutputs()[0], 500, addr1, t0+500, privKey1, u.LedgerIdentity())
+		require.NoError(t, err)
+		err = u.AddTransaction(txBytes, state.TraceOptionFailedConstraints)
+		require.NoError(t, err)
+		require.EqualValues(t, 1000, u.Balance(addr1))
+		require.EqualValues(t, 500, u.Balance(addr1, t0+500))
+		require.EqualValues(t, 500+250, u.Balance(addr1, t0+750))
+
+		_, err = txbuilder.MakeVestingWithdrawalTransaction(vestingOutputs()[0], 251, addr1, t0+750, privKey1, u.LedgerIdentity())
+		require.Error(t, err)
+		txBytes, err = txbuilder.MakeVestingWithdrawalTransaction(vestingOutputs()[0], 250, addr1, t0+750, privKey1, u.LedgerIdentity())
+		require.NoError(t, err)
+		err = u.AddTransaction(txBytes, state.TraceOptionFailedConstraints)
+		require.NoError(t, err)
+
+		// after the end everything is withdrawn without successor
+		txBytes, err = txbuilder.MakeVestingWithdrawalTransaction(vestingOutputs()[0], 250, addr0, t0+1000, privKey1, u.LedgerIdentity())
+		require.NoError(t, err)
+		err = u.AddTransaction(txBytes, state.TraceOptionFailedConstraints)
+		require.NoError(t, err)
+		require.EqualValues(t, 0, len(vestingOutputs()))
+		require.EqualValues(t, 750, u.Balance(addr1))
+		require.EqualValues(t, 10000-1000+250, u.Balance(addr0))
+	})
+	t.Run("withdraw unvested", func(t *testing.T) {
+		initTest(1)
+		err := withdraw(vesting, 499, t0+500)
+		easyfl.RequireErrorWith(t, err, "vesting unlock failed")
+		err = withdraw(vesting, 500, t0+500)
+		require.NoError(t, err)
+	})
+	t.Run("before cliff", func(t *testing.T) {
+		initTest(1)
+		err := withdraw(vesting, 990, t0+99)
+		easyfl.RequireErrorWith(t, err, "vesting unlock failed")
+	})
+	t.Run("wrong beneficiary", func(t *testing.T) {
+		initTest(1)
+		txBytes, err := txbuilder.MakeVestingWithdrawalTransaction(vestingOutputs()[0], 500, addr0, t0+500, privKey0, u.LedgerIdentity())
+		require.NoError(t, err)
+		err = u.AddTransaction(txBytes, state.TraceOptionFailedConstraints)
+		easyfl.RequireErrorWith(t, err, "addressED25519 unlock failed")
+	})
+	t.Run("change terms", func(t *testing.T) {
+		initTest(1)
+		other, err := constraints.NewVesting(t0, t0+100, t0+500, 1000, addr1)
+		require.NoError(t, err)
+		err = withdraw(other, 500, t0+500)
+		easyfl.RequireErrorWith(t, err, "vesting unlock failed")
+		err = withdraw(addr1, 500, t0+500)
+		easyfl.RequireErrorWith(t, err, "vesting unlock failed")
+	})
+	t.Run("shared successor", func(t *testing.T) {
+		initTest(2)
+		outs := vestingOutputs()
+		require.EqualValues(t, 2, len(outs))
+		ts := t0 + 500
+		txb := txbuilder.NewTransactionBuilder(u.LedgerIdentity())
+		for _, o := range outs {
+			in, err := txbuilder.OutputFromBytes(o.OutputData)
+			require.NoError(t, err)
+			_, err = txb.ConsumeOutput(in, o.ID)
+			require.NoError(t, err)
+		}
+		// one successor with the unvested part of one output
+		_, err := txb.ProduceOutput(txbuilder.OutputBasic(500, ts, vesting))
+		require.NoError(t, err)
+		_, err = txb.ProduceOutput(txbuilder.OutputBasic(1500, ts, addr1))
+		require.NoError(t, err)
+		txb.PutSignatureUnlock(0, constraints.ConstraintIndexLock)
+		err = txb.PutUnlockReference(1, constraints.ConstraintIndexLock, 0)
+		require.NoError(t, err)
+		txb.Transaction.Timestamp = ts
+		txb.Transaction.InputCommitment = txb.InputCommitment()
+		txb.SignED25519(privKey1)
+		err = u.AddTransaction(txb.Transaction.Bytes(), state.TraceOptionFailedConstraints)
+		easyfl.RequireErrorWith(t, err, "vesting unlock failed")
+	})
+	t.Run("wrong schedule", func(t *testing.T) {
+		_, err := constraints.NewVesting(1000, 900, 2000, 1000, addr1)
+		require.Error(t, err)
+		_, err = constraints.NewVesting(1000, 1000, 1000, 1000, addr1)
+		require.Error(t, err)
+	})
+}
+
+func TestCovenant(t *testing.T) {
+	var privKey0, privKey1 ed25519.PrivateKey
+	var addr0, addr1, addr2 constraints.AddressED25519
+	var u *utxodb.UTXODB
+	var covenant *constraints.Covenant
+	var whitelist []constraints.Lock
+	var ts uint32
+
+	vaultID := blake2b.Sum256([]byte("vault"))
+	vaultLock := constraints.ChainLock(vaultID[:])
+	initTest := func() {
+		u = utxodb.NewUTXODB(true)
+		privKey0, _, addr0 = u.GenerateAddress(0)
+		privKey1, _, addr1 = u.GenerateAddress(1)
+		_, _, addr2 = u.GenerateAddress(2)
+		err := u.TokensFromFaucet(addr0, 10000)
+		require.NoError(t, err)
+
+		whitelist = []constraints.Lock{addr2, vaultLock}
+		covenant = constraints.NewCovenant(whitelist...)
+		ts = uint32(time.Now().Unix())
+		par, err := u.MakeTransferData(privKey0, nil, ts)
+		require.NoError(t, err)
+		err = u.DoTransfer(par.WithAmount(1000).WithTargetLock(addr1).WithConstraint(covenant))
+		require.NoError(t, err)
+		outsData, err := u.IndexerAccess().GetUTXOsLockedInAccount(addr1, u.StateReader())
+		require.NoError(t, err)
+		require.EqualValues(t, 1, len(outsData))
+		out, err := txbuilder.OutputFromBytes(outsData[0].OutputData)
+		require.NoError(t, err)
+		ts = out.Timestamp() + 1
+	}
+	// spend consumes all outputs of addr1 and produces the outputs. The covenant unlock parameters
+	// reveal the whitelist and name the produced outputs
+	spend := func(reveal []constraints.Lock, outs []*txbuilder.Output, indices ...byte) error {
+		outsData, err := u.IndexerAccess().GetUTXOsLockedInAccount(addr1, u.StateReader())
+		require.NoError(t, err)
+		ins, err := txbuilder.ParseAndSortOutputData(outsData, nil)
+		require.NoError(t, err)
+
+		txb := txbuilder.NewTransactionBuilder(u.LedgerIdentity())
+		for i, in := range ins {
+			idx, err := txb.ConsumeOutput(in.Output, in.ID)
+			require.NoError(t, err)
+			if i == 0 {
+				txb.PutSignatureUnlock(idx, constraints.ConstraintIndexLock)
+			} else {
+				err = txb.PutUnlockReference(idx, constraints.ConstraintIndexLock, 0)
+				require.NoError(t, err)
+			}
+			if _, covenantIdx := in.Output.Covenant(); covenantIdx != 0xff {
+				txb.PutUnlockParams(idx, covenantIdx, constraints.NewCovenantUnlockParams(reveal, indices...))
+			}
+		}
+		for _, o := range outs {
+			_, err = txb.ProduceOutput(o)
+			require.NoError(t, err)
+		}
+		txb.Transaction.Timestamp = ts
+		txb.Transaction.InputCommitment = txb.InputCommitment()
+		txb.SignED25519(privKey1)
+		return u.AddTransaction(txb.Transaction.Bytes(), state.TraceOptionFailedConstraints)
+	}
+	t.Run("parse", func(t *testing.T) {
+		initTest()
+		back, err := constraints.CovenantFromBytes(covenant.Bytes())
+		require.NoError(t, err)
+		require.EqualValues(t, covenant.WhitelistHash, back.WhitelistHash)
+		require.EqualValues(t, 1000, u.Balance(addr1))
+	})
+	t.Run("to whitelisted address", func(t *testing.T) {
+		initTest()
+		err := spend(whitelist, []*txbuilder.Output{txbuilder.OutputBasic(1000, ts, addr2)}, 0)
+		require.NoError(t, err)
+		require.EqualValues(t, 1000, u.Balance(addr2))
+		require.EqualValues(t, 0, u.Balance(addr1))
+	})
+	t.Run("back to the vault", func(t *testing.T) {
+		initTest()
+		err := spend(whitelist, []*txbuilder.Output{
+			txbuilder.OutputBasic(600, ts, vaultLock),
+			txbuilder.OutputBasic(400, ts, vaultLock),
+		}, 0, 1)
+		require.NoError(t, err)
+		require.EqualValues(t, 1000, u.Balance(vaultLock))
+	})
+	t.Run("not whitelisted", func(t *testing.T) {
+		initTest()
+		err := spend(whitelist, []*txbuilder.Output{txbuilder.OutputBasic(1000, ts, addr0)}, 0)
+		easyfl.RequireErrorWith(t, err, "covenant constraint failed")
+		// the output not named in the unlock parameters doesn't count
+		err = spend(whitelist, []*txbuilder.Output{
+			txbuilder.OutputBasic(999, ts, addr0),
+			txbuilder.OutputBasic(1, ts, addr2),
+		}, 1)
+		easyfl.RequireErrorWith(t, err, "covenant constraint failed")
+	})
+	t.Run("wrong whitelist", func(t *testing.T) {
+		initTest()
+		err := spend([]constraints.Lock{addr0}, []*txbuilder.Output{txbuilder.OutputBasic(1000, ts, addr0)}, 0)
+		easyfl.RequireErrorWith(t, err, "covenant constraint failed")
+		err = spend(whitelist[:1], []*txbuilder.Output{txbuilder.OutputBasic(1000, ts, addr2)}, 0)
+		easyfl.RequireErrorWith(t, err, "covenant constraint failed")
+	})
+	t.Run("repeating index", func(t *testing.T) {
+		initTest()
+		err := spend(whitelist, []*txbuilder.Output{
+			txbuilder.OutputBasic(500, ts, addr2),
+			txbuilder.OutputBasic(500, ts, addr0),
+		}, 0, 0)
+		easyfl.RequireErrorWith(t, err, "covenant constraint failed")
+		err = spend(whitelist, []*txbuilder.Output{txbuilder.OutputBasic(1000, ts, addr2)})
+		easyfl.RequireErrorWith(t, err, "covenant constraint failed")
+	})
+	t.Run("different covenants share produced output", func(t *testing.T) {
+		initTest()
+		// the second covenant output with the narrower whitelist
+		whitelistA := []constraints.Lock{addr2}
+		par, err := u.MakeTransferData(privKey0, nil, ts)
+		require.NoError(t, err)
+		err = u.DoTransfer(par.WithAmount(1000).WithTargetLock(addr1).WithConstraint(constraints.NewCovenant(whitelistA...)))
+		require.NoError(t, err)
+		outsData, err := u.IndexerAccess().GetUTXOsLockedInAccount(addr1, u.StateReader())
+		require.NoError(t, err)
+		ins, err := txbuilder.ParseAndSortOutputData(outsData, nil)
+		require.NoError(t, err)
+		require.EqualValues(t, 2, len(ins))
+
+		spendBoth := func(second ...*txbuilder.Output) error {
+			txTs := ts
+			txb := txbuilder.NewTransactionBuilder(u.LedgerIdentity())
+			for i, in := range ins {
+				if in.Output.Timestamp() >= txTs {
+					txTs = in.Output.Timestamp() + 1
+				}
+				_, err := txb.ConsumeOutput(in.Output, in.ID)
+				require.NoError(t, err)
+				cov, covenantIdx := in.Output.Covenant()
+				require.True(t, covenantIdx != 0xff)
+				// both covenants point to the output #0, unless the second output is given for the wider covenant
+				if cov.WhitelistHash == constraints.NewCovenant(whitelistA...).WhitelistHash {
+					txb.PutUnlockParams(byte(i), covenantIdx, constraints.NewCovenantUnlockParams(whitelistA, 0))
+				} else if len(second) > 0 {
+					txb.PutUnlockParams(byte(i), covenantIdx, constraints.NewCovenantUnlockParams(whitelist, 1))
+				} else {
+					txb.PutUnlockParams(byte(i), covenantIdx, constraints.NewCovenantUnlockParams(whitelist, 0))
+				}
+			}
+			txb.PutSignatureUnlock(0, constraints.ConstraintIndexLock)
+			require.NoError(t, txb.PutUnlockReference(1, constraints.ConstraintIndexLock, 0))
+			_, err := txb.ProduceOutput(txbuilder.OutputBasic(1000, txTs, addr2))
+			require.NoError(t, err)
+			if len(second) > 0 {
+				second[0].WithTimestamp(txTs)
+				_, err = txb.ProduceOutput(second[0])
+			} else {
+				_, err = txb.ProduceOutput(txbuilder.OutputBasic(1000, txTs, addr0))
+			}
+			require.NoError(t, err)
+			txb.Transaction.Timestamp = txTs
+			txb.Transaction.InputCommitment = txb.InputCommitment()
+			txb.SignED25519(privKey1)
+			return u.AddTransaction(txb.Transaction.Bytes())
+		}
+		// the other 1000 would go anywhere
+		err = spendBoth()
+		easyfl.RequireErrorWith(t, err, "covenant constraint failed")
+
+		err = spendBoth(txbuilder.OutputBasic(1000, ts, vaultLock))
+		require.NoError(t, err)
+		require.EqualValues(t, 1000, u.Balance(addr2))
+		require.EqualValues(t, 1000, u.Balance(vaultLock))
+	})
+	t.Run("same covenant shares produced output", func(t *testing.T) {
+		initTest()
+		par, err := u.MakeTransferData(privKey0, nil, ts)
+		require.NoError(t, err)
+		err = u.DoTransfer(par.WithAmount(1000).WithTargetLock(addr1).WithConstraint(covenant))
+		require.NoError(t, err)
+		require.EqualValues(t, 2, u.NumUTXOs(addr1))
+		ts = uint32(time.Now().Unix()) + 1000
+
+		// outputs with the same covenant are counted together
+		err = spend(whitelist, []*txbuilder.Output{txbuilder.OutputBasic(1999, ts, addr2), txbuilder.OutputBasic(1, ts, addr0)}, 0)
+		easyfl.RequireErrorWith(t, err, "covenant constraint failed")
+		err = spend(whitelist, []*txbuilder.Output{txbuilder.OutputBasic(2000, ts, addr2)}, 0)
+		require.NoError(t, err)
+		require.EqualValues(t, 2000, u.Balance(addr2))
+	})
+	t.Run("native tokens", func(t *testing.T) {
+		initTest()
+		// addr0 mints native tokens on its chain to the second covenant output of addr1
+		par, err := u.MakeTransferData(privKey0, nil, ts)
+		require.NoError(t, err)
+		outs, err := u.DoTransferOutputs(par.WithAmount(2000).WithTargetLock(addr0).WithConstraint(constraints.NewChainInit()))
+		require.NoError(t, err)
+		chains, err := txbuilder.ParseChainConstraints(outs)
+		require.NoError(t, err)
+		require.EqualValues(t, 1, len(chains))
+		chainIn := chains[0]
+		predIdx := chainIn.PredecessorConstraintIndex
+		ts = chainIn.Output.Timestamp() + 1
+		tokenID := constraints.FoundryTokenID(chainIn.ChainID, ts)
+
+		txb := txbuilder.NewTransactionBuilder(u.LedgerIdentity())
+		_, err = txb.ConsumeOutput(chainIn.Output, chainIn.ID)
+		require.NoError(t, err)
+		successor := chainIn.Output.Clone().WithTimestamp(ts).WithAmount(1000)
+		successor.PutConstraint(constraints.NewChainConstraint(chainIn.ChainID, 0, predIdx, 0).Bytes(), predIdx)
+		_, err = successor.PushConstraint(constraints.NewFoundry(predIdx, 100, 100, ts).Bytes())
+		require.NoError(t, err)
+		_, err = txb.ProduceOutput(successor)
+		require.NoError(t, err)
+		withTokens := txbuilder.OutputBasic(1000, ts, addr1).WithNativeToken(tokenID, 100)
+		_, err = withTokens.PushConstraint(covenant.Bytes())
+		require.NoError(t, err)
+		_, err = txb.ProduceOutput(withTokens)
+		require.NoError(t, err)
+		txb.PutUnlockParams(0, predIdx, []byte{0, predIdx, 0})
+		txb.PutSignatureUnlock(0, constraints.ConstraintIndexLock)
+		txb.Transaction.Timestamp = ts
+		txb.Transaction.InputCommitment = txb.InputCommitment()
+		txb.SignED25519(privKey0)
+		err = u.AddTransaction(txb.Transaction.Bytes(), state.TraceOptionFailedConstraints)
+		require.NoError(t, err)
+		// the output without covenant pays for the storage of the tokens
+		err = u.TransferTokens(privKey0, addr1, 1000)
+		require.NoError(t, err)
+		require.EqualValues(t, 3, u.NumUTXOs(addr1))
+		ts = uint32(time.Now().Unix()) + 1000
+
+		// the native tokens would go anywhere
+		err = spend(whitelist, []*txbuilder.Output{
+			txbuilder.OutputBasic(2000, ts, addr2),
+			txbuilder.OutputBasic(1000, ts, addr0).WithNativeToken(tokenID, 100),
+		}, 0)
+		easyfl.RequireErrorWith(t, err, "covenant constraint failed")
+
+		err = spend(whitelist, []*txbuilder.Output{
+			txbuilder.OutputBasic(2000, ts, addr2).WithNativeToken(tokenID, 100),
+			txbuilder.OutputBasic(1000, ts, addr0),
+		}, 0)
+		require.NoError(t, err)
+		require.EqualValues(t, 100, u.BalanceNativeToken(addr2, tokenID))
+	})
+}
+
+func TestOrder(t *testing.T) {
+	var privKey0, privKey1 ed25519.PrivateKey
+	var addr0, addr1 constraints.AddressED25519
+	var u *utxodb.UTXODB
+
+	// addr0 places orders offering 1000 for 2000
+	initTest := func(numOrders int) {
+		u = utxodb.NewUTXODB(true)
+		privKey0, _, addr0 = u.GenerateAddress(0)
+		privKey1, _, addr1 = u.GenerateAddress(1)
+		err := u.TokensFromFaucet(addr0, 10000)
+		require.NoError(t, err)
+		err = u.TokensFromFaucet(addr1, 10000)
+		require.NoError(t, err)
+		for i := 0; i < numOrders; i++ {
+			par, err := u.MakeTransferData(privKey0, nil, 0)
+			require.NoError(t, err)
+			err = u.DoTransfer(par.WithAmount(1000).WithOrder(2000))
+			require.NoError(t, err)
+		}
+	}
+	orderOutputs := func() []*ledger.OutputDataWithID {
+		outs, err := u.IndexerAccess().GetUTXOsLockedInAccount(addr0, u.StateReader())
+		require.NoError(t, err)
+		ret := make([]*ledger.OutputDataWithID, 0)
+		for _, o := range outs {
+			out, err := txbuilder.OutputFromBytes(o.OutputData)
+			require.NoError(t, err)
+			if _, isOrder := out.Lock().(*constraints.Order); isOrder {
+				ret = append(ret, o)
+			}
+		}
+		return ret
+	}
+	// timestamp after all orders
+	orderTimestamp := func() uint32 {
+		ret := uint32(0)
+		for _, o := range orderOutputs() {
+			out, err := txbuilder.OutputFromBytes(o.OutputData)
+			require.NoError(t, err)
+			if out.Timestamp() >= ret {
+				ret = out.Timestamp() + 1
+			}
+		}
+		return ret
+	}
+	fillerOutput := func() *txbuilder.OutputWithID {
+		outsData, err := u.IndexerAccess().GetUTXOsLockedInAccount(addr1, u.StateReader())
+		require.NoError(t, err)
+		outs, err := txbuilder.ParseAndSortOutputData(outsData, nil)
+		require.NoError(t, err)
+		require.EqualValues(t, 1, len(outs))
+		return outs[0]
+	}
+	// fill consumes the output of the filler and the orders. Produces the outputs, the taken amount and the remainder.
+	// Fills reference produced outputs by indices
+	fill := func(orders []*ledger.OutputDataWithID, outs []*txbuilder.Output, fills ...[]byte) error {
+		filler := fillerOutput()
+		ts := filler.Output.Timestamp() + 1
+		txb := txbuilder.NewTransactionBuilder(u.LedgerIdentity())
+		_, err := txb.ConsumeOutput(filler.Output, filler.ID)
+		require.NoError(t, err)
+		txb.PutSignatureUnlock(0, constraints.ConstraintIndexLock)
+		total := filler.Output.Amount()
+		for i, o := range orders {
+			in, err := txbuilder.OutputFromBytes(o.OutputData)
+			require.NoError(t, err)
+			idx, err := txb.ConsumeOutput(in, o.ID)
+			require.NoError(t, err)
+			txb.PutUnlockParams(idx, constraints.ConstraintIndexTimestamp, fills[i])
+			total += in.Amount()
+			if in.Timestamp() >= ts {
+				ts = in.Timestamp() + 1
+			}
+		}
+		for _, o := range outs {
+			_, err = txb.ProduceOutput(o.WithTimestamp(ts))
+			require.NoError(t, err)
+			total -= o.Amount()
+		}
+		_, err = txb.ProduceOutput(txbuilder.OutputBasic(total, ts, addr1))
+		require.NoError(t, err)
+		txb.Transaction.Timestamp = ts
+		txb.Transaction.InputCommitment = txb.InputCommitment()
+		txb.SignED25519(privKey1)
+		return u.AddTransaction(txb.Transaction.Bytes(), state.TraceOptionFailedConstraints)
+	}
+	t.Run("place", func(t *testing.T) {
+		initTest(1)
+		orders := orderOutputs()
+		require.EqualValues(t, 1, len(orders))
+		out, err := txbuilder.OutputFromBytes(orders[0].OutputData)
+		require.NoError(t, err)
+		order := out.Lock().(*constraints.Order)
+		require.True(t, constraints.Equal(addr0, order.Owner))
+		require.EqualValues(t, 2000, order.WantAmount)
+		require.EqualValues(t, 0, len(order.WantTokenID))
+		require.EqualValues(t, 10000, u.Balance(addr0))
+	})
+	t.Run("fill", func(t *testing.T) {
+		initTest(1)
+		par, err := u.MakeTransferData(privKey1, nil, 0)
+		require.NoError(t, err)
+		txBytes, err := txbuilder.MakeOrderFillTransaction(par.WithTargetLock(addr1), orderOutputs()[0], 1000)
+		require.NoError(t, err)
+		err = u.AddTransaction(txBytes, state.TraceOptionFailedConstraints)
+		require.NoError(t, err)
+		require.EqualValues(t, 0, len(orderOutputs()))
+		require.EqualValues(t, 11000, u.Balance(addr0))
+		require.EqualValues(t, 9000, u.Balance(addr1))
+	})
+	t.Run("partial fill", func(t *testing.T) {
+		initTest(1)
+		par, err := u.MakeTransferData(privKey1, nil, 0)
+		require.NoError(t, err)
+		txBytes, err := txbuilder.MakeOrderFillTransaction(par.WithTargetLock(addr1), orderOutputs()[0], 300)
+		require.NoError(t, err)
+		err = u.AddTransaction(txBytes, state.TraceOptionFailedConstraints)
+		require.NoError(t, err)
+
+		orders := orderOutputs()
+		require.EqualValues(t, 1, len(orders))
+		out, err := txbuilder.OutputFromBytes(orders[0].OutputData)
+		require.NoError(t, err)
+		require.EqualValues(t, 700, out.Amount())
+		require.EqualValues(t, 1400, out.Lock().(*constraints.Order).WantAmount)
+		require.EqualValues(t, 10000-1000+700+600, u.Balance(addr0))
+		require.EqualValues(t, 10000-600+300, u.Balance(addr1))
+
+		par, err = u.MakeTransferData(privKey1, nil, 0)
+		require.NoError(t, err)
+		txBytes, err = txbuilder.MakeOrderFillTransaction(par.WithTargetLock(addr1), orders[0], 700)
+		require.NoError(t, err)
+		err = u.AddTransaction(txBytes, state.TraceOptionFailedConstraints)
+		require.NoError(t, err)
+		require.EqualValues(t, 0, len(orderOutputs()))
+		require.EqualValues(t, 11000, u.Balance(addr0))
+		require.EqualValues(t, 9000, u.Balance(addr1))
+	})
+	t.Run("underpaid", func(t *testing.T) {
+		initTest(1)
+		orders := orderOutputs()
+		err := fill(orders, []*txbuilder.Output{txbuilder.OutputBasic(1999, 0, addr0)}, []byte{0, 0xff})
+		easyfl.RequireErrorWith(t, err, "order constraint failed")
+		err = fill(orders, []*txbuilder.Output{txbuilder.OutputBasic(2000, 0, addr1)}, []byte{0, 0xff})
+		easyfl.RequireErrorWith(t, err, "order constraint failed")
+		err = fill(orders, []*txbuilder.Output{txbuilder.OutputBasic(2000, 0, addr0)}, []byte{0, 0xff})
+		require.NoError(t, err)
+	})
+	t.Run("wrong successor", func(t *testing.T) {
+		initTest(1)
+		orders := orderOutputs()
+		payment := txbuilder.OutputBasic(600, 0, addr0)
+		// the rest is sold cheaper
+		err := fill(orders, []*txbuilder.Output{payment, txbuilder.OutputBasic(700, 0, constraints.NewOrder(addr0, 1399))}, []byte{0, 1})
+		easyfl.RequireErrorWith(t, err, "order constraint failed")
+		// the successor is not an order of the owner
+		err = fill(orders, []*txbuilder.Output{payment, txbuilder.OutputBasic(700, 0, constraints.NewOrder(addr1, 1400))}, []byte{0, 1})
+		easyfl.RequireErrorWith(t, err, "order constraint failed")
+		err = fill(orders, []*txbuilder.Output{payment, txbuilder.OutputBasic(700, 0, constraints.NewOrder(addr0, 1400))}, []byte{0, 1})
+		require.NoError(t, err)
+	})
+	t.Run("successor with extra blocks", func(t *testing.T) {
+		initTest(1)
+		orders := orderOutputs()
+		payment := txbuilder.OutputBasic(600, 0, addr0)
+		successorWith := func(constr constraints.Constraint) *txbuilder.Output {
+			ret := txbuilder.OutputBasic(700, 0, constraints.NewOrder(addr0, 1400))
+			_, err := ret.PushConstraint(constr.Bytes())
+			require.NoError(t, err)
+			return ret
+		}
+		// the filler freezes the rest of the order
+		err := fill(orders, []*txbuilder.Output{payment, successorWith(constraints.NewTimelock(orderTimestamp() + 1000))}, []byte{0, 1})
+		easyfl.RequireErrorWith(t, err, "order constraint failed")
+		// the filler restricts where the rest goes
+		err = fill(orders, []*txbuilder.Output{payment, successorWith(constraints.NewCovenant(addr1))}, []byte{0, 1})
+		easyfl.RequireErrorWith(t, err, "order constraint failed")
+		// the filler diverts part of the rest to itself
+		err = fill(orders, []*txbuilder.Output{payment, successorWith(constraints.NewStorageDepositReturn(addr1, 100))}, []byte{0, 1})
+		easyfl.RequireErrorWith(t, err, "order constraint failed")
+
+		err = fill(orders, []*txbuilder.Output{payment, txbuilder.OutputBasic(700, 0, constraints.NewOrder(addr0, 1400))}, []byte{0, 1})
+		require.NoError(t, err)
+	})
+	t.Run("shared payment", func(t *testing.T) {
+		initTest(2)
+		orders := orderOutputs()
+		require.EqualValues(t, 2, len(orders))
+		err := fill(orders, []*txbuilder.Output{txbuilder.OutputBasic(2000, 0, addr0)}, []byte{0, 0xff}, []byte{0, 0xff})
+		easyfl.RequireErrorWith(t, err, "order constraint failed")
+		err = fill(orders, []*txbuilder.Output{
+			txbuilder.OutputBasic(2000, 0, addr0),
+			txbuilder.OutputBasic(2000, 0, addr0),
+		}, []byte{0, 0xff}, []byte{1, 0xff})
+		require.NoError(t, err)
+		require.EqualValues(t, 12000, u.Balance(addr0))
+	})
+	t.Run("payment shared with storage deposit return", func(t *testing.T) {
+		initTest(1)
+		// addr0 sends 2000 to addr1 requiring to return 2000
+		par, err := u.MakeTransferData(privKey0, nil, orderTimestamp())
+		require.NoError(t, err)
+		err = u.DoTransfer(par.
+			WithAmount(2000).
+			WithTargetLock(addr1).
+			WithStorageDepositReturn(addr0, 2000),
+		)
+		require.NoError(t, err)
+		orders := orderOutputs()
+		require.EqualValues(t, 1, len(orders))
+		order, err := txbuilder.OutputFromBytes(orders[0].OutputData)
+		require.NoError(t, err)
+		outsData, err := u.IndexerAccess().GetUTXOsLockedInAccount(addr1, u.StateReader())
+		require.NoError(t, err)
+		outs, err := txbuilder.ParseAndSortOutputData(outsData, nil)
+		require.NoError(t, err)
+		require.EqualValues(t, 2, len(outs))
+
+		// fills the order and returns the storage deposit. The order is paid by the output 0
+		fillAndReturn := func(returnIdx byte, payments ...*txbuilder.Output) error {
+			ts := orderTimestamp()
+			txb := txbuilder.NewTransactionBuilder(u.LedgerIdentity())
+			_, err := txb.ConsumeOutput(order, orders[0].ID)
+			require.NoError(t, err)
+			txb.PutUnlockParams(0, constraints.ConstraintIndexTimestamp, constraints.NewOrderFillUnlockParams(0, 0xff))
+			total := order.Amount()
+			for i, o := range outs {
+				idx, err := txb.ConsumeOutput(o.Output, o.ID)
+				require.NoError(t, err)
+				if i == 0 {
+					txb.PutSignatureUnlock(idx, constraints.ConstraintIndexLock)
+				} else {
+					err = txb.PutUnlockReference(idx, constraints.ConstraintIndexLock, 1)
+					require.NoError(t, err)
+				}
+				if _, sdrIdx := o.Output.StorageDepositReturn(); sdrIdx != 0xff {
+					txb.PutUnlockParams(idx, sdrIdx, []byte{returnIdx})
+				}
+				total += o.Output.Amount()
+				if o.Output.Timestamp() >= ts {
+					ts = o.Output.Timestamp() + 1
+				}
+			}
+			for _, o := range payments {
+				_, err = txb.ProduceOutput(o.WithTimestamp(ts))
+				require.NoError(t, err)
+				total -= o.Amount()
+			}
+			_, err = txb.ProduceOutput(txbuilder.OutputBasic(total, ts, addr1))
+			require.NoError(t, err)
+			txb.Transaction.Timestamp = ts
+			txb.Transaction.InputCommitment = txb.InputCommitment()
+			txb.SignED25519(privKey1)
+			return u.AddTransaction(txb.Transaction.Bytes(), state.TraceOptionFailedConstraints)
+		}
+		err = fillAndReturn(0, txbuilder.OutputBasic(2000, 0, addr0))
+		easyfl.RequireErrorWith(t, err, "order constraint failed")
+
+		err = fillAndReturn(1, txbuilder.OutputBasic(2000, 0, addr0), txbuilder.OutputBasic(2000, 0, addr0))
+		require.NoError(t, err)
+		require.EqualValues(t, 10000-2000-1000+4000, u.Balance(addr0))
+	})
+	t.Run("cancel", func(t *testing.T) {
+		initTest(1)
+		orders := orderOutputs()
+		txBytes, err := txbuilder.MakeOrderCancelTransaction(orders[0], addr1, orderTimestamp(), privKey1, u.LedgerIdentity())
+		require.NoError(t, err)
+		err = u.AddTransaction(txBytes, state.TraceOptionFailedConstraints)
+		easyfl.RequireErrorWith(t, err, "addressED25519 unlock failed")
+
+		txBytes, err = txbuilder.MakeOrderCancelTransaction(orders[0], addr0, orderTimestamp(), privKey0, u.LedgerIdentity())
+		require.NoError(t, err)
+		err = u.AddTransaction(txBytes, state.TraceOptionFailedConstraints)
+		require.NoError(t, err)
+		require.EqualValues(t, 0, len(orderOutputs()))
+		require.EqualValues(t, 10000, u.Balance(addr0))
+	})
+	t.Run("cancel by reference", func(t *testing.T) {
+		initTest(2)
+		orders := orderOutputs()
+		require.EqualValues(t, 2, len(orders))
+		filler := fillerOutput()
+		ts := orderTimestamp()
+		if filler.Output.Timestamp() >= ts {
+			ts = filler.Output.Timestamp() + 1
+		}
+		txb := txbuilder.NewTransactionBuilder(u.LedgerIdentity())
+		for _, o := range orders {
+			in, err := txbuilder.OutputFromBytes(o.OutputData)
+			require.NoError(t, err)
+			_, err = txb.ConsumeOutput(in, o.ID)
+			require.NoError(t, err)
+		}
+		_, err := txb.ConsumeOutput(filler.Output, filler.ID)
+		require.NoError(t, err)
+		txb.PutSignatureUnlock(2, constraints.ConstraintIndexLock)
+		// the first order is filled, the second refers to it
+		txb.PutUnlockParams(0, constraints.ConstraintIndexTimestamp, constraints.NewOrderFillUnlockParams(0, 0xff))
+		err = txb.PutUnlockReference(1, constraints.ConstraintIndexLock, 0)
+		require.NoError(t, err)
+		_, err = txb.ProduceOutput(txbuilder.OutputBasic(2000, ts, addr0))
+		require.NoError(t, err)
+		_, err = txb.ProduceOutput(txbuilder.OutputBasic(10000, ts, addr1))
+		require.NoError(t, err)
+		txb.Transaction.Timestamp = ts
+		txb.Transaction.InputCommitment = txb.InputCommitment()
+		txb.SignED25519(privKey1)
+		err = u.AddTransaction(txb.Transaction.Bytes(), state.TraceOptionFailedConstraints)
+		easyfl.RequireErrorWith(t, err, "order constraint failed")
+	})
+}
+
+func TestScriptHashLock(t *testing.T) {
+	var privKey0, privKey1, privKey3 ed25519.PrivateKey
+	var u *utxodb.UTXODB
+	var addr0, addr1, addr2, addr3 constraints.AddressED25519
+	var policy *constraints.MultisigPolicyED25519
+	var multisigScript []byte
+	var lock constraints.ScriptHashLock
+
+	// the script is 2 of 3 multisig of addr1, addr2 and addr3
+	initTest := func() {
+		u = utxodb.NewUTXODB(true)
+		privKey0, _, addr0 = u.GenerateAddress(0)
+		privKey1, _, addr1 = u.GenerateAddress(1)
+		_, _, addr2 = u.GenerateAddress(2)
+		privKey3, _, addr3 = u.GenerateAddress(3)
+		err := u.TokensFromFaucet(addr0, 10000)
+		require.NoError(t, err)
+		policy, err = constraints.NewMultisigPolicyED25519(2, addr1, addr2, addr3)
+		require.NoError(t, err)
+		multisigScript = policy.Address().Bytes()
+		lock = constraints.ScriptHashLockFromScript(multisigScript)
+		err = u.TransferTokens(privKey0, lock, 5000)
+		require.NoError(t, err)
+		require.EqualValues(t, 5000, u.Balance(lock))
+	}
+	t.Run("parse", func(t *testing.T) {
+		initTest()
+		back, err := constraints.LockFromBytes(lock.Bytes())
+		require.NoError(t, err)
+		require.EqualValues(t, lock.String(), back.String())
+		outs, err := u.IndexerAccess().GetUTXOsLockedInAccount(lock, u.StateReader())
+		require.NoError(t, err)
+		require.EqualValues(t, 1, len(outs))
+		t.Logf("script hash lock: %s", back.String())
+	})
+	t.Run("multisig script", func(t *testing.T) {
+		initTest()
+		par, err := u.MakeTransferData(privKey1, lock, 0)
+		require.NoError(t, err)
+		err = u.DoTransfer(par.
+			WithAmount(1000).
+			WithTargetLock(addr0).
+			WithMultisig(policy, privKey3).
+			WithScript(multisigScript),
+		)
+		require.NoError(t, err)
+		require.EqualValues(t, 4000, u.Balance(lock))
+		require.EqualValues(t, 6000, u.Balance(addr0))
+	})
+	t.Run("consume by reference", func(t *testing.T) {
+		initTest()
+		err := u.TransferTokens(privKey0, lock, 1000)
+		require.NoError(t, err)
+		require.EqualValues(t, 2, u.NumUTXOs(lock))
+		par, err := u.MakeTransferData(privKey1, lock, 0)
+		require.NoError(t, err)
+		err = u.DoTransfer(par.
+			WithAmount(5500).
+			WithTargetLock(addr0).
+			WithMultisig(policy, privKey3).
+			WithScript(multisigScript),
+		)
+		require.NoError(t, err)
+		require.EqualValues(t, 500, u.Balance(lock))
+	})
+	t.Run("wrong script", func(t *testing.T) {
+		initTest()
+		par, err := u.MakeTransferData(privKey1, lock, 0)
+		require.NoError(t, err)
+		err = u.DoTransfer(par.
+			WithAmount(1000).
+			WithTargetLock(addr0).
+			WithMultisig(policy, privKey3).
+			WithScript(addr1.Bytes()),
+		)
+		easyfl.RequireErrorWith(t, err, "scriptHashLock unlock failed")
+		par, err = u.MakeTransferData(privKey1, lock, 0)
+		require.NoError(t, err)
+		err = u.DoTransfer(par.
+			WithAmount(1000).
+			WithTargetLock(addr0).
+			WithMultisig(policy, privKey3),
+		)
+		easyfl.RequireErrorWith(t, err, "scriptHashLock unlock failed")
+		require.EqualValues(t, 5000, u.Balance(lock))
+	})
+	t.Run("script fails", func(t *testing.T) {
+		initTest()
+		par, err := u.MakeTransferData(privKey1, lock, 0)
+		require.NoError(t, err)
+		err = u.DoTransfer(par.
+			WithAmount(1000).
+			WithTargetLock(addr0).
+			WithMultisig(policy).
+			WithScript(multisigScript),
+		)
+		easyfl.RequireErrorWith(t, err, "multisigED25519 unlock failed")
+		require.EqualValues(t, 5000, u.Balance(lock))
+	})
+	t.Run("time locked script", func(t *testing.T) {
+		initTest()
+		ts := uint32(time.Now().Unix()) + 100
+		_, _, script, err := easyfl.CompileExpression(fmt.Sprintf("and(x/%s, x/%s)",
+			hex.EncodeToString(constraints.NewTimelock(ts).Bytes()), hex.EncodeToString(addr1.Bytes())))
+		require.NoError(t, err)
+		timeLocked := constraints.ScriptHashLockFromScript(script)
+		err = u.TransferTokens(privKey0, timeLocked, 1000)
+		require.NoError(t, err)
+
+		par, err := u.MakeTransferData(privKey1, timeLocked, ts)
+		require.NoError(t, err)
+		err = u.DoTransfer(par.WithAmount(1000).WithTargetLock(addr1).WithScript(script))
+		require.Error(t, err)
+		require.EqualValues(t, 1000, u.Balance(timeLocked))
+
+		par, err = u.MakeTransferData(privKey1, timeLocked, ts+1)
+		require.NoError(t, err)
+		err = u.DoTransfer(par.WithAmount(1000).WithTargetLock(addr1).WithScript(script))
+		require.NoError(t, err)
+		require.EqualValues(t, 0, u.Balance(timeLocked))
+		require.EqualValues(t, 1000, u.Balance(addr1))
+	})
+}
+
+func TestOracleCondition(t *testing.T) {
+	var privKey0, privKey1 ed25519.PrivateKey
+	var u *utxodb.UTXODB
+	var addr0, addr1 constraints.AddressED25519
+	var oraclePub ed25519.PublicKey
+	var oraclePriv ed25519.PrivateKey
+	var ts uint32
+
+	// the local oracle reports the price as uint64. Output of addr1 can be spent if the price is above 1000
+	initTest := func() {
+		u = utxodb.NewUTXODB(true)
+		privKey0, _, addr0 = u.GenerateAddress(0)
+		privKey1, _, addr1 = u.GenerateAddress(1)
+		var err error
+		oraclePub, oraclePriv, err = ed25519.GenerateKey(crand.Reader)
+		require.NoError(t, err)
+		err = u.TokensFromFaucet(addr0, 10000)
+		require.NoError(t, err)
+
+		cond, err := constraints.NewOracleConditionFromSource(oraclePub, 60, "lessThan(u64/1000, oracleValue)")
+		require.NoError(t, err)
+		par, err := u.MakeTransferData(privKey0, nil, 0)
+		require.NoError(t, err)
+		outs, err := u.DoTransferOutputs(par.WithAmount(1000).WithTargetLock(addr1).WithConstraint(cond))
+		require.NoError(t, err)
+		for _, o := range outs {
+			out, err := txbuilder.OutputFromBytes(o.OutputData)
+			require.NoError(t, err)
+			if out.Timestamp() >= ts {
+				ts = out.Timestamp() + 1
+			}
+		}
+	}
+	price := func(p uint64) []byte {
+		var ret [8]byte
+		binary.BigEndian.PutUint64(ret[:], p)
+		return ret[:]
+	}
+	// spend spends the output with the oracle condition at the timestamp with the unlock parameters
+	spend := func(txTs uint32, unlockParams []byte) error {
+		outsData, err := u.IndexerAccess().GetUTXOsLockedInAccount(addr1, u.StateReader())
+		require.NoError(t, err)
+		require.EqualValues(t, 1, len(outsData))
+		out, err := txbuilder.OutputFromBytes(outsData[0].OutputData)
+		require.NoError(t, err)
+		_, idx := out.OracleCondition()
+		require.True(t, idx != 0xff)
+
+		par, err := u.MakeTransferData(privKey1, nil, txTs)
+		require.NoError(t, err)
+		return u.DoTransfer(par.WithAmount(1000).WithTargetLock(addr0).WithUnlockData(0, idx, unlockParams))
+	}
+	t.Run("parse", func(t *testing.T) {
+		initTest()
+		outsData, err := u.IndexerAccess().GetUTXOsLockedInAccount(addr1, u.StateReader())
+		require.NoError(t, err)
+		out, err := txbuilder.OutputFromBytes(outsData[0].OutputData)
+		require.NoError(t, err)
+		cond, idx := out.OracleCondition()
+		require.EqualValues(t, 3, idx)
+		require.EqualValues(t, oraclePub, cond.OraclePublicKey)
+		require.EqualValues(t, 60, cond.MaxAge)
+		t.Logf("oracle condition: %s", cond.String())
+	})
+	t.Run("condition holds", func(t *testing.T) {
+		initTest()
+		err := spend(ts+10, constraints.SignOracleData(oraclePriv, ts, price(1500)))
+		require.NoError(t, err)
+		require.EqualValues(t, 0, u.Balance(addr1))
+		require.EqualValues(t, 10000, u.Balance(addr0))
+	})
+	t.Run("condition does not hold", func(t *testing.T) {
+		initTest()
+		err := spend(ts+10, constraints.SignOracleData(oraclePriv, ts, price(900)))
+		easyfl.RequireErrorWith(t, err, "oracleCondition failed")
+		err = spend(ts+10, nil)
+		require.Error(t, err)
+		require.EqualValues(t, 1000, u.Balance(addr1))
+	})
+	t.Run("freshness", func(t *testing.T) {
+		initTest()
+		// too old
+		err := spend(ts+61, constraints.SignOracleData(oraclePriv, ts, price(1500)))
+		easyfl.RequireErrorWith(t, err, "oracleCondition failed")
+		// from the future
+		err = spend(ts+10, constraints.SignOracleData(oraclePriv, ts+11, price(1500)))
+		easyfl.RequireErrorWith(t, err, "oracleCondition failed")
+		err = spend(ts+60, constraints.SignOracleData(oraclePriv, ts, price(1500)))
+		require.NoError(t, err)
+	})
+	t.Run("wrong signature", func(t *testing.T) {
+		initTest()
+		_, otherPriv, err := ed25519.GenerateKey(crand.Reader)
+		require.NoError(t, err)
+		err = spend(ts+10, constraints.SignOracleData(otherPriv, ts, price(1500)))
+		easyfl.RequireErrorWith(t, err, "oracleCondition failed")
+		// the value is replaced after signing
+		data := constraints.OracleData(ts, price(900))
+		sig := ed25519.Sign(oraclePriv, data)
+		err = spend(ts+10, constraints.NewOracleUnlockParams(constraints.OracleData(ts, price(1500)), sig))
+		easyfl.RequireErrorWith(t, err, "oracleCondition failed")
+	})
+}
+
+func TestMerkleClaim(t *testing.T) {
+	var privKey0, privKey1 ed25519.PrivateKey
+	var u *utxodb.UTXODB
+	var addr0, addr1, addr2 constraints.AddressED25519
+	var entries []*constraints.AirdropEntry
+	var proofs [][]byte
+	var poolID [32]byte
+
+	// addr0 creates the airdrop pool for entries paid to addr1 and addr2
+	initTest := func(numEntries int) {
+		u = utxodb.NewUTXODB(true)
+		privKey0, _, addr0 = u.GenerateAddress(0)
+		privKey1, _, addr1 = u.GenerateAddress(1)
+		_, _, addr2 = u.GenerateAddress(2)
+		err := u.TokensFromFaucet(addr0, 10_000_000)
+		require.NoError(t, err)
+
+		entries = make([]*constraints.AirdropEntry, numEntries)
+		for i := range entries {
+			lock := addr1
+			if i%2 == 1 {
+				lock = addr2
+			}
+			entries[i] = &constraints.AirdropEntry{Lock: lock, Amount: uint64(1000 + i)}
+		}
+		var root [32]byte
+		root, proofs = constraints.MerkleClaimTree(entries)
+		pool := constraints.NewMerkleClaim(root, numEntries, addr0)
+
+		par, err := u.MakeTransferData(privKey0, nil, uint32(time.Now().Unix()))
+		require.NoError(t, err)
+		outs, err := u.DoTransferOutputs(par.
+			WithAmount(5_000_000).
+			WithTargetLock(pool).
+			WithConstraint(constraints.NewChainInit()),
+		)
+		require.NoError(t, err)
+		chains, err := txbuilder.ParseChainConstraints(outs)
+		require.NoError(t, err)
+		require.EqualValues(t, 1, len(chains))
+		poolID = chains[0].ChainID
+	}
+	chainData := func(chainID [32]byte) *ledger.OutputDataWithChainID {
+		o, err := u.IndexerAccess().GetUTXOForChainID(chainID[:], u.StateReader())
+		require.NoError(t, err)
+		return &ledger.OutputDataWithChainID{
+			OutputDataWithID: *o,
+			ChainID:          chainID,
+		}
+	}
+	poolData := func() *ledger.OutputDataWithChainID {
+		return chainData(poolID)
+	}
+	poolOutput := func() *txbuilder.Output {
+		o, err := txbuilder.OutputFromBytes(poolData().OutputData)
+		require.NoError(t, err)
+		return o
+	}
+	claim := func(index uint16, entry *constraints.AirdropEntry, proof []byte) error {
+		txBytes, err := txbuilder.MakeMerkleClaimTransaction(poolData(), index, entry, proof, poolOutput().Timestamp()+1, privKey1, u.LedgerIdentity())
+		if err != nil {
+			return err
+		}
+		return u.AddTransaction(txBytes, state.TraceOptionFailedConstraints)
+	}
+	// claimWithSuccessor makes the claim with the arbitrary lock of the successor
+	claimWithSuccessor := func(index uint16, successorLock constraints.Lock) error {
+		in := poolOutput()
+		ts := in.Timestamp() + 1
+		txb := txbuilder.NewTransactionBuilder(u.LedgerIdentity())
+		err := txb.InsertChainStateTransition(poolData(), ts, func(o *txbuilder.Output) {
+			o.WithLock(successorLock).WithAmount(in.Amount() - entries[index].Amount)
+		})
+		require.NoError(t, err)
+		paymentIndex, err := txb.ProduceOutput(txbuilder.OutputBasic(entries[index].Amount, ts, entries[index].Lock))
+		require.NoError(t, err)
+		params := constraints.NewMerkleClaimUnlockParams(in.ChainBlockIndex(), index, entries[index], proofs[index], paymentIndex)
+		txb.PutUnlockParams(0, constraints.ConstraintIndexTimestamp, params)
+		txb.Transaction.Timestamp = ts
+		txb.Transaction.InputCommitment = txb.InputCommitment()
+		txb.SignED25519(privKey1)
+		return u.AddTransaction(txb.Transaction.Bytes(), state.TraceOptionFailedConstraints)
+	}
+	t.Run("claim", func(t *testing.T) {
+		initTest(300)
+		err := claim(2, entries[2], proofs[2])
+		require.NoError(t, err)
+		require.EqualValues(t, 1002, u.Balance(addr1))
+		require.EqualValues(t, 5_000_000-1002, poolOutput().Amount())
+
+		pool, ok := poolOutput().Lock().(*constraints.MerkleClaim)
+		require.True(t, ok)
+		require.True(t, pool.IsClaimed(2))
+		require.False(t, pool.IsClaimed(3))
+
+		err = claim(3, entries[3], proofs[3])
+		require.NoError(t, err)
+		require.EqualValues(t, 1003, u.Balance(addr2))
+		require.EqualValues(t, 5_000_000-1002-1003, poolOutput().Amount())
+	})
+	t.Run("double claim", func(t *testing.T) {
+		initTest(300)
+		err := claim(2, entries[2], proofs[2])
+		require.NoError(t, err)
+		err = claim(2, entries[2], proofs[2])
+		require.Error(t, err)
+		// the successor does not change the bitmap
+		pool := poolOutput().Lock()
+		err = claimWithSuccessor(2, pool)
+		easyfl.RequireErrorWith(t, err, "merkleClaim unlock failed")
+		require.EqualValues(t, 1002, u.Balance(addr1))
+	})
+	t.Run("successor does not mark the claim", func(t *testing.T) {
+		initTest(300)
+		pool := poolOutput().Lock().(*constraints.MerkleClaim)
+		err := claimWithSuccessor(2, pool)
+		easyfl.RequireErrorWith(t, err, "merkleClaim unlock failed")
+		wrong, err := pool.WithClaimed(4)
+		require.NoError(t, err)
+		err = claimWithSuccessor(2, wrong)
+		easyfl.RequireErrorWith(t, err, "merkleClaim unlock failed")
+		err = claimWithSuccessor(2, addr1)
+		easyfl.RequireErrorWith(t, err, "merkleClaim unlock failed")
+		right, err := pool.WithClaimed(2)
+		require.NoError(t, err)
+		err = claimWithSuccessor(2, right)
+		require.NoError(t, err)
+	})
+	t.Run("wrong proof", func(t *testing.T) {
+		initTest(300)
+		err := claim(2, entries[2], proofs[4])
+		easyfl.RequireErrorWith(t, err, "merkleClaim unlock failed")
+		err = claim(2, entries[2], proofs[2][:len(proofs[2])-32])
+		easyfl.RequireErrorWith(t, err, "merkleClaim unlock failed")
+		require.EqualValues(t, 0, u.Balance(addr1))
+	})
+	t.Run("wrong entry", func(t *testing.T) {
+		initTest(300)
+		err := claim(2, &constraints.AirdropEntry{Lock: addr1, Amount: 2000}, proofs[2])
+		easyfl.RequireErrorWith(t, err, "merkleClaim unlock failed")
+		err = claim(2, &constraints.AirdropEntry{Lock: addr2, Amount: 1002}, proofs[2])
+		easyfl.RequireErrorWith(t, err, "merkleClaim unlock failed")
+		err = claim(3, entries[2], proofs[2])
+		easyfl.RequireErrorWith(t, err, "merkleClaim unlock failed")
+		require.EqualValues(t, 0, u.Balance(addr1))
+	})
+	t.Run("owner withdraws", func(t *testing.T) {
+		initTest(300)
+		err := claim(2, entries[2], proofs[2])
+		require.NoError(t, err)
+		withdraw := func(privKey ed25519.PrivateKey) error {
+			ts := poolOutput().Timestamp() + 1
+			txb := txbuilder.NewTransactionBuilder(u.LedgerIdentity())
+			err := txb.InsertChainStateTransition(poolData(), ts, func(o *txbuilder.Output) {
+				o.WithLock(addr0)
+			})
+			require.NoError(t, err)
+			txb.Transaction.Timestamp = ts
+			txb.Transaction.InputCommitment = txb.InputCommitment()
+			txb.SignED25519(privKey)
+			return u.AddTransaction(txb.Transaction.Bytes(), state.TraceOptionFailedConstraints)
+		}
+		err = withdraw(privKey1)
+		easyfl.RequireErrorWith(t, err, "addressED25519 unlock failed")
+		err = withdraw(privKey0)
+		require.NoError(t, err)
+		require.EqualValues(t, 10_000_000-1002, u.Balance(addr0))
+	})
+	t.Run("big pool", func(t *testing.T) {
+		initTest(2000)
+		pool := poolOutput().Lock().(*constraints.MerkleClaim)
+		require.EqualValues(t, 250, len(pool.Claimed))
+		err := claim(1999, entries[1999], proofs[1999])
+		require.NoError(t, err)
+		err = claim(0, entries[0], proofs[0])
+		require.NoError(t, err)
+		pool = poolOutput().Lock().(*constraints.MerkleClaim)
+		require.True(t, pool.IsClaimed(0) && pool.IsClaimed(1999) && !pool.IsClaimed(1))
+		err = claim(1999, entries[1999], proofs[1999])
+		require.Error(t, err)
+	})
+	t.Run("pools share payment", func(t *testing.T) {
+		initTest(300)
+		// the second pool with the same entries
+		par, err := u.MakeTransferData(privKey0, nil, poolOutput().Timestamp()+1)
+		require.NoError(t, err)
+		outs, err := u.DoTransferOutputs(par.
+			WithAmount(5_000_000).
+			WithTargetLock(poolOutput().Lock()).
+			WithConstraint(constraints.NewChainInit()),
+		)
+		require.NoError(t, err)
+		chains, err := txbuilder.ParseChainConstraints(outs)
+		require.NoError(t, err)
+		require.EqualValues(t, 1, len(chains))
+		pools := []*ledger.OutputDataWithChainID{poolData(), chainData(chains[0].ChainID)}
+
+		// both pools pay the entry 2. Claims reference payments by indices
+		claimFromPools := func(payments []*txbuilder.Output, paymentIndices ...byte) error {
+			ts := uint32(0)
+			for _, p := range pools {
+				in, err := txbuilder.OutputFromBytes(p.OutputData)
+				require.NoError(t, err)
+				if in.Timestamp() >= ts {
+					ts = in.Timestamp() + 1
+				}
+			}
+			claimed, err := poolOutput().Lock().(*constraints.MerkleClaim).WithClaimed(2)
+			require.NoError(t, err)
+			txb := txbuilder.NewTransactionBuilder(u.LedgerIdentity())
+			for i, p := range pools {
+				in, err := txbuilder.OutputFromBytes(p.OutputData)
+				require.NoError(t, err)
+				err = txb.InsertChainStateTransition(p, ts, func(o *txbuilder.Output) {
+					o.WithLock(claimed).WithAmount(in.Amount() - entries[2].Amount)
+				})
+				require.NoError(t, err)
+				params := constraints.NewMerkleClaimUnlockParams(in.ChainBlockIndex(), 2, entries[2], proofs[2], paymentIndices[i])
+				txb.PutUnlockParams(byte(i), constraints.ConstraintIndexTimestamp, params)
+			}
+			for _, o := range payments {
+				_, err = txb.ProduceOutput(o.WithTimestamp(ts))
+				require.NoError(t, err)
+			}
+			txb.Transaction.Timestamp = ts
+			txb.Transaction.InputCommitment = txb.InputCommitment()
+			txb.SignED25519(privKey1)
+			return u.AddTransaction(txb.Transaction.Bytes(), state.TraceOptionFailedConstraints)
+		}
+		// successors of the pools are outputs 0 and 1
+		err = claimFromPools([]*txbuilder.Output{txbuilder.OutputBasic(1002, 0, addr1)}, 2, 2)
+		easyfl.RequireErrorWith(t, err, "merkleClaim unlock failed")
+		require.EqualValues(t, 0, u.Balance(addr1))
+
+		err = claimFromPools([]*txbuilder.Output{
+			txbuilder.OutputBasic(1002, 0, addr1),
+			txbuilder.OutputBasic(1002, 0, addr1),
+		}, 2, 3)
+		require.NoError(t, err)
+		require.EqualValues(t, 2004, u.Balance(addr1))
+	})
+}
+
+func TestEndorsementLimit(t *testing.T) {
+	u := utxodb.NewUTXODB(true)
+	privKey0, _, addr0 := u.GenerateAddress(0)
+	_, _, addr1 := u.GenerateAddress(1)
+	err := u.TokensFromFaucet(addr0, 10000)
+	require.NoError(t, err)
+
+	par, err := u.MakeTransferData(privKey0, nil, 0)
+	require.NoError(t, err)
+	txBytes, err := txbuilder.MakeTransferTransaction(par.WithAmount(1000).WithTargetLock(addr1))
+	require.NoError(t, err)
+
+	withEndorsements := func(n int) []byte {
+		arr := lazyslice.ArrayFromBytes(append([]byte{}, txBytes...), int(constraints.TxTreeIndexMax))
+		endorsements := lazyslice.EmptyArray(256)
+		for i := 0; i < n; i++ {
+			txid := ledger.TransactionID(blake2b.Sum256([]byte{byte(i)}))
+			endorsements.Push(txid[:])
+		}
+		arr.PutAtIdxGrow(constraints.TxEndorsements, endorsements.Bytes())
+		return arr.Bytes()
+	}
+	for n := 0; n <= constraints.MaxNumberOfEndorsements; n++ {
+		require.NotPanics(t, func() {
+			state.MustTransactionFromTransferableBytes(withEndorsements(n), u.LedgerIdentity())
+		})
+	}
+	easyfl.RequirePanicOrErrorWith(t, func() error {
+		state.MustTransactionFromTransferableBytes(withEndorsements(constraints.MaxNumberOfEndorsements+1), u.LedgerIdentity())
+		return nil
+	}, "number of endorsements exceeds limit")
+
+	// endorsements are signed, so the relayer can't replace them
+	require.NotEqualValues(t,
+		ledger.TransactionIDFromTransactionBytes(withEndorsements(1), u.LedgerIdentity()),
+		ledger.TransactionIDFromTransactionBytes(withEndorsements(2), u.LedgerIdentity()))
+	err = u.AddTransaction(withEndorsements(1), state.TraceOptionFailedConstraints)
+	easyfl.RequireErrorWith(t, err, "addressED25519 unlock failed")
+	err = u.AddTransaction(txBytes, state.TraceOptionFailedConstraints)
+	require.NoError(t, err)
+}
+
+func TestTransactionID(t *testing.T) {
+	u := utxodb.NewUTXODB(true)
+	privKey0, _, addr0 := u.GenerateAddress(0)
+	privKey1, pubKey1, addr1 := u.GenerateAddress(1)
+	err := u.TokensFromFaucet(addr0, 10000)
+	require.NoError(t, err)
+
+	par, err := u.MakeTransferData(privKey0, nil, 0)
+	require.NoError(t, err)
+	txBytes, err := txbuilder.MakeTransferTransaction(par.WithAmount(1000).WithTargetLock(addr1))
+	require.NoError(t, err)
+
+	txid := ledger.TransactionIDFromTransactionBytes(txBytes, u.LedgerIdentity())
+	require.EqualValues(t, txid, state.MustTransactionFromTransferableBytes(txBytes, u.LedgerIdentity()).ID())
+	ctx, err := state.TransactionContextFromTransferableBytes(txBytes, u.StateReader())
+	require.NoError(t, err)
+	require.EqualValues(t, txid, ctx.TransactionID())
+
+	// the relayer adds a signature to the transaction. It is valid, but the ID does not change
+	arr := lazyslice.ArrayFromBytes(append([]byte{}, txBytes...), int(constraints.TxTreeIndexMax))
+	sigs := lazyslice.ArrayFromBytes(arr.At(int(constraints.TxSignatures)), 256)
+	sig := ed25519.Sign(privKey1, ledger.TransactionEssenceBytes(txBytes, u.LedgerIdentity()))
+	sigs.Push(append(sig, pubKey1...))
+	arr.PutAtIdx(constraints.TxSignatures, sigs.Bytes())
+	malleated := arr.Bytes()
+	require.NotEqualValues(t, txBytes, malleated)
+	require.EqualValues(t, txid, ledger.TransactionIDFromTransactionBytes(malleated, u.LedgerIdentity()))
+
+	err = u.AddTransaction(malleated, state.TraceOptionFailedConstraints)
+	require.NoError(t, err)
+	require.EqualValues(t, 1000, u.Balance(addr1))
+	// outputs are identified by the ID of the signed transaction
+	for i := byte(0); i < 2; i++ {
+		oid := ledger.NewOutputID(txid, i)
+		_, found := u.StateReader().GetUTXO(&oid)
+		require.True(t, found)
+	}
+	// the original transaction is a double spend
+	err = u.AddTransaction(txBytes)
+	require.Error(t, err)
+}
+
+func TestReplayProtection(t *testing.T) {
+	u := utxodb.NewUTXODB(true)
+	privKey0, _, addr0 := u.GenerateAddress(0)
+	_, _, addr1 := u.GenerateAddress(1)
+	err := u.TokensFromFaucet(addr0, 10000)
+	require.NoError(t, err)
+	require.True(t, len(u.LedgerIdentity()) > 0)
+
+	t.Run("signed for another ledger", func(t *testing.T) {
+		par, err := u.MakeTransferData(privKey0, nil, 0)
+		require.NoError(t, err)
+		par.WithAmount(1000).WithTargetLock(addr1).WithLedgerIdentity([]byte("another ledger"))
+		txBytes, err := txbuilder.MakeTransferTransaction(par)
+		require.NoError(t, err)
+		// same transaction has different ID on different ledgers
+		require.NotEqualValues(t,
+			ledger.TransactionIDFromTransactionBytes(txBytes, u.LedgerIdentity()),
+			ledger.TransactionIDFromTransactionBytes(txBytes, []byte("another ledger")))
+
+		err = u.AddTransaction(txBytes, state.TraceOptionFailedConstraints)
+		easyfl.RequireErrorWith(t, err, "addressED25519 unlock failed")
+		require.EqualValues(t, 0, u.Balance(addr1))
+	})
+	t.Run("timestamp is signed", func(t *testing.T) {
+		par, err := u.MakeTransferData(privKey0, nil, 0)
+		require.NoError(t, err)
+		txBytes, err := txbuilder.MakeTransferTransaction(par.WithAmount(1000).WithTargetLock(addr1))
+		require.NoError(t, err)
+
+		arr := lazyslice.ArrayFromBytes(append([]byte{}, txBytes...), int(constraints.TxTreeIndexMax))
+		var tsBin [4]byte
+		binary.BigEndian.PutUint32(tsBin[:], binary.BigEndian.Uint32(arr.At(int(constraints.TxTimestamp)))+1)
+		arr.PutAtIdx(constraints.TxTimestamp, tsBin[:])
+		require.NotEqualValues(t,
+			ledger.TransactionEssenceBytes(txBytes, u.LedgerIdentity()),
+			ledger.TransactionEssenceBytes(arr.Bytes(), u.LedgerIdentity()))
+
+		err = u.AddTransaction(arr.Bytes())
+		require.Error(t, err)
+
+		err = u.AddTransaction(txBytes, state.TraceOptionFailedConstraints)
+		require.NoError(t, err)
+		require.EqualValues(t, 1000, u.Balance(addr1))
+	})
+	t.Run("local libraries are signed", func(t *testing.T) {
+		par, err := u.MakeTransferData(privKey0, nil, 0)
+		require.NoError(t, err)
+		txBytes, err := txbuilder.MakeTransferTransaction(par.WithAmount(1000).WithTargetLock(addr1))
+		require.NoError(t, err)
+
+		libBin, err := constraints.CompileLocalLibrary("func fun1 : concat($0,$1)")
+		require.NoError(t, err)
+		arr := lazyslice.ArrayFromBytes(append([]byte{}, txBytes...), int(constraints.TxTreeIndexMax))
+		arr.PutAtIdxGrow(constraints.TxLocalLibraries, libBin)
+		require.NotEqualValues(t,
+			ledger.TransactionIDFromTransactionBytes(txBytes, u.LedgerIdentity()),
+			ledger.TransactionIDFromTransactionBytes(arr.Bytes(), u.LedgerIdentity()))
+
+		err = u.AddTransaction(arr.Bytes(), state.TraceOptionFailedConstraints)
+		easyfl.RequireErrorWith(t, err, "addressED25519 unlock failed")
+
+		err = u.AddTransaction(txBytes, state.TraceOptionFailedConstraints)
+		require.NoError(t, err)
+		require.EqualValues(t, 2000, u.Balance(addr1))
+	})
+}
+
+func TestValidationError(t *testing.T) {
+	u := utxodb.NewUTXODB(true)
+	privKey0, _, addr0 := u.GenerateAddress(0)
+	_, _, addr1 := u.GenerateAddress(1)
+	err := u.TokensFromFaucet(addr0, 10000)
+	require.NoError(t, err)
+
+	t.Run("failure code", func(t *testing.T) {
+		par, err := u.MakeTransferData(privKey0, nil, 0)
+		require.NoError(t, err)
+		par.WithAmount(1000).WithTargetLock(addr1).WithLedgerIdentity([]byte("another ledger"))
+		txBytes, err := txbuilder.MakeTransferTransaction(par)
+		require.NoError(t, err)
+		err = u.AddTransaction(txBytes)
+		require.Error(t, err)
+
+		var verr *state.ValidationError
+		require.True(t, errors.As(err, &verr))
+		require.True(t, verr.Consumed)
+		require.EqualValues(t, 0, verr.OutputIndex)
+		require.EqualValues(t, constraints.ConstraintIndexLock, verr.BlockIndex)
+		require.EqualValues(t, "addressED25519", verr.ConstraintName)
+		prefix, err := easyfl.ParseBytecodePrefix(addr0.Bytes())
+		require.NoError(t, err)
+		require.EqualValues(t, prefix, verr.Prefix)
+		require.EqualValues(t, "addressED25519_unlock_failed", verr.FailureCode)
+		require.Error(t, verr.Err)
+		require.EqualValues(t, "@.consumed.[0].out[0].block[2]", state.PathToString(verr.Path()))
+	})
+	t.Run("constraint is false", func(t *testing.T) {
+		outsData, err := u.IndexerAccess().GetUTXOsLockedInAccount(addr0, u.StateReader())
+		require.NoError(t, err)
+		outs, err := txbuilder.ParseAndSortOutputData(outsData, nil)
+		require.NoError(t, err)
+		require.EqualValues(t, 1, len(outs))
+		ts := outs[0].Output.Timestamp() + 1
+
+		txb := txbuilder.NewTransactionBuilder(u.LedgerIdentity())
+		_, err = txb.ConsumeOutput(outs[0].Output, outs[0].ID)
+		require.NoError(t, err)
+		txb.PutSignatureUnlock(0, constraints.ConstraintIndexLock)
+		// the timestamp of the produced output is not equal to the timestamp of the transaction
+		_, err = txb.ProduceOutput(txbuilder.OutputBasic(outs[0].Output.Amount(), ts+1, addr1))
+		require.NoError(t, err)
+		txb.Transaction.Timestamp = ts
+		txb.Transaction.InputCommitment = txb.InputCommitment()
+		txb.SignED25519(privKey0)
+		err = u.AddTransaction(txb.Transaction.Bytes())
+		require.Error(t, err)
+
+		var verr *state.ValidationError
+		require.True(t, errors.As(err, &verr))
+		require.False(t, verr.Consumed)
+		require.EqualValues(t, 0, verr.OutputIndex)
+		require.EqualValues(t, constraints.ConstraintIndexTimestamp, verr.BlockIndex)
+		require.EqualValues(t, "timestamp", verr.ConstraintName)
+		require.EqualValues(t, "", verr.FailureCode)
+		require.NoError(t, verr.Err)
+	})
+	t.Run("other errors", func(t *testing.T) {
+		par, err := u.MakeTransferData(privKey0, nil, 0)
+		require.NoError(t, err)
+		txBytes, err := txbuilder.MakeTransferTransaction(par.WithAmount(1000).WithTargetLock(addr1))
+		require.NoError(t, err)
+		err = u.AddTransaction(txBytes)
+		require.NoError(t, err)
+		// double spend is not a failure of the constraint
+		err = u.AddTransaction(txBytes)
+		require.Error(t, err)
+		var verr *state.ValidationError
+		require.False(t, errors.As(err, &verr))
+	})
+	// the failure code is parsed from the panic message of EasyFL. The tests pin its format
+	t.Run("numeric failure code", func(t *testing.T) {
+		_, _, script, err := easyfl.CompileExpression("fail(100)")
+		require.NoError(t, err)
+		lock := constraints.ScriptHashLockFromScript(script)
+		err = u.TransferTokens(privKey0, lock, 1000)
+		require.NoError(t, err)
+
+		par, err := u.MakeTransferData(privKey0, lock, 0)
+		require.NoError(t, err)
+		err = u.DoTransfer(par.WithAmount(1000).WithTargetLock(addr1).WithScript(script))
+		require.Error(t, err)
+
+		var verr *state.ValidationError
+		require.True(t, errors.As(err, &verr))
+		require.EqualValues(t, "scriptHashLock", verr.ConstraintName)
+		require.EqualValues(t, "error #100", verr.FailureCode)
+	})
+	t.Run("nested failure", func(t *testing.T) {
+		privKey2, _, addr2 := u.GenerateAddress(2)
+		policy, err := constraints.NewMultisigPolicyED25519(2, addr1, addr2)
+		require.NoError(t, err)
+		script := policy.Address().Bytes()
+		lock := constraints.ScriptHashLockFromScript(script)
+		err = u.TransferTokens(privKey0, lock, 1000)
+		require.NoError(t, err)
+
+		// only one of two signatures
+		par, err := u.MakeTransferData(privKey2, lock, 0)
+		require.NoError(t, err)
+		err = u.DoTransfer(par.WithAmount(1000).WithTargetLock(addr1).WithMultisig(policy).WithScript(script))
+		require.Error(t, err)
+
+		// the failure code of the innermost failed constraint is reported
+		var verr *state.ValidationError
+		require.True(t, errors.As(err, &verr))
+		require.EqualValues(t, "scriptHashLock", verr.ConstraintName)
+		require.EqualValues(t, "multisigED25519_unlock_failed", verr.FailureCode)
+	})
+}
+
+func TestTraceData(t *testing.T) {
+	u := utxodb.NewUTXODB()
+	privKey0, _, addr0 := u.GenerateAddress(0)
+	_, _, addr1 := u.GenerateAddress(1)
+	err := u.TokensFromFaucet(addr0, 10000)
+	require.NoError(t, err)
+
+	par, err := u.MakeTransferData(privKey0, nil, 0)
+	require.NoError(t, err)
+	txBytes, err := txbuilder.MakeTransferTransaction(par.WithAmount(1000).WithTargetLock(addr1))
+	require.NoError(t, err)
+
+	t.Run("valid", func(t *testing.T) {
+		trace, err := u.TraceTransaction(txBytes)
+		require.NoError(t, err)
+		txid := ledger.TransactionIDFromTransactionBytes(txBytes, u.LedgerIdentity())
+		require.EqualValues(t, txid.String(), trace.TransactionID)
+		require.EqualValues(t, "", trace.Error)
+		// 3 constraints of the consumed output and 3 constraints of each of 2 produced outputs
+		require.EqualValues(t, 9, len(trace.Constraints))
+		require.EqualValues(t, "@.consumed.[0].out[0].block[0]", trace.Constraints[0].Path)
+		require.EqualValues(t, "amount", trace.Constraints[0].Name)
+		for _, c := range trace.Constraints {
+			require.True(t, c.OK)
+			require.True(t, len(c.Calls) > 0)
+		}
+		lock := trace.Constraints[2]
+		require.EqualValues(t, "addressED25519", lock.Name)
+		found := false
+		for _, call := range lock.Calls {
+			switch call.Function {
+			case "ValidSigED25519":
+				require.EqualValues(t, "true", call.Result)
+				require.EqualValues(t, 3, len(call.Args))
+				require.True(t, strings.HasPrefix(call.Args[0], "msg="))
+				require.True(t, strings.HasPrefix(call.Args[1], "sig="))
+				require.True(t, strings.HasPrefix(call.Args[2], "pubKey="))
+				found = true
+			case "equal":
+				require.EqualValues(t, 2, len(call.Args))
+				require.EqualValues(t, fmt.Sprintf("equal:: %s, %s -> %s", call.Args[0], call.Args[1], call.Result), call.Raw)
+			case "unlockedWithSigED25519":
+				// only the number of arguments is traced
+				require.EqualValues(t, 0, len(call.Args))
+				require.True(t, strings.Contains(call.Raw, "2 params"))
+			}
+		}
+		require.True(t, found)
+
+		data, err := json.Marshal(trace)
+		require.NoError(t, err)
+		var back state.Trace
+		err = json.Unmarshal(data, &back)
+		require.NoError(t, err)
+		require.EqualValues(t, trace, &back)
+		// ledger state is not updated
+		require.EqualValues(t, 0, u.Balance(addr1))
+	})
+	t.Run("invalid", func(t *testing.T) {
+		par, err := u.MakeTransferData(privKey0, nil, 0)
+		require.NoError(t, err)
+		par.WithAmount(1000).WithTargetLock(addr1).WithLedgerIdentity([]byte("another ledger"))
+		txBytesWrong, err := txbuilder.MakeTransferTransaction(par)
+		require.NoError(t, err)
+
+		trace, err := u.TraceTransaction(txBytesWrong)
+		easyfl.RequireErrorWith(t, err, "addressED25519 unlock failed")
+		require.EqualValues(t, err.Error(), trace.Error)
+		failed := trace.Constraints[len(trace.Constraints)-1]
+		require.False(t, failed.OK)
+		require.EqualValues(t, "@.consumed.[0].out[0].block[2]", failed.Path)
+		require.Contains(t, failed.Error, "addressED25519 unlock failed")
+		panicCall := failed.Calls[len(failed.Calls)-1]
+		require.Contains(t, panicCall.Message, "addressED25519 unlock failed")
+		require.True(t, strings.HasPrefix(panicCall.Raw, "panic: "))
+		require.EqualValues(t, "", panicCall.Function)
+		require.EqualValues(t, 0, len(panicCall.Args))
+	})
+	t.Run("add after trace", func(t *testing.T) {
+		err := u.AddTransaction(txBytes)
+		require.NoError(t, err)
+		require.EqualValues(t, 1000, u.Balance(addr1))
+	})
+}
+
+func TestSigHashModes(t *testing.T) {
+	var privKey0, privKey1 ed25519.PrivateKey
+	var u *utxodb.UTXODB
+	var addr0, addr1, addr2 constraints.AddressED25519
+	var ts uint32
+
+	// adjustTimestamp makes the timestamp of the transaction later than timestamps of outputs of the addresses
+	adjustTimestamp := func(addrs ...constraints.AddressED25519) {
+		for _, addr := range addrs {
+			outsData, err := u.IndexerAccess().GetUTXOsLockedInAccount(addr, u.StateReader())
+			require.NoError(t, err)
+			outs, err := txbuilder.ParseAndSortOutputData(outsData, nil)
+			require.NoError(t, err)
+			for _, o := range outs {
+				if o.Output.Timestamp() >= ts {
+					ts = o.Output.Timestamp() + 1
+				}
+			}
+		}
+	}
+	initTest := func() {
+		u = utxodb.NewUTXODB(true)
+		privKey0, _, addr0 = u.GenerateAddress(0)
+		privKey1, _, addr1 = u.GenerateAddress(1)
+		_, _, addr2 = u.GenerateAddress(2)
+		err := u.TokensFromFaucet(addr0, 1500)
+		require.NoError(t, err)
+		err = u.TokensFromFaucet(addr1, 1500)
+		require.NoError(t, err)
+		ts = 0
+		adjustTimestamp(addr0, addr1)
+	}
+	// consume adds outputs of the address to the transaction. They are unlocked with the signature with the index
+	consume := func(txb *txbuilder.TransactionBuilder, addr constraints.AddressED25519, sigIndex byte) byte {
+		outsData, err := u.IndexerAccess().GetUTXOsLockedInAccount(addr, u.StateReader())
+		require.NoError(t, err)
+		outs, err := txbuilder.ParseAndSortOutputData(outsData, nil)
+		require.NoError(t, err)
+		require.EqualValues(t, 1, len(outs))
+		idx, err := txb.ConsumeOutput(outs[0].Output, outs[0].ID)
+		require.NoError(t, err)
+		txb.PutSignatureUnlock(idx, constraints.ConstraintIndexLock, sigIndex)
+		return idx
+	}
+	produce := func(txb *txbuilder.TransactionBuilder, amount uint64, lock constraints.Lock) {
+		_, err := txb.ProduceOutput(txbuilder.OutputBasic(amount, ts, lock))
+		require.NoError(t, err)
+	}
+	sign := func(txb *txbuilder.TransactionBuilder, privKey ed25519.PrivateKey, mode, inputIndex byte) {
+		txb.Transaction.Timestamp = ts
+		_, err := txb.SignED25519WithMode(privKey, mode, inputIndex)
+		require.NoError(t, err)
+	}
+	submit := func(txb *txbuilder.TransactionBuilder) error {
+		txb.Transaction.Timestamp = ts
+		txb.Transaction.InputCommitment = txb.InputCommitment()
+		return u.AddTransaction(txb.Transaction.Bytes(), state.TraceOptionFailedConstraints)
+	}
+	t.Run("single", func(t *testing.T) {
+		initTest()
+		// each party signs its input and the output with the same index
+		txb := txbuilder.NewTransactionBuilder(u.LedgerIdentity())
+		consume(txb, addr0, 0)
+		produce(txb, 1500, addr1)
+		sign(txb, privKey0, constraints.SigHashSingle, 0)
+		// the second party adds its part later, the signature of the first party remains valid
+		consume(txb, addr1, 1)
+		produce(txb, 1500, addr2)
+		sign(txb, privKey1, constraints.SigHashSingle, 1)
+		err := submit(txb)
+		require.NoError(t, err)
+		require.EqualValues(t, 1500, u.Balance(addr1))
+		require.EqualValues(t, 1500, u.Balance(addr2))
+		require.EqualValues(t, 0, u.Balance(addr0))
+	})
+	t.Run("single tampered", func(t *testing.T) {
+		initTest()
+		txb := txbuilder.NewTransactionBuilder(u.LedgerIdentity())
+		consume(txb, addr0, 0)
+		produce(txb, 1500, addr1)
+		sign(txb, privKey0, constraints.SigHashSingle, 0)
+		consume(txb, addr1, 1)
+		produce(txb, 1500, addr0)
+		sign(txb, privKey1, constraints.SigHashSingle, 1)
+		// the output signed by the first party is replaced
+		txb.Transaction.Outputs[0] = txbuilder.OutputBasic(1500, ts, addr2)
+		err := submit(txb)
+		easyfl.RequireErrorWith(t, err, "addressED25519 unlock failed")
+	})
+	t.Run("fee is signed", func(t *testing.T) {
+		initTest()
+		txb := txbuilder.NewTransactionBuilder(u.LedgerIdentity())
+		consume(txb, addr0, 0)
+		produce(txb, 1500, addr1)
+		sign(txb, privKey0, constraints.SigHashSingle, 0)
+		// the second party pays the fee, which was not agreed by the first party
+		consume(txb, addr1, 1)
+		produce(txb, 1400, addr2)
+		txb.Transaction.Fee = 100
+		sign(txb, privKey1, constraints.SigHashSingle, 1)
+		err := submit(txb)
+		easyfl.RequireErrorWith(t, err, "addressED25519 unlock failed")
+
+		txb.Transaction.Signatures = nil
+		sign(txb, privKey0, constraints.SigHashSingle, 0)
+		sign(txb, privKey1, constraints.SigHashSingle, 1)
+		err = submit(txb)
+		require.NoError(t, err)
+		require.EqualValues(t, 1400, u.Balance(addr2))
+	})
+	t.Run("anyone can pay", func(t *testing.T) {
+		initTest()
+		// contributors sign their inputs and all outputs
+		txb := txbuilder.NewTransactionBuilder(u.LedgerIdentity())
+		consume(txb, addr0, 0)
+		produce(txb, 3000, addr2)
+		sign(txb, privKey0, constraints.SigHashAnyoneCanPay, 0)
+		consume(txb, addr1, 1)
+		sign(txb, privKey1, constraints.SigHashAnyoneCanPay, 1)
+		err := submit(txb)
+		require.NoError(t, err)
+		require.EqualValues(t, 3000, u.Balance(addr2))
+	})
+	t.Run("anyone can pay tampered", func(t *testing.T) {
+		initTest()
+		txb := txbuilder.NewTransactionBuilder(u.LedgerIdentity())
+		consume(txb, addr0, 0)
+		produce(txb, 3000, addr2)
+		sign(txb, privKey0, constraints.SigHashAnyoneCanPay, 0)
+		// the second contributor takes back its contribution
+		consume(txb, addr1, 1)
+		txb.Transaction.Outputs[0] = txbuilder.OutputBasic(1500, ts, addr2)
+		produce(txb, 1500, addr1)
+		sign(txb, privKey1, constraints.SigHashAnyoneCanPay, 1)
+		err := submit(txb)
+		easyfl.RequireErrorWith(t, err, "addressED25519 unlock failed")
+	})
+	t.Run("sign all", func(t *testing.T) {
+		initTest()
+		txb := txbuilder.NewTransactionBuilder(u.LedgerIdentity())
+		consume(txb, addr0, 0)
+		consume(txb, addr1, 1)
+		produce(txb, 3000, addr2)
+		// the timestamp and the input commitment are signed as a part of the essence
+		txb.Transaction.Timestamp = ts
+		txb.Transaction.InputCommitment = txb.InputCommitment()
+		sign(txb, privKey0, constraints.SigHashAll, 0)
+		sign(txb, privKey1, constraints.SigHashAll, 1)
+		require.EqualValues(t, 96, len(txb.Transaction.Signatures[0]))
+		err := submit(txb)
+		require.NoError(t, err)
+		require.EqualValues(t, 3000, u.Balance(addr2))
+	})
+	t.Run("no reference to partial signature", func(t *testing.T) {
+		initTest()
+		err := u.TokensFromFaucet(addr2, 1500)
+		require.NoError(t, err)
+		par, err := u.MakeTransferData(privKey1, nil, 0)
+		require.NoError(t, err)
+		err = u.DoTransfer(par.WithAmount(1500).WithTargetLock(addr0))
+		require.NoError(t, err)
+		outsData, err := u.IndexerAccess().GetUTXOsLockedInAccount(addr0, u.StateReader())
+		require.NoError(t, err)
+		outs, err := txbuilder.ParseAndSortOutputData(outsData, nil)
+		require.NoError(t, err)
+		require.EqualValues(t, 2, len(outs))
+		adjustTimestamp(addr0)
+
+		// the second input of addr0 is unlocked by reference to the input with the partial signature
+		txb := txbuilder.NewTransactionBuilder(u.LedgerIdentity())
+		for _, o := range outs {
+			_, err = txb.ConsumeOutput(o.Output, o.ID)
+			require.NoError(t, err)
+		}
+		txb.PutSignatureUnlock(0, constraints.ConstraintIndexLock)
+		err = txb.PutUnlockReference(1, constraints.ConstraintIndexLock, 0)
+		require.NoError(t, err)
+		produce(txb, 3000, addr2)
+		sign(txb, privKey0, constraints.SigHashAnyoneCanPay, 0)
+		err = submit(txb)
+		easyfl.RequireErrorWith(t, err, "addressED25519 unlock failed")
+
+		txb.Transaction.Signatures = nil
+		txb.Transaction.InputCommitment = txb.InputCommitment()
+		sign(txb, privKey0, constraints.SigHashAll, 0)
+		err = submit(txb)
+		require.NoError(t, err)
+		require.EqualValues(t, 4500, u.Balance(addr2))
+	})
+	t.Run("sender signs all", func(t *testing.T) {
+		initTest()
+		txb := txbuilder.NewTransactionBuilder(u.LedgerIdentity())
+		consume(txb, addr0, 0)
+		out := txbuilder.OutputBasic(1500, ts, addr2)
+		_, err := out.PushConstraint(constraints.NewSenderAddressED25519(addr0).Bytes())
+		require.NoError(t, err)
+		_, err = txb.ProduceOutput(out)
+		require.NoError(t, err)
+		sign(txb, privKey0, constraints.SigHashSingle, 0)
+		err = submit(txb)
+		easyfl.RequireErrorWith(t, err, "senderAddressED25519")
+	})
+}
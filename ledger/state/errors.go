@@ -0,0 +1,103 @@
+package state
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/lunfardo314/easyfl"
+	"github.com/lunfardo314/easyutxo/ledger/constraints"
+)
+
+// ValidationError is returned by the validation of the transaction when a constraint of the output fails.
+// It identifies the failed constraint without parsing of the error message, for example with errors.As:
+//
+//	var verr *state.ValidationError
+//	if errors.As(err, &verr) && verr.FailureCode == "chain_wrong_successor" { ... }
+type ValidationError struct {
+	// Consumed is true if the output is consumed, false if it is produced
+	Consumed bool
+	// OutputIndex is the index of the output in the branch
+	OutputIndex byte
+	// BlockIndex is the index of the constraint in the output
+	BlockIndex byte
+	// ConstraintName is the name of the constraint, as registered in the library
+	ConstraintName string
+	// Prefix is the call prefix of the constraint bytecode. Nil for array constraints
+	Prefix []byte
+	// FailureCode is the message of the failing call, such as '!!!chain_wrong_successor', with underscores.
+	// Numeric codes of 'fail(N)' are reported as 'error #N'.
+	// Empty if the constraint returned false or failed for other reasons.
+	// The code is parsed from the panic message of EasyFL, so it depends on its format. Underscores are restored
+	// from spaces, so the message with spaces can't be distinguished from the one with underscores.
+	// When the constraint fails inside another one, for example in the script of the scriptHashLock,
+	// the code of the innermost failure is reported
+	FailureCode string
+	// Err is the underlying error or panic of the constraint. Nil if the constraint returned false
+	Err error
+
+	constraintData []byte
+}
+
+const scriptFailPrefix = "SCRIPT FAIL: "
+
+func newValidationError(consumedBranch bool, path []byte, constraintData []byte, err error) *ValidationError {
+	ret := &ValidationError{
+		Consumed:       consumedBranch,
+		OutputIndex:    path[len(path)-2],
+		BlockIndex:     path[len(path)-1],
+		Err:            err,
+		constraintData: constraintData,
+	}
+	if len(constraintData) > 0 {
+		ret.ConstraintName = constraintName(constraintData)
+		if constraintData[0] != 0 {
+			ret.Prefix, _ = easyfl.ParseBytecodePrefix(constraintData)
+		}
+	}
+	if err != nil {
+		ret.FailureCode = failureCode(err.Error())
+	}
+	return ret
+}
+
+// failureCode extracts the message of the 'fail' call from the panic message.
+// The '!!!' literal replaces underscores with spaces in the message, here they are restored
+func failureCode(msg string) string {
+	idx := strings.LastIndex(msg, scriptFailPrefix)
+	if idx < 0 {
+		return ""
+	}
+	code := msg[idx+len(scriptFailPrefix):]
+	if !strings.HasPrefix(code, "'") {
+		// numeric failure code, such as 'error #100'
+		return code
+	}
+	end := strings.Index(code[1:], "'")
+	if end < 0 {
+		return ""
+	}
+	return strings.ReplaceAll(code[1:end+1], " ", "_")
+}
+
+// Path returns the path of the constraint in the validation context
+func (e *ValidationError) Path() []byte {
+	if e.Consumed {
+		return Path(constraints.ConsumedBranch, constraints.ConsumedOutputsBranch, e.OutputIndex, e.BlockIndex)
+	}
+	return Path(constraints.TransactionBranch, constraints.TxOutputs, e.OutputIndex, e.BlockIndex)
+}
+
+func (e *ValidationError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("constraint '%s' failed with error '%v'. Path: %s", e.ConstraintName, e.Err, PathToString(e.Path()))
+	}
+	decomp, err := easyfl.DecompileBytecode(e.constraintData)
+	if err != nil {
+		decomp = fmt.Sprintf("(error while decompiling constraint '%s': '%v')", e.ConstraintName, err)
+	}
+	return fmt.Sprintf("constraint '%s' failed. Path: %s", decomp, PathToString(e.Path()))
+}
+
+func (e *ValidationError) Unwrap() error {
+	return e.Err
+}
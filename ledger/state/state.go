@@ -24,8 +24,15 @@ type (
 	}
 )
 
+// storageDepositParamsKey is the key of the storage deposit parameters in the ledger state.
+// It cannot collide with output IDs, which are 33 bytes long
+var storageDepositParamsKey = []byte{0xff}
+
+// ledgerIdentityKey is the key of the ledger identity in the ledger state
+var ledgerIdentityKey = []byte{0xfe}
+
 // InitLedgerState initializes origin ledger state in the empty store
-func InitLedgerState(store common.KVWriter, identity []byte, initialSupply uint64, genesisAddress constraints.AddressED25519, ts uint32) common.VCommitment {
+func InitLedgerState(store common.KVWriter, identity []byte, initialSupply uint64, genesisAddress constraints.AddressED25519, ts uint32, storageDepositParams *constraints.StorageDepositParams) common.VCommitment {
 	storeTmp := common.NewInMemoryKVStore()
 	emptyRoot := immutable.MustInitRoot(storeTmp, ledger.CommitmentModel, identity)
 
@@ -33,6 +40,8 @@ func InitLedgerState(store common.KVWriter, identity []byte, initialSupply uint6
 	easyfl.AssertNoError(err)
 
 	trie.Update(ledger.GenesisOutputID[:], genesisOutput(initialSupply, genesisAddress, ts))
+	trie.Update(storageDepositParamsKey, storageDepositParams.Bytes())
+	trie.Update(ledgerIdentityKey, identity)
 	trie = trie.CommitChained()
 
 	common.CopyAll(store, storeTmp)
@@ -87,6 +96,18 @@ func (r *Readable) GetUTXO(oid *ledger.OutputID) ([]byte, bool) {
 	return ret, true
 }
 
+// StorageDepositParams returns storage deposit parameters of the ledger, set in the genesis
+func (r *Readable) StorageDepositParams() *constraints.StorageDepositParams {
+	ret, err := constraints.StorageDepositParamsFromBytes(r.trie.Get(storageDepositParamsKey))
+	common.AssertNoError(err)
+	return ret
+}
+
+// LedgerIdentity returns identity of the ledger, set in the genesis. It is signed as part of the transaction essence
+func (r *Readable) LedgerIdentity() []byte {
+	return r.trie.Get(ledgerIdentityKey)
+}
+
 func (r *Readable) HasTransaction(txid *ledger.TransactionID) bool {
 	ret := false
 	r.trie.Iterator(txid.Bytes()).IterateKeys(func(_ []byte) bool {
@@ -101,43 +122,46 @@ func (u *Updatable) Root() common.VCommitment {
 	return u.root
 }
 
-// Update updates/mutates the ledger state by transaction
-func (u *Updatable) Update(txBytes []byte, traceOption ...int) ([]*indexer.Command, error) {
+// Update updates/mutates the ledger state by transaction. Returns indexer update commands and
+// the total amount of tokens burned by the transaction as a fee
+func (u *Updatable) Update(txBytes []byte, traceOption ...int) ([]*indexer.Command, uint64, error) {
 	ctx, err := TransactionContextFromTransferableBytes(txBytes, u.Readable(), traceOption...)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 	trie, err := immutable.NewTrieUpdatable(ledger.CommitmentModel, u.store, u.root)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
-	indexerUpdate, err := updateTrieMulti(trie, []*TransactionContext{ctx})
+	indexerUpdate, burned, err := updateTrieMulti(trie, []*TransactionContext{ctx})
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 	batch := u.store.BatchedWriter()
 	u.root = trie.Commit(batch)
-	return indexerUpdate, batch.Commit()
+	return indexerUpdate, burned, batch.Commit()
 }
 
 // UpdateMulti updates/mutates the ledger state by transaction
-func updateTrieMulti(trie *immutable.TrieUpdatable, txCtx []*TransactionContext) ([]*indexer.Command, error) {
+func updateTrieMulti(trie *immutable.TrieUpdatable, txCtx []*TransactionContext) ([]*indexer.Command, uint64, error) {
 	indexerUpdate := make([]*indexer.Command, 0)
+	burned := uint64(0)
 	for _, ctx := range txCtx {
-		iu, err := updateTrie(trie, ctx)
+		iu, fee, err := updateTrie(trie, ctx)
 		if err != nil {
-			return nil, err
+			return nil, 0, err
 		}
 		indexerUpdate = append(indexerUpdate, iu...)
+		burned += fee
 	}
-	return indexerUpdate, nil
+	return indexerUpdate, burned, nil
 }
 
 // updateTrie updates trie from transaction without committing
-func updateTrie(trie *immutable.TrieUpdatable, ctx *TransactionContext) ([]*indexer.Command, error) {
-	indexerUpdate, err := ctx.Validate()
+func updateTrie(trie *immutable.TrieUpdatable, ctx *TransactionContext) ([]*indexer.Command, uint64, error) {
+	indexerUpdate, fee, err := ctx.Validate()
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 
 	// delete consumed outputs from the ledger and from accounts
@@ -154,5 +178,5 @@ func updateTrie(trie *immutable.TrieUpdatable, ctx *TransactionContext) ([]*inde
 		return true
 	}, Path(constraints.TransactionBranch, constraints.TxOutputs))
 
-	return indexerUpdate, nil
+	return indexerUpdate, fee, nil
 }
@@ -0,0 +1,137 @@
+package state
+
+import (
+	"strings"
+
+	"github.com/lunfardo314/easyfl"
+	"github.com/lunfardo314/easyutxo/ledger"
+)
+
+// Trace is the evaluation trace of the transaction, collected with the TraceOptionData.
+// It contains each evaluated constraint with calls of EasyFL functions. It is serializable to JSON
+type Trace struct {
+	TransactionID string             `json:"transaction_id"`
+	Constraints   []*ConstraintTrace `json:"constraints"`
+	// Error is the error of the validation, if any
+	Error string `json:"error,omitempty"`
+}
+
+// ConstraintTrace is the trace of one constraint
+type ConstraintTrace struct {
+	// Path of the constraint in the validation context, as returned by PathToString
+	Path string `json:"path"`
+	Name string `json:"name"`
+	// OK is true if the constraint did not panic and returned non-empty result
+	OK     bool   `json:"ok"`
+	Result string `json:"result,omitempty"`
+	Error  string `json:"error,omitempty"`
+	// Calls are calls of EasyFL functions in the order of their completion
+	Calls []*CallTrace `json:"calls"`
+}
+
+// CallTrace is a call of the EasyFL function with its arguments and the result, parsed from the trace line of EasyFL.
+// Evaluated data literals have only the Result. Other trace messages, such as panics, are kept in the Message.
+// The trace line is kept in the Raw, because the format of trace lines is not fixed by EasyFL
+type CallTrace struct {
+	Function string `json:"function,omitempty"`
+	// Args are formatted arguments of the call, such as '1x61' or 'from: 1x03'.
+	// Nil if EasyFL traces only the number of arguments, such as '2 params'
+	Args    []string `json:"args,omitempty"`
+	Result  string   `json:"result,omitempty"`
+	Message string   `json:"message,omitempty"`
+	Raw     string   `json:"raw"`
+}
+
+func newTrace(txid ledger.TransactionID) *Trace {
+	return &Trace{
+		TransactionID: txid.String(),
+		Constraints:   make([]*ConstraintTrace, 0),
+	}
+}
+
+// traceCollector is the global data of the EasyFL evaluation, which collects the trace of the constraint
+type traceCollector struct {
+	glb    interface{}
+	constr *ConstraintTrace
+}
+
+func (t *Trace) newCollector(glb interface{}, name string, path []byte) *traceCollector {
+	constr := &ConstraintTrace{
+		Path:  PathToString(path),
+		Name:  name,
+		Calls: make([]*CallTrace, 0),
+	}
+	t.Constraints = append(t.Constraints, constr)
+	return &traceCollector{
+		glb:    glb,
+		constr: constr,
+	}
+}
+
+func (c *traceCollector) Data() interface{} {
+	return c.glb
+}
+
+func (c *traceCollector) Trace() bool {
+	return true
+}
+
+func (c *traceCollector) PutTrace(s string) {
+	c.constr.Calls = append(c.constr.Calls, parseCallTrace(s))
+}
+
+// finish records the outcome of the constraint
+func (c *traceCollector) finish(ret []byte, err error) {
+	if err != nil {
+		c.constr.Error = err.Error()
+		return
+	}
+	c.constr.OK = len(ret) > 0
+	c.constr.Result = easyfl.Fmt(ret)
+}
+
+// parseCallTrace parses trace message of the EasyFL function, for example 'equal:: 1x61, 1x61 -> 1xff'
+// or 'lessThan: 1xff, 1x00 -> false'. Data literals are traced as '-> 1x61'
+func parseCallTrace(s string) *CallTrace {
+	if strings.HasPrefix(s, "-> ") {
+		return &CallTrace{Result: strings.TrimPrefix(s, "-> "), Raw: s}
+	}
+	idxName := strings.Index(s, ": ")
+	idxRes := strings.LastIndex(s, "-> ")
+	if idxName <= 0 || idxRes < idxName || strings.HasPrefix(s, "panic: ") {
+		return &CallTrace{Message: s, Raw: s}
+	}
+	name := strings.Trim(strings.TrimSuffix(s[:idxName], ":"), "'")
+	if strings.ContainsAny(name, " ,") {
+		return &CallTrace{Message: s, Raw: s}
+	}
+	return &CallTrace{
+		Function: name,
+		Args:     parseCallArgs(strings.TrimSpace(s[idxName+len(": ") : idxRes])),
+		Result:   s[idxRes+len("-> "):],
+		Raw:      s,
+	}
+}
+
+// parseCallArgs splits formatted arguments of the call, for example '1x61, 1x62'.
+// Returns nil if the arguments are not traced, for example '2 params' or 'param 0'
+func parseCallArgs(s string) []string {
+	if s == "" || strings.HasSuffix(s, " params") || strings.HasPrefix(s, "param ") {
+		return nil
+	}
+	return strings.Split(s, ", ")
+}
+
+// TraceTransaction validates the transaction against the ledger state without updating it.
+// Returns the evaluation trace and the validation error, if any. The trace is nil if the validation context
+// can't be built, for example when inputs are not in the state
+func TraceTransaction(txBytes []byte, ledgerState ledger.StateReadAccess) (*Trace, error) {
+	ctx, err := TransactionContextFromTransferableBytes(txBytes, ledgerState, TraceOptionData)
+	if err != nil {
+		return nil, err
+	}
+	if _, _, err = ctx.Validate(); err != nil {
+		ctx.trace.Error = err.Error()
+	}
+	return ctx.trace, err
+}
@@ -8,7 +8,6 @@ import (
 	"github.com/lunfardo314/easyutxo/ledger"
 	"github.com/lunfardo314/easyutxo/ledger/constraints"
 	"github.com/lunfardo314/unitrie/common"
-	"golang.org/x/crypto/blake2b"
 )
 
 // Transaction provides access to the tree of transferable transaction
@@ -17,17 +16,17 @@ type Transaction struct {
 	txid ledger.TransactionID
 }
 
-func MustTransactionFromTransferableBytes(txBytes []byte) *Transaction {
+func MustTransactionFromTransferableBytes(txBytes, ledgerIdentity []byte) *Transaction {
 	ret := &Transaction{
 		tree: lazyslice.TreeFromBytes(txBytes),
-		txid: blake2b.Sum256(txBytes),
+		txid: ledger.TransactionIDFromTransactionBytes(txBytes, ledgerIdentity),
 	}
 
 	// validate what is possible without context
 
 	easyfl.Assert(ret.tree.NumElements(Path(constraints.TxOutputs)) > 0, "MustTransactionFromTransferableBytes: number of outputs can't be 0")
 	easyfl.Assert(ret.tree.NumElements(Path(constraints.TxInputIDs)) > 0, "MustTransactionFromTransferableBytes: number of inputs can't be 0")
-	easyfl.Assert(ret.tree.NumElements(Path(constraints.TxEndorsements)) > constraints.MaxNumberOfEndorsements,
+	easyfl.Assert(ret.tree.NumElements(Path(constraints.TxEndorsements)) <= constraints.MaxNumberOfEndorsements,
 		"MustTransactionFromTransferableBytes: number of endorsements exceeds limit of %d", constraints.MaxNumberOfEndorsements)
 
 	// check if inputs are unique
@@ -9,13 +9,14 @@ import (
 	"github.com/lunfardo314/easyutxo/ledger"
 	"github.com/lunfardo314/easyutxo/ledger/constraints"
 	"github.com/lunfardo314/unitrie/common"
-	"golang.org/x/crypto/blake2b"
 )
 
 // TransactionContext is a data structure, which contains transferable transaction, consumed outputs and constraint library
 type TransactionContext struct {
-	tree        *lazyslice.Tree
-	traceOption int
+	tree                 *lazyslice.Tree
+	traceOption          int
+	trace                *Trace
+	storageDepositParams *constraints.StorageDepositParams
 	// cached values
 	dataContext *constraints.DataContext
 	txid        ledger.TransactionID
@@ -27,6 +28,8 @@ const (
 	TraceOptionNone = iota
 	TraceOptionAll
 	TraceOptionFailedConstraints
+	// TraceOptionData collects the trace as data instead of printing it. See Trace
+	TraceOptionData
 )
 
 // TransactionContextFromTransferableBytes constructs lazytree from transaction bytes and consumed outputs
@@ -66,15 +69,20 @@ func TransactionContextFromTransferableBytes(txBytes []byte, ledgerState ledger.
 		lazyslice.MakeArray(consumedOutputsArray), // ConsumedContextBranch = 1
 	)
 	tree := ctx.AsTree()
+	storageDepositParams := ledgerState.StorageDepositParams()
 	ret := &TransactionContext{
-		tree:        tree,
-		dataContext: constraints.NewDataContext(tree),
-		traceOption: TraceOptionNone,
-		txid:        blake2b.Sum256(txBytes),
+		tree:                 tree,
+		traceOption:          TraceOptionNone,
+		storageDepositParams: storageDepositParams,
+		dataContext:          constraints.NewDataContext(tree, storageDepositParams, ledgerState.LedgerIdentity()),
+		txid:                 ledger.TransactionIDFromTransactionBytes(txBytes, ledgerState.LedgerIdentity()),
 	}
 	if len(traceOption) > 0 {
 		ret.traceOption = traceOption[0]
 	}
+	if ret.traceOption == TraceOptionData {
+		ret.trace = newTrace(ret.txid)
+	}
 	return ret, nil
 }
 
@@ -89,6 +97,11 @@ func (v *TransactionContext) rootContext() easyfl.GlobalData {
 	return v.evalContext(nil)
 }
 
+// Trace returns the evaluation trace, collected by the validation with the TraceOptionData. Otherwise nil
+func (v *TransactionContext) Trace() *Trace {
+	return v.trace
+}
+
 func (v *TransactionContext) TransactionBytes() []byte {
 	ret, err := easyfl.EvalFromSource(v.rootContext(), "txBytes")
 	if err != nil {
@@ -105,8 +118,12 @@ func (v *TransactionContext) InputCommitment() []byte {
 	return v.tree.BytesAtPath(Path(constraints.TransactionBranch, constraints.TxInputCommitment))
 }
 
-func (v *TransactionContext) Signature() []byte {
-	return v.tree.BytesAtPath(Path(constraints.TransactionBranch, constraints.TxSignature))
+func (v *TransactionContext) NumSignatures() int {
+	return v.tree.NumElements(Path(constraints.TransactionBranch, constraints.TxSignatures))
+}
+
+func (v *TransactionContext) Signature(idx byte) []byte {
+	return v.tree.BytesAtPath(Path(constraints.TransactionBranch, constraints.TxSignatures, idx))
 }
 
 func (v *TransactionContext) ForEachInputID(fun func(idx byte, oid *ledger.OutputID) bool) {
@@ -155,3 +172,12 @@ func (v *TransactionContext) TimestampData() ([]byte, uint32) {
 	}
 	return ret, retTs
 }
+
+// Fee returns amount of tokens burned by the transaction as a fee
+func (v *TransactionContext) Fee() (uint64, error) {
+	ret := v.tree.BytesAtPath(Path(constraints.TransactionBranch, constraints.TxFee))
+	if len(ret) != 8 {
+		return 0, fmt.Errorf("wrong fee data: 8 bytes expected")
+	}
+	return binary.BigEndian.Uint64(ret), nil
+}
@@ -24,6 +24,9 @@ func (v *TransactionContext) evalContext(path []byte) easyfl.GlobalData {
 		return easyfl.NewGlobalDataTracePrint(v.dataContext)
 	case TraceOptionFailedConstraints:
 		return easyfl.NewGlobalDataLog(v.dataContext)
+	case TraceOptionData:
+		// the trace is collected by constraints. See evalConstraint
+		return easyfl.NewGlobalDataNoTrace(v.dataContext)
 	default:
 		panic("wrong trace option")
 	}
@@ -45,48 +48,76 @@ func (v *TransactionContext) checkConstraint(constraintData []byte, constraintPa
 	return ret, name, nil
 }
 
-func (v *TransactionContext) Validate() ([]*indexer.Command, error) {
-	var inSum, outSum uint64
+// Validate validates the transaction. Returns indexer update commands and the fee, burned by the transaction.
+// Sum of amounts of consumed outputs must be equal to the sum of amounts of produced outputs plus the fee
+func (v *TransactionContext) Validate() ([]*indexer.Command, uint64, error) {
+	var inBalance, outBalance *branchBalance
 	var err error
 	ret := make([]*indexer.Command, 0)
 
 	err = easyfl.CatchPanicOrError(func() error {
 		var err1 error
-		inSum, err1 = v.validateConsumedOutputs(&ret)
+		inBalance, err1 = v.validateConsumedOutputs(&ret)
 		return err1
 	})
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 	err = easyfl.CatchPanicOrError(func() error {
 		var err1 error
-		outSum, err1 = v.validateProducedOutputs(&ret)
+		outBalance, err1 = v.validateProducedOutputs(&ret)
 		return err1
 	})
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 	err = easyfl.CatchPanicOrError(func() error {
 		return v.validateInputCommitment()
 	})
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
-	if inSum != outSum {
-		return nil, fmt.Errorf("unbalanced amount between inputs and outputs: inputs %d, outputs %d", inSum, outSum)
+	fee, err := v.Fee()
+	if err != nil {
+		return nil, 0, err
 	}
-	return ret, nil
+	if fee > math.MaxUint64-outBalance.amount {
+		return nil, 0, fmt.Errorf("uint64 arithmetic overflow: fee %d, outputs %d", fee, outBalance.amount)
+	}
+	if inBalance.amount != outBalance.amount+fee {
+		return nil, 0, fmt.Errorf("unbalanced amount between inputs and outputs: inputs %d, outputs %d, fee %d",
+			inBalance.amount, outBalance.amount, fee)
+	}
+	if err = validateNativeTokenBalance(inBalance, outBalance); err != nil {
+		return nil, 0, err
+	}
+	_, ts := v.TimestampData()
+	if err = validateFoundryCreation(inBalance, outBalance, ts); err != nil {
+		return nil, 0, err
+	}
+	return ret, fee, nil
 }
 
-func (v *TransactionContext) validateProducedOutputs(indexRecords *[]*indexer.Command) (uint64, error) {
+// branchBalance is the sum of amounts and of native tokens in all outputs of the branch,
+// and circulating supplies and serials of foundries in the branch
+type branchBalance struct {
+	amount  uint64
+	tokens  map[[32]byte]uint64
+	supply  map[[32]byte]uint64
+	serials map[[32]byte]uint32
+	// token IDs of consumed foundries, which are not destroyed, i.e. have the successor in the transaction
+	transitions map[[32]byte]struct{}
+}
+
+func (v *TransactionContext) validateProducedOutputs(indexRecords *[]*indexer.Command) (*branchBalance, error) {
 	return v.validateOutputs(false, indexRecords)
 }
 
-func (v *TransactionContext) validateConsumedOutputs(indexRecords *[]*indexer.Command) (uint64, error) {
+func (v *TransactionContext) validateConsumedOutputs(indexRecords *[]*indexer.Command) (*branchBalance, error) {
 	return v.validateOutputs(true, indexRecords)
 }
 
-func (v *TransactionContext) validateOutputs(consumedBranch bool, indexRecords *[]*indexer.Command) (uint64, error) {
+func (v *TransactionContext) validateOutputs(consumedBranch bool, indexRecords *[]*indexer.Command) (*branchBalance, error) {
 	var branch lazyslice.TreePath
 	if consumedBranch {
 		branch = Path(constraints.ConsumedBranch, constraints.ConsumedOutputsBranch)
@@ -94,7 +125,12 @@ func (v *TransactionContext) validateOutputs(consumedBranch bool, indexRecords *
 		branch = Path(constraints.TransactionBranch, constraints.TxOutputs)
 	}
 	var err error
-	var sum uint64
+	ret := &branchBalance{
+		tokens:      make(map[[32]byte]uint64),
+		supply:      make(map[[32]byte]uint64),
+		serials:     make(map[[32]byte]uint32),
+		transitions: make(map[[32]byte]struct{}),
+	}
 	var extraDepositWeight uint32
 	path := common.Concat(branch, 0)
 
@@ -104,7 +140,7 @@ func (v *TransactionContext) validateOutputs(consumedBranch bool, indexRecords *
 		if extraDepositWeight, err = v.runOutput(consumedBranch, arr, path); err != nil {
 			return false
 		}
-		minDeposit := constraints.MinimumStorageDeposit(uint32(len(data)), extraDepositWeight)
+		minDeposit := v.storageDepositParams.MinimumStorageDeposit(uint32(len(data)), extraDepositWeight)
 		var am constraints.Amount
 		am, err = constraints.AmountFromBytes(arr.At(int(constraints.ConstraintIndexAmount)))
 		if err != nil {
@@ -116,12 +152,24 @@ func (v *TransactionContext) validateOutputs(consumedBranch bool, indexRecords *
 				PathToString(path), minDeposit, amount)
 			return false
 		}
-		if amount > math.MaxUint64-sum {
+		if amount > math.MaxUint64-ret.amount {
 			err = fmt.Errorf("validateOutputs @ path %s: uint64 arithmetic overflow", PathToString(path))
 			return false
 		}
-		sum += amount
+		ret.amount += amount
 
+		if err = sumNativeTokens(ret.tokens, arr, path); err != nil {
+			return false
+		}
+		var unlockParams func(blockIdx byte) []byte
+		if consumedBranch {
+			unlockParams = func(blockIdx byte) []byte {
+				return v.UnlockParams(i, blockIdx)
+			}
+		}
+		if err = collectFoundrySupply(ret, arr, path, unlockParams); err != nil {
+			return false
+		}
 		// create update commands for indexer
 		if err = v.createIndexEntries(i, arr, consumedBranch, indexRecords); err != nil {
 			return false
@@ -129,9 +177,124 @@ func (v *TransactionContext) validateOutputs(consumedBranch bool, indexRecords *
 		return true
 	}, branch)
 	if err != nil {
-		return 0, err
+		return nil, err
+	}
+	return ret, nil
+}
+
+// forEachNonMandatoryBlock calls the function for each block of the output except the amount, timestamp and lock
+func forEachNonMandatoryBlock(outputArray *lazyslice.Array, fun func(i int, data []byte) bool) {
+	outputArray.ForEach(func(i int, data []byte) bool {
+		if i == int(constraints.ConstraintIndexAmount) || i == int(constraints.ConstraintIndexTimestamp) || i == int(constraints.ConstraintIndexLock) {
+			return true
+		}
+		return fun(i, data)
+	})
+}
+
+// sumNativeTokens adds amounts of native tokens in the output to the sums
+func sumNativeTokens(sums map[[32]byte]uint64, outputArray *lazyslice.Array, path lazyslice.TreePath) error {
+	var err error
+	forEachNonMandatoryBlock(outputArray, func(_ int, data []byte) bool {
+		nativeToken, err1 := constraints.NativeTokenFromBytes(data)
+		if err1 != nil {
+			return true
+		}
+		if nativeToken.Amount > math.MaxUint64-sums[nativeToken.TokenID] {
+			err = fmt.Errorf("sumNativeTokens @ path %s: uint64 arithmetic overflow", PathToString(path))
+			return false
+		}
+		sums[nativeToken.TokenID] += nativeToken.Amount
+		return true
+	})
+	return err
+}
+
+// collectFoundrySupply collects circulating supplies and serials of foundries in the output by token ID.
+// unlockParams is nil for produced outputs. For consumed outputs it returns unlock parameters of the block
+func collectFoundrySupply(balance *branchBalance, outputArray *lazyslice.Array, path lazyslice.TreePath, unlockParams func(blockIdx byte) []byte) error {
+	var err error
+	forEachNonMandatoryBlock(outputArray, func(i int, data []byte) bool {
+		foundry, err1 := constraints.FoundryFromBytes(data)
+		if err1 != nil {
+			return true
+		}
+		if int(foundry.ChainBlockIndex) >= outputArray.NumElements() {
+			err = fmt.Errorf("wrong chain block index of the foundry @ path %s", PathToString(path))
+			return false
+		}
+		chainConstraint, err1 := constraints.ChainConstraintFromBytes(outputArray.At(int(foundry.ChainBlockIndex)))
+		if err1 != nil {
+			err = fmt.Errorf("foundry is not bound to the chain @ path %s: %v", PathToString(path), err1)
+			return false
+		}
+		tokenID := constraints.FoundryTokenID(chainConstraint.ID, foundry.Serial)
+		if _, already := balance.supply[tokenID]; already {
+			err = fmt.Errorf("repeating foundry of the token %s @ path %s", easyfl.Fmt(tokenID[:]), PathToString(path))
+			return false
+		}
+		balance.supply[tokenID] = foundry.CirculatingSupply
+		balance.serials[tokenID] = foundry.Serial
+		if unlockParams != nil && !bytes.Equal(unlockParams(byte(i)), []byte{0xff}) {
+			// the foundry constraint checks the successor, unless the foundry is destroyed
+			balance.transitions[tokenID] = struct{}{}
+		}
+		return true
+	})
+	return err
+}
+
+// validateFoundryCreation checks that each produced foundry is either the successor of the consumed foundry or
+// is created with the serial equal to the transaction timestamp. Timestamps of the chain transitions are strictly
+// increasing, so the token ID of the destroyed foundry can't be re-used on the chain
+func validateFoundryCreation(in, out *branchBalance, ts uint32) error {
+	for tokenID, serial := range out.serials {
+		if _, isTransition := in.transitions[tokenID]; isTransition {
+			continue
+		}
+		if serial != ts {
+			return fmt.Errorf("foundry of the token %s is created with serial %d, expected transaction timestamp %d",
+				easyfl.Fmt(tokenID[:]), serial, ts)
+		}
 	}
-	return sum, nil
+	return nil
+}
+
+// validateNativeTokenBalance checks if each native token is balanced between inputs and outputs.
+// The difference is only allowed by the change of the circulating supply in the foundry transition of the token
+func validateNativeTokenBalance(in, out *branchBalance) error {
+	tokenIDs := make(map[[32]byte]struct{})
+	for tokenID := range in.tokens {
+		tokenIDs[tokenID] = struct{}{}
+	}
+	for tokenID := range out.tokens {
+		tokenIDs[tokenID] = struct{}{}
+	}
+	for tokenID := range in.supply {
+		tokenIDs[tokenID] = struct{}{}
+	}
+	for tokenID := range out.supply {
+		tokenIDs[tokenID] = struct{}{}
+	}
+	for tokenID := range tokenIDs {
+		inAmount, outAmount := in.tokens[tokenID], out.tokens[tokenID]
+		inSupply, outSupply := in.supply[tokenID], out.supply[tokenID]
+		var balanced bool
+		if outSupply >= inSupply {
+			// minting
+			minted := outSupply - inSupply
+			balanced = inAmount <= math.MaxUint64-minted && inAmount+minted == outAmount
+		} else {
+			// burning
+			burned := inSupply - outSupply
+			balanced = outAmount <= math.MaxUint64-burned && outAmount+burned == inAmount
+		}
+		if !balanced {
+			return fmt.Errorf("unbalanced native token %s between inputs and outputs: inputs %d, outputs %d, circulating supply before %d, after %d",
+				easyfl.Fmt(tokenID[:]), inAmount, outAmount, inSupply, outSupply)
+		}
+	}
+	return nil
 }
 
 func (v *TransactionContext) createIndexEntries(idx byte, outputArray *lazyslice.Array, consumedBranch bool, indexRecords *[]*indexer.Command) error {
@@ -143,12 +306,11 @@ func (v *TransactionContext) createIndexEntries(idx byte, outputArray *lazyslice
 }
 
 func (v *TransactionContext) indexLock(idx byte, outputArray *lazyslice.Array, consumedBranch bool, indexRecords *[]*indexer.Command) error {
-	var lock constraints.Lock
-	lock, err := constraints.LockFromBytes(outputArray.At(int(constraints.ConstraintIndexLock)))
+	tags, err := constraints.IndexableTagsFromBytes(outputArray.At(int(constraints.ConstraintIndexLock)))
 	if err != nil {
 		return err
 	}
-	for _, addr := range lock.IndexableTags() {
+	for _, addr := range tags {
 		indexEntry := &indexer.Command{
 			ID:        common.Concat(addr.AccountID()),
 			Delete:    consumedBranch,
@@ -164,44 +326,44 @@ func (v *TransactionContext) indexLock(idx byte, outputArray *lazyslice.Array, c
 	return nil
 }
 
+// indexChainID maintains the chain ID and NFT ID indices. The consumed chain output is always removed from the index,
+// the successor (if any) is added back in the produced branch. The ID of the chain origin is derived from the output ID
 func (v *TransactionContext) indexChainID(idx byte, outputArray *lazyslice.Array, consumedBranch bool, indexRecords *[]*indexer.Command) {
-	outputArray.ForEach(func(i int, data []byte) bool {
-		if i == int(constraints.ConstraintIndexAmount) || i == int(constraints.ConstraintIndexTimestamp) || i == int(constraints.ConstraintIndexLock) {
-			return true
-		}
-		chainConstraint, err := constraints.ChainConstraintFromBytes(data)
-		if err != nil {
+	var outputID ledger.OutputID
+	if consumedBranch {
+		outputID = v.InputID(idx)
+	} else {
+		outputID = ledger.NewOutputID(v.TransactionID(), idx)
+	}
+	forEachNonMandatoryBlock(outputArray, func(i int, data []byte) bool {
+		var chainID [32]byte
+		if chainConstraint, err := constraints.ChainConstraintFromBytes(data); err == nil {
+			chainID = chainConstraint.ID
+		} else if _, err = constraints.ChainInitFromBytes(data); err == nil {
+			chainID = constraints.OriginChainID(outputID[:])
+		} else {
 			return true
 		}
 		cmd := &indexer.Command{
 			Partition: indexer.PartitionChainID,
+			ID:        chainID[:],
+			Delete:    consumedBranch,
 		}
-		if consumedBranch {
-			if !bytes.Equal(v.UnlockParams(idx, byte(i)), []byte{0xff, 0xff, 0xff}) {
-				// found consumed chain. It is not being destroyed, do nothing
-				// leave loop as it only can be one chain constraint on output
-				return false
-			}
-			// chain is being destroyed. Delete index record
-			cmd.Delete = true
-			if chainConstraint.IsOrigin() {
-				// origin is destroyed, rare but possible
-				inpID := v.InputID(idx)
-				h := blake2b.Sum256(inpID[:])
-				cmd.ID = h[:]
-			} else {
-				cmd.ID = chainConstraint.ID[:]
-			}
-		} else {
-			cmd.OutputID = ledger.NewOutputID(v.TransactionID(), idx)
-			if chainConstraint.IsOrigin() {
-				h := blake2b.Sum256(cmd.OutputID.Bytes())
-				cmd.ID = h[:]
-			} else {
-				cmd.ID = chainConstraint.ID[:]
-			}
+		if !consumedBranch {
+			cmd.OutputID = outputID
 		}
 		*indexRecords = append(*indexRecords, cmd)
+		// the nft, if any, follows the chain constraint and is indexed by the chain ID
+		if i+1 < outputArray.NumElements() {
+			if _, err := constraints.NFTFromBytes(outputArray.At(i + 1)); err == nil {
+				*indexRecords = append(*indexRecords, &indexer.Command{
+					Partition: indexer.PartitionNFTID,
+					ID:        chainID[:],
+					Delete:    consumedBranch,
+					OutputID:  cmd.OutputID,
+				})
+			}
+		}
 		return false // only 1 chain constraint is possible
 	})
 }
@@ -218,32 +380,24 @@ func (v *TransactionContext) runOutput(consumedBranch bool, outputArray *lazysli
 	checkDuplicates := make(map[string]struct{})
 
 	outputArray.ForEach(func(idx int, data []byte) bool {
+		blockPath[len(blockPath)-1] = byte(idx)
 		// checking for duplicated constraints in produced outputs
 		if !consumedBranch {
 			sd := string(data)
 			if _, already := checkDuplicates[sd]; already {
-				err = fmt.Errorf("duplicated constraints not allowed. Path %s", PathToString(blockPath))
+				err = newValidationError(consumedBranch, blockPath, data, fmt.Errorf("duplicated constraints not allowed"))
 				return false
 			}
 			checkDuplicates[sd] = struct{}{}
 		}
-		blockPath[len(blockPath)-1] = byte(idx)
 		var res []byte
-		var name string
-
-		res, name, err = v.checkConstraint(data, blockPath)
+		res, _, err = v.checkConstraint(data, blockPath)
 		if err != nil {
-			err = fmt.Errorf("constraint '%s' failed with error '%v'. Path: %s",
-				name, err, PathToString(blockPath))
+			err = newValidationError(consumedBranch, blockPath, data, err)
 			return false
 		}
 		if len(res) == 0 {
-			var decomp string
-			decomp, err = easyfl.DecompileBytecode(data)
-			if err != nil {
-				decomp = fmt.Sprintf("(error while decompiling constraint 'name': '%v')", err)
-			}
-			err = fmt.Errorf("constraint '%s' failed. Path: %s", decomp, PathToString(blockPath))
+			err = newValidationError(consumedBranch, blockPath, data, nil)
 			return false
 		}
 		if len(res) == 4 {
@@ -286,7 +440,7 @@ func PathToString(path []byte) string {
 					ret += ".inID"
 				case constraints.TxOutputs:
 					ret += ".out"
-				case constraints.TxSignature:
+				case constraints.TxSignatures:
 					ret += ".sig"
 				case constraints.TxTimestamp:
 					ret += ".ts"
@@ -352,7 +506,9 @@ func (v *TransactionContext) evalConstraint(constr []byte, path lazyslice.TreePa
 	var err error
 	name := constraintName(constr)
 	ctx := v.evalContext(path)
-	if ctx.Trace() {
+	if v.trace != nil {
+		ctx = v.trace.newCollector(v.dataContext, name, path)
+	} else if ctx.Trace() {
 		ctx.PutTrace(fmt.Sprintf("--- check constraint '%s' at path %s", name, PathToString(path)))
 	}
 
@@ -376,14 +532,16 @@ func (v *TransactionContext) evalConstraint(constr []byte, path lazyslice.TreePa
 		}
 	}
 
-	if ctx.Trace() {
+	if collector, ok := ctx.(*traceCollector); ok {
+		collector.finish(ret, err)
+	} else if ctx.Trace() {
 		if err != nil {
 			ctx.PutTrace(fmt.Sprintf("--- constraint '%s' at path %s: FAILED with '%v'", name, PathToString(path), err))
-			ctx.(*easyfl.GlobalDataLog).PrintLog()
+			printLog(ctx)
 		} else {
 			if len(ret) == 0 {
 				ctx.PutTrace(fmt.Sprintf("--- constraint '%s' at path %s: FAILED", name, PathToString(path)))
-				ctx.(*easyfl.GlobalDataLog).PrintLog()
+				printLog(ctx)
 			} else {
 				ctx.PutTrace(fmt.Sprintf("--- constraint '%s' at path %s: OK", name, PathToString(path)))
 			}
@@ -392,3 +550,10 @@ func (v *TransactionContext) evalConstraint(constr []byte, path lazyslice.TreePa
 
 	return ret, name, err
 }
+
+// printLog prints the log of failed constraint. With TraceOptionAll the trace is already printed
+func printLog(ctx easyfl.GlobalData) {
+	if log, ok := ctx.(*easyfl.GlobalDataLog); ok {
+		log.PrintLog()
+	}
+}
@@ -5,6 +5,7 @@ import (
 
 	"github.com/lunfardo314/easyfl"
 	"github.com/lunfardo314/easyutxo/lazyslice"
+	"github.com/lunfardo314/easyutxo/ledger/constraints"
 	"github.com/lunfardo314/easyutxo/ledger/state"
 	"golang.org/x/crypto/blake2b"
 )
@@ -15,11 +16,22 @@ func ValidationContextToString(v *state.TransactionContext) string {
 	tsBin, ts := v.TimestampData()
 	ret += fmt.Sprintf("Timestamp: %s (%d)\n", easyfl.Fmt(tsBin), ts)
 	ret += fmt.Sprintf("Input commitment: %s\n", easyfl.Fmt(v.InputCommitment()))
-	sign := v.Signature()
-	ret += fmt.Sprintf("Signature: %s\n", easyfl.Fmt(sign))
-	if len(sign) == 96 {
-		sender := blake2b.Sum256(sign[64:])
-		ret += fmt.Sprintf("     ED25519 sender address: %s\n", easyfl.Fmt(sender[:]))
+	if fee, err := v.Fee(); err == nil {
+		ret += fmt.Sprintf("Fee: %d\n", fee)
+	}
+	ret += "Signatures: \n"
+	for i := byte(0); int(i) < v.NumSignatures(); i++ {
+		sign := v.Signature(i)
+		ret += fmt.Sprintf("  #%d: %s\n", i, easyfl.Fmt(sign))
+		if len(sign) == 96 || len(sign) == 97 {
+			sender := blake2b.Sum256(sign[64:96])
+			ret += fmt.Sprintf("     ED25519 address: %s\n", easyfl.Fmt(sender[:]))
+			if len(sign) == 97 {
+				ret += fmt.Sprintf("     signature hash mode: %d\n", sign[96])
+			}
+		} else if _, _, pubKey, err := constraints.DecodeSignatureP256(sign); err == nil {
+			ret += fmt.Sprintf("     P-256 address: %s\n", easyfl.Fmt(constraints.AddressP256FromPublicKey(pubKey)))
+		}
 	}
 
 	ret += "Inputs (consumed outputs): \n"
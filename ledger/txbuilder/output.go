@@ -171,6 +171,33 @@ func (o *Output) TimeLock() (uint32, bool) {
 	return 0, false
 }
 
+func (o *Output) RelativeTimeLock() (uint32, bool) {
+	var ret constraints.RelativeTimelock
+	var err error
+	found := false
+	o.ForEachConstraint(func(idx byte, constr []byte) bool {
+		if idx == constraints.ConstraintIndexAmount || idx == constraints.ConstraintIndexTimestamp || idx == constraints.ConstraintIndexLock {
+			return true
+		}
+		ret, err = constraints.RelativeTimelockFromBytes(constr)
+		if err == nil {
+			found = true
+			return false
+		}
+		return true
+	})
+	if found {
+		return uint32(ret), true
+	}
+	return 0, false
+}
+
+// RelativeTimeLockExpired returns true if the output has no relative time lock or it is expired at the timestamp
+func (o *Output) RelativeTimeLockExpired(ts uint32) bool {
+	rtl, found := o.RelativeTimeLock()
+	return !found || constraints.RelativeTimelock(rtl).IsExpired(o.Timestamp(), ts)
+}
+
 func (o *Output) SenderAddressED25519() (constraints.AddressED25519, bool) {
 	var ret *constraints.SenderAddressED25519
 	var err error
@@ -214,6 +241,206 @@ func (o *Output) ChainConstraint() (*constraints.ChainConstraint, byte) {
 	return nil, 0xff
 }
 
+// ChainInit returns block index of the chain origin constraint. 0xff if not found
+func (o *Output) ChainInit() byte {
+	found := byte(0xff)
+	o.ForEachConstraint(func(idx byte, constr []byte) bool {
+		if idx == constraints.ConstraintIndexAmount || idx == constraints.ConstraintIndexTimestamp || idx == constraints.ConstraintIndexLock {
+			return true
+		}
+		if _, err := constraints.ChainInitFromBytes(constr); err == nil {
+			found = idx
+			return false
+		}
+		return true
+	})
+	return found
+}
+
+// ChainBlockIndex returns block index of the chain constraint or of the chain origin. 0xff if not found
+func (o *Output) ChainBlockIndex() byte {
+	if _, idx := o.ChainConstraint(); idx != 0xff {
+		return idx
+	}
+	return o.ChainInit()
+}
+
+// ChainMetadata returns chain metadata with the transition mode and its block index. 0xff if not found
+func (o *Output) ChainMetadata(mode byte) (*constraints.ChainMetadata, byte) {
+	var ret *constraints.ChainMetadata
+	found := byte(0xff)
+	o.ForEachConstraint(func(idx byte, constr []byte) bool {
+		if idx == constraints.ConstraintIndexAmount || idx == constraints.ConstraintIndexTimestamp || idx == constraints.ConstraintIndexLock {
+			return true
+		}
+		md, err := constraints.ChainMetadataFromBytes(constr)
+		if err == nil && md.Mode == mode {
+			ret = md
+			found = idx
+			return false
+		}
+		return true
+	})
+	return ret, found
+}
+
+// Foundry finds and parses foundry constraint. Returns its constraintIndex or 0xff if not found
+func (o *Output) Foundry() (*constraints.Foundry, byte) {
+	var ret *constraints.Foundry
+	var err error
+	found := byte(0xff)
+	o.ForEachConstraint(func(idx byte, constr []byte) bool {
+		if idx == constraints.ConstraintIndexAmount || idx == constraints.ConstraintIndexTimestamp || idx == constraints.ConstraintIndexLock {
+			return true
+		}
+		ret, err = constraints.FoundryFromBytes(constr)
+		if err == nil {
+			found = idx
+			return false
+		}
+		return true
+	})
+	if found != 0xff {
+		return ret, found
+	}
+	return nil, 0xff
+}
+
+// NFT finds and parses nft constraint. Returns its constraintIndex or 0xff if not found
+func (o *Output) NFT() (*constraints.NFT, byte) {
+	var ret *constraints.NFT
+	var err error
+	found := byte(0xff)
+	o.ForEachConstraint(func(idx byte, constr []byte) bool {
+		if idx == constraints.ConstraintIndexAmount || idx == constraints.ConstraintIndexTimestamp || idx == constraints.ConstraintIndexLock {
+			return true
+		}
+		ret, err = constraints.NFTFromBytes(constr)
+		if err == nil {
+			found = idx
+			return false
+		}
+		return true
+	})
+	if found != 0xff {
+		return ret, found
+	}
+	return nil, 0xff
+}
+
+// Covenant finds and parses covenant constraint. Returns its constraintIndex or 0xff if not found
+func (o *Output) Covenant() (*constraints.Covenant, byte) {
+	var ret *constraints.Covenant
+	var err error
+	found := byte(0xff)
+	o.ForEachConstraint(func(idx byte, constr []byte) bool {
+		if idx == constraints.ConstraintIndexAmount || idx == constraints.ConstraintIndexTimestamp || idx == constraints.ConstraintIndexLock {
+			return true
+		}
+		ret, err = constraints.CovenantFromBytes(constr)
+		if err == nil {
+			found = idx
+			return false
+		}
+		return true
+	})
+	if found != 0xff {
+		return ret, found
+	}
+	return nil, 0xff
+}
+
+// OracleCondition finds and parses oracle condition constraint. Returns its constraintIndex or 0xff if not found
+func (o *Output) OracleCondition() (*constraints.OracleCondition, byte) {
+	var ret *constraints.OracleCondition
+	var err error
+	found := byte(0xff)
+	o.ForEachConstraint(func(idx byte, constr []byte) bool {
+		if idx == constraints.ConstraintIndexAmount || idx == constraints.ConstraintIndexTimestamp || idx == constraints.ConstraintIndexLock {
+			return true
+		}
+		ret, err = constraints.OracleConditionFromBytes(constr)
+		if err == nil {
+			found = idx
+			return false
+		}
+		return true
+	})
+	if found != 0xff {
+		return ret, found
+	}
+	return nil, 0xff
+}
+
+// StorageDepositReturn finds and parses storage deposit return constraint. Returns its constraintIndex or 0xff if not found
+func (o *Output) StorageDepositReturn() (*constraints.StorageDepositReturn, byte) {
+	var ret *constraints.StorageDepositReturn
+	var err error
+	found := byte(0xff)
+	o.ForEachConstraint(func(idx byte, constr []byte) bool {
+		if idx == constraints.ConstraintIndexAmount || idx == constraints.ConstraintIndexTimestamp || idx == constraints.ConstraintIndexLock {
+			return true
+		}
+		ret, err = constraints.StorageDepositReturnFromBytes(constr)
+		if err == nil {
+			found = idx
+			return false
+		}
+		return true
+	})
+	if found != 0xff {
+		return ret, found
+	}
+	return nil, 0xff
+}
+
+// NativeTokens returns amounts of all native tokens in the output
+func (o *Output) NativeTokens() map[[32]byte]uint64 {
+	ret := make(map[[32]byte]uint64)
+	o.ForEachConstraint(func(idx byte, constr []byte) bool {
+		if idx == constraints.ConstraintIndexAmount || idx == constraints.ConstraintIndexTimestamp || idx == constraints.ConstraintIndexLock {
+			return true
+		}
+		nt, err := constraints.NativeTokenFromBytes(constr)
+		if err == nil {
+			ret[nt.TokenID] += nt.Amount
+		}
+		return true
+	})
+	return ret
+}
+
+// NativeTokenAmount returns amount of the native token in the output or 0 if the output does not contain the token
+func (o *Output) NativeTokenAmount(tokenID [32]byte) uint64 {
+	return o.NativeTokens()[tokenID]
+}
+
+// WithNativeToken adds amount of the native token to the output. If the output already contains the token,
+// the amount in the existing constraint is increased, otherwise new constraint is pushed
+func (o *Output) WithNativeToken(tokenID [32]byte, amount uint64) *Output {
+	found := byte(0xff)
+	var existing *constraints.NativeToken
+	o.ForEachConstraint(func(idx byte, constr []byte) bool {
+		if idx == constraints.ConstraintIndexAmount || idx == constraints.ConstraintIndexTimestamp || idx == constraints.ConstraintIndexLock {
+			return true
+		}
+		nt, err := constraints.NativeTokenFromBytes(constr)
+		if err == nil && nt.TokenID == tokenID {
+			existing = nt
+			found = idx
+			return false
+		}
+		return true
+	})
+	if found != 0xff {
+		o.PutConstraint(constraints.NewNativeToken(tokenID, existing.Amount+amount).Bytes(), found)
+		return o
+	}
+	_, err := o.PushConstraint(constraints.NewNativeToken(tokenID, amount).Bytes())
+	easyfl.AssertNoError(err)
+	return o
+}
+
 func ParseAndSortOutputData(outs []*ledger.OutputDataWithID, filter func(o *Output) bool, desc ...bool) ([]*OutputWithID, error) {
 	ret := make([]*OutputWithID, 0, len(outs))
 	for _, od := range outs {
@@ -248,11 +475,11 @@ func (o *Output) ToString(prefix ...string) string {
 		pref = prefix[0]
 	}
 	o.arr.ForEach(func(i int, data []byte) bool {
-		c, err := constraints.FromBytes(data)
+		str, err := constraints.StringFromBytes(data)
 		if err != nil {
 			ret += fmt.Sprintf("%s%d: %v (%d bytes)\n", pref, i, err, len(data))
 		} else {
-			ret += fmt.Sprintf("%s%d: %s (%d bytes)\n", pref, i, c.String(), len(data))
+			ret += fmt.Sprintf("%s%d: %s (%d bytes)\n", pref, i, str, len(data))
 		}
 		return true
 	})
@@ -1,11 +1,15 @@
 package txbuilder
 
 import (
+	"bytes"
 	"crypto"
+	"crypto/ecdsa"
 	"crypto/ed25519"
 	"encoding/binary"
 	"fmt"
+	"math"
 	"math/rand"
+	"sort"
 	"time"
 
 	"github.com/lunfardo314/easyfl"
@@ -26,11 +30,15 @@ type (
 		InputIDs        []*ledger.OutputID
 		Outputs         []*Output
 		UnlockBlocks    []*UnlockParams
-		Signature       []byte
+		Signatures      [][]byte
 		Timestamp       uint32
 		InputCommitment [32]byte
 		Endorsements    []*ledger.TransactionID
 		LocalLibraries  [][]byte
+		// Fee is amount of tokens burned by the transaction
+		Fee uint64
+		// LedgerIdentity is the identity of the ledger the transaction is signed for. It is not transferred
+		LedgerIdentity []byte
 	}
 
 	UnlockParams struct {
@@ -38,17 +46,20 @@ type (
 	}
 )
 
-func NewTransactionBuilder() *TransactionBuilder {
+// NewTransactionBuilder creates the builder of the transaction for the ledger with the identity
+func NewTransactionBuilder(ledgerIdentity []byte) *TransactionBuilder {
 	return &TransactionBuilder{
 		ConsumedOutputs: make([]*Output, 0),
 		Transaction: &transaction{
 			InputIDs:        make([]*ledger.OutputID, 0),
 			Outputs:         make([]*Output, 0),
 			UnlockBlocks:    make([]*UnlockParams, 0),
+			Signatures:      make([][]byte, 0),
 			Timestamp:       0,
 			InputCommitment: [32]byte{},
 			Endorsements:    make([]*ledger.TransactionID, 0),
 			LocalLibraries:  make([][]byte, 0),
+			LedgerIdentity:  ledgerIdentity,
 		},
 	}
 }
@@ -80,7 +91,12 @@ func (txb *TransactionBuilder) PutUnlockParams(outputIndex, constraintIndex byte
 
 // PutSignatureUnlock marker 0xff references signature of the transaction.
 // It can be distinguished from any reference because it cannot be stringly less than any other reference
-func (txb *TransactionBuilder) PutSignatureUnlock(outputIndex, constraintIndex byte) {
+// Marker 0xff references the first signature, 0xff||idx references signature with index idx
+func (txb *TransactionBuilder) PutSignatureUnlock(outputIndex, constraintIndex byte, sigIndex ...byte) {
+	if len(sigIndex) > 0 && sigIndex[0] != 0 {
+		txb.PutUnlockParams(outputIndex, constraintIndex, []byte{0xff, sigIndex[0]})
+		return
+	}
 	txb.PutUnlockParams(outputIndex, constraintIndex, []byte{0xff})
 }
 
@@ -137,13 +153,16 @@ func (tx *transaction) ToArray() *lazyslice.Array {
 	elems[constraints.TxUnlockParams] = unlockParams
 	elems[constraints.TxInputIDs] = inputIDs
 	elems[constraints.TxOutputs] = outputs
-	elems[constraints.TxSignature] = tx.Signature
+	elems[constraints.TxSignatures] = lazyslice.MakeArrayFromData(tx.Signatures...)
 	var ts [4]byte
 	binary.BigEndian.PutUint32(ts[:], tx.Timestamp)
 	elems[constraints.TxTimestamp] = ts[:]
 	elems[constraints.TxInputCommitment] = tx.InputCommitment[:]
 	elems[constraints.TxEndorsements] = endorsements
 	elems[constraints.TxLocalLibraries] = lazyslice.MakeArrayFromData(tx.LocalLibraries...)
+	var fee [8]byte
+	binary.BigEndian.PutUint64(fee[:], tx.Fee)
+	elems[constraints.TxFee] = fee[:]
 	return lazyslice.MakeArray(elems...)
 }
 
@@ -152,36 +171,103 @@ func (tx *transaction) Bytes() []byte {
 }
 
 func (tx *transaction) EssenceBytes() []byte {
-	arr := tx.ToArray()
-	return common.Concat(
-		arr.At(int(constraints.TxInputIDs)),
-		arr.At(int(constraints.TxOutputs)),
-		arr.At(int(constraints.TxInputCommitment)),
-	)
+	return ledger.TransactionEssenceBytes(tx.Bytes(), tx.LedgerIdentity)
 }
 
 var rnd = rand.New(rand.NewSource(time.Now().UnixNano()))
 
-func (txb *TransactionBuilder) SignED25519(privKey ed25519.PrivateKey) {
+// SignED25519 signs the essence and appends signature to the list of signatures of the transaction.
+// Returns index of the signature
+func (txb *TransactionBuilder) SignED25519(privKey ed25519.PrivateKey) byte {
+	easyfl.Assert(len(txb.Transaction.Signatures) < 256, "too many signatures")
 	sig, err := privKey.Sign(rnd, txb.Transaction.EssenceBytes(), crypto.Hash(0))
 	easyfl.AssertNoError(err)
 	pubKey := privKey.Public().(ed25519.PublicKey)
-	txb.Transaction.Signature = common.Concat(sig, []byte(pubKey))
+	txb.Transaction.Signatures = append(txb.Transaction.Signatures, common.Concat(sig, []byte(pubKey)))
+	return byte(len(txb.Transaction.Signatures) - 1)
+}
+
+// SigHashMessage returns the message signed with the signature hash mode to unlock the input with the index
+func (tx *transaction) SigHashMessage(mode, inputIndex byte) ([]byte, error) {
+	if mode == constraints.SigHashAll {
+		return tx.EssenceBytes(), nil
+	}
+	if int(inputIndex) >= len(tx.InputIDs) {
+		return nil, fmt.Errorf("wrong input index %d", inputIndex)
+	}
+	arr := tx.ToArray()
+	var outputs []byte
+	switch mode {
+	case constraints.SigHashAnyoneCanPay:
+		outputs = arr.At(int(constraints.TxOutputs))
+	case constraints.SigHashSingle:
+		if int(inputIndex) >= len(tx.Outputs) {
+			return nil, fmt.Errorf("no output with the same index as input %d", inputIndex)
+		}
+		outputs = tx.Outputs[inputIndex].Bytes()
+	default:
+		return nil, fmt.Errorf("unknown signature hash mode %d", mode)
+	}
+	return common.Concat(mode, tx.InputIDs[inputIndex][:], outputs,
+		arr.At(int(constraints.TxTimestamp)), arr.At(int(constraints.TxFee)), tx.LedgerIdentity), nil
+}
+
+// SignED25519WithMode signs the part of the transaction, defined by the signature hash mode, for unlocking
+// the input with the index. The mode byte is appended to the signature. Returns index of the signature
+func (txb *TransactionBuilder) SignED25519WithMode(privKey ed25519.PrivateKey, mode, inputIndex byte) (byte, error) {
+	if mode == constraints.SigHashAll {
+		return txb.SignED25519(privKey), nil
+	}
+	easyfl.Assert(len(txb.Transaction.Signatures) < 256, "too many signatures")
+	msg, err := txb.Transaction.SigHashMessage(mode, inputIndex)
+	if err != nil {
+		return 0, err
+	}
+	sig := ed25519.Sign(privKey, msg)
+	pubKey := privKey.Public().(ed25519.PublicKey)
+	txb.Transaction.Signatures = append(txb.Transaction.Signatures, common.Concat(sig, []byte(pubKey), mode))
+	return byte(len(txb.Transaction.Signatures) - 1), nil
+}
+
+// SignP256 signs the essence with the ECDSA P-256 key and appends the signature to the list of signatures of the transaction.
+// Returns index of the signature
+func (txb *TransactionBuilder) SignP256(privKey *ecdsa.PrivateKey) byte {
+	easyfl.Assert(len(txb.Transaction.Signatures) < 256, "too many signatures")
+	sig, err := constraints.SignP256(privKey, txb.Transaction.EssenceBytes())
+	easyfl.AssertNoError(err)
+	txb.Transaction.Signatures = append(txb.Transaction.Signatures, sig)
+	return byte(len(txb.Transaction.Signatures) - 1)
 }
 
 type TransferData struct {
 	SenderPrivateKey ed25519.PrivateKey
 	SenderPublicKey  ed25519.PublicKey
-	SourceAccount    constraints.Accountable
-	Outputs          []*OutputWithID
-	ChainOutput      *OutputWithChainID
-	Timestamp        uint32 // takes time.Now() if 0
-	Lock             constraints.Lock
-	Amount           uint64
-	AdjustToMinimum  bool
-	AddSender        bool
-	AddConstraints   [][]byte
-	UnlockData       []*UnlockData
+	// SenderPrivateKeyP256 is the key of the sender with P-256 address. If not nil, it is used instead of the ED25519 key
+	SenderPrivateKeyP256 *ecdsa.PrivateKey
+	SourceAccount        constraints.Accountable
+	Outputs              []*OutputWithID
+	ChainOutput          *OutputWithChainID
+	Timestamp            uint32 // takes time.Now() if 0
+	Lock                 constraints.Lock
+	Amount               uint64
+	AdjustToMinimum      bool
+	AddSender            bool
+	AddConstraints       [][]byte
+	UnlockData           []*UnlockData
+	NativeTokens         map[[32]byte]uint64
+	MultisigPolicy       *constraints.MultisigPolicyED25519
+	Cosigners            []ed25519.PrivateKey
+	Preimage             []byte
+	// Script is revealed to unlock consumed outputs with the pay-to-script-hash lock
+	Script []byte
+	// Fee is burned by the transaction. It is paid by the source account in addition to the amount
+	Fee uint64
+	// NFTMetadata is the metadata of the NFT minted on the main output. No NFT is minted if nil
+	NFTMetadata []byte
+	// StorageDepositParams are used to adjust amount to the minimum. Default parameters are used if nil
+	StorageDepositParams *constraints.StorageDepositParams
+	// LedgerIdentity is the identity of the ledger the transaction is signed for
+	LedgerIdentity []byte
 }
 
 type UnlockData struct {
@@ -202,6 +288,19 @@ func NewTransferData(senderKey ed25519.PrivateKey, sourceAccount constraints.Acc
 		Timestamp:        ts,
 		AddConstraints:   make([][]byte, 0),
 		UnlockData:       make([]*UnlockData, 0),
+		NativeTokens:     make(map[[32]byte]uint64),
+	}
+}
+
+// NewTransferDataP256 makes the transfer from the P-256 address of the sender key
+func NewTransferDataP256(senderKey *ecdsa.PrivateKey, ts uint32) *TransferData {
+	return &TransferData{
+		SenderPrivateKeyP256: senderKey,
+		SourceAccount:        constraints.AddressP256FromPublicKey(&senderKey.PublicKey),
+		Timestamp:            ts,
+		AddConstraints:       make([][]byte, 0),
+		UnlockData:           make([]*UnlockData, 0),
+		NativeTokens:         make(map[[32]byte]uint64),
 	}
 }
 
@@ -216,6 +315,62 @@ func (t *TransferData) WithAmount(amount uint64, adjustToMinimum ...bool) *Trans
 	return t
 }
 
+// WithNativeToken adds amount of the native token to be transferred to the target lock
+func (t *TransferData) WithNativeToken(tokenID [32]byte, amount uint64) *TransferData {
+	t.NativeTokens[tokenID] += amount
+	return t
+}
+
+// WithMultisig makes the transfer from the multisig account of the policy. The transaction is signed
+// by the sender and by all cosigners
+func (t *TransferData) WithMultisig(policy *constraints.MultisigPolicyED25519, cosigners ...ed25519.PrivateKey) *TransferData {
+	t.MultisigPolicy = policy
+	t.Cosigners = cosigners
+	return t
+}
+
+// WithStorageDepositParams sets storage deposit parameters of the ledger
+func (t *TransferData) WithStorageDepositParams(params *constraints.StorageDepositParams) *TransferData {
+	t.StorageDepositParams = params
+	return t
+}
+
+// WithLedgerIdentity sets the identity of the ledger the transaction is signed for
+func (t *TransferData) WithLedgerIdentity(ledgerIdentity []byte) *TransferData {
+	t.LedgerIdentity = ledgerIdentity
+	return t
+}
+
+// WithFee sets the fee to be burned by the transaction
+func (t *TransferData) WithFee(fee uint64) *TransferData {
+	t.Fee = fee
+	return t
+}
+
+// WithPreimage provides preimage to claim consumed HTLC outputs before the deadline
+func (t *TransferData) WithPreimage(preimage []byte) *TransferData {
+	t.Preimage = preimage
+	return t
+}
+
+// WithScript provides the script to unlock consumed outputs locked with the hash of it
+func (t *TransferData) WithScript(script []byte) *TransferData {
+	t.Script = script
+	return t
+}
+
+// WithStorageDepositReturn requires the consumer of the transferred output to return amount to the return account
+func (t *TransferData) WithStorageDepositReturn(returnAccount constraints.Accountable, amount uint64) *TransferData {
+	return t.WithConstraint(constraints.NewStorageDepositReturn(returnAccount, amount))
+}
+
+// WithMintNFT mints the NFT with the metadata on the main output. The issuer of the NFT is the sender:
+// the chain of the transfer, if any, otherwise the address of the sender
+func (t *TransferData) WithMintNFT(metadata []byte) *TransferData {
+	t.NFTMetadata = metadata
+	return t
+}
+
 func (t *TransferData) WithConstraintBinary(constr []byte, idx ...byte) *TransferData {
 	if len(idx) == 0 {
 		t.AddConstraints = append(t.AddConstraints, constr)
@@ -244,6 +399,15 @@ func (t *TransferData) WithChainOutput(out *OutputWithChainID) *TransferData {
 	return t
 }
 
+// senderAddressED25519 returns the address of the sender for the sender constraint. Sender constraints
+// are signed with the ED25519 key, so the sender with the P-256 key can't add them
+func (t *TransferData) senderAddressED25519() (constraints.AddressED25519, error) {
+	if t.SenderPrivateKeyP256 != nil || len(t.SenderPublicKey) == 0 {
+		return nil, fmt.Errorf("sender constraint requires the ED25519 key of the sender")
+	}
+	return constraints.AddressED25519FromPublicKey(t.SenderPublicKey), nil
+}
+
 func (t *TransferData) WithSender() *TransferData {
 	t.AddSender = true
 	return t
@@ -270,11 +434,18 @@ func (t *TransferData) AdjustedAmount() uint64 {
 	outTentative.WithAmount(t.Amount)
 	outTentative.WithTimestamp(ts)
 	outTentative.WithLock(t.Lock)
+	for _, tokenID := range sortedTokenIDs(t.NativeTokens) {
+		outTentative.WithNativeToken(tokenID, t.NativeTokens[tokenID])
+	}
 	for _, c := range t.AddConstraints {
 		_, err := outTentative.PushConstraint(c)
 		easyfl.AssertNoError(err)
 	}
-	minimumDeposit := constraints.MinimumStorageDeposit(uint32(len(outTentative.Bytes())), 0)
+	params := t.StorageDepositParams
+	if params == nil {
+		params = constraints.DefaultStorageDepositParams()
+	}
+	minimumDeposit := params.MinimumStorageDeposit(uint32(len(outTentative.Bytes())), 0)
 	if t.Amount < minimumDeposit {
 		return minimumDeposit
 	}
@@ -292,30 +463,135 @@ func MakeTransferTransaction(par *TransferData) ([]byte, error) {
 	return ret, err
 }
 
-func outputsToConsumeSimple(par *TransferData, amount uint64) (uint64, uint32, []*OutputWithID, error) {
+// outputsToConsumeSimple selects outputs to consume for the amount and for the native tokens of the transfer.
+// Returns available amount, available native tokens, timestamp and consumed outputs.
+// Storage deposits to be returned when consuming outputs are not counted as available
+func outputsToConsumeSimple(par *TransferData, amount uint64) (uint64, map[[32]byte]uint64, uint32, []*OutputWithID, error) {
 	ts := uint32(time.Now().Unix())
 	if par.Timestamp > 0 {
 		ts = par.Timestamp
 	}
 	consumedOuts := par.Outputs[:0]
 	availableTokens := uint64(0)
+	availableNativeTokens := make(map[[32]byte]uint64)
 	numConsumedOutputs := 0
 
 	for _, o := range par.Outputs {
 		if numConsumedOutputs >= 256 {
-			return 0, 0, nil, fmt.Errorf("exceeded max number of consumed outputs 256")
+			return 0, nil, 0, nil, fmt.Errorf("exceeded max number of consumed outputs 256")
 		}
 		consumedOuts = append(consumedOuts, o)
 		if o.Output.Timestamp() >= ts {
 			ts = o.Output.Timestamp() + 1
 		}
 		numConsumedOutputs++
-		availableTokens += o.Output.Amount()
-		if availableTokens >= amount {
+		availableTokens += o.Output.Amount() - storageDepositToReturn(o.Output, ts)
+		for tokenID, a := range o.Output.NativeTokens() {
+			availableNativeTokens[tokenID] += a
+		}
+		if availableTokens >= amount && enoughNativeTokens(availableNativeTokens, par.NativeTokens) {
 			break
 		}
 	}
-	return availableTokens, ts, consumedOuts, nil
+	// timestamp may have been moved forward, so storage deposit returns are recalculated with the final one
+	availableTokens = 0
+	for _, o := range consumedOuts {
+		availableTokens += o.Output.Amount() - storageDepositToReturn(o.Output, ts)
+	}
+	return availableTokens, availableNativeTokens, ts, consumedOuts, nil
+}
+
+// storageDepositToReturn returns amount of tokens which must be returned when consuming the output at timestamp ts
+func storageDepositToReturn(o *Output, ts uint32) uint64 {
+	sdr, idx := o.StorageDepositReturn()
+	if idx == 0xff || !sdr.ReturnRequired(o.Lock(), ts) {
+		return 0
+	}
+	return sdr.Amount
+}
+
+// produceStorageDepositReturns produces outputs which return storage deposits of the consumed outputs and points
+// unlock parameters of storage deposit return constraints to them. Consumed outputs are expected in the transaction
+// starting from input index firstInputIndex
+func (txb *TransactionBuilder) produceStorageDepositReturns(consumedOuts []*OutputWithID, firstInputIndex byte, ts uint32) error {
+	for i, o := range consumedOuts {
+		sdr, constraintIdx := o.Output.StorageDepositReturn()
+		if constraintIdx == 0xff || !sdr.ReturnRequired(o.Output.Lock(), ts) {
+			continue
+		}
+		returnOutputIdx, err := txb.ProduceOutput(OutputBasic(sdr.Amount, ts, sdr.ReturnAccount.AsLock()))
+		if err != nil {
+			return err
+		}
+		txb.PutUnlockParams(firstInputIndex+byte(i), constraintIdx, []byte{returnOutputIdx})
+	}
+	return nil
+}
+
+// consumeOutputsUnlockedByReference consumes the outputs. The lock of each output is unlocked by reference
+// to the first preceding consumed output with the same lock. Other locks are unlocked with putUnlock
+func (txb *TransactionBuilder) consumeOutputsUnlockedByReference(outs []*OutputWithID, putUnlock func(inputIndex byte)) error {
+	inputIndices := make([]byte, len(outs))
+	for i, o := range outs {
+		idx, err := txb.ConsumeOutput(o.Output, o.ID)
+		if err != nil {
+			return err
+		}
+		inputIndices[i] = idx
+		lockBytes := o.Output.Constraint(constraints.ConstraintIndexLock)
+		ref := -1
+		for j := 0; j < i; j++ {
+			if bytes.Equal(lockBytes, outs[j].Output.Constraint(constraints.ConstraintIndexLock)) {
+				ref = j
+				break
+			}
+		}
+		if ref < 0 {
+			putUnlock(idx)
+			continue
+		}
+		if err = txb.PutUnlockReference(idx, constraints.ConstraintIndexLock, inputIndices[ref]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func enoughNativeTokens(available, needed map[[32]byte]uint64) bool {
+	for tokenID, a := range needed {
+		if available[tokenID] < a {
+			return false
+		}
+	}
+	return true
+}
+
+// checkNativeTokens checks if native tokens of the transfer are available and returns leftover native tokens
+func checkNativeTokens(par *TransferData, available map[[32]byte]uint64) (map[[32]byte]uint64, error) {
+	ret := make(map[[32]byte]uint64)
+	for tokenID, a := range par.NativeTokens {
+		if available[tokenID] < a {
+			return nil, fmt.Errorf("not enough native tokens %s in account %s: needed %d, got %d",
+				easyfl.Fmt(tokenID[:]), par.SourceAccount.String(), a, available[tokenID])
+		}
+	}
+	for tokenID, a := range available {
+		if a > par.NativeTokens[tokenID] {
+			ret[tokenID] = a - par.NativeTokens[tokenID]
+		}
+	}
+	return ret, nil
+}
+
+func sortedTokenIDs(tokens map[[32]byte]uint64) [][32]byte {
+	ret := make([][32]byte, 0, len(tokens))
+	for tokenID := range tokens {
+		ret = append(ret, tokenID)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return bytes.Compare(ret[i][:], ret[j][:]) < 0
+	})
+	return ret
 }
 
 func MakeSimpleTransferTransactionOutputs(par *TransferData) ([]byte, []*ledger.OutputDataWithID, error) {
@@ -323,34 +599,65 @@ func MakeSimpleTransferTransactionOutputs(par *TransferData) ([]byte, []*ledger.
 		return nil, nil, fmt.Errorf("ChainOutput must be nil. Use MakeSimpleTransferTransactionOutputs instead")
 	}
 	amount := par.AdjustedAmount()
-	availableTokens, ts, consumedOuts, err := outputsToConsumeSimple(par, amount)
+	if amount > math.MaxUint64-par.Fee {
+		return nil, nil, fmt.Errorf("uint64 arithmetic overflow: amount %d, fee %d", amount, par.Fee)
+	}
+	// fee is paid in addition to the amount
+	amountWithFee := amount + par.Fee
+	availableTokens, availableNativeTokens, ts, consumedOuts, err := outputsToConsumeSimple(par, amountWithFee)
 	if err != nil {
 		return nil, nil, err
 	}
-	if availableTokens < amount {
-		if availableTokens < amount {
-			return nil, nil, fmt.Errorf("not enough tokens in account %s: needed %d, got %d",
-				par.SourceAccount.String(), par.Amount, availableTokens)
-		}
+	if availableTokens < amountWithFee {
+		return nil, nil, fmt.Errorf("not enough tokens in account %s: needed %d, got %d",
+			par.SourceAccount.String(), amountWithFee, availableTokens)
+	}
+	leftoverNativeTokens, err := checkNativeTokens(par, availableNativeTokens)
+	if err != nil {
+		return nil, nil, err
+	}
+	if availableTokens == amountWithFee && len(leftoverNativeTokens) > 0 {
+		return nil, nil, fmt.Errorf("not enough tokens in account %s for the remainder with native tokens",
+			par.SourceAccount.String())
 	}
 
-	txb := NewTransactionBuilder()
-	for _, o := range consumedOuts {
-		if _, err = txb.ConsumeOutput(o.Output, o.ID); err != nil {
-			return nil, nil, err
+	txb := NewTransactionBuilder(par.LedgerIdentity)
+	err = txb.consumeOutputsUnlockedByReference(consumedOuts, func(inputIndex byte) {
+		if par.MultisigPolicy != nil {
+			txb.PutUnlockParams(inputIndex, constraints.ConstraintIndexLock, par.MultisigPolicy.Bytes())
+		} else {
+			txb.PutSignatureUnlock(inputIndex, constraints.ConstraintIndexLock)
 		}
+	})
+	if err != nil {
+		return nil, nil, err
 	}
 	mainOutput := NewOutput().
 		WithAmount(amount).
 		WithTimestamp(ts).
 		WithLock(par.Lock)
+	for _, tokenID := range sortedTokenIDs(par.NativeTokens) {
+		mainOutput.WithNativeToken(tokenID, par.NativeTokens[tokenID])
+	}
 
 	if par.AddSender {
-		senderAddr := constraints.AddressED25519FromPublicKey(par.SenderPublicKey)
+		senderAddr, err := par.senderAddressED25519()
+		if err != nil {
+			return nil, nil, err
+		}
 		if _, err = mainOutput.PushConstraint(constraints.NewSenderAddressED25519(senderAddr).Bytes()); err != nil {
 			return nil, nil, err
 		}
 	}
+	if par.NFTMetadata != nil {
+		senderAddr, err := par.senderAddressED25519()
+		if err != nil {
+			return nil, nil, err
+		}
+		if err = pushMintNFT(mainOutput, constraints.NewSenderAddressED25519(senderAddr), par.NFTMetadata); err != nil {
+			return nil, nil, err
+		}
+	}
 
 	for _, constr := range par.AddConstraints {
 		if _, err = mainOutput.PushConstraint(constr); err != nil {
@@ -358,11 +665,14 @@ func MakeSimpleTransferTransactionOutputs(par *TransferData) ([]byte, []*ledger.
 		}
 	}
 	var reminderOut *Output
-	if availableTokens > amount {
+	if availableTokens > amountWithFee {
 		reminderOut = NewOutput().
-			WithAmount(availableTokens - amount).
+			WithAmount(availableTokens - amountWithFee).
 			WithTimestamp(ts).
 			WithLock(par.SourceAccount.AsLock())
+		for _, tokenID := range sortedTokenIDs(leftoverNativeTokens) {
+			reminderOut.WithNativeToken(tokenID, leftoverNativeTokens[tokenID])
+		}
 	}
 	if reminderOut != nil {
 		if _, err = txb.ProduceOutput(reminderOut); err != nil {
@@ -372,14 +682,16 @@ func MakeSimpleTransferTransactionOutputs(par *TransferData) ([]byte, []*ledger.
 	if _, err = txb.ProduceOutput(mainOutput); err != nil {
 		return nil, nil, err
 	}
+	if err = txb.produceStorageDepositReturns(consumedOuts, 0, ts); err != nil {
+		return nil, nil, err
+	}
 
-	for i := range consumedOuts {
-		if i == 0 {
-			txb.PutSignatureUnlock(0, constraints.ConstraintIndexLock)
-		} else {
-			// always referencing the 0 output
-			err = txb.PutUnlockReference(byte(i), constraints.ConstraintIndexLock, 0)
-			easyfl.AssertNoError(err)
+	for i, o := range consumedOuts {
+		if _, isHTLC := o.Output.Lock().(*constraints.HTLC); isHTLC && len(par.Preimage) > 0 {
+			txb.PutUnlockParams(byte(i), constraints.ConstraintIndexTimestamp, par.Preimage)
+		}
+		if _, isScriptHash := o.Output.Lock().(constraints.ScriptHashLock); isScriptHash && len(par.Script) > 0 {
+			txb.PutUnlockParams(byte(i), constraints.ConstraintIndexTimestamp, par.Script)
 		}
 	}
 
@@ -387,12 +699,13 @@ func MakeSimpleTransferTransactionOutputs(par *TransferData) ([]byte, []*ledger.
 		txb.PutUnlockParams(un.OutputIndex, un.ConstraintIndex, un.Data)
 	}
 	txb.Transaction.Timestamp = ts
+	txb.Transaction.Fee = par.Fee
 	txb.Transaction.InputCommitment = txb.InputCommitment()
-	txb.SignED25519(par.SenderPrivateKey)
+	par.sign(txb)
 
 	retOut := make([]*ledger.OutputDataWithID, 0)
 	txBytes := txb.Transaction.Bytes()
-	txid := ledger.TransactionID(blake2b.Sum256(txBytes))
+	txid := ledger.TransactionIDFromTransactionBytes(txBytes, txb.Transaction.LedgerIdentity)
 
 	for i, o := range txb.Transaction.Outputs {
 		retOut = append(retOut, &ledger.OutputDataWithID{
@@ -408,20 +721,36 @@ func MakeChainTransferTransactionOutputs(par *TransferData) ([]byte, []*ledger.O
 		return nil, nil, fmt.Errorf("ChainOutput must be provided")
 	}
 	amount := par.AdjustedAmount()
+	if amount > math.MaxUint64-par.Fee {
+		return nil, nil, fmt.Errorf("uint64 arithmetic overflow: amount %d, fee %d", amount, par.Fee)
+	}
+	// fee is paid in addition to the amount
+	amountWithFee := amount + par.Fee
 	// we are trying to consume non-chain outputs for the amount. Only if it is not enough, we are taking tokens from the chain
-	availableTokens, ts, consumedOuts, err := outputsToConsumeSimple(par, amount)
+	availableTokens, availableNativeTokens, ts, consumedOuts, err := outputsToConsumeSimple(par, amountWithFee)
+	if err != nil {
+		return nil, nil, err
+	}
+	// native tokens are only taken from non-chain outputs. Leftovers go to the chain
+	leftoverNativeTokens, err := checkNativeTokens(par, availableNativeTokens)
 	if err != nil {
 		return nil, nil, err
 	}
+	chainAccount := constraints.ChainLock(par.ChainOutput.ChainID[:]).AccountID()
+	for _, o := range consumedOuts {
+		if !o.Output.Lock().UnlockableWith(chainAccount, ts) {
+			return nil, nil, fmt.Errorf("output %s can't be unlocked by the chain at timestamp %d", o.ID.String(), ts)
+		}
+	}
 	// count the chain output in
 	availableTokens += par.ChainOutput.Output.Amount()
 	// some tokens must remain in the chain account
-	if availableTokens <= amount {
+	if availableTokens <= amountWithFee {
 		return nil, nil, fmt.Errorf("not enough tokens in account %s: needed %d, got %d",
-			par.SourceAccount.String(), par.Amount, availableTokens)
+			par.SourceAccount.String(), amountWithFee, availableTokens)
 	}
 
-	txb := NewTransactionBuilder()
+	txb := NewTransactionBuilder(par.LedgerIdentity)
 
 	if _, err = txb.ConsumeOutput(par.ChainOutput.Output, par.ChainOutput.ID); err != nil {
 		return nil, nil, err
@@ -432,11 +761,19 @@ func MakeChainTransferTransactionOutputs(par *TransferData) ([]byte, []*ledger.O
 		}
 	}
 	chainConstr := constraints.NewChainConstraint(par.ChainOutput.ChainID, 0, par.ChainOutput.PredecessorConstraintIndex, 0)
-	easyfl.Assert(availableTokens > amount, "availableTokens > amount")
+	easyfl.Assert(availableTokens > amountWithFee, "availableTokens > amountWithFee")
 	chainSuccessorOutput := par.ChainOutput.Output.Clone().
-		WithAmount(availableTokens - amount).
+		WithAmount(availableTokens - amountWithFee).
 		WithTimestamp(ts)
 	chainSuccessorOutput.PutConstraint(chainConstr.Bytes(), par.ChainOutput.PredecessorConstraintIndex)
+	// leftovers are added to the native token block of the chain output if it already holds the token,
+	// so the successor never contains two blocks of the same token
+	for _, tokenID := range sortedTokenIDs(leftoverNativeTokens) {
+		if leftoverNativeTokens[tokenID] > math.MaxUint64-chainSuccessorOutput.NativeTokenAmount(tokenID) {
+			return nil, nil, fmt.Errorf("uint64 arithmetic overflow: native token %s", easyfl.Fmt(tokenID[:]))
+		}
+		chainSuccessorOutput.WithNativeToken(tokenID, leftoverNativeTokens[tokenID])
+	}
 	if _, err = txb.ProduceOutput(chainSuccessorOutput); err != nil {
 		return nil, nil, err
 	}
@@ -445,13 +782,25 @@ func MakeChainTransferTransactionOutputs(par *TransferData) ([]byte, []*ledger.O
 		WithAmount(amount).
 		WithTimestamp(ts).
 		WithLock(par.Lock)
+	for _, tokenID := range sortedTokenIDs(par.NativeTokens) {
+		mainOutput.WithNativeToken(tokenID, par.NativeTokens[tokenID])
+	}
 
 	if par.AddSender {
-		senderAddr := constraints.AddressED25519FromPublicKey(par.SenderPublicKey)
+		senderAddr, err := par.senderAddressED25519()
+		if err != nil {
+			return nil, nil, err
+		}
 		if _, err = mainOutput.PushConstraint(constraints.NewSenderAddressED25519(senderAddr).Bytes()); err != nil {
 			return nil, nil, err
 		}
 	}
+	if par.NFTMetadata != nil {
+		issuer := constraints.NewSenderChain(par.ChainOutput.ChainID, 0, par.ChainOutput.PredecessorConstraintIndex)
+		if err = pushMintNFT(mainOutput, issuer, par.NFTMetadata); err != nil {
+			return nil, nil, err
+		}
+	}
 	for _, constr := range par.AddConstraints {
 		if _, err = mainOutput.PushConstraint(constr); err != nil {
 			return nil, nil, err
@@ -460,9 +809,15 @@ func MakeChainTransferTransactionOutputs(par *TransferData) ([]byte, []*ledger.O
 	if _, err = txb.ProduceOutput(mainOutput); err != nil {
 		return nil, nil, err
 	}
+	if err = txb.produceStorageDepositReturns(consumedOuts, 1, ts); err != nil {
+		return nil, nil, err
+	}
 	// unlock chain input
 	txb.PutSignatureUnlock(0, constraints.ConstraintIndexLock)
 	txb.PutUnlockParams(0, par.ChainOutput.PredecessorConstraintIndex, []byte{0, par.ChainOutput.PredecessorConstraintIndex, 0})
+	if err = txb.putChainMetadataUnlockParams(par.ChainOutput.Output, 0, chainSuccessorOutput); err != nil {
+		return nil, nil, err
+	}
 
 	// always reference chain input
 	for i := range consumedOuts {
@@ -472,12 +827,13 @@ func MakeChainTransferTransactionOutputs(par *TransferData) ([]byte, []*ledger.O
 	}
 
 	txb.Transaction.Timestamp = ts
+	txb.Transaction.Fee = par.Fee
 	txb.Transaction.InputCommitment = txb.InputCommitment()
-	txb.SignED25519(par.SenderPrivateKey)
+	par.sign(txb)
 
 	retOut := make([]*ledger.OutputDataWithID, 0)
 	txBytes := txb.Transaction.Bytes()
-	txid := ledger.TransactionID(blake2b.Sum256(txBytes))
+	txid := ledger.TransactionIDFromTransactionBytes(txBytes, txb.Transaction.LedgerIdentity)
 
 	for i, o := range txb.Transaction.Outputs {
 		retOut = append(retOut, &ledger.OutputDataWithID{
@@ -488,6 +844,27 @@ func MakeChainTransferTransactionOutputs(par *TransferData) ([]byte, []*ledger.O
 	return txBytes, retOut, nil
 }
 
+// pushMintNFT pushes the chain origin and the nft immediately after it
+func pushMintNFT(o *Output, issuer constraints.Constraint, metadata []byte) error {
+	if _, err := o.PushConstraint(constraints.NewChainInit().Bytes()); err != nil {
+		return err
+	}
+	_, err := o.PushConstraint(constraints.NewNFTFromMetadata(issuer, metadata).Bytes())
+	return err
+}
+
+// sign signs the transaction by the sender and by cosigners, if any
+func (t *TransferData) sign(txb *TransactionBuilder) {
+	if t.SenderPrivateKeyP256 != nil {
+		txb.SignP256(t.SenderPrivateKeyP256)
+	} else {
+		txb.SignED25519(t.SenderPrivateKey)
+	}
+	for _, key := range t.Cosigners {
+		txb.SignED25519(key)
+	}
+}
+
 //---------------------------------------------------------
 
 func (u *UnlockParams) Bytes() []byte {
@@ -516,8 +893,13 @@ func ForEachOutput(outs []*ledger.OutputDataWithID, fun func(o *Output, odata *l
 func ParseChainConstraints(outs []*ledger.OutputDataWithID) ([]*OutputWithChainID, error) {
 	ret := make([]*OutputWithChainID, 0)
 	err := ForEachOutput(outs, func(o *Output, odata *ledger.OutputDataWithID) bool {
+		var chainID [32]byte
 		ch, constraintIndex := o.ChainConstraint()
-		if constraintIndex == 0xff {
+		if constraintIndex != 0xff {
+			chainID = ch.ID
+		} else if constraintIndex = o.ChainInit(); constraintIndex != 0xff {
+			chainID = constraints.OriginChainID(odata.ID[:])
+		} else {
 			return true
 		}
 		d := &OutputWithChainID{
@@ -525,14 +907,9 @@ func ParseChainConstraints(outs []*ledger.OutputDataWithID) ([]*OutputWithChainI
 				ID:     odata.ID,
 				Output: o,
 			},
+			ChainID:                    chainID,
 			PredecessorConstraintIndex: constraintIndex,
 		}
-		if ch.IsOrigin() {
-			h := blake2b.Sum256(odata.ID[:])
-			d.ChainID = h
-		} else {
-			d.ChainID = ch.ID
-		}
 		ret = append(ret, d)
 		return true
 	})
@@ -572,11 +949,31 @@ func GetChainAccount(chainID []byte, ind ledger.IndexerAccess, state ledger.Stat
 // InsertSimpleChainTransition inserts a simple chain transition. Takes output with chain constraint from parameters,
 // Produces identical output, only modifies timestamp. Unlocks chain-input lock with signature reference
 func (txb *TransactionBuilder) InsertSimpleChainTransition(inChainData *ledger.OutputDataWithChainID, ts uint32) error {
+	return txb.InsertChainTransition(inChainData, ts, constraints.ChainTransitionModeState)
+}
+
+// InsertChainStateTransition inserts a state transition of the chain. The successor output can be modified
+// with the function, for example to change state metadata. The lock must be unlocked by the state controller
+func (txb *TransactionBuilder) InsertChainStateTransition(inChainData *ledger.OutputDataWithChainID, ts uint32, modify ...func(o *Output)) error {
+	return txb.InsertChainTransition(inChainData, ts, constraints.ChainTransitionModeState, modify...)
+}
+
+// InsertChainGovernanceTransition inserts a governance transition of the chain. The successor output can be modified
+// with the function, for example to replace the lock or to change governance metadata.
+// The lock must be unlocked by the governor
+func (txb *TransactionBuilder) InsertChainGovernanceTransition(inChainData *ledger.OutputDataWithChainID, ts uint32, modify ...func(o *Output)) error {
+	return txb.InsertChainTransition(inChainData, ts, constraints.ChainTransitionModeGovernance, modify...)
+}
+
+// InsertChainTransition inserts a chain transition in the given mode. Takes output with chain constraint from parameters,
+// Produces identical output with the timestamp, modified by optional functions. Unlocks chain-input lock with
+// signature reference and points chain metadata to its successors
+func (txb *TransactionBuilder) InsertChainTransition(inChainData *ledger.OutputDataWithChainID, ts uint32, mode byte, modify ...func(o *Output)) error {
 	chainIN, err := OutputFromBytes(inChainData.OutputData)
 	if err != nil {
 		return err
 	}
-	_, predecessorConstraintIndex := chainIN.ChainConstraint()
+	predecessorConstraintIndex := chainIN.ChainBlockIndex()
 	if predecessorConstraintIndex == 0xff {
 		return fmt.Errorf("can't find chain constrain in the output")
 	}
@@ -585,14 +982,349 @@ func (txb *TransactionBuilder) InsertSimpleChainTransition(inChainData *ledger.O
 		return err
 	}
 	chainOut := chainIN.Clone().WithTimestamp(ts)
-	successor := constraints.NewChainConstraint(inChainData.ChainID, predecessorOutputIndex, predecessorConstraintIndex, 0)
+	for _, fun := range modify {
+		fun(chainOut)
+	}
+	successor := constraints.NewChainConstraint(inChainData.ChainID, predecessorOutputIndex, predecessorConstraintIndex, mode)
 	chainOut.PutConstraint(successor.Bytes(), predecessorConstraintIndex)
 	successorOutputIndex, err := txb.ProduceOutput(chainOut)
 	if err != nil {
 		return err
 	}
-	txb.PutUnlockParams(predecessorOutputIndex, predecessorConstraintIndex, []byte{successorOutputIndex, predecessorConstraintIndex, 0})
-	txb.PutSignatureUnlock(successorOutputIndex, constraints.ConstraintIndexLock)
+	txb.PutUnlockParams(predecessorOutputIndex, predecessorConstraintIndex, []byte{successorOutputIndex, predecessorConstraintIndex, mode})
+	txb.PutSignatureUnlock(predecessorOutputIndex, constraints.ConstraintIndexLock)
+	return txb.putChainMetadataUnlockParams(chainIN, predecessorOutputIndex, chainOut)
+}
+
+// putChainMetadataUnlockParams points each chain metadata block of the consumed chain output to the block
+// with the same transition mode in the successor
+func (txb *TransactionBuilder) putChainMetadataUnlockParams(predecessor *Output, predecessorOutputIndex byte, successor *Output) error {
+	var err error
+	predecessor.ForEachConstraint(func(idx byte, constr []byte) bool {
+		md, errParse := constraints.ChainMetadataFromBytes(constr)
+		if errParse != nil {
+			return true
+		}
+		_, successorIdx := successor.ChainMetadata(md.Mode)
+		if successorIdx == 0xff {
+			err = fmt.Errorf("can't find successor of the chain metadata at block %d", idx)
+			return false
+		}
+		txb.PutUnlockParams(predecessorOutputIndex, idx, []byte{successorIdx})
+		return true
+	})
+	return err
+}
+
+// InsertNFTTransfer inserts the transfer of the NFT to the target lock. It is the state transition of the NFT chain,
+// which repeats the nft constraint and replaces the lock
+func (txb *TransactionBuilder) InsertNFTTransfer(nftData *ledger.OutputDataWithChainID, targetLock constraints.Lock, ts uint32) error {
+	return txb.InsertChainStateTransition(nftData, ts, func(o *Output) {
+		o.WithLock(targetLock)
+	})
+}
+
+// InsertNFTBurn inserts the burn of the NFT. The NFT chain is destroyed, the amount of the NFT output
+// goes to the basic output with the target lock. Chain-input lock is unlocked with signature reference
+func (txb *TransactionBuilder) InsertNFTBurn(nftData *ledger.OutputDataWithChainID, targetLock constraints.Lock, ts uint32) error {
+	nftIN, err := OutputFromBytes(nftData.OutputData)
+	if err != nil {
+		return err
+	}
+	chainConstraintIndex := nftIN.ChainBlockIndex()
+	if chainConstraintIndex == 0xff {
+		return fmt.Errorf("can't find chain constrain in the output")
+	}
+	if _, nftIdx := nftIN.NFT(); nftIdx == 0xff {
+		return fmt.Errorf("can't find nft constraint in the output")
+	}
+	consumedIndex, err := txb.ConsumeOutput(nftIN, nftData.ID)
+	if err != nil {
+		return err
+	}
+	if _, err = txb.ProduceOutput(OutputBasic(nftIN.Amount(), ts, targetLock)); err != nil {
+		return err
+	}
+	txb.PutUnlockParams(consumedIndex, chainConstraintIndex, []byte{0xff, 0xff, 0xff})
+	txb.PutSignatureUnlock(consumedIndex, constraints.ConstraintIndexLock)
+	return nil
+}
+
+// makeSignedTransaction makes the transaction with the outputs and unlocks inserted by the function.
+// The transaction is finalized with the timestamp and the input commitment and signed with the private key
+func makeSignedTransaction(ts uint32, privKey ed25519.PrivateKey, ledgerIdentity []byte, insert func(txb *TransactionBuilder) error) ([]byte, error) {
+	txb := NewTransactionBuilder(ledgerIdentity)
+	if err := insert(txb); err != nil {
+		return nil, err
+	}
+	txb.Transaction.Timestamp = ts
+	txb.Transaction.InputCommitment = txb.InputCommitment()
+	txb.SignED25519(privKey)
+	return txb.Transaction.Bytes(), nil
+}
 
+// MakeNFTTransferTransaction makes the transaction which transfers the NFT to the target lock
+func MakeNFTTransferTransaction(nftData *ledger.OutputDataWithChainID, targetLock constraints.Lock, ts uint32, privKey ed25519.PrivateKey, ledgerIdentity []byte) ([]byte, error) {
+	return makeSignedTransaction(ts, privKey, ledgerIdentity, func(txb *TransactionBuilder) error {
+		return txb.InsertNFTTransfer(nftData, targetLock, ts)
+	})
+}
+
+// MakeNFTBurnTransaction makes the transaction which burns the NFT and sends its amount to the target lock
+func MakeNFTBurnTransaction(nftData *ledger.OutputDataWithChainID, targetLock constraints.Lock, ts uint32, privKey ed25519.PrivateKey, ledgerIdentity []byte) ([]byte, error) {
+	return makeSignedTransaction(ts, privKey, ledgerIdentity, func(txb *TransactionBuilder) error {
+		return txb.InsertNFTBurn(nftData, targetLock, ts)
+	})
+}
+
+// InsertVestingWithdrawal inserts withdrawal of the amount from the vesting output to the target lock.
+// The rest is left in the successor with the same vesting lock. The successor must have the same index as
+// the consumed vesting output, so the numbers of inputs and outputs must be equal before the insertion
+func (txb *TransactionBuilder) InsertVestingWithdrawal(vestingData *ledger.OutputDataWithID, amount uint64, targetLock constraints.Lock, ts uint32) error {
+	vestingIN, err := OutputFromBytes(vestingData.OutputData)
+	if err != nil {
+		return err
+	}
+	vesting, isVesting := vestingIN.Lock().(*constraints.Vesting)
+	if !isVesting {
+		return fmt.Errorf("not a vesting output")
+	}
+	if txb.NumInputs() != txb.NumOutputs() {
+		return fmt.Errorf("numbers of inputs and outputs must be equal")
+	}
+	if vested := vesting.Vested(vestingIN.Amount(), ts); amount > vested {
+		return fmt.Errorf("not enough vested tokens: needed %d, vested %d", amount, vested)
+	}
+	consumedIndex, err := txb.ConsumeOutput(vestingIN, vestingData.ID)
+	if err != nil {
+		return err
+	}
+	if amount < vestingIN.Amount() {
+		successorIndex, err := txb.ProduceOutput(vestingIN.Clone().WithAmount(vestingIN.Amount() - amount).WithTimestamp(ts))
+		if err != nil {
+			return err
+		}
+		easyfl.Assert(successorIndex == consumedIndex, "successorIndex == consumedIndex")
+	}
+	if _, err = txb.ProduceOutput(OutputBasic(amount, ts, targetLock)); err != nil {
+		return err
+	}
+	txb.PutSignatureUnlock(consumedIndex, constraints.ConstraintIndexLock)
 	return nil
 }
+
+// MakeVestingWithdrawalTransaction makes the transaction which withdraws the amount from the vesting output to the target lock
+func MakeVestingWithdrawalTransaction(vestingData *ledger.OutputDataWithID, amount uint64, targetLock constraints.Lock, ts uint32, privKey ed25519.PrivateKey, ledgerIdentity []byte) ([]byte, error) {
+	return makeSignedTransaction(ts, privKey, ledgerIdentity, func(txb *TransactionBuilder) error {
+		return txb.InsertVestingWithdrawal(vestingData, amount, targetLock, ts)
+	})
+}
+
+// WithOrder makes the main output of the transfer the limit order of the source account: it offers the amount
+// of the transfer for the want amount of the native token or of base tokens, if the token ID is not specified
+func (t *TransferData) WithOrder(wantAmount uint64, wantTokenID ...[32]byte) *TransferData {
+	return t.WithTargetLock(constraints.NewOrder(t.SourceAccount, wantAmount, wantTokenID...))
+}
+
+// orderPaymentOutput makes the output which pays the price to the owner of the order. The payment in native tokens
+// carries the minimum storage deposit
+func orderPaymentOutput(order *constraints.Order, price uint64, ts uint32, params *constraints.StorageDepositParams) *Output {
+	if len(order.WantTokenID) == 0 {
+		return OutputBasic(price, ts, order.Owner.AsLock())
+	}
+	var tokenID [32]byte
+	copy(tokenID[:], order.WantTokenID)
+	ret := OutputBasic(0, ts, order.Owner.AsLock()).WithNativeToken(tokenID, price)
+	if params == nil {
+		params = constraints.DefaultStorageDepositParams()
+	}
+	return ret.WithAmount(params.MinimumStorageDeposit(uint32(len(ret.Bytes())), 0))
+}
+
+// InsertOrderFill inserts the fill of the order: takes the amount from the order output, produces the payment
+// to the owner and, if the order is filled partially, the successor order with the rest.
+// The taken amount is not produced, it must be produced by the caller together with the funds of the payment.
+// Returns the payment output
+func (txb *TransactionBuilder) InsertOrderFill(orderData *ledger.OutputDataWithID, takeAmount uint64, ts uint32, params *constraints.StorageDepositParams) (*Output, error) {
+	orderIN, err := OutputFromBytes(orderData.OutputData)
+	if err != nil {
+		return nil, err
+	}
+	order, isOrder := orderIN.Lock().(*constraints.Order)
+	if !isOrder {
+		return nil, fmt.Errorf("not an order output")
+	}
+	if takeAmount == 0 || takeAmount > orderIN.Amount() {
+		return nil, fmt.Errorf("wrong amount to take from the order: %d, offered %d", takeAmount, orderIN.Amount())
+	}
+	consumedIndex, err := txb.ConsumeOutput(orderIN, orderData.ID)
+	if err != nil {
+		return nil, err
+	}
+	payment := orderPaymentOutput(order, order.Price(orderIN.Amount(), takeAmount), ts, params)
+	paymentIndex, err := txb.ProduceOutput(payment)
+	if err != nil {
+		return nil, err
+	}
+	successorIndex := constraints.OrderFillNoSuccessor
+	if rest := orderIN.Amount() - takeAmount; rest > 0 {
+		// the successor wants not less than proportional to the rest
+		successorLock := constraints.NewOrder(order.Owner, order.Price(orderIN.Amount(), rest))
+		successorLock.WantTokenID = order.WantTokenID
+		successor := orderIN.Clone().WithAmount(rest).WithTimestamp(ts).WithLock(successorLock)
+		if successorIndex, err = txb.ProduceOutput(successor); err != nil {
+			return nil, err
+		}
+	}
+	txb.PutUnlockParams(consumedIndex, constraints.ConstraintIndexTimestamp, constraints.NewOrderFillUnlockParams(paymentIndex, successorIndex))
+	return payment, nil
+}
+
+// MakeOrderFillTransaction makes the transaction which takes the amount from the order and sends it to the target lock
+// of the transfer. The price is paid from the source account of the transfer
+func MakeOrderFillTransaction(par *TransferData, orderData *ledger.OutputDataWithID, takeAmount uint64) ([]byte, error) {
+	orderIN, err := OutputFromBytes(orderData.OutputData)
+	if err != nil {
+		return nil, err
+	}
+	order, isOrder := orderIN.Lock().(*constraints.Order)
+	if !isOrder {
+		return nil, fmt.Errorf("not an order output")
+	}
+	if takeAmount == 0 || takeAmount > orderIN.Amount() {
+		return nil, fmt.Errorf("wrong amount to take from the order: %d, offered %d", takeAmount, orderIN.Amount())
+	}
+	price := order.Price(orderIN.Amount(), takeAmount)
+	// the payment is funded by the source account
+	funding := *par
+	if funding.Timestamp <= orderIN.Timestamp() {
+		funding.Timestamp = orderIN.Timestamp() + 1
+	}
+	funding.NativeTokens = make(map[[32]byte]uint64)
+	if len(order.WantTokenID) > 0 {
+		var tokenID [32]byte
+		copy(tokenID[:], order.WantTokenID)
+		funding.NativeTokens[tokenID] = price
+	}
+	paymentAmount := orderPaymentOutput(order, price, 0, par.StorageDepositParams).Amount()
+	if paymentAmount > math.MaxUint64-par.Fee {
+		return nil, fmt.Errorf("uint64 arithmetic overflow: payment %d, fee %d", paymentAmount, par.Fee)
+	}
+	amountWithFee := paymentAmount + par.Fee
+	availableTokens, availableNativeTokens, ts, consumedOuts, err := outputsToConsumeSimple(&funding, amountWithFee)
+	if err != nil {
+		return nil, err
+	}
+	if availableTokens < amountWithFee {
+		return nil, fmt.Errorf("not enough tokens in account %s: needed %d, got %d",
+			par.SourceAccount.String(), amountWithFee, availableTokens)
+	}
+	leftoverNativeTokens, err := checkNativeTokens(&funding, availableNativeTokens)
+	if err != nil {
+		return nil, err
+	}
+	if availableTokens == amountWithFee && len(leftoverNativeTokens) > 0 {
+		return nil, fmt.Errorf("not enough tokens in account %s for the remainder with native tokens",
+			par.SourceAccount.String())
+	}
+
+	txb := NewTransactionBuilder(par.LedgerIdentity)
+	err = txb.consumeOutputsUnlockedByReference(consumedOuts, func(inputIndex byte) {
+		txb.PutSignatureUnlock(inputIndex, constraints.ConstraintIndexLock)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if _, err = txb.InsertOrderFill(orderData, takeAmount, ts, par.StorageDepositParams); err != nil {
+		return nil, err
+	}
+	if _, err = txb.ProduceOutput(OutputBasic(takeAmount, ts, par.Lock)); err != nil {
+		return nil, err
+	}
+	if availableTokens > amountWithFee {
+		reminderOut := OutputBasic(availableTokens-amountWithFee, ts, par.SourceAccount.AsLock())
+		for _, tokenID := range sortedTokenIDs(leftoverNativeTokens) {
+			reminderOut.WithNativeToken(tokenID, leftoverNativeTokens[tokenID])
+		}
+		if _, err = txb.ProduceOutput(reminderOut); err != nil {
+			return nil, err
+		}
+	}
+	if err = txb.produceStorageDepositReturns(consumedOuts, 0, ts); err != nil {
+		return nil, err
+	}
+	txb.Transaction.Timestamp = ts
+	txb.Transaction.Fee = par.Fee
+	txb.Transaction.InputCommitment = txb.InputCommitment()
+	par.sign(txb)
+	return txb.Transaction.Bytes(), nil
+}
+
+// InsertOrderCancel inserts the cancel of the order by the owner. The amount of the order goes to the target lock
+func (txb *TransactionBuilder) InsertOrderCancel(orderData *ledger.OutputDataWithID, targetLock constraints.Lock, ts uint32) error {
+	orderIN, err := OutputFromBytes(orderData.OutputData)
+	if err != nil {
+		return err
+	}
+	if _, isOrder := orderIN.Lock().(*constraints.Order); !isOrder {
+		return fmt.Errorf("not an order output")
+	}
+	consumedIndex, err := txb.ConsumeOutput(orderIN, orderData.ID)
+	if err != nil {
+		return err
+	}
+	if _, err = txb.ProduceOutput(orderIN.Clone().WithTimestamp(ts).WithLock(targetLock)); err != nil {
+		return err
+	}
+	// orders can't be cancelled by reference
+	txb.PutSignatureUnlock(consumedIndex, constraints.ConstraintIndexLock)
+	return nil
+}
+
+// MakeOrderCancelTransaction makes the transaction which cancels the order and sends its amount to the target lock
+func MakeOrderCancelTransaction(orderData *ledger.OutputDataWithID, targetLock constraints.Lock, ts uint32, privKey ed25519.PrivateKey, ledgerIdentity []byte) ([]byte, error) {
+	return makeSignedTransaction(ts, privKey, ledgerIdentity, func(txb *TransactionBuilder) error {
+		return txb.InsertOrderCancel(orderData, targetLock, ts)
+	})
+}
+
+// InsertMerkleClaim inserts the claim of the airdrop entry from the pool, held by the chain. The successor of the pool
+// marks the entry as claimed and keeps the rest of the pool, the amount of the entry is paid to the lock of the entry
+func (txb *TransactionBuilder) InsertMerkleClaim(poolData *ledger.OutputDataWithChainID, index uint16, entry *constraints.AirdropEntry, proof []byte, ts uint32) error {
+	poolIN, err := OutputFromBytes(poolData.OutputData)
+	if err != nil {
+		return err
+	}
+	pool, isPool := poolIN.Lock().(*constraints.MerkleClaim)
+	if !isPool {
+		return fmt.Errorf("not an airdrop pool output")
+	}
+	if entry.Amount > poolIN.Amount() {
+		return fmt.Errorf("not enough tokens in the pool: needed %d, got %d", entry.Amount, poolIN.Amount())
+	}
+	successorLock, err := pool.WithClaimed(index)
+	if err != nil {
+		return err
+	}
+	err = txb.InsertChainStateTransition(poolData, ts, func(o *Output) {
+		o.WithLock(successorLock).WithAmount(poolIN.Amount() - entry.Amount)
+	})
+	if err != nil {
+		return err
+	}
+	consumedIndex := byte(txb.NumInputs() - 1)
+	paymentIndex, err := txb.ProduceOutput(OutputBasic(entry.Amount, ts, entry.Lock))
+	if err != nil {
+		return err
+	}
+	params := constraints.NewMerkleClaimUnlockParams(poolIN.ChainBlockIndex(), index, entry, proof, paymentIndex)
+	txb.PutUnlockParams(consumedIndex, constraints.ConstraintIndexTimestamp, params)
+	return nil
+}
+
+// MakeMerkleClaimTransaction makes the transaction which claims the airdrop entry from the pool. Anyone can sign it
+func MakeMerkleClaimTransaction(poolData *ledger.OutputDataWithChainID, index uint16, entry *constraints.AirdropEntry, proof []byte, ts uint32, privKey ed25519.PrivateKey, ledgerIdentity []byte) ([]byte, error) {
+	return makeSignedTransaction(ts, privKey, ledgerIdentity, func(txb *TransactionBuilder) error {
+		return txb.InsertMerkleClaim(poolData, index, entry, proof, ts)
+	})
+}
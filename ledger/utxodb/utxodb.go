@@ -1,6 +1,7 @@
 package utxodb
 
 import (
+	"crypto/ecdsa"
 	"crypto/ed25519"
 	"encoding/binary"
 	"encoding/hex"
@@ -23,6 +24,7 @@ type UTXODB struct {
 	indexer           *indexer.Indexer
 	root              common.VCommitment
 	supply            uint64
+	burned            uint64
 	genesisPrivateKey ed25519.PrivateKey
 	genesisPublicKey  ed25519.PublicKey
 	genesisAddress    constraints.AddressED25519
@@ -39,6 +41,11 @@ const (
 )
 
 func NewUTXODB(trace ...bool) *UTXODB {
+	return NewUTXODBWithStorageDepositParams(constraints.DefaultStorageDepositParams(), trace...)
+}
+
+// NewUTXODBWithStorageDepositParams creates UTXODB with the storage deposit parameters in the genesis
+func NewUTXODBWithStorageDepositParams(storageDepositParams *constraints.StorageDepositParams, trace ...bool) *UTXODB {
 	genesisPrivateKeyBin, err := hex.DecodeString(originPrivateKey)
 	common.AssertNoError(err)
 	genesisPubKey := ed25519.PrivateKey(genesisPrivateKeyBin).Public().(ed25519.PublicKey)
@@ -48,7 +55,7 @@ func NewUTXODB(trace ...bool) *UTXODB {
 	stateStore := common.NewInMemoryKVStore()
 	indexerStore := common.NewInMemoryKVStore()
 	ts := uint32(time.Now().Unix())
-	root := state.InitLedgerState(stateStore, []byte(utxodbIdentity), supplyForTesting, genesisAddr, ts)
+	root := state.InitLedgerState(stateStore, []byte(utxodbIdentity), supplyForTesting, genesisAddr, ts, storageDepositParams)
 	stateObj, err := state.NewUpdatable(stateStore, root)
 	common.AssertNoError(err)
 
@@ -69,6 +76,11 @@ func (u *UTXODB) Supply() uint64 {
 	return u.supply
 }
 
+// Burned returns total amount of tokens burned by transactions as fees. Circulating supply is Supply() - Burned()
+func (u *UTXODB) Burned() uint64 {
+	return u.burned
+}
+
 func (u *UTXODB) Root() common.VCommitment {
 	return u.root
 }
@@ -81,6 +93,15 @@ func (u *UTXODB) IndexerAccess() ledger.IndexerAccess {
 	return u.indexer
 }
 
+func (u *UTXODB) StorageDepositParams() *constraints.StorageDepositParams {
+	return u.state.Readable().StorageDepositParams()
+}
+
+// LedgerIdentity is the identity of the ledger. Transactions must be signed for it
+func (u *UTXODB) LedgerIdentity() []byte {
+	return u.state.Readable().LedgerIdentity()
+}
+
 func (u *UTXODB) GenesisKeys() (ed25519.PrivateKey, ed25519.PublicKey) {
 	return u.genesisPrivateKey, u.genesisPublicKey
 }
@@ -93,17 +114,23 @@ func (u *UTXODB) GenesisAddress() constraints.AddressED25519 {
 // Ledger state and indexer are on different DB transactions, so ledger state can
 // succeed while indexer fails. In that case indexer can be updated from ledger state
 func (u *UTXODB) AddTransaction(txBytes []byte, traceOption ...int) error {
-	indexerUpdate, err := u.state.Update(txBytes, traceOption...)
+	indexerUpdate, burned, err := u.state.Update(txBytes, traceOption...)
 	if err != nil {
 		return err
 	}
+	u.burned += burned
 	if err = u.indexer.Update(indexerUpdate); err != nil {
 		return fmt.Errorf("ledger state has been updated but indexer update failed with '%v'", err)
 	}
 	return nil
 }
 
-func (u *UTXODB) TokensFromFaucet(addr constraints.AddressED25519, howMany ...uint64) error {
+// TraceTransaction validates the transaction without adding it to the ledger. Returns the evaluation trace
+func (u *UTXODB) TraceTransaction(txBytes []byte) (*state.Trace, error) {
+	return state.TraceTransaction(txBytes, u.StateReader())
+}
+
+func (u *UTXODB) TokensFromFaucet(addr constraints.Lock, howMany ...uint64) error {
 	amount := TokensFromFaucetDefault
 	if len(howMany) > 0 && howMany[0] > 0 {
 		amount = howMany[0]
@@ -119,7 +146,9 @@ func (u *UTXODB) TokensFromFaucet(addr constraints.AddressED25519, howMany ...ui
 	par := txbuilder.NewTransferData(u.genesisPrivateKey, nil, uint32(time.Now().Unix())).
 		WithAmount(amount, true).
 		WithTargetLock(addr).
-		WithOutputs(outs)
+		WithOutputs(outs).
+		WithStorageDepositParams(u.StorageDepositParams()).
+		WithLedgerIdentity(u.LedgerIdentity())
 	txBytes, err := txbuilder.MakeTransferTransaction(par)
 	if err != nil {
 		return fmt.Errorf("UTXODB faucet: %v", err)
@@ -146,11 +175,13 @@ func (u *UTXODB) MakeTransferData(privKey ed25519.PrivateKey, sourceAccount cons
 	if ts == 0 {
 		ts = uint32(time.Now().Unix())
 	}
-	ret := txbuilder.NewTransferData(privKey, sourceAccount, ts)
+	ret := txbuilder.NewTransferData(privKey, sourceAccount, ts).
+		WithStorageDepositParams(u.StorageDepositParams()).
+		WithLedgerIdentity(u.LedgerIdentity())
 
 	switch addr := ret.SourceAccount.(type) {
-	case constraints.AddressED25519:
-		if err := u.makeTransferInputsED25519(ret, desc...); err != nil {
+	case constraints.AddressED25519, constraints.MultisigED25519, constraints.ScriptHashLock:
+		if err := u.makeTransferInputs(ret, desc...); err != nil {
 			return nil, err
 		}
 		return ret, nil
@@ -164,12 +195,46 @@ func (u *UTXODB) MakeTransferData(privKey ed25519.PrivateKey, sourceAccount cons
 	return ret, nil
 }
 
-func (u *UTXODB) makeTransferInputsED25519(par *txbuilder.TransferData, desc ...bool) error {
+// MakeTransferDataP256 makes the transfer from the P-256 address of the key
+func (u *UTXODB) MakeTransferDataP256(privKey *ecdsa.PrivateKey, ts uint32, desc ...bool) (*txbuilder.TransferData, error) {
+	if ts == 0 {
+		ts = uint32(time.Now().Unix())
+	}
+	ret := txbuilder.NewTransferDataP256(privKey, ts).
+		WithStorageDepositParams(u.StorageDepositParams()).
+		WithLedgerIdentity(u.LedgerIdentity())
+	if err := u.makeTransferInputs(ret, desc...); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+func (u *UTXODB) makeTransferInputs(par *txbuilder.TransferData, desc ...bool) error {
 	outsData, err := u.indexer.GetUTXOsLockedInAccount(par.SourceAccount, u.state.Readable())
 	if err != nil {
 		return err
 	}
 	outs, err := txbuilder.ParseAndSortOutputData(outsData, func(o *txbuilder.Output) bool {
+		if o.ChainBlockIndex() != 0xff {
+			// chain outputs are consumed only by chain transitions
+			return false
+		}
+		if _, isVesting := o.Lock().(*constraints.Vesting); isVesting {
+			// vesting outputs are consumed only by withdrawals
+			return false
+		}
+		if _, idx := o.Covenant(); idx != 0xff {
+			// covenant outputs are spent only to the whitelisted locks
+			return false
+		}
+		if _, isOrder := o.Lock().(*constraints.Order); isOrder {
+			// orders are consumed only by fills and cancels
+			return false
+		}
+		if !o.RelativeTimeLockExpired(par.Timestamp) {
+			// relative time lock is not expired at the timestamp of the transfer
+			return false
+		}
 		return o.Lock().UnlockableWith(par.SourceAccount.AccountID(), par.Timestamp)
 	}, desc...)
 	if err != nil {
@@ -184,7 +249,16 @@ func (u *UTXODB) makeTransferDataChainLock(par *txbuilder.TransferData, chainLoc
 	if err != nil {
 		return err
 	}
-	par.WithOutputs(outs).
+	// only outputs unlockable by the chain at the timestamp are consumed, e.g. deadline locks with the chain
+	// as main account before the deadline and as expiry account after the deadline.
+	// Outputs with not expired relative time locks are skipped too
+	unlockable := outs[:0]
+	for _, o := range outs {
+		if o.Output.Lock().UnlockableWith(chainLock.AccountID(), par.Timestamp) && o.Output.RelativeTimeLockExpired(par.Timestamp) {
+			unlockable = append(unlockable, o)
+		}
+	}
+	par.WithOutputs(unlockable).
 		WithChainOutput(outChain)
 	return nil
 }
@@ -207,32 +281,49 @@ func (u *UTXODB) TransferTokens(privKey ed25519.PrivateKey, targetLock constrain
 	return u.AddTransaction(txBytes, trace)
 }
 
-func (u *UTXODB) account(addr constraints.Accountable, ts ...uint32) (uint64, int) {
+func (u *UTXODB) account(addr constraints.Accountable, ts ...uint32) (uint64, map[[32]byte]uint64, int) {
 	outs, err := u.indexer.GetUTXOsLockedInAccount(addr, u.state.Readable())
 	easyfl.AssertNoError(err)
 	balance := uint64(0)
+	nativeTokens := make(map[[32]byte]uint64)
 	var filter func(o *txbuilder.Output) bool
 	if len(ts) > 0 {
 		filter = func(o *txbuilder.Output) bool {
-			return o.Lock().UnlockableWith(addr.AccountID(), ts[0])
+			return o.Lock().UnlockableWith(addr.AccountID(), ts[0]) && o.RelativeTimeLockExpired(ts[0])
 		}
 	}
 	outs1, err := txbuilder.ParseAndSortOutputData(outs, filter)
 	easyfl.AssertNoError(err)
 
 	for _, o := range outs1 {
-		balance += o.Output.Amount()
+		amount := o.Output.Amount()
+		if vesting, isVesting := o.Output.Lock().(*constraints.Vesting); isVesting && len(ts) > 0 {
+			// only vested part of the vesting output is available at the timestamp
+			amount = vesting.Vested(amount, ts[0])
+		}
+		balance += amount
+		for tokenID, a := range o.Output.NativeTokens() {
+			nativeTokens[tokenID] += a
+		}
 	}
-	return balance, len(outs1)
+	return balance, nativeTokens, len(outs1)
 }
 
 // Balance returns balance of address unlockable at timestamp ts, if provided. Otherwise, all outputs taken
+// Only vested part of vesting outputs is counted at the timestamp
 // For chains, this does not include te chain-output itself
 func (u *UTXODB) Balance(addr constraints.Accountable, ts ...uint32) uint64 {
-	ret, _ := u.account(addr, ts...)
+	ret, _, _ := u.account(addr, ts...)
 	return ret
 }
 
+// BalanceNativeToken returns balance of the native token on the address unlockable at timestamp ts, if provided.
+// Otherwise, all outputs taken. For chains, this does not include te chain-output itself
+func (u *UTXODB) BalanceNativeToken(addr constraints.Accountable, tokenID [32]byte, ts ...uint32) uint64 {
+	_, ret, _ := u.account(addr, ts...)
+	return ret[tokenID]
+}
+
 // BalanceOnChain returns balance locked in chain and separately balance on chain output
 func (u *UTXODB) BalanceOnChain(chainID []byte) (uint64, uint64, error) {
 	outChain, outs, err := txbuilder.GetChainAccount(chainID, u.IndexerAccess(), u.state.Readable())
@@ -248,7 +339,7 @@ func (u *UTXODB) BalanceOnChain(chainID []byte) (uint64, uint64, error) {
 
 // NumUTXOs returns number of outputs of address unlockable at timestamp ts, if provided. Otherwise, all outputs taken
 func (u *UTXODB) NumUTXOs(addr constraints.Accountable, ts ...uint32) int {
-	_, ret := u.account(addr, ts...)
+	_, _, ret := u.account(addr, ts...)
 	return ret
 }
 
@@ -269,7 +360,14 @@ func (u *UTXODB) DoTransferOutputs(par *txbuilder.TransferData) ([]*ledger.Outpu
 	if u.trace {
 		trace = state.TraceOptionFailedConstraints
 	}
-	txBytes, retOuts, err := txbuilder.MakeSimpleTransferTransactionOutputs(par)
+	var txBytes []byte
+	var retOuts []*ledger.OutputDataWithID
+	var err error
+	if par.ChainOutput == nil {
+		txBytes, retOuts, err = txbuilder.MakeSimpleTransferTransactionOutputs(par)
+	} else {
+		txBytes, retOuts, err = txbuilder.MakeChainTransferTransactionOutputs(par)
+	}
 	if err != nil {
 		return nil, err
 	}